@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"net/http"
 	"time"
 
@@ -27,11 +28,19 @@ func main() {
 		log.Fatal(err.Error())
 	}
 
-	router := app.BuildApp(log, dbpool, cfg)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	application, err := app.BuildApp(ctx, log, dbpool, cfg)
+	if err != nil {
+		log.Fatal(err.Error())
+	}
+
+	application.StartWorkers(ctx)
 
 	server := &http.Server{
 		Addr:         cfg.HTTPAddr,
-		Handler:      router,
+		Handler:      application.Handler,
 		ReadTimeout:  10 * time.Second,
 		WriteTimeout: 15 * time.Second,
 		IdleTimeout:  120 * time.Second,
@@ -0,0 +1,243 @@
+package account
+
+import (
+	"time"
+
+	"github.com/raphico/go-device-telemetry-api/internal/command"
+	"github.com/raphico/go-device-telemetry-api/internal/device"
+	"github.com/raphico/go-device-telemetry-api/internal/identity"
+	"github.com/raphico/go-device-telemetry-api/internal/mfa"
+	"github.com/raphico/go-device-telemetry-api/internal/organization"
+	"github.com/raphico/go-device-telemetry-api/internal/telemetry"
+	"github.com/raphico/go-device-telemetry-api/internal/token"
+	"github.com/raphico/go-device-telemetry-api/internal/user"
+)
+
+// Record types, in the order they appear in an export.
+const (
+	RecordProfile             = "profile"
+	RecordMFA                 = "mfa"
+	RecordIdentity            = "identity"
+	RecordSession             = "session"
+	RecordPersonalAccessToken = "personal_access_token"
+	RecordOrganization        = "organization"
+	RecordDevice              = "device"
+	RecordCommand             = "command"
+	RecordTelemetry           = "telemetry"
+	// RecordEnd closes every export, saying whether it is complete.
+	RecordEnd = "end"
+)
+
+// record is one line of an export: what kind of data it holds and the data.
+type record struct {
+	Type string `json:"type"`
+	Data any    `json:"data"`
+}
+
+// endRow tells a complete export apart from one cut short, whose last
+// record would otherwise look like any other.
+type endRow struct {
+	Complete bool `json:"complete"`
+}
+
+type profileRow struct {
+	ID              string     `json:"id"`
+	Username        string     `json:"username"`
+	Email           string     `json:"email"`
+	EmailVerifiedAt *time.Time `json:"email_verified_at"`
+	PendingEmail    *string    `json:"pending_email"`
+	CreatedAt       time.Time  `json:"created_at"`
+	UpdatedAt       time.Time  `json:"updated_at"`
+}
+
+type mfaRow struct {
+	Enabled                bool       `json:"enabled"`
+	EnabledAt              *time.Time `json:"enabled_at"`
+	RecoveryCodesRemaining int        `json:"recovery_codes_remaining"`
+}
+
+type identityRow struct {
+	ID          string     `json:"id"`
+	Provider    string     `json:"provider"`
+	Subject     string     `json:"subject"`
+	Email       string     `json:"email"`
+	LastLoginAt *time.Time `json:"last_login_at"`
+	CreatedAt   time.Time  `json:"created_at"`
+}
+
+type sessionRow struct {
+	ID           string    `json:"id"`
+	UserAgent    string    `json:"user_agent"`
+	IP           string    `json:"ip"`
+	CreatedAt    time.Time `json:"created_at"`
+	LastActiveAt time.Time `json:"last_active_at"`
+	ExpiresAt    time.Time `json:"expires_at"`
+}
+
+type personalAccessTokenRow struct {
+	ID         string     `json:"id"`
+	Name       string     `json:"name"`
+	Prefix     string     `json:"prefix"`
+	Scopes     []string   `json:"scopes"`
+	ExpiresAt  *time.Time `json:"expires_at"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+	CreatedAt  time.Time  `json:"created_at"`
+}
+
+type organizationRow struct {
+	ID        string    `json:"id"`
+	Name      string    `json:"name"`
+	Role      string    `json:"role"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type deviceRow struct {
+	ID             string         `json:"id"`
+	OrganizationID string         `json:"organization_id"`
+	Name           string         `json:"name"`
+	Status         string         `json:"status"`
+	DeviceType     string         `json:"device_type"`
+	Metadata       map[string]any `json:"metadata"`
+	CreatedAt      time.Time      `json:"created_at"`
+	UpdatedAt      time.Time      `json:"updated_at"`
+}
+
+type commandRow struct {
+	ID         string         `json:"id"`
+	DeviceID   string         `json:"device_id"`
+	Name       string         `json:"name"`
+	Payload    map[string]any `json:"payload"`
+	Status     string         `json:"status"`
+	ExecutedAt *time.Time     `json:"executed_at"`
+	CreatedAt  time.Time      `json:"created_at"`
+}
+
+type telemetryRow struct {
+	ID            string         `json:"id"`
+	DeviceID      string         `json:"device_id"`
+	TelemetryType string         `json:"telemetry_type"`
+	Payload       map[string]any `json:"payload"`
+	RecordedAt    time.Time      `json:"recorded_at"`
+	CreatedAt     time.Time      `json:"created_at"`
+}
+
+func toProfileRow(u *user.User) profileRow {
+	var pendingEmail *string
+	if u.PendingEmail != nil {
+		v := u.PendingEmail.String()
+		pendingEmail = &v
+	}
+
+	return profileRow{
+		ID:              u.ID.String(),
+		Username:        u.Username.String(),
+		Email:           u.Email.String(),
+		EmailVerifiedAt: u.EmailVerifiedAt,
+		PendingEmail:    pendingEmail,
+		CreatedAt:       u.CreatedAt,
+		UpdatedAt:       u.UpdatedAt,
+	}
+}
+
+func toMFARow(s *mfa.Status) mfaRow {
+	return mfaRow{
+		Enabled:                s.Enabled,
+		EnabledAt:              s.EnabledAt,
+		RecoveryCodesRemaining: s.RecoveryCodesRemaining,
+	}
+}
+
+func toIdentityRow(i *identity.Identity) identityRow {
+	return identityRow{
+		ID:          i.ID.String(),
+		Provider:    i.Provider,
+		Subject:     i.Subject,
+		Email:       i.Email,
+		LastLoginAt: i.LastLoginAt,
+		CreatedAt:   i.CreatedAt,
+	}
+}
+
+func toSessionRow(s *token.Session) sessionRow {
+	return sessionRow{
+		ID:           s.ID.String(),
+		UserAgent:    s.Client.UserAgent,
+		IP:           s.Client.IP,
+		CreatedAt:    s.CreatedAt,
+		LastActiveAt: s.LastActiveAt,
+		ExpiresAt:    s.ExpiresAt,
+	}
+}
+
+func toPersonalAccessTokenRow(t *token.Token) personalAccessTokenRow {
+	scopes := make([]string, 0, len(t.AccessScopes))
+	for _, scope := range t.AccessScopes {
+		scopes = append(scopes, scope.String())
+	}
+
+	var expiresAt *time.Time
+	if !t.ExpiresAt.IsZero() {
+		expiresAt = &t.ExpiresAt
+	}
+
+	return personalAccessTokenRow{
+		ID:         t.ID.String(),
+		Name:       t.Name.String(),
+		Prefix:     t.Prefix,
+		Scopes:     scopes,
+		ExpiresAt:  expiresAt,
+		LastUsedAt: t.LastUsedAt,
+		CreatedAt:  t.CreatedAt,
+	}
+}
+
+func toOrganizationRow(a *organization.Affiliation) organizationRow {
+	return organizationRow{
+		ID:        a.Organization.ID.String(),
+		Name:      a.Organization.Name.String(),
+		Role:      a.Role.String(),
+		CreatedAt: a.Organization.CreatedAt,
+	}
+}
+
+func toDeviceRow(d *device.Device) deviceRow {
+	return deviceRow{
+		ID:             d.ID.String(),
+		OrganizationID: d.OrganizationID.String(),
+		Name:           d.Name.String(),
+		Status:         d.Status.String(),
+		DeviceType:     d.DeviceType.String(),
+		Metadata:       d.Metadata,
+		CreatedAt:      d.CreatedAt,
+		UpdatedAt:      d.UpdatedAt,
+	}
+}
+
+func toCommandRow(c *command.Command) commandRow {
+	var executedAt *time.Time
+	if c.ExecutedAt.Valid() {
+		t := c.ExecutedAt.Time()
+		executedAt = &t
+	}
+
+	return commandRow{
+		ID:         c.ID.String(),
+		DeviceID:   c.DeviceID.String(),
+		Name:       c.Name.String(),
+		Payload:    c.Payload,
+		Status:     c.Status.String(),
+		ExecutedAt: executedAt,
+		CreatedAt:  c.CreatedAt,
+	}
+}
+
+func toTelemetryRow(t *telemetry.Telemetry) telemetryRow {
+	return telemetryRow{
+		ID:            t.ID.String(),
+		DeviceID:      t.DeviceID.String(),
+		TelemetryType: t.TelemetryType.String(),
+		Payload:       t.Payload,
+		RecordedAt:    t.RecordedAt.Time(),
+		CreatedAt:     t.CreatedAt.UTC(),
+	}
+}
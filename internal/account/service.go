@@ -0,0 +1,227 @@
+// Package account gathers everything a user owns across the other domains,
+// for the data export users can download about themselves.
+package account
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+
+	"github.com/raphico/go-device-telemetry-api/internal/authz"
+	"github.com/raphico/go-device-telemetry-api/internal/command"
+	"github.com/raphico/go-device-telemetry-api/internal/common/pagination"
+	"github.com/raphico/go-device-telemetry-api/internal/device"
+	"github.com/raphico/go-device-telemetry-api/internal/identity"
+	"github.com/raphico/go-device-telemetry-api/internal/mfa"
+	"github.com/raphico/go-device-telemetry-api/internal/organization"
+	"github.com/raphico/go-device-telemetry-api/internal/telemetry"
+	"github.com/raphico/go-device-telemetry-api/internal/token"
+	"github.com/raphico/go-device-telemetry-api/internal/user"
+)
+
+type Service struct {
+	users         *user.Service
+	mfa           *mfa.Service
+	identities    *identity.Service
+	tokens        *token.Service
+	organizations *organization.Service
+	devices       *device.Service
+	commands      *command.Service
+	telemetry     *telemetry.Service
+}
+
+func NewService(
+	userService *user.Service,
+	mfaService *mfa.Service,
+	identityService *identity.Service,
+	tokenService *token.Service,
+	organizationService *organization.Service,
+	deviceService *device.Service,
+	commandService *command.Service,
+	telemetryService *telemetry.Service,
+) *Service {
+	return &Service{
+		users:         userService,
+		mfa:           mfaService,
+		identities:    identityService,
+		tokens:        tokenService,
+		organizations: organizationService,
+		devices:       deviceService,
+		commands:      commandService,
+		telemetry:     telemetryService,
+	}
+}
+
+// Export writes userID's data to w as newline-delimited JSON, one
+// {"type", "data"} record per line: their profile, sign-in methods and
+// sessions, every organization they belong to, and the devices, commands
+// and telemetry of the organizations they own. Secrets such as password
+// hashes and token hashes are left out. Records are streamed, so a failure
+// part way leaves w with a truncated export; the final "end" record says
+// whether everything made it.
+func (s *Service) Export(ctx context.Context, userID user.UserID, w io.Writer) error {
+	enc := json.NewEncoder(w)
+	write := func(recordType string, data any) error {
+		return enc.Encode(record{Type: recordType, Data: data})
+	}
+
+	err := s.export(ctx, userID, write)
+
+	// when w itself failed this cannot get through either, and the missing
+	// record gives the export away just the same
+	if endErr := write(RecordEnd, endRow{Complete: err == nil}); err == nil {
+		err = endErr
+	}
+
+	return err
+}
+
+func (s *Service) export(ctx context.Context, userID user.UserID, write func(string, any) error) error {
+
+	u, err := s.users.GetUser(ctx, userID)
+	if err != nil {
+		return err
+	}
+	if err := write(RecordProfile, toProfileRow(u)); err != nil {
+		return err
+	}
+
+	status, err := s.mfa.Status(ctx, userID)
+	if err != nil {
+		return err
+	}
+	if err := write(RecordMFA, toMFARow(status)); err != nil {
+		return err
+	}
+
+	identities, err := s.identities.ListIdentities(ctx, userID)
+	if err != nil {
+		return err
+	}
+	for _, i := range identities {
+		if err := write(RecordIdentity, toIdentityRow(i)); err != nil {
+			return err
+		}
+	}
+
+	sessions, err := s.tokens.ListSessions(ctx, userID)
+	if err != nil {
+		return err
+	}
+	for _, session := range sessions {
+		if err := write(RecordSession, toSessionRow(session)); err != nil {
+			return err
+		}
+	}
+
+	pats, err := s.tokens.ListPersonalAccessTokens(ctx, userID)
+	if err != nil {
+		return err
+	}
+	for _, pat := range pats {
+		if err := write(RecordPersonalAccessToken, toPersonalAccessTokenRow(pat)); err != nil {
+			return err
+		}
+	}
+
+	actor := authz.NewActor(userID)
+
+	affiliations, err := s.organizations.ListOrganizations(ctx, actor)
+	if err != nil {
+		return err
+	}
+
+	var devices []device.DeviceID
+	for _, a := range affiliations {
+		if err := write(RecordOrganization, toOrganizationRow(a)); err != nil {
+			return err
+		}
+
+		if a.Role != authz.RoleOwner {
+			continue
+		}
+
+		ids, err := s.exportDevices(ctx, actor, a.Organization.ID, write)
+		if err != nil {
+			return err
+		}
+		devices = append(devices, ids...)
+	}
+
+	for _, id := range devices {
+		if err := s.exportCommands(ctx, actor, id, write); err != nil {
+			return err
+		}
+	}
+
+	// an empty DeviceIDs filter would match every device the user can see,
+	// shared organizations included
+	if len(devices) == 0 {
+		return nil
+	}
+
+	filter := telemetry.ExportFilter{UserID: userID, DeviceIDs: devices}
+	return s.telemetry.StreamTelemetry(ctx, actor, filter, func(t *telemetry.Telemetry) error {
+		return write(RecordTelemetry, toTelemetryRow(t))
+	})
+}
+
+// exportDevices writes the devices of one organization and returns their
+// IDs.
+func (s *Service) exportDevices(
+	ctx context.Context,
+	actor authz.Actor,
+	organizationID organization.OrganizationID,
+	write func(string, any) error,
+) ([]device.DeviceID, error) {
+	var (
+		ids    []device.DeviceID
+		cursor *pagination.Cursor
+	)
+
+	for {
+		page, next, err := s.devices.ListDevices(ctx, actor, &organizationID, pagination.MaxLimit, cursor)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, d := range page {
+			if err := write(RecordDevice, toDeviceRow(d)); err != nil {
+				return nil, err
+			}
+			ids = append(ids, d.ID)
+		}
+
+		if next == nil {
+			return ids, nil
+		}
+		cursor = next
+	}
+}
+
+func (s *Service) exportCommands(
+	ctx context.Context,
+	actor authz.Actor,
+	deviceID device.DeviceID,
+	write func(string, any) error,
+) error {
+	var cursor *pagination.Cursor
+
+	for {
+		page, next, err := s.commands.ListDeviceCommands(ctx, actor, deviceID, pagination.MaxLimit, cursor)
+		if err != nil {
+			return err
+		}
+
+		for _, c := range page {
+			if err := write(RecordCommand, toCommandRow(c)); err != nil {
+				return err
+			}
+		}
+
+		if next == nil {
+			return nil
+		}
+		cursor = next
+	}
+}
@@ -1,54 +1,425 @@
 package app
 
 import (
+	"context"
+	"fmt"
 	"net/http"
+	"strings"
+	"time"
 
 	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/raphico/go-device-telemetry-api/internal/account"
+	"github.com/raphico/go-device-telemetry-api/internal/audit"
 	"github.com/raphico/go-device-telemetry-api/internal/auth"
+	"github.com/raphico/go-device-telemetry-api/internal/authz"
 	"github.com/raphico/go-device-telemetry-api/internal/command"
 	"github.com/raphico/go-device-telemetry-api/internal/config"
 	"github.com/raphico/go-device-telemetry-api/internal/db"
 	"github.com/raphico/go-device-telemetry-api/internal/device"
+	"github.com/raphico/go-device-telemetry-api/internal/export"
+	"github.com/raphico/go-device-telemetry-api/internal/idempotency"
+	"github.com/raphico/go-device-telemetry-api/internal/identity"
+	"github.com/raphico/go-device-telemetry-api/internal/lockout"
 	"github.com/raphico/go-device-telemetry-api/internal/logger"
+	"github.com/raphico/go-device-telemetry-api/internal/mail"
+	"github.com/raphico/go-device-telemetry-api/internal/mfa"
+	"github.com/raphico/go-device-telemetry-api/internal/organization"
+	"github.com/raphico/go-device-telemetry-api/internal/ratelimit"
+	"github.com/raphico/go-device-telemetry-api/internal/storage"
 	"github.com/raphico/go-device-telemetry-api/internal/telemetry"
 	"github.com/raphico/go-device-telemetry-api/internal/token"
 	transporthttp "github.com/raphico/go-device-telemetry-api/internal/transport/http"
 	"github.com/raphico/go-device-telemetry-api/internal/user"
 )
 
-func BuildApp(log *logger.Logger, dbpool *pgxpool.Pool, cfg config.Config) http.Handler {
+// App holds the HTTP handler together with the background workers that
+// share its services.
+type App struct {
+	Handler http.Handler
+	workers []func(ctx context.Context)
+}
+
+func BuildApp(ctx context.Context, log *logger.Logger, dbpool *pgxpool.Pool, cfg config.Config) (*App, error) {
+	auditRepo := db.NewAuditRepository(dbpool)
+	auditService := audit.NewService(auditRepo, log)
+
 	tokenRepo := db.NewTokenRepository(dbpool)
-	jwtGenerator := token.NewJWTAdapter([]byte(cfg.JWTSecret))
-	tokenService := token.NewService(jwtGenerator, tokenRepo, cfg.AccessTokenTTL, cfg.RefreshTokenTTL)
+	jwtGenerator, err := newJWTGenerator(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	tokenService := token.NewService(
+		jwtGenerator,
+		tokenRepo,
+		auditService,
+		cfg.AccessTokenTTL,
+		cfg.RefreshTokenTTL,
+		cfg.RefreshTokenReuseGrace,
+		cfg.EmailVerificationTTL,
+		cfg.PasswordResetTTL,
+		cfg.MFAChallengeTTL,
+	)
 
 	userRepo := db.NewUserRepository(dbpool)
 	userService := user.NewService(userRepo)
 
-	authService := auth.NewService(userService, tokenService)
+	organizationRepo := db.NewOrganizationRepository(dbpool)
+	authorizer := authz.NewAuthorizer(organizationRepo)
+	organizationService := organization.NewService(organizationRepo, userService, authorizer)
+	organizationHandler := transporthttp.NewOrganizationHandler(log, organizationService)
+
+	mailer, err := newMailer(log, cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	identityRepo := db.NewIdentityRepository(dbpool)
+	identityService := identity.NewService(identityRepo, oidcProviders(cfg), cfg.OIDCStateTTL)
+
+	mfaRepo := db.NewMFARepository(dbpool)
+	mfaService := mfa.NewService(mfaRepo, auditService, cfg.MFAIssuer)
+
+	lockoutRepo := db.NewLockoutRepository(dbpool)
+	lockoutService := lockout.NewService(lockoutRepo, lockout.Policy{
+		Window:           cfg.LoginFailureWindow,
+		DelayAfter:       cfg.LoginDelayAfter,
+		BaseDelay:        cfg.LoginBaseDelay,
+		MaxDelay:         cfg.LoginMaxDelay,
+		AccountThreshold: cfg.LoginLockoutThreshold,
+		IPThreshold:      cfg.LoginIPLockoutThreshold,
+		LockoutDuration:  cfg.LoginLockoutDuration,
+	})
+
+	artifactStore, artifactServer, err := newArtifactStore(ctx, cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	exportRepo := db.NewExportRepository(dbpool)
+	exportService := export.NewService(exportRepo, artifactStore, cfg.ExportURLTTL, cfg.ExportArtifactTTL)
+
+	authService := auth.NewService(
+		log,
+		userService,
+		tokenService,
+		organizationService,
+		identityService,
+		mfaService,
+		lockoutService,
+		exportService,
+		auditService,
+		mailer,
+		cfg.EmailVerificationURL,
+		cfg.PasswordResetURL,
+	)
 	authHandler := transporthttp.NewAuthHandler(log, cfg, authService)
+	tokenHandler := transporthttp.NewTokenHandler(log, tokenService)
+	jwksHandler := transporthttp.NewJWKSHandler(log, tokenService)
+	sessionHandler := transporthttp.NewSessionHandler(log, cfg, tokenService)
+	adminHandler := transporthttp.NewAdminHandler(log, tokenService)
+	identityHandler := transporthttp.NewIdentityHandler(log, cfg, authService, identityService)
+	mfaHandler := transporthttp.NewMFAHandler(log, cfg, authService, mfaService)
 
 	deviceRepo := db.NewDeviceRepository(dbpool)
-	deviceService := device.NewService(deviceRepo)
+	deviceService := device.NewService(deviceRepo, authorizer, organizationService)
 	deviceHandler := transporthttp.NewDeviceHandler(log, deviceService)
 
+	clockSkewPolicy, err := telemetry.NewClockSkewPolicy(cfg.ClockSkewPolicy)
+	if err != nil {
+		return nil, err
+	}
+
+	tiers := rollupTiers(cfg)
+	if len(tiers) == 0 {
+		return nil, telemetry.ErrNoRollupTiers
+	}
+
 	telemetryRepo := db.NewTelemetryRepository(dbpool)
-	telemetryService := telemetry.NewService(telemetryRepo)
-	telemetryHandler := transporthttp.NewTelemetryHandler(log, telemetryService)
+	telemetryService := telemetry.NewService(
+		telemetryRepo,
+		deviceService,
+		tiers,
+		deviceService,
+		telemetry.ClockSkewConfig{
+			Policy:       clockSkewPolicy,
+			Tolerance:    cfg.ClockSkewTolerance,
+			MaxSampleAge: cfg.ClockSkewMaxSampleAge,
+		},
+	)
+	rateLimitService, err := newRateLimitService(cfg, dbpool)
+	if err != nil {
+		return nil, err
+	}
+
+	telemetryHandler := transporthttp.NewTelemetryHandler(log, telemetryService, rateLimitService, cfg.TelemetryImportTimeout)
 
 	commandRepo := db.NewCommandRepository(dbpool)
-	commandService := command.NewService(commandRepo)
+	commandService := command.NewService(commandRepo, deviceService)
 	commandHandler := transporthttp.NewCommandHandler(log, commandService)
 
-	userMiddleware := transporthttp.NewUserMiddleware(tokenService)
+	accountService := account.NewService(
+		userService,
+		mfaService,
+		identityService,
+		tokenService,
+		organizationService,
+		deviceService,
+		commandService,
+		telemetryService,
+	)
+	accountHandler := transporthttp.NewAccountHandler(log, cfg, authService, accountService)
+
+	exportHandler := transporthttp.NewExportHandler(log, exportService, artifactServer)
+	exportWorker := export.NewWorker(exportRepo, artifactStore, telemetryService, log)
+
+	idempotencyRepo := db.NewIdempotencyRepository(dbpool)
+	idempotencyService := idempotency.NewService(idempotencyRepo, cfg.IdempotencyTTL)
+
+	unverifiedAccess, err := user.NewUnverifiedAccess(cfg.UnverifiedAccess)
+	if err != nil {
+		return nil, err
+	}
+
+	userMiddleware := transporthttp.NewUserMiddleware(log, tokenService, userService, unverifiedAccess)
+	idempotencyMiddleware := transporthttp.NewIdempotencyMiddleware(log, idempotencyService)
+	compressionMiddleware := transporthttp.NewCompressionMiddleware(log, cfg.MaxDecompressedBodyBytes)
+	rateLimitMiddleware := transporthttp.NewRateLimitMiddleware(log, rateLimitService)
 
 	router := transporthttp.NewRouter(
 		log,
+		cfg.TrustedProxies,
 		userMiddleware,
+		idempotencyMiddleware,
+		compressionMiddleware,
+		rateLimitMiddleware,
 		authHandler,
+		accountHandler,
+		tokenHandler,
+		sessionHandler,
+		adminHandler,
+		identityHandler,
+		mfaHandler,
+		organizationHandler,
 		deviceHandler,
 		telemetryHandler,
 		commandHandler,
+		exportHandler,
+		jwksHandler,
+	)
+
+	partitions := db.NewTelemetryPartitionManager(
+		dbpool,
+		log,
+		cfg.TelemetryRetention,
+		cfg.TelemetryPartitionAhead,
 	)
+	rollups := db.NewTelemetryRollupWorker(dbpool, log, tiers)
+
+	return &App{
+		Handler: router,
+		workers: []func(ctx context.Context){
+			func(ctx context.Context) { partitions.Run(ctx, cfg.TelemetryPartitionPeriod) },
+			func(ctx context.Context) { rollups.Run(ctx, cfg.RollupPeriod) },
+			func(ctx context.Context) { exportWorker.Run(ctx, cfg.ExportPollInterval) },
+			func(ctx context.Context) {
+				runEvery(ctx, log, time.Hour, "idempotency key purge", func(ctx context.Context) error {
+					_, err := idempotencyService.PurgeExpired(ctx)
+					return err
+				})
+			},
+			func(ctx context.Context) {
+				runEvery(ctx, log, time.Hour, "export artifact purge", func(ctx context.Context) error {
+					_, err := exportService.PurgeExpiredArtifacts(ctx)
+					return err
+				})
+			},
+			func(ctx context.Context) {
+				runEvery(ctx, log, time.Hour, "token purge", func(ctx context.Context) error {
+					_, err := tokenService.PurgeExpired(ctx)
+					return err
+				})
+			},
+			func(ctx context.Context) {
+				runEvery(ctx, log, time.Hour, "oidc login state purge", func(ctx context.Context) error {
+					_, err := identityService.PurgeExpiredStates(ctx)
+					return err
+				})
+			},
+			func(ctx context.Context) {
+				runEvery(ctx, log, time.Hour, "login failure purge", func(ctx context.Context) error {
+					_, err := lockoutService.PurgeExpired(ctx)
+					return err
+				})
+			},
+			func(ctx context.Context) {
+				runEvery(ctx, log, 10*time.Minute, "rate limit purge", func(ctx context.Context) error {
+					_, err := rateLimitService.PurgeExpired(ctx)
+					return err
+				})
+			},
+		},
+	}, nil
+}
+
+func (a *App) StartWorkers(ctx context.Context) {
+	for _, run := range a.workers {
+		go run(ctx)
+	}
+}
+
+// runEvery calls fn immediately and then every interval until ctx is done,
+// logging failures instead of stopping.
+func runEvery(
+	ctx context.Context,
+	log *logger.Logger,
+	interval time.Duration,
+	name string,
+	fn func(ctx context.Context) error,
+) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		if err := fn(ctx); err != nil {
+			log.Error(fmt.Sprintf("%s failed: %v", name, err))
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func rollupTiers(cfg config.Config) []telemetry.RollupTier {
+	return []telemetry.RollupTier{
+		{Resolution: telemetry.ResolutionMinute, Retention: cfg.RollupRetentionMinute},
+		{Resolution: telemetry.ResolutionHour, Retention: cfg.RollupRetentionHour},
+		{Resolution: telemetry.ResolutionDay, Retention: cfg.RollupRetentionDay},
+	}
+}
+
+// newRateLimitService builds the limiter from the configured limits, keeping
+// counters in memory or, to share them across replicas, in Postgres.
+func newRateLimitService(cfg config.Config, dbpool *pgxpool.Pool) (*ratelimit.Service, error) {
+	specs := map[ratelimit.Group]string{
+		ratelimit.GroupAuth:   cfg.RateLimitAuth,
+		ratelimit.GroupAPI:    cfg.RateLimitAPI,
+		ratelimit.GroupIngest: cfg.RateLimitIngest,
+		ratelimit.GroupDevice: cfg.RateLimitDevice,
+	}
+
+	limits := make(map[ratelimit.Group]ratelimit.Limit, len(specs))
+	for group, spec := range specs {
+		limit, err := ratelimit.ParseLimit(spec)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s rate limit %q: %w", group, spec, err)
+		}
+		limits[group] = limit
+	}
+
+	var repo ratelimit.Repository
+	switch cfg.RateLimitStore {
+	case "memory":
+		repo = ratelimit.NewMemoryRepository()
+	case "postgres":
+		repo = db.NewRateLimitRepository(dbpool)
+	default:
+		return nil, fmt.Errorf("unknown rate limit store: %s", cfg.RateLimitStore)
+	}
+
+	return ratelimit.NewService(repo, limits, cfg.DailyIngestionQuota), nil
+}
+
+// newArtifactStore returns the configured export store and, for stores that
+// serve their own downloads through the API, the matching ArtifactServer.
+func newArtifactStore(ctx context.Context, cfg config.Config) (export.ArtifactStore, export.ArtifactServer, error) {
+	switch cfg.ExportStorage {
+	case "local":
+		store, err := storage.NewLocalStore(cfg.ExportDir, cfg.PublicBaseURL, []byte(cfg.ExportSigningSecret))
+		if err != nil {
+			return nil, nil, err
+		}
+		return store, store, nil
+	case "s3":
+		store, err := storage.NewS3Store(ctx, storage.S3Config{
+			Endpoint:  cfg.ExportS3Endpoint,
+			Region:    cfg.ExportS3Region,
+			Bucket:    cfg.ExportS3Bucket,
+			AccessKey: cfg.ExportS3AccessKey,
+			SecretKey: cfg.ExportS3SecretKey,
+			UseSSL:    cfg.ExportS3UseSSL,
+		})
+		if err != nil {
+			return nil, nil, err
+		}
+		return store, nil, nil
+	default:
+		return nil, nil, fmt.Errorf("unknown export storage: %s", cfg.ExportStorage)
+	}
+}
+
+// newJWTGenerator signs access tokens with the configured asymmetric keys,
+// falling back to HS256 with JWT_SECRET when there are none.
+func newJWTGenerator(cfg config.Config) (token.JWTGenerator, error) {
+	claimsConfig := token.ClaimsConfig{Issuer: cfg.JWTIssuer, Audience: cfg.JWTAudience}
+
+	if cfg.JWTSigningKeyFiles == "" {
+		return token.NewJWTAdapter([]byte(cfg.JWTSecret), claimsConfig), nil
+	}
+
+	var paths []string
+	for _, p := range strings.Split(cfg.JWTSigningKeyFiles, ",") {
+		if p = strings.TrimSpace(p); p != "" {
+			paths = append(paths, p)
+		}
+	}
+
+	keys, err := token.LoadSigningKeys(paths)
+	if err != nil {
+		return nil, err
+	}
+
+	return token.NewKeySetAdapter(keys, claimsConfig)
+}
+
+// oidcProviders builds the configured identity providers, each calling
+// back to its route under PUBLIC_BASE_URL.
+func oidcProviders(cfg config.Config) []*identity.Provider {
+	providers := make([]*identity.Provider, 0, len(cfg.OIDCProviders))
+	for _, p := range cfg.OIDCProviders {
+		providers = append(providers, identity.NewProvider(identity.ProviderConfig{
+			Name:         p.Name,
+			IssuerURL:    p.IssuerURL,
+			ClientID:     p.ClientID,
+			ClientSecret: p.ClientSecret,
+			Scopes:       p.Scopes,
+			RedirectURL:  strings.TrimRight(cfg.PublicBaseURL, "/") + "/api/v1/auth/oidc/" + p.Name + "/callback",
+		}))
+	}
+
+	return providers
+}
 
-	return router
+// newMailer returns the configured mailer: log and file keep mail local for
+// development, smtp delivers it.
+func newMailer(log *logger.Logger, cfg config.Config) (mail.Mailer, error) {
+	switch cfg.MailDriver {
+	case "log":
+		return mail.NewLogMailer(log), nil
+	case "file":
+		return mail.NewFileMailer(cfg.MailDir, cfg.MailFrom)
+	case "smtp":
+		return mail.NewSMTPMailer(mail.SMTPConfig{
+			Host:     cfg.SMTPHost,
+			Port:     cfg.SMTPPort,
+			Username: cfg.SMTPUsername,
+			Password: cfg.SMTPPassword,
+			From:     cfg.MailFrom,
+		})
+	default:
+		return nil, fmt.Errorf("unknown mail driver: %s", cfg.MailDriver)
+	}
 }
@@ -0,0 +1,83 @@
+// Package audit records security-relevant events, such as a stolen refresh
+// token being replayed, so they can be investigated later.
+package audit
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/raphico/go-device-telemetry-api/internal/user"
+)
+
+var (
+	// EventRefreshTokenReused is recorded when a refresh token that was
+	// already rotated or revoked is presented again.
+	EventRefreshTokenReused = EventType{"refresh_token.reused"}
+	// EventAccessTokenRevoked is recorded when an access token is
+	// denylisted, by an admin or by its owner deleting their account; the
+	// event's user is whoever revoked it.
+	EventAccessTokenRevoked = EventType{"access_token.revoked"}
+	// EventIdentityLinked and EventIdentityUnlinked are recorded when an
+	// identity provider account is attached to or removed from a user.
+	EventIdentityLinked   = EventType{"identity.linked"}
+	EventIdentityUnlinked = EventType{"identity.unlinked"}
+	// EventMFAEnabled, EventMFADisabled and EventMFARecoveryCodeUsed track
+	// changes to and fallbacks of a user's two-factor authentication.
+	EventMFAEnabled          = EventType{"mfa.enabled"}
+	EventMFADisabled         = EventType{"mfa.disabled"}
+	EventMFARecoveryCodeUsed = EventType{"mfa.recovery_code_used"}
+	// EventLoginLocked is recorded when failed logins lock an account, and
+	// EventLoginIPLocked when they lock out a client IP.
+	EventLoginLocked   = EventType{"login.locked"}
+	EventLoginIPLocked = EventType{"login.ip_locked"}
+	// EventEmailChanged is recorded when a user changes their email
+	// address, and EventAccountDeleted when they delete their account; the
+	// latter keeps the deleted user's ID in its metadata only.
+	EventEmailChanged   = EventType{"user.email_changed"}
+	EventAccountDeleted = EventType{"user.deleted"}
+)
+
+// personalDataKeys are the metadata keys that identify a person rather
+// than an account, dropped when the account is deleted.
+var personalDataKeys = []string{"email", "ip"}
+
+// ---------- Types ----------
+
+type EventID uuid.UUID
+
+type EventType struct {
+	value string
+}
+
+type Event struct {
+	ID EventID
+	// UserID is nil for events that cannot be tied to an account.
+	UserID    *user.UserID
+	Type      EventType
+	Metadata  map[string]any
+	CreatedAt time.Time
+}
+
+// ---------- EventType ----------
+
+func (t EventType) String() string {
+	return t.value
+}
+
+// ---------- Event ----------
+
+func NewEvent(eventType EventType, userID *user.UserID, metadata map[string]any) *Event {
+	if metadata == nil {
+		metadata = map[string]any{}
+	}
+
+	return &Event{
+		UserID:   userID,
+		Type:     eventType,
+		Metadata: metadata,
+	}
+}
+
+func (id EventID) String() string {
+	return uuid.UUID(id).String()
+}
@@ -0,0 +1,14 @@
+package audit
+
+import (
+	"context"
+
+	"github.com/raphico/go-device-telemetry-api/internal/user"
+)
+
+type Repository interface {
+	Create(ctx context.Context, e *Event) error
+	// RemoveMetadata drops keys from the metadata of userID's events and of
+	// events recorded for email without an account.
+	RemoveMetadata(ctx context.Context, userID user.UserID, email string, keys []string) error
+}
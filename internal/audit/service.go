@@ -0,0 +1,50 @@
+package audit
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/raphico/go-device-telemetry-api/internal/logger"
+	"github.com/raphico/go-device-telemetry-api/internal/user"
+)
+
+type Service struct {
+	repo Repository
+	log  *logger.Logger
+}
+
+func NewService(repo Repository, log *logger.Logger) *Service {
+	return &Service{
+		repo: repo,
+		log:  log,
+	}
+}
+
+// Record logs a security event and stores it. A failure to store is logged
+// rather than returned: the flow that raised the event must not fail
+// because the audit log is unavailable.
+func (s *Service) Record(
+	ctx context.Context,
+	eventType EventType,
+	userID *user.UserID,
+	metadata map[string]any,
+) {
+	e := NewEvent(eventType, userID, metadata)
+
+	subject := "-"
+	if userID != nil {
+		subject = userID.String()
+	}
+	s.log.Info(fmt.Sprintf("security event: type=%s user=%s metadata=%v", eventType, subject, e.Metadata))
+
+	if err := s.repo.Create(context.WithoutCancel(ctx), e); err != nil {
+		s.log.Error(fmt.Sprintf("failed to record %s event: %v", eventType, err))
+	}
+}
+
+// ScrubPersonalData removes email addresses and IPs from the events of a
+// user about to be deleted, including lockouts of their address recorded
+// before it had an account. The events themselves stay.
+func (s *Service) ScrubPersonalData(ctx context.Context, userID user.UserID, email user.Email) error {
+	return s.repo.RemoveMetadata(ctx, userID, email.String(), personalDataKeys)
+}
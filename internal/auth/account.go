@@ -0,0 +1,151 @@
+package auth
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/raphico/go-device-telemetry-api/internal/audit"
+	"github.com/raphico/go-device-telemetry-api/internal/authz"
+	"github.com/raphico/go-device-telemetry-api/internal/organization"
+	"github.com/raphico/go-device-telemetry-api/internal/token"
+	"github.com/raphico/go-device-telemetry-api/internal/user"
+)
+
+func (s *Service) Profile(ctx context.Context, userID user.UserID) (*user.User, error) {
+	return s.user.GetUser(ctx, userID)
+}
+
+// UpdateProfile changes userID's username and requests an email change,
+// leaving nil ones as they are. A new address takes the current password,
+// checked like a login, or for an account without one a recent login on the
+// session accessToken belongs to. It only becomes the account's email once
+// the verification link mailed to it is followed, so nobody can sign in as
+// or take over an address they do not own.
+func (s *Service) UpdateProfile(
+	ctx context.Context,
+	userID user.UserID,
+	accessToken string,
+	username *user.Username,
+	email *user.Email,
+	password string,
+	client token.ClientInfo,
+) (*user.User, error) {
+	current, err := s.user.GetUser(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	emailChanged := email != nil && *email != current.Email &&
+		(current.PendingEmail == nil || *email != *current.PendingEmail)
+	if emailChanged {
+		if err := s.reauthenticate(ctx, current, accessToken, password, "", false, client); err != nil {
+			return nil, err
+		}
+	}
+
+	u, err := s.user.UpdateProfile(ctx, userID, username, email)
+	if err != nil {
+		return nil, err
+	}
+
+	if !emailChanged {
+		return u, nil
+	}
+
+	// the request is saved either way; a lost email can be sent again
+	// through ResendVerification
+	if err := s.sendVerification(ctx, u); err != nil {
+		s.log.Error(fmt.Sprintf("failed to send verification email: %v", err))
+	}
+
+	return u, nil
+}
+
+// DeleteAccount re-authenticates the user like DisableMFA, or for an
+// account with neither a password nor two-factor authentication through a
+// recent login on the session accessToken belongs to, and then deletes
+// their account for good: sessions, personal access tokens and identities
+// go with it, as do the organizations no one else belongs to along with
+// their devices, telemetry and commands. Audit events stay, no longer tied
+// to the user, but lose the email addresses and IPs in their metadata, and
+// the user's export artifacts are deleted. Every session is ended and
+// accessToken, the one the request came with, is denylisted, so no access
+// token outlives the account.
+//
+// Users who alone own an organization that has other members must hand it
+// over first; until then this fails with organization.ErrLastOwner.
+func (s *Service) DeleteAccount(
+	ctx context.Context,
+	userID user.UserID,
+	accessToken string,
+	password string,
+	code string,
+	client token.ClientInfo,
+) error {
+	u, err := s.user.GetUser(ctx, userID)
+	if err != nil {
+		return err
+	}
+
+	mfaEnabled, err := s.mfa.IsEnabled(ctx, userID)
+	if err != nil {
+		return err
+	}
+
+	if err := s.reauthenticate(ctx, u, accessToken, password, code, mfaEnabled, client); err != nil {
+		return err
+	}
+
+	// checked up front so a refused deletion leaves the access token alone
+	owned, err := s.organizations.SoleOwnerships(ctx, authz.NewActor(userID))
+	if err != nil {
+		return err
+	}
+	if len(owned) > 0 {
+		names := make([]string, 0, len(owned))
+		for _, org := range owned {
+			names = append(names, org.Name.String())
+		}
+		return fmt.Errorf("%w: transfer ownership of %s first", organization.ErrLastOwner, strings.Join(names, ", "))
+	}
+
+	if err := s.token.RevokeAllRefreshTokens(ctx, userID); err != nil {
+		return err
+	}
+	// access tokens issued before sessions were tracked have no sid
+	if accessToken != "" && !token.IsPersonalAccessToken(accessToken) {
+		if err := s.token.RevokeAccessToken(ctx, accessToken, "", userID); err != nil {
+			return err
+		}
+	}
+
+	// the jobs holding the keys go with the user
+	artifacts, err := s.exports.ArtifactKeys(ctx, userID)
+	if err != nil {
+		return err
+	}
+
+	// scrubbed first since the events lose their user with the deletion
+	if err := s.audit.ScrubPersonalData(ctx, userID, u.Email); err != nil {
+		return err
+	}
+
+	if err := s.user.DeleteUser(ctx, userID); err != nil {
+		return err
+	}
+
+	s.audit.Record(ctx, audit.EventAccountDeleted, nil, map[string]any{
+		"user_id": userID.String(),
+	})
+
+	// the account is gone either way, so leftovers are only logged
+	if err := s.exports.DeleteArtifacts(ctx, artifacts); err != nil {
+		s.log.Error(fmt.Sprintf("failed to delete export artifacts of deleted user %s: %v", userID, err))
+	}
+	if err := s.lockout.Forget(ctx, u.Email.String()); err != nil {
+		s.log.Error(fmt.Sprintf("failed to forget login failures of deleted user %s: %v", userID, err))
+	}
+
+	return nil
+}
@@ -0,0 +1,8 @@
+package auth
+
+import "errors"
+
+// ErrRecentLoginRequired refuses a sensitive change to an account without
+// a password when its session did not start recently enough to count as
+// proof that the user is present.
+var ErrRecentLoginRequired = errors.New("log in again to confirm this change")
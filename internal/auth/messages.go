@@ -0,0 +1,79 @@
+package auth
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/raphico/go-device-telemetry-api/internal/mail"
+	"github.com/raphico/go-device-telemetry-api/internal/user"
+)
+
+func verificationMessage(u *user.User, link string, expiresAt time.Time) mail.Message {
+	return mail.Message{
+		To:      u.EmailToVerify().String(),
+		Subject: "Verify your email address",
+		Body: fmt.Sprintf(
+			"Hi %s,\n\n"+
+				"Confirm your email address by opening the link below:\n\n"+
+				"%s\n\n"+
+				"The link expires at %s. If you did not create an account, you can ignore this email.\n",
+			u.Username.String(),
+			link,
+			expiresAt.UTC().Format(time.RFC1123),
+		),
+	}
+}
+
+// passwordResetMessage includes link when a reset page is configured, and
+// otherwise the token to submit to the API directly.
+func passwordResetMessage(u *user.User, plaintext string, link string, expiresAt time.Time) mail.Message {
+	instructions := fmt.Sprintf("Use this token to choose a new password:\n\n%s\n\n", plaintext)
+	if link != "" {
+		instructions = fmt.Sprintf("Choose a new password by opening the link below:\n\n%s\n\n", link)
+	}
+
+	return mail.Message{
+		To:      u.Email.String(),
+		Subject: "Reset your password",
+		Body: fmt.Sprintf(
+			"Hi %s,\n\n"+
+				"Someone asked to reset the password for your account. %s"+
+				"This expires at %s and signs you out of every device. "+
+				"If you did not ask for it, you can ignore this email and your password will stay the same.\n",
+			u.Username.String(),
+			instructions,
+			expiresAt.UTC().Format(time.RFC1123),
+		),
+	}
+}
+
+func lockoutMessage(u *user.User, lockedUntil time.Time, ip string) mail.Message {
+	return mail.Message{
+		To:      u.Email.String(),
+		Subject: "Your account was temporarily locked",
+		Body: fmt.Sprintf(
+			"Hi %s,\n\n"+
+				"After too many failed login attempts, the last one from %s, logging into your account is blocked until %s. "+
+				"If these attempts were not yours, someone may be guessing your password; consider changing it once the lock ends.\n",
+			u.Username.String(),
+			ip,
+			lockedUntil.UTC().Format(time.RFC1123),
+		),
+	}
+}
+
+// emailChangedMessage goes to the address an account used to have, so its
+// owner hears about a change they did not make.
+func emailChangedMessage(u *user.User, previous user.Email) mail.Message {
+	return mail.Message{
+		To:      previous.String(),
+		Subject: "Your email address was changed",
+		Body: fmt.Sprintf(
+			"Hi %s,\n\n"+
+				"The email address of your account was changed to %s. "+
+				"If you did not make this change, reset your password and contact support right away.\n",
+			u.Username.String(),
+			u.Email.String(),
+		),
+	}
+}
@@ -0,0 +1,237 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/raphico/go-device-telemetry-api/internal/audit"
+	"github.com/raphico/go-device-telemetry-api/internal/identity"
+	"github.com/raphico/go-device-telemetry-api/internal/token"
+	"github.com/raphico/go-device-telemetry-api/internal/user"
+)
+
+// usernameAttempts bounds how many suffixed usernames are tried when the
+// one derived from the provider's claims is taken.
+const usernameAttempts = 5
+
+var usernameUnsafeChars = regexp.MustCompile(`[^a-zA-Z0-9_.-]+`)
+
+// OIDCResult is the outcome of an identity provider callback: a new
+// session, the challenge to answer through VerifyMFA for users with
+// two-factor authentication or, for flows started by a signed in user, the
+// identity that was linked to their account.
+type OIDCResult struct {
+	AccessToken  string
+	RefreshToken *token.Token
+	MFAChallenge *token.Token
+	Linked       *identity.Identity
+}
+
+// StartOIDCLogin returns the provider URL that starts signing in and the
+// state the callback has to come back with.
+func (s *Service) StartOIDCLogin(ctx context.Context, provider string) (string, string, error) {
+	return s.identities.StartLogin(ctx, provider, nil)
+}
+
+// StartOIDCLink returns the provider URL that starts linking an identity
+// to userID and the state the callback has to come back with.
+func (s *Service) StartOIDCLink(ctx context.Context, userID user.UserID, provider string) (string, string, error) {
+	return s.identities.StartLogin(ctx, provider, &userID)
+}
+
+// CompleteOIDC finishes an authorization code flow. Sign-ins resolve the
+// account in this order: an identity linked earlier, an existing account
+// with the same email address if both the provider and the account have
+// verified it, or a new account. Other accounts with the address are never
+// linked automatically and fail with identity.ErrAccountExists. Users with
+// two-factor authentication get a challenge, as with Login.
+func (s *Service) CompleteOIDC(
+	ctx context.Context,
+	provider, state, code string,
+	client token.ClientInfo,
+) (*OIDCResult, error) {
+	claims, loginState, err := s.identities.CompleteLogin(ctx, provider, state, code)
+	if err != nil {
+		return nil, err
+	}
+
+	if loginState.LinkUserID != nil {
+		linked, err := s.linkIdentity(ctx, *loginState.LinkUserID, claims)
+		if err != nil {
+			return nil, err
+		}
+
+		return &OIDCResult{Linked: linked}, nil
+	}
+
+	userID, err := s.resolveOIDCUser(ctx, claims)
+	if err != nil {
+		return nil, err
+	}
+
+	// the provider vouches for the first factor only, like a password does
+	mfaEnabled, err := s.mfa.IsEnabled(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	if mfaEnabled {
+		challenge, err := s.token.CreateMFAChallengeToken(ctx, userID)
+		if err != nil {
+			return nil, err
+		}
+
+		return &OIDCResult{MFAChallenge: challenge}, nil
+	}
+
+	accessToken, refreshToken, err := s.issueSession(ctx, userID, client)
+	if err != nil {
+		return nil, err
+	}
+
+	return &OIDCResult{AccessToken: accessToken, RefreshToken: refreshToken}, nil
+}
+
+func (s *Service) ListIdentities(ctx context.Context, userID user.UserID) ([]*identity.Identity, error) {
+	return s.identities.ListIdentities(ctx, userID)
+}
+
+// UnlinkIdentity removes one of userID's identities, refusing when it is
+// the only way left to sign in.
+func (s *Service) UnlinkIdentity(ctx context.Context, userID user.UserID, id identity.IdentityID) error {
+	u, err := s.user.GetUser(ctx, userID)
+	if err != nil {
+		return err
+	}
+
+	if !u.Password.IsSet() {
+		identities, err := s.identities.ListIdentities(ctx, userID)
+		if err != nil {
+			return err
+		}
+
+		if len(identities) <= 1 {
+			return identity.ErrLastSignInMethod
+		}
+	}
+
+	if err := s.identities.Unlink(ctx, userID, id); err != nil {
+		return err
+	}
+
+	s.audit.Record(ctx, audit.EventIdentityUnlinked, &userID, map[string]any{
+		"identity_id": id.String(),
+	})
+
+	return nil
+}
+
+func (s *Service) resolveOIDCUser(ctx context.Context, claims *identity.Claims) (user.UserID, error) {
+	linked, err := s.identities.RecordLogin(ctx, claims)
+	if err == nil {
+		return linked.UserID, nil
+	}
+	if !errors.Is(err, identity.ErrIdentityNotFound) {
+		return user.UserID{}, err
+	}
+
+	email, err := user.NewEmail(claims.Email)
+	if err != nil {
+		return user.UserID{}, identity.ErrEmailRequired
+	}
+
+	existing, err := s.user.GetUserByEmail(ctx, email)
+	switch {
+	case err == nil:
+		// an unverified account may have been registered by someone else
+		// ahead of the address's owner, with a password they still know
+		if !claims.EmailVerified || !existing.IsEmailVerified() {
+			return user.UserID{}, identity.ErrAccountExists
+		}
+
+		if _, err := s.linkIdentity(ctx, existing.ID, claims); err != nil {
+			return user.UserID{}, err
+		}
+
+		return existing.ID, nil
+	case !errors.Is(err, user.ErrUserNotFound):
+		return user.UserID{}, err
+	}
+
+	u, err := s.registerOIDCUser(ctx, email, claims)
+	if err != nil {
+		return user.UserID{}, err
+	}
+
+	if _, err := s.linkIdentity(ctx, u.ID, claims); err != nil {
+		return user.UserID{}, err
+	}
+
+	return u.ID, nil
+}
+
+// registerOIDCUser creates a password-less account for claims, deriving the
+// username from the provider's preferred username or the email address.
+func (s *Service) registerOIDCUser(ctx context.Context, email user.Email, claims *identity.Claims) (*user.User, error) {
+	base := claims.PreferredUsername
+	if base == "" {
+		base, _, _ = strings.Cut(email.String(), "@")
+	}
+	base = usernameUnsafeChars.ReplaceAllString(base, "")
+	if len(base) > 24 {
+		base = base[:24]
+	}
+	if len(base) < 3 {
+		base = "user"
+	}
+
+	candidate := base
+	for attempt := 0; ; attempt++ {
+		username, err := user.NewUsername(candidate)
+		if err != nil {
+			return nil, fmt.Errorf("invalid derived username %q: %w", candidate, err)
+		}
+
+		u, err := s.createAccount(ctx, username, email, user.Password{}, claims.EmailVerified)
+		if err == nil {
+			return u, nil
+		}
+		if !errors.Is(err, user.ErrUsernameTaken) || attempt+1 == usernameAttempts {
+			return nil, err
+		}
+
+		suffix, err := randomSuffix()
+		if err != nil {
+			return nil, err
+		}
+		candidate = base + "-" + suffix
+	}
+}
+
+func (s *Service) linkIdentity(ctx context.Context, userID user.UserID, claims *identity.Claims) (*identity.Identity, error) {
+	linked, err := s.identities.Link(ctx, userID, claims)
+	if err != nil {
+		return nil, err
+	}
+
+	s.audit.Record(ctx, audit.EventIdentityLinked, &userID, map[string]any{
+		"identity_id": linked.ID.String(),
+		"provider":    linked.Provider,
+	})
+
+	return linked, nil
+}
+
+func randomSuffix() (string, error) {
+	b := make([]byte, 2)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("failed to generate username suffix: %w", err)
+	}
+
+	return hex.EncodeToString(b), nil
+}
@@ -2,20 +2,68 @@ package auth
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"time"
 
+	"github.com/raphico/go-device-telemetry-api/internal/audit"
+	"github.com/raphico/go-device-telemetry-api/internal/export"
+	"github.com/raphico/go-device-telemetry-api/internal/identity"
+	"github.com/raphico/go-device-telemetry-api/internal/lockout"
+	"github.com/raphico/go-device-telemetry-api/internal/logger"
+	"github.com/raphico/go-device-telemetry-api/internal/mail"
+	"github.com/raphico/go-device-telemetry-api/internal/mfa"
+	"github.com/raphico/go-device-telemetry-api/internal/organization"
 	"github.com/raphico/go-device-telemetry-api/internal/token"
 	"github.com/raphico/go-device-telemetry-api/internal/user"
 )
 
 type Service struct {
-	user  *user.Service
-	token *token.Service
+	log           *logger.Logger
+	user          *user.Service
+	token         *token.Service
+	organizations *organization.Service
+	identities    *identity.Service
+	mfa           *mfa.Service
+	lockout       *lockout.Service
+	exports       *export.Service
+	audit         *audit.Service
+	mailer        mail.Mailer
+	// verifyURL and resetURL are where emailed links point; the token is
+	// added as the token query parameter. resetURL may be empty, in which
+	// case reset emails carry the bare token.
+	verifyURL string
+	resetURL  string
 }
 
-func NewService(userService *user.Service, tokenService *token.Service) *Service {
+func NewService(
+	log *logger.Logger,
+	userService *user.Service,
+	tokenService *token.Service,
+	organizationService *organization.Service,
+	identityService *identity.Service,
+	mfaService *mfa.Service,
+	lockoutService *lockout.Service,
+	exportService *export.Service,
+	auditService *audit.Service,
+	mailer mail.Mailer,
+	verifyURL string,
+	resetURL string,
+) *Service {
 	return &Service{
-		user:  userService,
-		token: tokenService,
+		log:           log,
+		user:          userService,
+		token:         tokenService,
+		organizations: organizationService,
+		identities:    identityService,
+		mfa:           mfaService,
+		lockout:       lockoutService,
+		exports:       exportService,
+		audit:         auditService,
+		mailer:        mailer,
+		verifyURL:     verifyURL,
+		resetURL:      resetURL,
 	}
 }
 
@@ -25,21 +73,488 @@ func (s *Service) Register(
 	email user.Email,
 	password user.Password,
 ) (*user.User, error) {
-	return s.user.RegisterUser(ctx, username, email, password)
+	return s.createAccount(ctx, username, email, password, false)
 }
 
-func (s *Service) Login(ctx context.Context, email user.Email, rawPassword string) (string, *token.Token, error) {
-	u, err := s.user.AuthenticateUser(ctx, email, rawPassword)
+// createAccount registers a user, who gets a personal organization so
+// devices can be created without naming one, and, unless the address is
+// already known to be theirs, emails a verification link.
+func (s *Service) createAccount(
+	ctx context.Context,
+	username user.Username,
+	email user.Email,
+	password user.Password,
+	emailVerified bool,
+) (*user.User, error) {
+	u, err := s.user.RegisterUser(ctx, username, email, password, emailVerified)
+	if err != nil {
+		return nil, err
+	}
+
+	if emailVerified {
+		return u, nil
+	}
+
+	// the account exists either way; a lost email can be sent again through
+	// ResendVerification
+	if err := s.sendVerification(ctx, u); err != nil {
+		s.log.Error(fmt.Sprintf("failed to send verification email: %v", err))
+	}
+
+	return u, nil
+}
+
+// VerifyEmail consumes a verification token and marks the address it was
+// sent to as verified, which completes a pending email change. Invalid
+// tokens fail with token.ErrTokenNotFound.
+func (s *Service) VerifyEmail(ctx context.Context, plaintext string) error {
+	userID, err := s.token.ConsumeEmailVerificationToken(ctx, plaintext)
+	if err != nil {
+		return err
+	}
+
+	u, previous, err := s.user.ConfirmEmail(ctx, userID)
+	if err != nil {
+		return err
+	}
+
+	if u.Email == previous {
+		return nil
+	}
+
+	s.audit.Record(ctx, audit.EventEmailChanged, &userID, nil)
+
+	if err := s.mailer.Send(ctx, emailChangedMessage(u, previous)); err != nil {
+		s.log.Error(fmt.Sprintf("failed to send email change notice: %v", err))
+	}
+
+	return nil
+}
+
+// ResendVerification emails a fresh verification link, to the pending email
+// while a change is waiting, invalidating earlier ones. Like ForgotPassword
+// it works in the background, ignoring unknown and already verified
+// addresses, so callers cannot probe which emails are registered; failures
+// are only logged.
+func (s *Service) ResendVerification(ctx context.Context, email user.Email) {
+	go func() {
+		if err := s.resendVerification(context.WithoutCancel(ctx), email); err != nil {
+			s.log.Error(fmt.Sprintf("failed to resend verification email: %v", err))
+		}
+	}()
+}
+
+func (s *Service) resendVerification(ctx context.Context, email user.Email) error {
+	u, err := s.user.GetUserByEmail(ctx, email)
+	if err != nil {
+		if errors.Is(err, user.ErrUserNotFound) {
+			return nil
+		}
+		return err
+	}
+
+	if u.IsEmailVerified() && u.PendingEmail == nil {
+		return nil
+	}
+
+	return s.sendVerification(ctx, u)
+}
+
+// ForgotPassword emails a password reset token. The lookup and the email
+// happen in the background so neither the outcome nor the time taken
+// reveals whether the address is registered; failures are only logged.
+func (s *Service) ForgotPassword(ctx context.Context, email user.Email) {
+	go func() {
+		if err := s.sendPasswordReset(context.WithoutCancel(ctx), email); err != nil {
+			s.log.Error(fmt.Sprintf("failed to send password reset email: %v", err))
+		}
+	}()
+}
+
+// sendPasswordReset emails a reset token to the owner of email, ignoring
+// unknown addresses.
+func (s *Service) sendPasswordReset(ctx context.Context, email user.Email) error {
+	u, err := s.user.GetUserByEmail(ctx, email)
+	if err != nil {
+		if errors.Is(err, user.ErrUserNotFound) {
+			return nil
+		}
+		return err
+	}
+
+	t, err := s.token.CreatePasswordResetToken(ctx, u.ID)
+	if err != nil {
+		return err
+	}
+
+	link := ""
+	if s.resetURL != "" {
+		if link, err = withToken(s.resetURL, t.Plaintext); err != nil {
+			return fmt.Errorf("invalid password reset url: %w", err)
+		}
+	}
+
+	return s.mailer.Send(ctx, passwordResetMessage(u, t.Plaintext, link, t.ExpiresAt))
+}
+
+// ResetPassword sets a new password using a reset token and signs the user
+// out everywhere. Invalid tokens fail with token.ErrTokenNotFound.
+func (s *Service) ResetPassword(ctx context.Context, plaintext string, password user.Password) error {
+	userID, err := s.token.ConsumePasswordResetToken(ctx, plaintext)
+	if err != nil {
+		return err
+	}
+
+	if err := s.user.ResetPassword(ctx, userID, password); err != nil {
+		return err
+	}
+
+	// the token arrived by email, which proves the address as well as a
+	// verification link would
+	if err := s.user.VerifyEmail(ctx, userID); err != nil {
+		return err
+	}
+
+	return s.token.RevokeAllRefreshTokens(ctx, userID)
+}
+
+// ChangePassword replaces userID's password after checking the current one
+// like Login does, lockout included, and signs the user out everywhere,
+// this session included.
+func (s *Service) ChangePassword(
+	ctx context.Context,
+	userID user.UserID,
+	currentPassword string,
+	newPassword user.Password,
+	client token.ClientInfo,
+) error {
+	u, err := s.user.GetUser(ctx, userID)
+	if err != nil {
+		return err
+	}
+
+	attempt, err := s.checkCredentials(ctx, u.Email, client, func() error {
+		return s.user.ChangePassword(ctx, userID, currentPassword, newPassword)
+	})
+	if err != nil {
+		return err
+	}
+
+	if err := s.lockout.Succeed(ctx, attempt); err != nil {
+		return err
+	}
+
+	return s.token.RevokeAllRefreshTokens(ctx, userID)
+}
+
+func (s *Service) sendVerification(ctx context.Context, u *user.User) error {
+	t, err := s.token.CreateEmailVerificationToken(ctx, u.ID)
+	if err != nil {
+		return err
+	}
+
+	link, err := withToken(s.verifyURL, t.Plaintext)
+	if err != nil {
+		return fmt.Errorf("invalid verification url: %w", err)
+	}
+
+	return s.mailer.Send(ctx, verificationMessage(u, link, t.ExpiresAt))
+}
+
+func withToken(rawURL string, plaintext string) (string, error) {
+	link, err := url.Parse(rawURL)
+	if err != nil {
+		return "", err
+	}
+
+	q := link.Query()
+	q.Set("token", plaintext)
+	link.RawQuery = q.Encode()
+
+	return link.String(), nil
+}
+
+// LoginResult is either a new session or, for users with two-factor
+// authentication, the challenge to answer through VerifyMFA.
+type LoginResult struct {
+	AccessToken  string
+	RefreshToken *token.Token
+	MFAChallenge *token.Token
+}
+
+// Login checks a password, failing with a *lockout.LockedError while too
+// many recent failures block the account or client IP. Unknown addresses
+// are tracked and answered like registered ones.
+func (s *Service) Login(
+	ctx context.Context,
+	email user.Email,
+	rawPassword string,
+	client token.ClientInfo,
+) (*LoginResult, error) {
+	var u *user.User
+	attempt, err := s.checkCredentials(ctx, email, client, func() error {
+		var err error
+		u, err = s.user.AuthenticateUser(ctx, email, rawPassword)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	mfaEnabled, err := s.mfa.IsEnabled(ctx, u.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	if mfaEnabled {
+		// failures are only forgotten once the second factor is in too
+		if err := s.lockout.Forgive(ctx, attempt); err != nil {
+			return nil, err
+		}
+
+		challenge, err := s.token.CreateMFAChallengeToken(ctx, u.ID)
+		if err != nil {
+			return nil, err
+		}
+
+		return &LoginResult{MFAChallenge: challenge}, nil
+	}
+
+	if err := s.lockout.Succeed(ctx, attempt); err != nil {
+		return nil, err
+	}
+
+	accessToken, refreshToken, err := s.issueSession(ctx, u.ID, client)
+	if err != nil {
+		return nil, err
+	}
+
+	return &LoginResult{AccessToken: accessToken, RefreshToken: refreshToken}, nil
+}
+
+// VerifyMFA completes a login that returned an MFA challenge, given a TOTP
+// or recovery code. A wrong code counts as a failed login and leaves the
+// challenge usable until it expires; unknown and used challenges fail with
+// token.ErrTokenNotFound.
+func (s *Service) VerifyMFA(
+	ctx context.Context,
+	challenge string,
+	code string,
+	client token.ClientInfo,
+) (string, *token.Token, error) {
+	userID, err := s.token.MFAChallengeUser(ctx, challenge)
 	if err != nil {
 		return "", nil, err
 	}
 
-	accessToken, err := s.token.GenerateAccessToken(u.ID)
+	u, err := s.user.GetUser(ctx, userID)
 	if err != nil {
 		return "", nil, err
 	}
 
-	refreshToken, err := s.token.CreateRefreshToken(ctx, u.ID)
+	attempt, err := s.checkCredentials(ctx, u.Email, client, func() error {
+		return s.mfa.Verify(ctx, userID, code)
+	})
+	if err != nil {
+		return "", nil, err
+	}
+
+	if _, err := s.token.ConsumeMFAChallengeToken(ctx, challenge); err != nil {
+		return "", nil, err
+	}
+
+	if err := s.lockout.Succeed(ctx, attempt); err != nil {
+		return "", nil, err
+	}
+
+	return s.issueSession(ctx, userID, client)
+}
+
+// checkCredentials runs check, which verifies a password or second factor
+// given for the account tried as email, as a login attempt: it fails with a
+// *lockout.LockedError while the account or client IP is blocked, and is
+// counted before check runs so concurrent guesses cannot all slip in ahead
+// of the count. Wrong credentials stay counted; other errors give the
+// attempt back. When check passes, the caller ends the attempt with
+// Succeed or Forgive.
+func (s *Service) checkCredentials(
+	ctx context.Context,
+	email user.Email,
+	client token.ClientInfo,
+	check func() error,
+) (*lockout.Attempt, error) {
+	attempt, err := s.lockout.Begin(ctx, email.String(), client.IP)
+	if err != nil {
+		return nil, err
+	}
+
+	err = check()
+	switch {
+	case err == nil:
+		return attempt, nil
+	case errors.Is(err, user.ErrInvalidCredentials), errors.Is(err, mfa.ErrInvalidCode):
+		s.reportLockouts(ctx, email, client, attempt)
+	default:
+		if err := s.lockout.Forgive(ctx, attempt); err != nil {
+			s.log.Error(fmt.Sprintf("failed to forgive login attempt: %v", err))
+		}
+	}
+
+	return nil, err
+}
+
+// reportLockouts records the lockouts a failed attempt started.
+func (s *Service) reportLockouts(ctx context.Context, email user.Email, client token.ClientInfo, attempt *lockout.Attempt) {
+	if attempt.IPLocked {
+		s.audit.Record(ctx, audit.EventLoginIPLocked, nil, map[string]any{
+			"ip":           client.IP,
+			"locked_until": attempt.LockedUntil,
+		})
+	}
+
+	if attempt.AccountLocked {
+		// detached so registered addresses, which get an email, are not
+		// answered more slowly than unknown ones
+		go s.reportAccountLockout(context.WithoutCancel(ctx), email, attempt.LockedUntil, client)
+	}
+}
+
+// reportAccountLockout records a lockout and, when email belongs to an
+// account, tells its owner.
+func (s *Service) reportAccountLockout(
+	ctx context.Context,
+	email user.Email,
+	lockedUntil time.Time,
+	client token.ClientInfo,
+) {
+	metadata := map[string]any{
+		"email":        email.String(),
+		"ip":           client.IP,
+		"locked_until": lockedUntil,
+	}
+
+	u, err := s.user.GetUserByEmail(ctx, email)
+	if err != nil {
+		if !errors.Is(err, user.ErrUserNotFound) {
+			s.log.Error(fmt.Sprintf("failed to look up locked account: %v", err))
+		}
+		s.audit.Record(ctx, audit.EventLoginLocked, nil, metadata)
+		return
+	}
+
+	s.audit.Record(ctx, audit.EventLoginLocked, &u.ID, metadata)
+
+	if err := s.mailer.Send(ctx, lockoutMessage(u, lockedUntil, client.IP)); err != nil {
+		s.log.Error(fmt.Sprintf("failed to send lockout email: %v", err))
+	}
+}
+
+// EnrollMFA starts setting up TOTP for userID, returning the secret and its
+// provisioning URI.
+func (s *Service) EnrollMFA(ctx context.Context, userID user.UserID) (*mfa.Enrollment, string, error) {
+	u, err := s.user.GetUser(ctx, userID)
+	if err != nil {
+		return nil, "", err
+	}
+
+	return s.mfa.Enroll(ctx, u)
+}
+
+// DisableMFA turns two-factor authentication off after re-authenticating
+// the user with their password, when they have one, and a current TOTP or
+// recovery code.
+func (s *Service) DisableMFA(
+	ctx context.Context,
+	userID user.UserID,
+	password string,
+	code string,
+	client token.ClientInfo,
+) error {
+	u, err := s.user.GetUser(ctx, userID)
+	if err != nil {
+		return err
+	}
+
+	if err := s.reauthenticate(ctx, u, "", password, code, true, client); err != nil {
+		return err
+	}
+
+	return s.mfa.Disable(ctx, userID)
+}
+
+// recentLoginWindow is how long after logging in a user without a password,
+// who has nothing else to re-authenticate with, may still make sensitive
+// changes to their account.
+const recentLoginWindow = 5 * time.Minute
+
+// reauthenticate checks the password of a signed in user, when they have
+// one, and with requireCode a TOTP or recovery code, as a login attempt
+// with the same lockout. Users with neither have to have logged in, through
+// single sign-on, within recentLoginWindow on the session accessToken was
+// issued to, or get ErrRecentLoginRequired.
+func (s *Service) reauthenticate(
+	ctx context.Context,
+	u *user.User,
+	accessToken string,
+	password string,
+	code string,
+	requireCode bool,
+	client token.ClientInfo,
+) error {
+	if !u.Password.IsSet() && !requireCode {
+		if err := s.requireRecentLogin(ctx, accessToken); err != nil {
+			return err
+		}
+	}
+
+	attempt, err := s.checkCredentials(ctx, u.Email, client, func() error {
+		if u.Password.IsSet() && !u.Password.Matches(password) {
+			return user.ErrInvalidCredentials
+		}
+
+		if requireCode {
+			return s.mfa.Verify(ctx, u.ID, code)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	return s.lockout.Succeed(ctx, attempt)
+}
+
+// requireRecentLogin fails with ErrRecentLoginRequired unless the session
+// accessToken was issued to started within recentLoginWindow. Personal
+// access tokens, which have no session, never qualify.
+func (s *Service) requireRecentLogin(ctx context.Context, accessToken string) error {
+	if accessToken == "" || token.IsPersonalAccessToken(accessToken) {
+		return ErrRecentLoginRequired
+	}
+
+	startedAt, err := s.token.SessionStartedAt(ctx, accessToken)
+	if errors.Is(err, token.ErrSessionNotFound) {
+		return ErrRecentLoginRequired
+	}
+	if err != nil {
+		return err
+	}
+
+	if time.Since(startedAt) > recentLoginWindow {
+		return ErrRecentLoginRequired
+	}
+
+	return nil
+}
+
+func (s *Service) issueSession(ctx context.Context, userID user.UserID, client token.ClientInfo) (string, *token.Token, error) {
+	refreshToken, err := s.token.CreateRefreshToken(ctx, userID, client)
+	if err != nil {
+		return "", nil, err
+	}
+
+	accessToken, err := s.token.GenerateAccessToken(userID, refreshToken.FamilyID)
 	if err != nil {
 		return "", nil, err
 	}
@@ -47,10 +562,23 @@ func (s *Service) Login(ctx context.Context, email user.Email, rawPassword strin
 	return accessToken, refreshToken, nil
 }
 
-func (s *Service) Refresh(ctx context.Context, refreshTok string) (string, *token.Token, error) {
-	return s.token.RotateTokens(ctx, refreshTok)
+func (s *Service) Refresh(ctx context.Context, refreshTok string, client token.ClientInfo) (string, *token.Token, error) {
+	return s.token.RotateTokens(ctx, refreshTok, client)
 }
 
-func (s *Service) Logout(ctx context.Context, refreshTok string) error {
+// Logout ends the sessions of refreshTok and accessToken, which are the
+// same one unless the client mixed them up, so neither token works after.
+// Either may be empty.
+func (s *Service) Logout(ctx context.Context, refreshTok string, accessToken string) error {
+	if accessToken != "" {
+		if err := s.token.EndAccessTokenSession(ctx, accessToken); err != nil {
+			return err
+		}
+	}
+
+	if refreshTok == "" {
+		return nil
+	}
+
 	return s.token.RevokeRefreshToken(ctx, refreshTok)
 }
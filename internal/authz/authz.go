@@ -0,0 +1,131 @@
+package authz
+
+import (
+	"context"
+
+	"github.com/google/uuid"
+	"github.com/raphico/go-device-telemetry-api/internal/user"
+)
+
+var (
+	RoleOwner    = Role{value: "owner", rank: 4}
+	RoleAdmin    = Role{value: "admin", rank: 3}
+	RoleOperator = Role{value: "operator", rank: 2}
+	RoleViewer   = Role{value: "viewer", rank: 1}
+)
+
+// ---------- Types ----------
+
+// Actor is the user a service call is made on behalf of.
+type Actor struct {
+	UserID user.UserID
+}
+
+// Role is a member's standing in an organization. Each role can do
+// everything the roles below it can.
+type Role struct {
+	value string
+	rank  int
+}
+
+// Permission is an action guarded by a minimum role.
+type Permission string
+
+const (
+	PermOrganizationRead   Permission = "organization:read"
+	PermOrganizationManage Permission = "organization:manage"
+	PermMembersManage      Permission = "members:manage"
+	PermDeviceRead         Permission = "device:read"
+	PermDeviceWrite        Permission = "device:write"
+	PermTelemetryRead      Permission = "telemetry:read"
+	PermTelemetryWrite     Permission = "telemetry:write"
+	PermCommandRead        Permission = "command:read"
+	PermCommandWrite       Permission = "command:write"
+)
+
+// minimumRole is the permission matrix: viewers read, operators also ingest
+// telemetry and send commands, admins also manage devices and members, and
+// owners also manage the organization itself.
+var minimumRole = map[Permission]Role{
+	PermOrganizationRead:   RoleViewer,
+	PermDeviceRead:         RoleViewer,
+	PermTelemetryRead:      RoleViewer,
+	PermCommandRead:        RoleViewer,
+	PermTelemetryWrite:     RoleOperator,
+	PermCommandWrite:       RoleOperator,
+	PermDeviceWrite:        RoleAdmin,
+	PermMembersManage:      RoleAdmin,
+	PermOrganizationManage: RoleOwner,
+}
+
+// MembershipFinder looks up a user's role in an organization, returning
+// ErrNotMember when they have none.
+type MembershipFinder interface {
+	FindRole(ctx context.Context, organizationID uuid.UUID, userID user.UserID) (Role, error)
+}
+
+// Authorizer decides whether an actor may act on an organization's
+// resources.
+type Authorizer struct {
+	members MembershipFinder
+}
+
+// ---------- Actor ----------
+
+func NewActor(userID user.UserID) Actor {
+	return Actor{UserID: userID}
+}
+
+// ---------- Role ----------
+
+func NewRole(value string) (Role, error) {
+	for _, r := range []Role{RoleOwner, RoleAdmin, RoleOperator, RoleViewer} {
+		if r.value == value {
+			return r, nil
+		}
+	}
+
+	return Role{}, ErrInvalidRole
+}
+
+func (r Role) String() string {
+	return r.value
+}
+
+// AtLeast reports whether r ranks the same as or above other.
+func (r Role) AtLeast(other Role) bool {
+	return r.rank >= other.rank
+}
+
+func (r Role) Can(p Permission) bool {
+	required, ok := minimumRole[p]
+	return ok && r.AtLeast(required)
+}
+
+// ---------- Authorizer ----------
+
+func NewAuthorizer(members MembershipFinder) *Authorizer {
+	return &Authorizer{members: members}
+}
+
+// Authorize checks that actor belongs to the organization with a role that
+// grants perm, and returns that role. It fails with ErrNotMember for
+// outsiders, which callers usually report as not found, and ErrForbidden for
+// members whose role is too low.
+func (a *Authorizer) Authorize(
+	ctx context.Context,
+	actor Actor,
+	organizationID uuid.UUID,
+	perm Permission,
+) (Role, error) {
+	role, err := a.members.FindRole(ctx, organizationID, actor.UserID)
+	if err != nil {
+		return Role{}, err
+	}
+
+	if !role.Can(perm) {
+		return role, ErrForbidden
+	}
+
+	return role, nil
+}
@@ -0,0 +1,9 @@
+package authz
+
+import "errors"
+
+var (
+	ErrForbidden   = errors.New("role does not allow this action")
+	ErrNotMember   = errors.New("not a member of the organization")
+	ErrInvalidRole = errors.New("role must be owner, admin, operator or viewer")
+)
@@ -3,24 +3,42 @@ package command
 import (
 	"context"
 
+	"github.com/raphico/go-device-telemetry-api/internal/authz"
 	"github.com/raphico/go-device-telemetry-api/internal/common/pagination"
 	"github.com/raphico/go-device-telemetry-api/internal/device"
 )
 
+// DeviceAuthorizer checks an actor's permission on a device's organization,
+// failing with device.ErrDeviceNotFound or authz.ErrForbidden.
+type DeviceAuthorizer interface {
+	AuthorizeDevice(
+		ctx context.Context,
+		actor authz.Actor,
+		id device.DeviceID,
+		perm authz.Permission,
+	) (*device.Device, error)
+}
+
 type Service struct {
-	repo Repository
+	repo    Repository
+	devices DeviceAuthorizer
 }
 
-func NewService(repo Repository) *Service {
-	return &Service{repo: repo}
+func NewService(repo Repository, devices DeviceAuthorizer) *Service {
+	return &Service{repo: repo, devices: devices}
 }
 
 func (s *Service) CreateCommand(
 	ctx context.Context,
+	actor authz.Actor,
 	deviceID device.DeviceID,
 	name Name,
 	payload Payload,
 ) (*Command, error) {
+	if _, err := s.devices.AuthorizeDevice(ctx, actor, deviceID, authz.PermCommandWrite); err != nil {
+		return nil, err
+	}
+
 	cmd := NewCommand(deviceID, name, payload)
 
 	err := s.repo.Create(ctx, cmd)
@@ -33,20 +51,30 @@ func (s *Service) CreateCommand(
 
 func (s *Service) ListDeviceCommands(
 	ctx context.Context,
+	actor authz.Actor,
 	deviceID device.DeviceID,
 	limit int,
 	cursor *pagination.Cursor,
 ) ([]*Command, *pagination.Cursor, error) {
+	if _, err := s.devices.AuthorizeDevice(ctx, actor, deviceID, authz.PermCommandRead); err != nil {
+		return nil, nil, err
+	}
+
 	return s.repo.FindCommands(ctx, deviceID, limit, cursor)
 }
 
 func (s *Service) UpdateCommandStatus(
 	ctx context.Context,
+	actor authz.Actor,
 	id CommandID,
 	deviceID device.DeviceID,
 	status Status,
 	executedAt ExecutedAt,
 ) (*Command, error) {
+	if _, err := s.devices.AuthorizeDevice(ctx, actor, deviceID, authz.PermCommandWrite); err != nil {
+		return nil, err
+	}
+
 	cmd, err := s.repo.FindById(ctx, id, deviceID)
 	if err != nil {
 		return nil, err
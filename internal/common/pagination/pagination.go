@@ -15,41 +15,86 @@ const (
 	MaxLimit     = 10
 )
 
+// recordedAtPrefix marks recorded_at cursors so one cannot be passed off as
+// a created_at cursor or the other way round.
+const recordedAtPrefix = "r"
+
 type Cursor struct {
 	ID        uuid.UUID
 	CreatedAt time.Time
 }
 
+// RecordedAtCursor pages through telemetry in device time, by
+// (recorded_at, id).
+type RecordedAtCursor struct {
+	ID         uuid.UUID
+	RecordedAt time.Time
+}
+
 func Encode(c Cursor) string {
 	payload := fmt.Sprintf("%d|%s", c.CreatedAt.UTC().UnixNano(), c.ID.String())
 	return base64.RawURLEncoding.EncodeToString([]byte(payload))
 }
 
 func Decode(raw string) (Cursor, error) {
+	ts, id, err := decode(raw, "")
+	if err != nil {
+		return Cursor{}, err
+	}
+
+	return Cursor{
+		CreatedAt: ts,
+		ID:        id,
+	}, nil
+}
+
+func EncodeRecordedAt(c RecordedAtCursor) string {
+	payload := fmt.Sprintf("%s|%d|%s", recordedAtPrefix, c.RecordedAt.UTC().UnixNano(), c.ID.String())
+	return base64.RawURLEncoding.EncodeToString([]byte(payload))
+}
+
+func DecodeRecordedAt(raw string) (RecordedAtCursor, error) {
+	ts, id, err := decode(raw, recordedAtPrefix)
+	if err != nil {
+		return RecordedAtCursor{}, err
+	}
+
+	return RecordedAtCursor{
+		RecordedAt: ts,
+		ID:         id,
+	}, nil
+}
+
+func decode(raw string, prefix string) (time.Time, uuid.UUID, error) {
 	data, err := base64.RawURLEncoding.DecodeString(raw)
 	if err != nil {
-		return Cursor{}, fmt.Errorf("invalid cursor encoding")
+		return time.Time{}, uuid.Nil, fmt.Errorf("invalid cursor encoding")
+	}
+
+	payload := string(data)
+	if prefix != "" {
+		var ok bool
+		if payload, ok = strings.CutPrefix(payload, prefix+"|"); !ok {
+			return time.Time{}, uuid.Nil, fmt.Errorf("invalid cursor format")
+		}
 	}
 
-	parts := strings.SplitN(string(data), "|", 2)
+	parts := strings.SplitN(payload, "|", 2)
 	if len(parts) != 2 {
-		return Cursor{}, fmt.Errorf("invalid cursor format")
+		return time.Time{}, uuid.Nil, fmt.Errorf("invalid cursor format")
 	}
 
 	id, err := uuid.Parse(parts[1])
 	if err != nil {
-		return Cursor{}, fmt.Errorf("invalid cursor uuid")
+		return time.Time{}, uuid.Nil, fmt.Errorf("invalid cursor uuid")
 	}
 
 	ts, err := strconv.ParseInt(parts[0], 10, 64)
 	if err != nil {
-		return Cursor{}, err
+		return time.Time{}, uuid.Nil, err
 	}
 
-	return Cursor{
-		CreatedAt: time.Unix(0, ts).UTC(),
-		ID:        id,
-	}, nil
+	return time.Unix(0, ts).UTC(), id, nil
 }
 
 func NewCursor(id uuid.UUID, createdAt time.Time) *Cursor {
@@ -59,6 +104,13 @@ func NewCursor(id uuid.UUID, createdAt time.Time) *Cursor {
 	}
 }
 
+func NewRecordedAtCursor(id uuid.UUID, recordedAt time.Time) *RecordedAtCursor {
+	return &RecordedAtCursor{
+		ID:         id,
+		RecordedAt: recordedAt,
+	}
+}
+
 func ClampLimit(n int) int {
 	if n <= 0 {
 		return DefaultLimit
@@ -1,34 +1,99 @@
 package config
 
 import (
+	"net/netip"
 	"os"
+	"regexp"
+	"strconv"
+	"strings"
 	"time"
 )
 
+var oidcProviderNameRegex = regexp.MustCompile(`^[a-z0-9-]+$`)
+
 type Config struct {
-	DatabaseURL     string
-	HTTPAddr        string
-	JWTSecret       string
-	AccessTokenTTL  time.Duration
-	RefreshTokenTTL time.Duration
-	Env             string
+	DatabaseURL              string
+	HTTPAddr                 string
+	JWTSecret                string
+	JWTSigningKeyFiles       string
+	JWTIssuer                string
+	JWTAudience              string
+	AccessTokenTTL           time.Duration
+	RefreshTokenTTL          time.Duration
+	RefreshTokenReuseGrace   time.Duration
+	Env                      string
+	TelemetryRetention       time.Duration
+	TelemetryPartitionAhead  int
+	TelemetryPartitionPeriod time.Duration
+	RollupRetentionMinute    time.Duration
+	RollupRetentionHour      time.Duration
+	RollupRetentionDay       time.Duration
+	RollupPeriod             time.Duration
+	PublicBaseURL            string
+	ExportStorage            string
+	ExportDir                string
+	ExportURLTTL             time.Duration
+	ExportPollInterval       time.Duration
+	ExportArtifactTTL        time.Duration
+	ExportSigningSecret      string
+	ExportS3Endpoint         string
+	ExportS3Region           string
+	ExportS3Bucket           string
+	ExportS3AccessKey        string
+	ExportS3SecretKey        string
+	ExportS3UseSSL           bool
+	IdempotencyTTL           time.Duration
+	ClockSkewPolicy          string
+	ClockSkewTolerance       time.Duration
+	ClockSkewMaxSampleAge    time.Duration
+	MaxDecompressedBodyBytes int64
+	RateLimitStore           string
+	RateLimitAuth            string
+	RateLimitAPI             string
+	RateLimitIngest          string
+	RateLimitDevice          string
+	DailyIngestionQuota      int64
+	MailDriver               string
+	MailDir                  string
+	MailFrom                 string
+	SMTPHost                 string
+	SMTPPort                 int
+	SMTPUsername             string
+	SMTPPassword             string
+	EmailVerificationTTL     time.Duration
+	EmailVerificationURL     string
+	UnverifiedAccess         string
+	PasswordResetTTL         time.Duration
+	PasswordResetURL         string
+	OIDCProviders            []OIDCProvider
+	OIDCStateTTL             time.Duration
+	MFAIssuer                string
+	MFAChallengeTTL          time.Duration
+	LoginFailureWindow       time.Duration
+	LoginDelayAfter          int
+	LoginBaseDelay           time.Duration
+	LoginMaxDelay            time.Duration
+	LoginLockoutThreshold    int
+	LoginIPLockoutThreshold  int
+	LoginLockoutDuration     time.Duration
+	TrustedProxies           []netip.Prefix
+	AccountExportTimeout     time.Duration
+	TelemetryImportTimeout   time.Duration
 }
 
-func Load() Config {
-	accessTokenTTL := 15 * time.Minute
-	refreshTokenTTL := 7 * 24 * time.Hour
-
-	if v := os.Getenv("ACCESS_TOKEN_TTL"); v != "" {
-		if d, err := time.ParseDuration(v); err == nil {
-			accessTokenTTL = d
-		}
-	}
+// OIDCProvider is an OpenID Connect identity provider users can sign in
+// with. Its callback is PUBLIC_BASE_URL/api/v1/auth/oidc/<name>/callback.
+type OIDCProvider struct {
+	Name         string
+	IssuerURL    string
+	ClientID     string
+	ClientSecret string
+	Scopes       []string // empty means openid, email and profile
+}
 
-	if v := os.Getenv("REFRESH_TOKEN_TTL"); v != "" {
-		if d, err := time.ParseDuration(v); err == nil {
-			refreshTokenTTL = d
-		}
-	}
+func Load() Config {
+	accessTokenTTL := durationEnv("ACCESS_TOKEN_TTL", 15*time.Minute)
+	refreshTokenTTL := durationEnv("REFRESH_TOKEN_TTL", 7*24*time.Hour)
 
 	env := os.Getenv("APP_ENV")
 	if env == "" {
@@ -45,17 +110,196 @@ func Load() Config {
 		port = "8080"
 	}
 
+	jwtSigningKeyFiles := os.Getenv("JWT_SIGNING_KEY_FILES")
 	jwtSecret := os.Getenv("JWT_SECRET")
-	if jwtSecret == "" {
-		panic("JWT_SECRET environment variable is required")
+	if jwtSecret == "" && jwtSigningKeyFiles == "" {
+		panic("JWT_SECRET or JWT_SIGNING_KEY_FILES environment variable is required")
 	}
 
+	// export download links are signed with their own secret, so leaking one
+	// cannot be used to forge access tokens and rotating it logs nobody out
+	exportSigningSecret := os.Getenv("EXPORT_SIGNING_SECRET")
+	if exportSigningSecret == "" {
+		panic("EXPORT_SIGNING_SECRET environment variable is required")
+	}
+	if exportSigningSecret == jwtSecret {
+		panic("EXPORT_SIGNING_SECRET must differ from JWT_SECRET")
+	}
+
+	publicBaseURL := stringEnv("PUBLIC_BASE_URL", "http://localhost:"+port)
+
 	return Config{
-		DatabaseURL:     dbURL,
-		HTTPAddr:        ":" + port,
-		JWTSecret:       jwtSecret,
-		RefreshTokenTTL: refreshTokenTTL,
-		AccessTokenTTL:  accessTokenTTL,
-		Env:             env,
+		DatabaseURL:              dbURL,
+		HTTPAddr:                 ":" + port,
+		JWTSecret:                jwtSecret,
+		JWTSigningKeyFiles:       jwtSigningKeyFiles, // comma-separated PEM files, the first one signs
+		JWTIssuer:                stringEnv("JWT_ISSUER", publicBaseURL),
+		JWTAudience:              stringEnv("JWT_AUDIENCE", "device-telemetry-api"),
+		RefreshTokenTTL:          refreshTokenTTL,
+		RefreshTokenReuseGrace:   durationEnv("REFRESH_TOKEN_REUSE_GRACE", 10*time.Second),
+		AccessTokenTTL:           accessTokenTTL,
+		Env:                      env,
+		TelemetryRetention:       durationEnv("TELEMETRY_RETENTION", 0), // 0 keeps telemetry forever
+		TelemetryPartitionAhead:  intEnv("TELEMETRY_PARTITION_AHEAD", 3),
+		TelemetryPartitionPeriod: durationEnv("TELEMETRY_PARTITION_PERIOD", time.Hour),
+		RollupRetentionMinute:    durationEnv("ROLLUP_RETENTION_1M", 7*24*time.Hour),
+		RollupRetentionHour:      durationEnv("ROLLUP_RETENTION_1H", 90*24*time.Hour),
+		RollupRetentionDay:       durationEnv("ROLLUP_RETENTION_1D", 0),
+		RollupPeriod:             durationEnv("ROLLUP_PERIOD", time.Minute),
+		PublicBaseURL:            publicBaseURL,
+		ExportStorage:            stringEnv("EXPORT_STORAGE", "local"), // local or s3
+		ExportDir:                stringEnv("EXPORT_DIR", "./exports"),
+		ExportURLTTL:             durationEnv("EXPORT_URL_TTL", 15*time.Minute),
+		ExportPollInterval:       durationEnv("EXPORT_POLL_INTERVAL", 5*time.Second),
+		ExportArtifactTTL:        durationEnv("EXPORT_ARTIFACT_TTL", 7*24*time.Hour),
+		ExportSigningSecret:      exportSigningSecret,
+		ExportS3Endpoint:         stringEnv("EXPORT_S3_ENDPOINT", "localhost:9000"),
+		ExportS3Region:           os.Getenv("EXPORT_S3_REGION"),
+		ExportS3Bucket:           stringEnv("EXPORT_S3_BUCKET", "telemetry-exports"),
+		ExportS3AccessKey:        os.Getenv("EXPORT_S3_ACCESS_KEY"),
+		ExportS3SecretKey:        os.Getenv("EXPORT_S3_SECRET_KEY"),
+		ExportS3UseSSL:           boolEnv("EXPORT_S3_USE_SSL", false),
+		IdempotencyTTL:           durationEnv("IDEMPOTENCY_TTL", 24*time.Hour),
+		ClockSkewPolicy:          stringEnv("CLOCK_SKEW_POLICY", "reject"), // reject, correct or flag
+		ClockSkewTolerance:       durationEnv("CLOCK_SKEW_TOLERANCE", 5*time.Minute),
+		ClockSkewMaxSampleAge:    durationEnv("CLOCK_SKEW_MAX_SAMPLE_AGE", time.Hour),
+		MaxDecompressedBodyBytes: int64(intEnv("MAX_DECOMPRESSED_BODY_BYTES", 64<<20)),
+		RateLimitStore:           stringEnv("RATE_LIMIT_STORE", "memory"), // memory or postgres
+		RateLimitAuth:            stringEnv("RATE_LIMIT_AUTH", "20/1m"),   // <count>/<period> or off
+		RateLimitAPI:             stringEnv("RATE_LIMIT_API", "3000/1m"),
+		RateLimitIngest:          stringEnv("RATE_LIMIT_INGEST", "1800/1m"),
+		RateLimitDevice:          stringEnv("RATE_LIMIT_DEVICE", "120/1m"),
+		DailyIngestionQuota:      int64(intEnv("DAILY_INGESTION_QUOTA", 1_000_000)), // 0 disables the quota
+		MailDriver:               stringEnv("MAIL_DRIVER", "log"),                   // log, file or smtp
+		MailDir:                  stringEnv("MAIL_DIR", "./mail"),
+		MailFrom:                 stringEnv("MAIL_FROM", "no-reply@localhost"),
+		SMTPHost:                 os.Getenv("SMTP_HOST"),
+		SMTPPort:                 intEnv("SMTP_PORT", 587),
+		SMTPUsername:             os.Getenv("SMTP_USERNAME"),
+		SMTPPassword:             os.Getenv("SMTP_PASSWORD"),
+		EmailVerificationTTL:     durationEnv("EMAIL_VERIFICATION_TTL", 24*time.Hour),
+		EmailVerificationURL:     stringEnv("EMAIL_VERIFICATION_URL", publicBaseURL+"/api/v1/auth/verify-email"),
+		UnverifiedAccess:         stringEnv("UNVERIFIED_ACCESS", "read_only"), // allow, read_only or block
+		PasswordResetTTL:         durationEnv("PASSWORD_RESET_TTL", time.Hour),
+		PasswordResetURL:         os.Getenv("PASSWORD_RESET_URL"), // empty emails the bare token
+		OIDCProviders:            oidcProvidersEnv(),
+		OIDCStateTTL:             durationEnv("OIDC_STATE_TTL", 10*time.Minute),
+		MFAIssuer:                stringEnv("MFA_ISSUER", "Device Telemetry API"), // shown in authenticator apps
+		MFAChallengeTTL:          durationEnv("MFA_CHALLENGE_TTL", 5*time.Minute),
+		LoginFailureWindow:       durationEnv("LOGIN_FAILURE_WINDOW", 15*time.Minute),
+		LoginDelayAfter:          intEnv("LOGIN_DELAY_AFTER", 3), // 0 disables delays
+		LoginBaseDelay:           durationEnv("LOGIN_BASE_DELAY", time.Second),
+		LoginMaxDelay:            durationEnv("LOGIN_MAX_DELAY", 30*time.Second),
+		LoginLockoutThreshold:    intEnv("LOGIN_LOCKOUT_THRESHOLD", 10), // 0 disables lockout
+		LoginIPLockoutThreshold:  intEnv("LOGIN_IP_LOCKOUT_THRESHOLD", 100),
+		LoginLockoutDuration:     durationEnv("LOGIN_LOCKOUT_DURATION", 15*time.Minute),
+		TrustedProxies:           trustedProxiesEnv(),
+		AccountExportTimeout:     durationEnv("ACCOUNT_EXPORT_TIMEOUT", 10*time.Minute),
+		TelemetryImportTimeout:   durationEnv("TELEMETRY_IMPORT_TIMEOUT", 30*time.Minute),
+	}
+}
+
+// oidcProvidersEnv reads the providers listed in OIDC_PROVIDERS, each
+// configured through OIDC_<NAME>_ISSUER_URL, OIDC_<NAME>_CLIENT_ID,
+// OIDC_<NAME>_CLIENT_SECRET and the space-separated OIDC_<NAME>_SCOPES,
+// where <NAME> is the upper-cased name with dashes replaced by underscores.
+func oidcProvidersEnv() []OIDCProvider {
+	var providers []OIDCProvider
+
+	for _, name := range strings.Split(os.Getenv("OIDC_PROVIDERS"), ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+
+		if !oidcProviderNameRegex.MatchString(name) {
+			panic("OIDC_PROVIDERS names may only contain lowercase letters, numbers and -")
+		}
+
+		prefix := "OIDC_" + strings.ToUpper(strings.ReplaceAll(name, "-", "_")) + "_"
+		p := OIDCProvider{
+			Name:         name,
+			IssuerURL:    os.Getenv(prefix + "ISSUER_URL"),
+			ClientID:     os.Getenv(prefix + "CLIENT_ID"),
+			ClientSecret: os.Getenv(prefix + "CLIENT_SECRET"),
+			Scopes:       strings.Fields(os.Getenv(prefix + "SCOPES")),
+		}
+
+		if p.IssuerURL == "" || p.ClientID == "" {
+			panic(prefix + "ISSUER_URL and " + prefix + "CLIENT_ID environment variables are required")
+		}
+
+		providers = append(providers, p)
+	}
+
+	return providers
+}
+
+// trustedProxiesEnv reads TRUSTED_PROXIES, a comma-separated list of the
+// addresses or CIDR ranges of the proxies in front of the API. Only they
+// may set the client address through X-Forwarded-For or X-Real-IP.
+func trustedProxiesEnv() []netip.Prefix {
+	var prefixes []netip.Prefix
+
+	for _, v := range strings.Split(os.Getenv("TRUSTED_PROXIES"), ",") {
+		v = strings.TrimSpace(v)
+		if v == "" {
+			continue
+		}
+
+		if !strings.Contains(v, "/") {
+			addr, err := netip.ParseAddr(v)
+			if err != nil {
+				panic("TRUSTED_PROXIES entries must be IP addresses or CIDR ranges")
+			}
+			prefixes = append(prefixes, netip.PrefixFrom(addr.Unmap(), addr.Unmap().BitLen()))
+			continue
+		}
+
+		prefix, err := netip.ParsePrefix(v)
+		if err != nil {
+			panic("TRUSTED_PROXIES entries must be IP addresses or CIDR ranges")
+		}
+		prefixes = append(prefixes, prefix.Masked())
 	}
+
+	return prefixes
+}
+
+func stringEnv(key string, fallback string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+
+	return fallback
+}
+
+func boolEnv(key string, fallback bool) bool {
+	if v := os.Getenv(key); v != "" {
+		if b, err := strconv.ParseBool(v); err == nil {
+			return b
+		}
+	}
+
+	return fallback
+}
+
+func durationEnv(key string, fallback time.Duration) time.Duration {
+	if v := os.Getenv(key); v != "" {
+		if d, err := time.ParseDuration(v); err == nil {
+			return d
+		}
+	}
+
+	return fallback
+}
+
+func intEnv(key string, fallback int) int {
+	if v := os.Getenv(key); v != "" {
+		if n, err := strconv.Atoi(v); err == nil {
+			return n
+		}
+	}
+
+	return fallback
 }
@@ -0,0 +1,56 @@
+package db
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/raphico/go-device-telemetry-api/internal/audit"
+	"github.com/raphico/go-device-telemetry-api/internal/user"
+)
+
+type AuditRepository struct {
+	db *pgxpool.Pool
+}
+
+func NewAuditRepository(db *pgxpool.Pool) *AuditRepository {
+	return &AuditRepository{
+		db: db,
+	}
+}
+
+func (r *AuditRepository) Create(ctx context.Context, e *audit.Event) error {
+	jsonMetadata, err := json.Marshal(e.Metadata)
+	if err != nil {
+		return fmt.Errorf("failed to marshal metadata: %w", err)
+	}
+
+	query := `
+		INSERT INTO audit_events (user_id, event_type, metadata)
+		VALUES ($1, $2, $3)
+		RETURNING id, created_at
+	`
+
+	err = r.db.QueryRow(ctx, query, e.UserID, e.Type.String(), jsonMetadata).Scan(&e.ID, &e.CreatedAt)
+	if err != nil {
+		return fmt.Errorf("failed to insert audit event: %w", err)
+	}
+
+	return nil
+}
+
+func (r *AuditRepository) RemoveMetadata(ctx context.Context, userID user.UserID, email string, keys []string) error {
+	query := `
+		UPDATE audit_events
+		SET metadata = metadata - $3::text[]
+		WHERE (user_id = $1 OR lower(metadata->>'email') = lower($2))
+		  AND metadata ?| $3::text[]
+	`
+
+	if _, err := r.db.Exec(ctx, query, userID, email, keys); err != nil {
+		return fmt.Errorf("failed to scrub audit events: %w", err)
+	}
+
+	return nil
+}
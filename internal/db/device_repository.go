@@ -13,6 +13,7 @@ import (
 	"github.com/jackc/pgx/v5/pgxpool"
 	"github.com/raphico/go-device-telemetry-api/internal/common/pagination"
 	"github.com/raphico/go-device-telemetry-api/internal/device"
+	"github.com/raphico/go-device-telemetry-api/internal/organization"
 	"github.com/raphico/go-device-telemetry-api/internal/user"
 )
 
@@ -33,7 +34,7 @@ func (r *DeviceRepository) Create(ctx context.Context, dev *device.Device) error
 	}
 
 	query := `
-		INSERT INTO devices (user_id, name, device_type, status, metadata)
+		INSERT INTO devices (organization_id, name, device_type, status, metadata)
 		VALUES ($1, $2, $3, $4, $5)
 		returning id, created_at, updated_at
 	`
@@ -41,7 +42,7 @@ func (r *DeviceRepository) Create(ctx context.Context, dev *device.Device) error
 	err = r.db.QueryRow(
 		ctx,
 		query,
-		dev.UserID,
+		dev.OrganizationID,
 		dev.Name,
 		dev.DeviceType,
 		dev.Status,
@@ -51,8 +52,8 @@ func (r *DeviceRepository) Create(ctx context.Context, dev *device.Device) error
 	if err != nil {
 		var pgError *pgconn.PgError
 		if errors.As(err, &pgError) {
-			if pgError.Code == "23503" && pgError.ConstraintName == "devices_user_id_fkey" {
-				return user.ErrUserNotFound
+			if pgError.Code == "23503" && pgError.ConstraintName == "devices_organization_id_fkey" {
+				return organization.ErrOrganizationNotFound
 			}
 		}
 
@@ -65,32 +66,36 @@ func (r *DeviceRepository) Create(ctx context.Context, dev *device.Device) error
 func (r *DeviceRepository) FindById(
 	ctx context.Context,
 	id device.DeviceID,
-	userID user.UserID,
 ) (*device.Device, error) {
 	var (
-		deviceID   uuid.UUID
-		uID        uuid.UUID
-		name       string
-		deviceType string
-		status     string
-		metadata   []byte
-		createdAt  time.Time
-		updatedAt  time.Time
+		deviceID      uuid.UUID
+		orgID         uuid.UUID
+		name          string
+		deviceType    string
+		status        string
+		metadata      []byte
+		skewMs        *int64
+		skewUpdatedAt *time.Time
+		createdAt     time.Time
+		updatedAt     time.Time
 	)
 
 	query := `
-		SELECT id, user_id, name, device_type, status, metadata, created_at, updated_at
+		SELECT id, organization_id, name, device_type, status, metadata,
+			clock_skew_ms, clock_skew_updated_at, created_at, updated_at
 		FROM devices
-		WHERE id = $1 AND user_id = $2
+		WHERE id = $1
 	`
 
-	err := r.db.QueryRow(ctx, query, id, uID).Scan(
+	err := r.db.QueryRow(ctx, query, id).Scan(
 		&deviceID,
-		&userID,
+		&orgID,
 		&name,
 		&deviceType,
 		&status,
 		&metadata,
+		&skewMs,
+		&skewUpdatedAt,
 		&createdAt,
 		&updatedAt,
 	)
@@ -105,11 +110,12 @@ func (r *DeviceRepository) FindById(
 
 	return device.RehydrateDevice(
 		deviceID,
-		uID,
+		orgID,
 		name,
 		deviceType,
 		status,
 		metadata,
+		clockSkew(skewMs, skewUpdatedAt),
 		createdAt,
 		updatedAt,
 	)
@@ -119,33 +125,46 @@ func (r *DeviceRepository) FindById(
 func (r *DeviceRepository) FindDevices(
 	ctx context.Context,
 	userID user.UserID,
+	organizationID *organization.OrganizationID,
 	limit int,
 	cursor *pagination.Cursor,
 ) ([]*device.Device, *pagination.Cursor, error) {
 	var (
-		query string
-		args  []any
+		query     string
+		args      []any
+		orgFilter *uuid.UUID
 	)
 
+	if organizationID != nil {
+		id := uuid.UUID(*organizationID)
+		orgFilter = &id
+	}
+
 	if cursor == nil {
 		query = `
-			SELECT id, user_id, name, device_type, status, metadata, created_at, updated_at
-			FROM devices
-			WHERE user_id = $1
-			ORDER BY created_at ASC, id ASC
-			LIMIT $2
+			SELECT d.id, d.organization_id, d.name, d.device_type, d.status, d.metadata,
+				d.clock_skew_ms, d.clock_skew_updated_at, d.created_at, d.updated_at
+			FROM devices d
+			JOIN organization_members m ON m.organization_id = d.organization_id
+			WHERE m.user_id = $1
+			  AND ($2::uuid IS NULL OR d.organization_id = $2)
+			ORDER BY d.created_at ASC, d.id ASC
+			LIMIT $3
 		`
-		args = []any{userID, limit + 1}
+		args = []any{userID, orgFilter, limit + 1}
 	} else {
 		query = `
-			SELECT id, user_id, name, device_type, status, metadata, created_at, updated_at
-			FROM devices
-			WHERE user_id = $1
-			  AND (created_at, id) > ($2, $3)
-			ORDER BY created_at ASC, id ASC
-			LIMIT $4
+			SELECT d.id, d.organization_id, d.name, d.device_type, d.status, d.metadata,
+				d.clock_skew_ms, d.clock_skew_updated_at, d.created_at, d.updated_at
+			FROM devices d
+			JOIN organization_members m ON m.organization_id = d.organization_id
+			WHERE m.user_id = $1
+			  AND ($2::uuid IS NULL OR d.organization_id = $2)
+			  AND (d.created_at, d.id) > ($3, $4)
+			ORDER BY d.created_at ASC, d.id ASC
+			LIMIT $5
 		`
-		args = []any{userID, cursor.CreatedAt, cursor.ID, limit + 1}
+		args = []any{userID, orgFilter, cursor.CreatedAt, cursor.ID, limit + 1}
 	}
 
 	rows, err := r.db.Query(ctx, query, args...)
@@ -158,23 +177,27 @@ func (r *DeviceRepository) FindDevices(
 
 	for rows.Next() {
 		var (
-			deviceID   uuid.UUID
-			uID        uuid.UUID
-			name       string
-			deviceType string
-			status     string
-			metadata   []byte
-			createdAt  time.Time
-			updatedAt  time.Time
+			deviceID      uuid.UUID
+			orgID         uuid.UUID
+			name          string
+			deviceType    string
+			status        string
+			metadata      []byte
+			skewMs        *int64
+			skewUpdatedAt *time.Time
+			createdAt     time.Time
+			updatedAt     time.Time
 		)
 
 		if err := rows.Scan(
 			&deviceID,
-			&uID,
+			&orgID,
 			&name,
 			&deviceType,
 			&status,
 			&metadata,
+			&skewMs,
+			&skewUpdatedAt,
 			&createdAt,
 			&updatedAt,
 		); err != nil {
@@ -183,11 +206,12 @@ func (r *DeviceRepository) FindDevices(
 
 		dev, err := device.RehydrateDevice(
 			deviceID,
-			uID,
+			orgID,
 			name,
 			deviceType,
 			status,
 			metadata,
+			clockSkew(skewMs, skewUpdatedAt),
 			createdAt,
 			updatedAt,
 		)
@@ -216,10 +240,10 @@ func (r *DeviceRepository) Update(ctx context.Context, dev *device.Device) error
 	query := `
 		UPDATE devices
         SET name = $1, device_type = $2, metadata = $3, updated_at = NOW()
-        WHERE id = $4 AND user_id = $5	
+        WHERE id = $4
 	`
 
-	tag, err := r.db.Exec(ctx, query, dev.Name, dev.DeviceType, dev.Metadata, dev.ID, dev.UserID)
+	tag, err := r.db.Exec(ctx, query, dev.Name, dev.DeviceType, dev.Metadata, dev.ID)
 	if err != nil {
 		return fmt.Errorf("failed to update device: %w", err)
 	}
@@ -230,3 +254,51 @@ func (r *DeviceRepository) Update(ctx context.Context, dev *device.Device) error
 
 	return nil
 }
+
+func (r *DeviceRepository) UpdateClockSkew(
+	ctx context.Context,
+	id device.DeviceID,
+	sample time.Duration,
+	decayPerHour time.Duration,
+) (*device.ClockSkew, error) {
+	query := `
+		UPDATE devices
+		SET clock_skew_ms = CASE
+				WHEN clock_skew_ms IS NULL THEN $2
+				ELSE GREATEST(
+					$2,
+					clock_skew_ms - (EXTRACT(EPOCH FROM NOW() - clock_skew_updated_at) / 3600 * $3)::BIGINT
+				)
+			END,
+			clock_skew_updated_at = NOW()
+		WHERE id = $1
+		RETURNING clock_skew_ms, clock_skew_updated_at
+	`
+
+	var (
+		skewMs    int64
+		updatedAt time.Time
+	)
+
+	err := r.db.QueryRow(ctx, query, id, sample.Milliseconds(), decayPerHour.Milliseconds()).
+		Scan(&skewMs, &updatedAt)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, device.ErrDeviceNotFound
+		}
+		return nil, fmt.Errorf("failed to update device clock skew: %w", err)
+	}
+
+	return clockSkew(&skewMs, &updatedAt), nil
+}
+
+func clockSkew(ms *int64, estimatedAt *time.Time) *device.ClockSkew {
+	if ms == nil || estimatedAt == nil {
+		return nil
+	}
+
+	return &device.ClockSkew{
+		Offset:      time.Duration(*ms) * time.Millisecond,
+		EstimatedAt: *estimatedAt,
+	}
+}
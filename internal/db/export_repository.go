@@ -0,0 +1,320 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/raphico/go-device-telemetry-api/internal/export"
+	"github.com/raphico/go-device-telemetry-api/internal/user"
+)
+
+type ExportRepository struct {
+	db *pgxpool.Pool
+}
+
+func NewExportRepository(db *pgxpool.Pool) *ExportRepository {
+	return &ExportRepository{db: db}
+}
+
+const exportJobColumns = `
+	id, user_id, device_ids, range_from, range_to, format, gzip, status,
+	rows_exported, total_rows, artifact_key, artifact_size, error,
+	created_at, started_at, completed_at
+`
+
+func (r *ExportRepository) Create(ctx context.Context, j *export.Job) error {
+	var deviceIDs []uuid.UUID
+	for _, id := range j.DeviceIDs {
+		deviceIDs = append(deviceIDs, uuid.UUID(id))
+	}
+
+	var from, to *time.Time
+	if j.TimeRange != nil {
+		f, t := j.TimeRange.From(), j.TimeRange.To()
+		from, to = &f, &t
+	}
+
+	query := `
+		INSERT INTO export_jobs (user_id, device_ids, range_from, range_to, format, gzip)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		RETURNING id, created_at
+	`
+
+	err := r.db.QueryRow(
+		ctx,
+		query,
+		j.UserID,
+		deviceIDs,
+		from,
+		to,
+		j.Format.String(),
+		j.Gzip,
+	).Scan(&j.ID, &j.CreatedAt)
+
+	if err != nil {
+		var pgError *pgconn.PgError
+		if errors.As(err, &pgError) {
+			if pgError.Code == "23503" && pgError.ConstraintName == "export_jobs_user_id_fkey" {
+				return user.ErrUserNotFound
+			}
+		}
+		return fmt.Errorf("failed to insert export job: %w", err)
+	}
+
+	return nil
+}
+
+func (r *ExportRepository) FindById(ctx context.Context, id export.JobID, userID user.UserID) (*export.Job, error) {
+	query := `SELECT ` + exportJobColumns + ` FROM export_jobs WHERE id = $1 AND user_id = $2`
+
+	job, err := scanExportJob(r.db.QueryRow(ctx, query, id, userID))
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, export.ErrJobNotFound
+		}
+		return nil, fmt.Errorf("failed to find export job: %w", err)
+	}
+
+	return job, nil
+}
+
+func (r *ExportRepository) ClaimNext(ctx context.Context, staleBefore time.Time) (*export.Job, error) {
+	query := `
+		UPDATE export_jobs
+		SET status = 'running',
+			started_at = COALESCE(started_at, now()),
+			heartbeat_at = now(),
+			rows_exported = 0
+		WHERE id = (
+			SELECT id
+			FROM export_jobs
+			WHERE status = 'pending'
+				OR (status = 'running' AND heartbeat_at < $1)
+			ORDER BY created_at ASC
+			FOR UPDATE SKIP LOCKED
+			LIMIT 1
+		)
+		RETURNING ` + exportJobColumns
+
+	job, err := scanExportJob(r.db.QueryRow(ctx, query, staleBefore))
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, export.ErrNoJobAvailable
+		}
+		return nil, fmt.Errorf("failed to claim export job: %w", err)
+	}
+
+	return job, nil
+}
+
+func (r *ExportRepository) Heartbeat(ctx context.Context, id export.JobID) error {
+	query := `UPDATE export_jobs SET heartbeat_at = now() WHERE id = $1 AND status = 'running'`
+
+	if _, err := r.db.Exec(ctx, query, id); err != nil {
+		return fmt.Errorf("failed to update export job heartbeat: %w", err)
+	}
+
+	return nil
+}
+
+func (r *ExportRepository) SetTotalRows(ctx context.Context, id export.JobID, total int64) error {
+	query := `UPDATE export_jobs SET total_rows = $1, heartbeat_at = now() WHERE id = $2`
+
+	if _, err := r.db.Exec(ctx, query, total, id); err != nil {
+		return fmt.Errorf("failed to update export job total: %w", err)
+	}
+
+	return nil
+}
+
+func (r *ExportRepository) UpdateProgress(ctx context.Context, id export.JobID, rows int64) error {
+	query := `UPDATE export_jobs SET rows_exported = $1, heartbeat_at = now() WHERE id = $2`
+
+	if _, err := r.db.Exec(ctx, query, rows, id); err != nil {
+		return fmt.Errorf("failed to update export job progress: %w", err)
+	}
+
+	return nil
+}
+
+func (r *ExportRepository) Complete(ctx context.Context, j *export.Job) error {
+	query := `
+		UPDATE export_jobs
+		SET status = 'completed',
+			rows_exported = $1,
+			artifact_key = $2,
+			artifact_size = $3,
+			completed_at = now()
+		WHERE id = $4
+		RETURNING completed_at
+	`
+
+	err := r.db.QueryRow(ctx, query, j.RowsExported, j.ArtifactKey, j.ArtifactSize, j.ID).Scan(&j.CompletedAt)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return export.ErrJobNotFound
+		}
+		return fmt.Errorf("failed to complete export job: %w", err)
+	}
+
+	j.Status = export.StatusCompleted
+
+	return nil
+}
+
+func (r *ExportRepository) Fail(ctx context.Context, id export.JobID, reason string) error {
+	query := `
+		UPDATE export_jobs
+		SET status = 'failed', error = $1, completed_at = now()
+		WHERE id = $2
+	`
+
+	tag, err := r.db.Exec(ctx, query, reason, id)
+	if err != nil {
+		return fmt.Errorf("failed to mark export job as failed: %w", err)
+	}
+
+	if tag.RowsAffected() == 0 {
+		return export.ErrJobNotFound
+	}
+
+	return nil
+}
+
+func (r *ExportRepository) FindExpired(
+	ctx context.Context,
+	completedBefore time.Time,
+	limit int,
+) ([]*export.Job, error) {
+	query := `
+		SELECT ` + exportJobColumns + `
+		FROM export_jobs
+		WHERE status = 'completed' AND completed_at < $1
+		ORDER BY completed_at ASC
+		LIMIT $2
+	`
+
+	rows, err := r.db.Query(ctx, query, completedBefore, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to find expired export jobs: %w", err)
+	}
+	defer rows.Close()
+
+	var jobs []*export.Job
+	for rows.Next() {
+		job, err := scanExportJob(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan expired export job: %w", err)
+		}
+		jobs = append(jobs, job)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to find expired export jobs: %w", err)
+	}
+
+	return jobs, nil
+}
+
+func (r *ExportRepository) Expire(ctx context.Context, id export.JobID) error {
+	query := `
+		UPDATE export_jobs
+		SET status = 'expired', artifact_key = NULL
+		WHERE id = $1 AND status = 'completed'
+	`
+
+	tag, err := r.db.Exec(ctx, query, id)
+	if err != nil {
+		return fmt.Errorf("failed to expire export job: %w", err)
+	}
+
+	if tag.RowsAffected() == 0 {
+		return export.ErrJobNotFound
+	}
+
+	return nil
+}
+
+func (r *ExportRepository) ArtifactKeys(ctx context.Context, userID user.UserID) ([]string, error) {
+	query := `SELECT artifact_key FROM export_jobs WHERE user_id = $1 AND artifact_key IS NOT NULL`
+
+	rows, err := r.db.Query(ctx, query, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list export artifacts: %w", err)
+	}
+
+	keys, err := pgx.CollectRows(rows, pgx.RowTo[string])
+	if err != nil {
+		return nil, fmt.Errorf("failed to list export artifacts: %w", err)
+	}
+
+	return keys, nil
+}
+
+func scanExportJob(row pgx.Row) (*export.Job, error) {
+	var (
+		id           uuid.UUID
+		userID       uuid.UUID
+		deviceIDs    []uuid.UUID
+		rangeFrom    *time.Time
+		rangeTo      *time.Time
+		format       string
+		gzip         bool
+		status       string
+		rowsExported int64
+		totalRows    *int64
+		artifactKey  *string
+		artifactSize *int64
+		errMsg       *string
+		createdAt    time.Time
+		startedAt    *time.Time
+		completedAt  *time.Time
+	)
+
+	err := row.Scan(
+		&id,
+		&userID,
+		&deviceIDs,
+		&rangeFrom,
+		&rangeTo,
+		&format,
+		&gzip,
+		&status,
+		&rowsExported,
+		&totalRows,
+		&artifactKey,
+		&artifactSize,
+		&errMsg,
+		&createdAt,
+		&startedAt,
+		&completedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return export.RehydrateJob(
+		id,
+		userID,
+		deviceIDs,
+		rangeFrom,
+		rangeTo,
+		format,
+		gzip,
+		status,
+		rowsExported,
+		totalRows,
+		artifactKey,
+		artifactSize,
+		errMsg,
+		createdAt,
+		startedAt,
+		completedAt,
+	)
+}
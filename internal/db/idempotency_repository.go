@@ -0,0 +1,185 @@
+package db
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/raphico/go-device-telemetry-api/internal/idempotency"
+	"github.com/raphico/go-device-telemetry-api/internal/user"
+)
+
+type IdempotencyRepository struct {
+	db *pgxpool.Pool
+}
+
+func NewIdempotencyRepository(db *pgxpool.Pool) *IdempotencyRepository {
+	return &IdempotencyRepository{db: db}
+}
+
+func (r *IdempotencyRepository) Reserve(
+	ctx context.Context,
+	rec *idempotency.Record,
+	staleBefore time.Time,
+) (*idempotency.Record, bool, error) {
+	insert := `
+		INSERT INTO idempotency_keys (user_id, idempotency_key, fingerprint, created_at, expires_at)
+		VALUES ($1, $2, $3, $4, $5)
+		ON CONFLICT (user_id, idempotency_key) DO UPDATE
+		SET fingerprint = EXCLUDED.fingerprint,
+			response_status = NULL,
+			response_headers = NULL,
+			response_body = NULL,
+			created_at = EXCLUDED.created_at,
+			expires_at = EXCLUDED.expires_at
+		WHERE idempotency_keys.expires_at < NOW()
+			OR (idempotency_keys.response_status IS NULL AND idempotency_keys.created_at < $6)
+		RETURNING created_at
+	`
+
+	// the holder can expire and be purged between the two statements, so
+	// give the insert a second chance
+	for range 2 {
+		err := r.db.QueryRow(
+			ctx,
+			insert,
+			rec.UserID,
+			rec.Key.String(),
+			rec.Fingerprint.String(),
+			rec.CreatedAt,
+			rec.ExpiresAt,
+			staleBefore,
+		).Scan(&rec.CreatedAt)
+
+		if err == nil {
+			return rec, true, nil
+		}
+
+		if !errors.Is(err, pgx.ErrNoRows) {
+			return nil, false, fmt.Errorf("failed to reserve idempotency key: %w", err)
+		}
+
+		held, err := r.find(ctx, rec.UserID, rec.Key)
+		if errors.Is(err, pgx.ErrNoRows) {
+			continue
+		}
+		if err != nil {
+			return nil, false, err
+		}
+
+		return held, false, nil
+	}
+
+	return nil, false, errors.New("failed to reserve idempotency key: key kept changing hands")
+}
+
+func (r *IdempotencyRepository) find(
+	ctx context.Context,
+	userID user.UserID,
+	key idempotency.Key,
+) (*idempotency.Record, error) {
+	query := `
+		SELECT user_id, idempotency_key, fingerprint, response_status, response_headers,
+			response_body, created_at, expires_at
+		FROM idempotency_keys
+		WHERE user_id = $1 AND idempotency_key = $2
+	`
+
+	var (
+		uid         uuid.UUID
+		keyStr      string
+		fingerprint string
+		status      *int
+		headers     []byte
+		body        []byte
+		createdAt   time.Time
+		expiresAt   time.Time
+	)
+
+	err := r.db.QueryRow(ctx, query, userID, key.String()).Scan(
+		&uid,
+		&keyStr,
+		&fingerprint,
+		&status,
+		&headers,
+		&body,
+		&createdAt,
+		&expiresAt,
+	)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, err
+		}
+		return nil, fmt.Errorf("failed to find idempotency key: %w", err)
+	}
+
+	var res *idempotency.Response
+	if status != nil {
+		res = &idempotency.Response{StatusCode: *status, Body: body}
+		if len(headers) > 0 {
+			if err := json.Unmarshal(headers, &res.Headers); err != nil {
+				return nil, fmt.Errorf("corrupt idempotency response headers: %w", err)
+			}
+		}
+	}
+
+	return idempotency.RehydrateRecord(
+		user.UserID(uid),
+		keyStr,
+		fingerprint,
+		res,
+		createdAt,
+		expiresAt,
+	), nil
+}
+
+func (r *IdempotencyRepository) Complete(
+	ctx context.Context,
+	userID user.UserID,
+	key idempotency.Key,
+	res *idempotency.Response,
+) error {
+	headers, err := json.Marshal(res.Headers)
+	if err != nil {
+		return fmt.Errorf("failed to marshal response headers: %w", err)
+	}
+
+	query := `
+		UPDATE idempotency_keys
+		SET response_status = $3, response_headers = $4, response_body = $5
+		WHERE user_id = $1 AND idempotency_key = $2
+	`
+
+	if _, err := r.db.Exec(ctx, query, userID, key.String(), res.StatusCode, headers, res.Body); err != nil {
+		return fmt.Errorf("failed to store idempotent response: %w", err)
+	}
+
+	return nil
+}
+
+func (r *IdempotencyRepository) Release(ctx context.Context, userID user.UserID, key idempotency.Key) error {
+	query := `
+		DELETE FROM idempotency_keys
+		WHERE user_id = $1 AND idempotency_key = $2 AND response_status IS NULL
+	`
+
+	if _, err := r.db.Exec(ctx, query, userID, key.String()); err != nil {
+		return fmt.Errorf("failed to release idempotency key: %w", err)
+	}
+
+	return nil
+}
+
+func (r *IdempotencyRepository) DeleteExpired(ctx context.Context, before time.Time) (int64, error) {
+	tag, err := r.db.Exec(ctx, `DELETE FROM idempotency_keys WHERE expires_at < $1`, before)
+	if err != nil {
+		return 0, fmt.Errorf("failed to purge idempotency keys: %w", err)
+	}
+
+	return tag.RowsAffected(), nil
+}
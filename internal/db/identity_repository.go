@@ -0,0 +1,178 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/raphico/go-device-telemetry-api/internal/identity"
+	"github.com/raphico/go-device-telemetry-api/internal/user"
+)
+
+type IdentityRepository struct {
+	db *pgxpool.Pool
+}
+
+func NewIdentityRepository(db *pgxpool.Pool) *IdentityRepository {
+	return &IdentityRepository{
+		db: db,
+	}
+}
+
+func (r *IdentityRepository) CreateState(ctx context.Context, s *identity.LoginState) error {
+	query := `
+		INSERT INTO oidc_login_states (state_hash, provider, code_verifier, nonce, link_user_id, expires_at)
+		VALUES ($1, $2, $3, $4, $5, $6)
+	`
+
+	_, err := r.db.Exec(ctx, query, s.StateHash, s.Provider, s.CodeVerifier, s.Nonce, s.LinkUserID, s.ExpiresAt)
+	if err != nil {
+		return fmt.Errorf("failed to insert login state: %w", err)
+	}
+
+	return nil
+}
+
+func (r *IdentityRepository) ConsumeState(ctx context.Context, hash []byte) (*identity.LoginState, error) {
+	query := `
+		DELETE FROM oidc_login_states
+		WHERE state_hash = $1 AND expires_at > now()
+		RETURNING provider, code_verifier, nonce, link_user_id, expires_at
+	`
+
+	var (
+		s          = identity.LoginState{StateHash: hash}
+		linkUserID *uuid.UUID
+	)
+
+	err := r.db.QueryRow(ctx, query, hash).Scan(&s.Provider, &s.CodeVerifier, &s.Nonce, &linkUserID, &s.ExpiresAt)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, identity.ErrInvalidState
+		}
+		return nil, fmt.Errorf("failed to consume login state: %w", err)
+	}
+
+	if linkUserID != nil {
+		id := user.UserID(*linkUserID)
+		s.LinkUserID = &id
+	}
+
+	return &s, nil
+}
+
+func (r *IdentityRepository) Create(ctx context.Context, i *identity.Identity) error {
+	query := `
+		INSERT INTO user_identities (user_id, provider, subject, email)
+		VALUES ($1, $2, $3, $4)
+		RETURNING id, created_at
+	`
+
+	err := r.db.QueryRow(ctx, query, i.UserID, i.Provider, i.Subject, i.Email).Scan(&i.ID, &i.CreatedAt)
+	if err != nil {
+		var pgErr *pgconn.PgError
+		if errors.As(err, &pgErr) && pgErr.Code == "23505" {
+			return identity.ErrIdentityLinked
+		}
+		return fmt.Errorf("failed to insert identity: %w", err)
+	}
+
+	return nil
+}
+
+func (r *IdentityRepository) RecordLogin(ctx context.Context, provider string, subject string) (*identity.Identity, error) {
+	query := `
+		UPDATE user_identities
+		SET last_login_at = now()
+		WHERE provider = $1 AND subject = $2
+		RETURNING id, user_id, provider, subject, email, last_login_at, created_at
+	`
+
+	i, err := scanIdentity(r.db.QueryRow(ctx, query, provider, subject))
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, identity.ErrIdentityNotFound
+		}
+		return nil, fmt.Errorf("failed to record identity login: %w", err)
+	}
+
+	return i, nil
+}
+
+func (r *IdentityRepository) FindByUser(ctx context.Context, userID user.UserID) ([]*identity.Identity, error) {
+	query := `
+		SELECT id, user_id, provider, subject, email, last_login_at, created_at
+		FROM user_identities
+		WHERE user_id = $1
+		ORDER BY created_at ASC, id ASC
+	`
+
+	rows, err := r.db.Query(ctx, query, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query identities: %w", err)
+	}
+	defer rows.Close()
+
+	var result []*identity.Identity
+
+	for rows.Next() {
+		i, err := scanIdentity(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan identity: %w", err)
+		}
+
+		result = append(result, i)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("rows error: %w", err)
+	}
+
+	return result, nil
+}
+
+func (r *IdentityRepository) Delete(ctx context.Context, id identity.IdentityID, userID user.UserID) error {
+	query := `DELETE FROM user_identities WHERE id = $1 AND user_id = $2`
+
+	tag, err := r.db.Exec(ctx, query, id, userID)
+	if err != nil {
+		return fmt.Errorf("failed to delete identity: %w", err)
+	}
+
+	if tag.RowsAffected() == 0 {
+		return identity.ErrIdentityNotFound
+	}
+
+	return nil
+}
+
+func (r *IdentityRepository) DeleteExpiredStates(ctx context.Context, before time.Time) (int64, error) {
+	tag, err := r.db.Exec(ctx, `DELETE FROM oidc_login_states WHERE expires_at < $1`, before)
+	if err != nil {
+		return 0, fmt.Errorf("failed to purge login states: %w", err)
+	}
+
+	return tag.RowsAffected(), nil
+}
+
+func scanIdentity(row pgx.Row) (*identity.Identity, error) {
+	var (
+		i      identity.Identity
+		id     uuid.UUID
+		userID uuid.UUID
+	)
+
+	if err := row.Scan(&id, &userID, &i.Provider, &i.Subject, &i.Email, &i.LastLoginAt, &i.CreatedAt); err != nil {
+		return nil, err
+	}
+
+	i.ID = identity.IdentityID(id)
+	i.UserID = user.UserID(userID)
+
+	return &i, nil
+}
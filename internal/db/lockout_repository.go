@@ -0,0 +1,157 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+type LockoutRepository struct {
+	db *pgxpool.Pool
+}
+
+func NewLockoutRepository(db *pgxpool.Pool) *LockoutRepository {
+	return &LockoutRepository{
+		db: db,
+	}
+}
+
+func (r *LockoutRepository) LockedUntil(ctx context.Context, keys []string) (time.Time, error) {
+	query := `SELECT max(locked_until) FROM login_failures WHERE key = ANY($1)`
+
+	var until *time.Time
+	if err := r.db.QueryRow(ctx, query, keys).Scan(&until); err != nil {
+		return time.Time{}, fmt.Errorf("failed to check login lockout: %w", err)
+	}
+
+	if until == nil {
+		return time.Time{}, nil
+	}
+
+	return *until, nil
+}
+
+func (r *LockoutRepository) RecordAttempt(
+	ctx context.Context,
+	key string,
+	now time.Time,
+	window time.Duration,
+) (int, time.Time, error) {
+	// the update only happens when the key is not blocked; otherwise the
+	// second branch returns the block so the caller can tell how long it
+	// lasts
+	query := `
+		WITH counted AS (
+			INSERT INTO login_failures AS f (key, failures, last_failed_at)
+			VALUES ($1, 1, $2)
+			ON CONFLICT (key) DO UPDATE
+			SET failures = CASE
+					WHEN f.last_failed_at < $3 THEN 1
+					ELSE f.failures + 1
+				END,
+				last_failed_at = EXCLUDED.last_failed_at
+			WHERE f.locked_until IS NULL OR f.locked_until <= $2
+			RETURNING failures, locked_until
+		)
+		SELECT failures, locked_until FROM counted
+		UNION ALL
+		SELECT failures, locked_until FROM login_failures
+		WHERE key = $1 AND NOT EXISTS (SELECT 1 FROM counted)
+	`
+
+	// a purge can delete the row between the two branches, so give the
+	// statement a second chance
+	for range 2 {
+		var (
+			failures int
+			until    *time.Time
+		)
+
+		err := r.db.QueryRow(ctx, query, key, now, now.Add(-window)).Scan(&failures, &until)
+		if errors.Is(err, pgx.ErrNoRows) {
+			continue
+		}
+		if err != nil {
+			return 0, time.Time{}, fmt.Errorf("failed to record login attempt: %w", err)
+		}
+
+		if until == nil {
+			return failures, time.Time{}, nil
+		}
+
+		return failures, *until, nil
+	}
+
+	return 0, time.Time{}, errors.New("failed to record login attempt: counter kept disappearing")
+}
+
+func (r *LockoutRepository) Forgive(ctx context.Context, key string, blockedUntil time.Time) error {
+	query := `
+		UPDATE login_failures
+		SET failures = GREATEST(failures - 1, 0),
+			locked_until = CASE WHEN locked_until <= $2 THEN NULL ELSE locked_until END
+		WHERE key = $1
+	`
+
+	if _, err := r.db.Exec(ctx, query, key, blockedUntil); err != nil {
+		return fmt.Errorf("failed to forgive login attempt: %w", err)
+	}
+
+	return nil
+}
+
+func (r *LockoutRepository) Block(ctx context.Context, key string, until time.Time) error {
+	query := `
+		UPDATE login_failures
+		SET locked_until = GREATEST(locked_until, $2)
+		WHERE key = $1
+	`
+
+	if _, err := r.db.Exec(ctx, query, key, until); err != nil {
+		return fmt.Errorf("failed to block login: %w", err)
+	}
+
+	return nil
+}
+
+func (r *LockoutRepository) Lock(ctx context.Context, key string, until time.Time) error {
+	query := `
+		UPDATE login_failures
+		SET locked_until = GREATEST(locked_until, $2),
+			failures = 0
+		WHERE key = $1
+	`
+
+	if _, err := r.db.Exec(ctx, query, key, until); err != nil {
+		return fmt.Errorf("failed to lock login: %w", err)
+	}
+
+	return nil
+}
+
+func (r *LockoutRepository) Reset(ctx context.Context, key string) error {
+	if _, err := r.db.Exec(ctx, `DELETE FROM login_failures WHERE key = $1`, key); err != nil {
+		return fmt.Errorf("failed to reset login failures: %w", err)
+	}
+
+	return nil
+}
+
+func (r *LockoutRepository) DeleteExpired(ctx context.Context, now time.Time, window time.Duration) (int64, error) {
+	query := `
+		DELETE FROM login_failures
+		WHERE last_failed_at < $2
+			AND (locked_until IS NULL OR locked_until < $1)
+	`
+
+	tag, err := r.db.Exec(ctx, query, now, now.Add(-window))
+	if err != nil {
+		return 0, fmt.Errorf("failed to purge login failures: %w", err)
+	}
+
+	return tag.RowsAffected(), nil
+}
@@ -0,0 +1,178 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/raphico/go-device-telemetry-api/internal/mfa"
+	"github.com/raphico/go-device-telemetry-api/internal/user"
+)
+
+type MFARepository struct {
+	db *pgxpool.Pool
+}
+
+func NewMFARepository(db *pgxpool.Pool) *MFARepository {
+	return &MFARepository{
+		db: db,
+	}
+}
+
+func (r *MFARepository) SaveEnrollment(ctx context.Context, e *mfa.Enrollment) error {
+	query := `
+		INSERT INTO mfa_totp (user_id, secret)
+		VALUES ($1, $2)
+		ON CONFLICT (user_id) DO UPDATE
+		SET secret = EXCLUDED.secret, last_used_step = 0, created_at = now()
+		WHERE mfa_totp.confirmed_at IS NULL
+		RETURNING created_at
+	`
+
+	err := r.db.QueryRow(ctx, query, e.UserID, e.Secret).Scan(&e.CreatedAt)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return mfa.ErrAlreadyEnabled
+		}
+		return fmt.Errorf("failed to save mfa enrollment: %w", err)
+	}
+
+	return nil
+}
+
+func (r *MFARepository) FindEnrollment(ctx context.Context, userID user.UserID) (*mfa.Enrollment, error) {
+	query := `
+		SELECT secret, confirmed_at, last_used_step, created_at
+		FROM mfa_totp
+		WHERE user_id = $1
+	`
+
+	e := mfa.Enrollment{UserID: userID}
+
+	err := r.db.QueryRow(ctx, query, userID).Scan(&e.Secret, &e.ConfirmedAt, &e.LastUsedStep, &e.CreatedAt)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, mfa.ErrNotEnrolled
+		}
+		return nil, fmt.Errorf("failed to find mfa enrollment: %w", err)
+	}
+
+	return &e, nil
+}
+
+func (r *MFARepository) Confirm(ctx context.Context, userID user.UserID, step int64, recoveryCodeHashes [][]byte) error {
+	tx, err := r.db.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer func() { _ = tx.Rollback(ctx) }()
+
+	tag, err := tx.Exec(ctx, `
+		UPDATE mfa_totp
+		SET confirmed_at = now(), last_used_step = $2
+		WHERE user_id = $1 AND confirmed_at IS NULL
+	`, userID, step)
+	if err != nil {
+		return fmt.Errorf("failed to confirm mfa enrollment: %w", err)
+	}
+
+	if tag.RowsAffected() == 0 {
+		return mfa.ErrNotEnrolled
+	}
+
+	if _, err := tx.Exec(ctx, `DELETE FROM mfa_recovery_codes WHERE user_id = $1`, userID); err != nil {
+		return fmt.Errorf("failed to delete recovery codes: %w", err)
+	}
+
+	_, err = tx.Exec(ctx, `
+		INSERT INTO mfa_recovery_codes (user_id, code_hash)
+		SELECT $1, unnest($2::bytea[])
+	`, userID, recoveryCodeHashes)
+	if err != nil {
+		return fmt.Errorf("failed to insert recovery codes: %w", err)
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	return nil
+}
+
+func (r *MFARepository) UseStep(ctx context.Context, userID user.UserID, step int64) error {
+	query := `
+		UPDATE mfa_totp
+		SET last_used_step = $2
+		WHERE user_id = $1 AND confirmed_at IS NOT NULL AND last_used_step < $2
+	`
+
+	tag, err := r.db.Exec(ctx, query, userID, step)
+	if err != nil {
+		return fmt.Errorf("failed to record totp step: %w", err)
+	}
+
+	if tag.RowsAffected() == 0 {
+		return mfa.ErrInvalidCode
+	}
+
+	return nil
+}
+
+func (r *MFARepository) UseRecoveryCode(ctx context.Context, userID user.UserID, hash []byte) error {
+	query := `
+		UPDATE mfa_recovery_codes
+		SET used_at = now()
+		WHERE user_id = $1 AND code_hash = $2 AND used_at IS NULL
+	`
+
+	tag, err := r.db.Exec(ctx, query, userID, hash)
+	if err != nil {
+		return fmt.Errorf("failed to use recovery code: %w", err)
+	}
+
+	if tag.RowsAffected() == 0 {
+		return mfa.ErrInvalidCode
+	}
+
+	return nil
+}
+
+func (r *MFARepository) CountRecoveryCodes(ctx context.Context, userID user.UserID) (int, error) {
+	query := `SELECT count(*) FROM mfa_recovery_codes WHERE user_id = $1 AND used_at IS NULL`
+
+	var count int
+	if err := r.db.QueryRow(ctx, query, userID).Scan(&count); err != nil {
+		return 0, fmt.Errorf("failed to count recovery codes: %w", err)
+	}
+
+	return count, nil
+}
+
+func (r *MFARepository) Delete(ctx context.Context, userID user.UserID) error {
+	tx, err := r.db.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer func() { _ = tx.Rollback(ctx) }()
+
+	tag, err := tx.Exec(ctx, `DELETE FROM mfa_totp WHERE user_id = $1`, userID)
+	if err != nil {
+		return fmt.Errorf("failed to delete mfa enrollment: %w", err)
+	}
+
+	if tag.RowsAffected() == 0 {
+		return mfa.ErrNotEnrolled
+	}
+
+	if _, err := tx.Exec(ctx, `DELETE FROM mfa_recovery_codes WHERE user_id = $1`, userID); err != nil {
+		return fmt.Errorf("failed to delete recovery codes: %w", err)
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	return nil
+}
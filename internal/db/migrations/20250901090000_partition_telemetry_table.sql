@@ -0,0 +1,112 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE OR REPLACE FUNCTION telemetry_create_partition(p_month TIMESTAMPTZ)
+RETURNS VOID
+LANGUAGE plpgsql
+SET timezone = 'UTC'
+AS $$
+DECLARE
+    v_start TIMESTAMPTZ := date_trunc('month', p_month);
+    v_end   TIMESTAMPTZ := date_trunc('month', p_month) + INTERVAL '1 month';
+    v_name  TEXT        := 'telemetry_' || to_char(date_trunc('month', p_month), 'YYYY_MM');
+BEGIN
+    -- serialise partition maintenance across replicas
+    PERFORM pg_advisory_xact_lock(hashtext('telemetry_partitions'));
+
+    IF to_regclass(v_name) IS NOT NULL THEN
+        RETURN;
+    END IF;
+
+    EXECUTE format(
+        'CREATE TABLE %I (LIKE telemetry INCLUDING DEFAULTS INCLUDING CONSTRAINTS)',
+        v_name
+    );
+
+    -- rows that landed in the default partition must move before the range can be attached
+    IF to_regclass('telemetry_default') IS NOT NULL THEN
+        EXECUTE format(
+            'WITH moved AS (
+                DELETE FROM telemetry_default
+                WHERE recorded_at >= $1 AND recorded_at < $2
+                RETURNING *
+            )
+            INSERT INTO %I SELECT * FROM moved',
+            v_name
+        ) USING v_start, v_end;
+    END IF;
+
+    EXECUTE format(
+        'ALTER TABLE telemetry ATTACH PARTITION %I FOR VALUES FROM (%L) TO (%L)',
+        v_name, v_start, v_end
+    );
+END;
+$$;
+-- +goose StatementEnd
+
+ALTER TABLE telemetry RENAME TO telemetry_unpartitioned;
+ALTER TABLE telemetry_unpartitioned RENAME CONSTRAINT telemetry_pkey TO telemetry_unpartitioned_pkey;
+ALTER TABLE telemetry_unpartitioned RENAME CONSTRAINT telemetry_device_id_fkey TO telemetry_unpartitioned_device_id_fkey;
+
+CREATE TABLE telemetry (
+    id UUID NOT NULL DEFAULT gen_random_uuid(),
+    device_id UUID NOT NULL REFERENCES devices(id) ON DELETE CASCADE,
+    telemetry_type VARCHAR(50) NOT NULL,
+    payload JSONB,
+    recorded_at TIMESTAMPTZ NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    PRIMARY KEY (id, recorded_at)
+) PARTITION BY RANGE (recorded_at);
+
+CREATE INDEX telemetry_device_id_recorded_at_idx ON telemetry (device_id, recorded_at);
+
+CREATE TABLE telemetry_default PARTITION OF telemetry DEFAULT;
+
+-- +goose StatementBegin
+DO $$
+DECLARE
+    v_month TIMESTAMPTZ;
+BEGIN
+    FOR v_month IN
+        SELECT generate_series(
+            date_trunc('month', LEAST(COALESCE(min(recorded_at), now()), now()) AT TIME ZONE 'UTC') AT TIME ZONE 'UTC',
+            date_trunc('month', GREATEST(COALESCE(max(recorded_at), now()), now()) AT TIME ZONE 'UTC') AT TIME ZONE 'UTC'
+                + INTERVAL '2 months',
+            INTERVAL '1 month'
+        )
+        FROM telemetry_unpartitioned
+    LOOP
+        PERFORM telemetry_create_partition(v_month);
+    END LOOP;
+END;
+$$;
+-- +goose StatementEnd
+
+INSERT INTO telemetry (id, device_id, telemetry_type, payload, recorded_at, created_at)
+SELECT id, device_id, telemetry_type, payload, recorded_at, created_at
+FROM telemetry_unpartitioned;
+
+DROP TABLE telemetry_unpartitioned;
+
+-- +goose Down
+ALTER TABLE telemetry RENAME TO telemetry_partitioned;
+
+CREATE TABLE telemetry (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    device_id UUID NOT NULL,
+    telemetry_type VARCHAR(50) NOT NULL,
+    payload JSONB,
+    recorded_at TIMESTAMPTZ NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+INSERT INTO telemetry (id, device_id, telemetry_type, payload, recorded_at, created_at)
+SELECT id, device_id, telemetry_type, payload, recorded_at, created_at
+FROM telemetry_partitioned;
+
+DROP TABLE telemetry_partitioned;
+
+ALTER TABLE telemetry
+    ADD CONSTRAINT telemetry_device_id_fkey
+    FOREIGN KEY (device_id) REFERENCES devices(id) ON DELETE CASCADE;
+
+DROP FUNCTION telemetry_create_partition(TIMESTAMPTZ);
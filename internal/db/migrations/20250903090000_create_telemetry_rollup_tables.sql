@@ -0,0 +1,39 @@
+-- +goose Up
+CREATE INDEX telemetry_created_at_idx ON telemetry (created_at);
+
+CREATE TABLE telemetry_rollup_1m (
+    device_id UUID NOT NULL REFERENCES devices(id) ON DELETE CASCADE,
+    telemetry_type VARCHAR(50) NOT NULL,
+    field TEXT NOT NULL,
+    bucket TIMESTAMPTZ NOT NULL,
+    sample_count BIGINT NOT NULL,
+    sum DOUBLE PRECISION NOT NULL,
+    min DOUBLE PRECISION NOT NULL,
+    max DOUBLE PRECISION NOT NULL,
+    PRIMARY KEY (device_id, field, bucket, telemetry_type)
+);
+
+CREATE TABLE telemetry_rollup_1h (LIKE telemetry_rollup_1m INCLUDING ALL);
+ALTER TABLE telemetry_rollup_1h
+    ADD FOREIGN KEY (device_id) REFERENCES devices(id) ON DELETE CASCADE;
+
+CREATE TABLE telemetry_rollup_1d (LIKE telemetry_rollup_1m INCLUDING ALL);
+ALTER TABLE telemetry_rollup_1d
+    ADD FOREIGN KEY (device_id) REFERENCES devices(id) ON DELETE CASCADE;
+
+CREATE INDEX telemetry_rollup_1m_bucket_idx ON telemetry_rollup_1m (bucket);
+CREATE INDEX telemetry_rollup_1h_bucket_idx ON telemetry_rollup_1h (bucket);
+CREATE INDEX telemetry_rollup_1d_bucket_idx ON telemetry_rollup_1d (bucket);
+
+-- how far (by ingestion time) each tier has aggregated the raw table
+CREATE TABLE telemetry_rollup_watermarks (
+    resolution TEXT PRIMARY KEY,
+    processed_until TIMESTAMPTZ NOT NULL
+);
+
+-- +goose Down
+DROP TABLE telemetry_rollup_watermarks;
+DROP TABLE telemetry_rollup_1d;
+DROP TABLE telemetry_rollup_1h;
+DROP TABLE telemetry_rollup_1m;
+DROP INDEX telemetry_created_at_idx;
@@ -0,0 +1,29 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE IF NOT EXISTS export_jobs (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    device_ids UUID[],
+    range_from TIMESTAMPTZ,
+    range_to TIMESTAMPTZ,
+    format TEXT NOT NULL CHECK (format IN ('csv', 'ndjson')),
+    gzip BOOLEAN NOT NULL DEFAULT FALSE,
+    status TEXT NOT NULL DEFAULT 'pending' CHECK (status IN ('pending', 'running', 'completed', 'failed')),
+    rows_exported BIGINT NOT NULL DEFAULT 0,
+    total_rows BIGINT,
+    artifact_key TEXT,
+    artifact_size BIGINT,
+    error TEXT,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    started_at TIMESTAMPTZ,
+    heartbeat_at TIMESTAMPTZ,
+    completed_at TIMESTAMPTZ
+);
+
+CREATE INDEX export_jobs_status_created_at_idx ON export_jobs (status, created_at);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE export_jobs;
+-- +goose StatementEnd
@@ -0,0 +1,17 @@
+-- +goose Up
+-- A unique constraint on the partitioned telemetry table would have to
+-- include recorded_at, so message ids are claimed in their own table.
+CREATE TABLE telemetry_message_ids (
+    device_id UUID NOT NULL REFERENCES devices(id) ON DELETE CASCADE,
+    message_id VARCHAR(128) NOT NULL,
+    telemetry_id UUID NOT NULL,
+    recorded_at TIMESTAMPTZ NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (device_id, message_id)
+);
+
+-- lets partition maintenance purge ids together with their readings
+CREATE INDEX telemetry_message_ids_recorded_at_idx ON telemetry_message_ids (recorded_at);
+
+-- +goose Down
+DROP TABLE telemetry_message_ids;
@@ -0,0 +1,8 @@
+-- +goose Up
+-- telemetry is listed and paged by (recorded_at, id) within a device
+CREATE INDEX telemetry_device_id_recorded_at_id_idx ON telemetry (device_id, recorded_at, id);
+DROP INDEX telemetry_device_id_recorded_at_idx;
+
+-- +goose Down
+CREATE INDEX telemetry_device_id_recorded_at_idx ON telemetry (device_id, recorded_at);
+DROP INDEX telemetry_device_id_recorded_at_id_idx;
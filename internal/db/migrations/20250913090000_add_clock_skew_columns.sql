@@ -0,0 +1,17 @@
+-- +goose Up
+ALTER TABLE devices
+    ADD COLUMN clock_skew_ms BIGINT,
+    ADD COLUMN clock_skew_updated_at TIMESTAMPTZ;
+
+ALTER TABLE telemetry
+    ADD COLUMN device_recorded_at TIMESTAMPTZ,
+    ADD COLUMN clock_skew_flagged BOOLEAN NOT NULL DEFAULT FALSE;
+
+-- +goose Down
+ALTER TABLE telemetry
+    DROP COLUMN clock_skew_flagged,
+    DROP COLUMN device_recorded_at;
+
+ALTER TABLE devices
+    DROP COLUMN clock_skew_updated_at,
+    DROP COLUMN clock_skew_ms;
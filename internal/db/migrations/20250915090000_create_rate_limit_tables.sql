@@ -0,0 +1,19 @@
+-- +goose Up
+-- buckets are cheap to lose (a crash just refills them), so skip the WAL
+CREATE UNLOGGED TABLE rate_limit_buckets (
+    bucket_key TEXT PRIMARY KEY,
+    tat TIMESTAMPTZ NOT NULL
+);
+
+CREATE INDEX rate_limit_buckets_tat_idx ON rate_limit_buckets (tat);
+
+CREATE TABLE quota_usage (
+    quota_key TEXT NOT NULL,
+    day DATE NOT NULL,
+    used BIGINT NOT NULL,
+    PRIMARY KEY (quota_key, day)
+);
+
+-- +goose Down
+DROP TABLE quota_usage;
+DROP TABLE rate_limit_buckets;
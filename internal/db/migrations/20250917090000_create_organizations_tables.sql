@@ -0,0 +1,65 @@
+-- +goose Up
+CREATE TABLE organizations (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    name VARCHAR(100) NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE TABLE organization_members (
+    organization_id UUID NOT NULL REFERENCES organizations(id) ON DELETE CASCADE,
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    role VARCHAR(20) NOT NULL CHECK (role IN ('owner', 'admin', 'operator', 'viewer')),
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (organization_id, user_id)
+);
+
+CREATE INDEX idx_organization_members_user_id ON organization_members (user_id);
+
+-- every existing user gets a personal organization that takes over their
+-- devices
+CREATE TEMP TABLE personal_organizations ON COMMIT DROP AS
+SELECT id AS user_id, gen_random_uuid() AS organization_id, username
+FROM users;
+
+INSERT INTO organizations (id, name)
+SELECT organization_id, username FROM personal_organizations;
+
+INSERT INTO organization_members (organization_id, user_id, role)
+SELECT organization_id, user_id, 'owner' FROM personal_organizations;
+
+ALTER TABLE devices
+    ADD COLUMN organization_id UUID REFERENCES organizations(id) ON DELETE CASCADE;
+
+UPDATE devices d
+SET organization_id = p.organization_id
+FROM personal_organizations p
+WHERE p.user_id = d.user_id;
+
+ALTER TABLE devices
+    ALTER COLUMN organization_id SET NOT NULL,
+    DROP COLUMN user_id;
+
+CREATE INDEX idx_devices_organization_id_created_at_id ON devices (organization_id, created_at, id);
+
+-- +goose Down
+ALTER TABLE devices
+    ADD COLUMN user_id UUID REFERENCES users(id) ON DELETE CASCADE;
+
+-- devices go back to the longest-standing owner of their organization
+UPDATE devices d
+SET user_id = (
+    SELECT m.user_id
+    FROM organization_members m
+    WHERE m.organization_id = d.organization_id AND m.role = 'owner'
+    ORDER BY m.created_at ASC, m.user_id ASC
+    LIMIT 1
+);
+
+DELETE FROM devices WHERE user_id IS NULL;
+
+ALTER TABLE devices
+    ALTER COLUMN user_id SET NOT NULL,
+    DROP COLUMN organization_id;
+
+DROP TABLE organization_members;
+DROP TABLE organizations;
@@ -0,0 +1,32 @@
+-- +goose Up
+ALTER TABLE tokens
+    DROP CONSTRAINT tokens_scope_check,
+    ADD CONSTRAINT tokens_scope_check
+        CHECK (scope IN ('auth', 'email_verification', 'password_reset', 'personal_access')),
+    ADD COLUMN name VARCHAR(100),
+    ADD COLUMN prefix VARCHAR(16),
+    ADD COLUMN access_scopes TEXT[] NOT NULL DEFAULT '{}',
+    ALTER COLUMN expires_at DROP NOT NULL,
+    -- only personal access tokens may live until revoked
+    ADD CONSTRAINT tokens_expires_at_check
+        CHECK (expires_at IS NOT NULL OR scope = 'personal_access'),
+    ADD CONSTRAINT tokens_personal_access_check
+        CHECK (scope <> 'personal_access' OR (name IS NOT NULL AND prefix IS NOT NULL));
+
+CREATE INDEX idx_tokens_user_id_scope ON tokens (user_id, scope, created_at);
+
+-- +goose Down
+DROP INDEX idx_tokens_user_id_scope;
+
+DELETE FROM tokens WHERE scope = 'personal_access';
+
+ALTER TABLE tokens
+    DROP CONSTRAINT tokens_personal_access_check,
+    DROP CONSTRAINT tokens_expires_at_check,
+    ALTER COLUMN expires_at SET NOT NULL,
+    DROP COLUMN access_scopes,
+    DROP COLUMN prefix,
+    DROP COLUMN name,
+    DROP CONSTRAINT tokens_scope_check,
+    ADD CONSTRAINT tokens_scope_check
+        CHECK (scope IN ('auth', 'email_verification', 'password_reset'));
@@ -0,0 +1,10 @@
+-- +goose Up
+ALTER TABLE users ADD COLUMN email_verified_at TIMESTAMPTZ;
+
+-- accounts created before verification existed are trusted as they are
+UPDATE users SET email_verified_at = created_at;
+
+-- +goose Down
+DELETE FROM tokens WHERE scope = 'email_verification';
+
+ALTER TABLE users DROP COLUMN email_verified_at;
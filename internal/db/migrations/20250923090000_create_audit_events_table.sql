@@ -0,0 +1,15 @@
+-- +goose Up
+CREATE TABLE audit_events (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    -- kept when the user is deleted so the trail survives
+    user_id UUID REFERENCES users(id) ON DELETE SET NULL,
+    event_type TEXT NOT NULL,
+    metadata JSONB NOT NULL DEFAULT '{}',
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE INDEX idx_audit_events_user_id_created_at ON audit_events (user_id, created_at);
+CREATE INDEX idx_audit_events_event_type_created_at ON audit_events (event_type, created_at);
+
+-- +goose Down
+DROP TABLE audit_events;
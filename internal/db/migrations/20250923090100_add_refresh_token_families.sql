@@ -0,0 +1,17 @@
+-- +goose Up
+ALTER TABLE tokens ADD COLUMN family_id UUID;
+
+-- every refresh token issued so far starts its own family
+UPDATE tokens SET family_id = id WHERE scope = 'auth';
+
+ALTER TABLE tokens
+    ADD CONSTRAINT tokens_family_id_check CHECK (scope <> 'auth' OR family_id IS NOT NULL);
+
+CREATE INDEX idx_tokens_family_id ON tokens (family_id) WHERE family_id IS NOT NULL;
+
+-- +goose Down
+DROP INDEX idx_tokens_family_id;
+
+ALTER TABLE tokens
+    DROP CONSTRAINT tokens_family_id_check,
+    DROP COLUMN family_id;
@@ -0,0 +1,23 @@
+-- +goose Up
+ALTER TABLE tokens
+    ADD COLUMN user_agent TEXT,
+    ADD COLUMN ip TEXT,
+    -- when the login behind a refresh token family happened, carried over
+    -- on every rotation
+    ADD COLUMN session_started_at TIMESTAMPTZ,
+    -- set when a refresh token is exchanged, so presenting it again can be
+    -- told apart from presenting one that was revoked on logout
+    ADD COLUMN rotated_at TIMESTAMPTZ;
+
+UPDATE tokens SET session_started_at = created_at WHERE scope = 'auth';
+
+CREATE INDEX idx_tokens_expires_at ON tokens (expires_at);
+
+-- +goose Down
+DROP INDEX idx_tokens_expires_at;
+
+ALTER TABLE tokens
+    DROP COLUMN rotated_at,
+    DROP COLUMN session_started_at,
+    DROP COLUMN ip,
+    DROP COLUMN user_agent;
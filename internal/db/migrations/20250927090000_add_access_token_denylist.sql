@@ -0,0 +1,17 @@
+-- +goose Up
+ALTER TABLE users ADD COLUMN is_admin BOOLEAN NOT NULL DEFAULT false;
+
+CREATE TABLE access_token_denylist (
+    jti UUID PRIMARY KEY,
+    -- when the token would have expired anyway; the entry can go then
+    expires_at TIMESTAMPTZ NOT NULL,
+    revoked_by UUID REFERENCES users(id) ON DELETE SET NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE INDEX idx_access_token_denylist_expires_at ON access_token_denylist (expires_at);
+
+-- +goose Down
+DROP TABLE access_token_denylist;
+
+ALTER TABLE users DROP COLUMN is_admin;
@@ -0,0 +1,13 @@
+-- +goose Up
+CREATE TABLE login_failures (
+    -- account:<email> or ip:<address>
+    key TEXT PRIMARY KEY,
+    failures INTEGER NOT NULL,
+    last_failed_at TIMESTAMPTZ NOT NULL,
+    locked_until TIMESTAMPTZ
+);
+
+CREATE INDEX idx_login_failures_last_failed_at ON login_failures (last_failed_at);
+
+-- +goose Down
+DROP TABLE login_failures;
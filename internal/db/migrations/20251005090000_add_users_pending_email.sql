@@ -0,0 +1,6 @@
+-- +goose Up
+-- a requested email change, applied once the new address is verified
+ALTER TABLE users ADD COLUMN pending_email TEXT;
+
+-- +goose Down
+ALTER TABLE users DROP COLUMN pending_email;
@@ -0,0 +1,24 @@
+-- +goose Up
+-- the organization a user got at registration, used when a request names
+-- none; it outlives the user when others still belong to it
+ALTER TABLE organizations
+    ADD COLUMN personal_user_id UUID UNIQUE REFERENCES users(id) ON DELETE SET NULL;
+
+-- the personal organization is the oldest one a user joined first; later
+-- organizations have the user join after their creator
+UPDATE organizations o
+SET personal_user_id = p.user_id
+FROM (
+    SELECT DISTINCT ON (f.user_id) f.user_id, f.organization_id
+    FROM (
+        SELECT DISTINCT ON (m.organization_id) m.organization_id, m.user_id, o.created_at
+        FROM organization_members m
+        JOIN organizations o ON o.id = m.organization_id
+        ORDER BY m.organization_id, m.created_at ASC, m.user_id ASC
+    ) f
+    ORDER BY f.user_id, f.created_at ASC, f.organization_id ASC
+) p
+WHERE o.id = p.organization_id;
+
+-- +goose Down
+ALTER TABLE organizations DROP COLUMN personal_user_id;
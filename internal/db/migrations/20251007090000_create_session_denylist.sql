@@ -0,0 +1,14 @@
+-- +goose Up
+-- ended sessions, by refresh token family, whose access tokens may not be
+-- used until they would have expired anyway
+CREATE TABLE session_denylist (
+    session_id UUID PRIMARY KEY,
+    expires_at TIMESTAMPTZ NOT NULL,
+    revoked_by UUID REFERENCES users(id) ON DELETE SET NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE INDEX idx_session_denylist_expires_at ON session_denylist (expires_at);
+
+-- +goose Down
+DROP TABLE session_denylist;
@@ -0,0 +1,17 @@
+-- +goose Up
+-- completed jobs whose artifact was deleted after EXPORT_ARTIFACT_TTL
+ALTER TABLE export_jobs DROP CONSTRAINT export_jobs_status_check;
+ALTER TABLE export_jobs ADD CONSTRAINT export_jobs_status_check
+    CHECK (status IN ('pending', 'running', 'completed', 'failed', 'expired'));
+
+CREATE INDEX idx_export_jobs_completed_at ON export_jobs (completed_at)
+    WHERE status = 'completed';
+
+-- +goose Down
+DROP INDEX idx_export_jobs_completed_at;
+
+UPDATE export_jobs SET status = 'failed', error = 'artifact expired' WHERE status = 'expired';
+
+ALTER TABLE export_jobs DROP CONSTRAINT export_jobs_status_check;
+ALTER TABLE export_jobs ADD CONSTRAINT export_jobs_status_check
+    CHECK (status IN ('pending', 'running', 'completed', 'failed'));
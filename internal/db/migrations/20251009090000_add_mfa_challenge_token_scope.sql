@@ -0,0 +1,13 @@
+-- +goose Up
+ALTER TABLE tokens
+    DROP CONSTRAINT tokens_scope_check,
+    ADD CONSTRAINT tokens_scope_check
+        CHECK (scope IN ('auth', 'email_verification', 'password_reset', 'personal_access', 'mfa_challenge'));
+
+-- +goose Down
+DELETE FROM tokens WHERE scope = 'mfa_challenge';
+
+ALTER TABLE tokens
+    DROP CONSTRAINT tokens_scope_check,
+    ADD CONSTRAINT tokens_scope_check
+        CHECK (scope IN ('auth', 'email_verification', 'password_reset', 'personal_access'));
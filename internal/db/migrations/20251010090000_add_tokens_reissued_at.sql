@@ -0,0 +1,7 @@
+-- +goose Up
+-- when a rotated refresh token was exchanged once more inside the reuse
+-- grace window, which it only may be once
+ALTER TABLE tokens ADD COLUMN reissued_at TIMESTAMPTZ;
+
+-- +goose Down
+ALTER TABLE tokens DROP COLUMN reissued_at;
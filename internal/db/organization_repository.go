@@ -0,0 +1,456 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/raphico/go-device-telemetry-api/internal/authz"
+	"github.com/raphico/go-device-telemetry-api/internal/organization"
+	"github.com/raphico/go-device-telemetry-api/internal/user"
+)
+
+type OrganizationRepository struct {
+	db *pgxpool.Pool
+}
+
+func NewOrganizationRepository(db *pgxpool.Pool) *OrganizationRepository {
+	return &OrganizationRepository{
+		db: db,
+	}
+}
+
+func (r *OrganizationRepository) Create(
+	ctx context.Context,
+	org *organization.Organization,
+	owner *organization.Membership,
+) error {
+	tx, err := r.db.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer func() { _ = tx.Rollback(ctx) }()
+
+	query := `
+		INSERT INTO organizations (name)
+		VALUES ($1)
+		RETURNING id, created_at
+	`
+
+	if err := tx.QueryRow(ctx, query, org.Name.String()).Scan(&org.ID, &org.CreatedAt); err != nil {
+		return fmt.Errorf("failed to insert organization: %w", err)
+	}
+
+	owner.OrganizationID = org.ID
+	if err := insertMember(ctx, tx, owner); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return fmt.Errorf("failed to commit organization: %w", err)
+	}
+
+	return nil
+}
+
+func (r *OrganizationRepository) FindById(
+	ctx context.Context,
+	id organization.OrganizationID,
+) (*organization.Organization, error) {
+	var (
+		orgID     uuid.UUID
+		name      string
+		createdAt time.Time
+	)
+
+	query := `SELECT id, name, created_at FROM organizations WHERE id = $1`
+
+	err := r.db.QueryRow(ctx, query, id).Scan(&orgID, &name, &createdAt)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, organization.ErrOrganizationNotFound
+		}
+		return nil, fmt.Errorf("failed to find organization by id: %w", err)
+	}
+
+	return organization.RehydrateOrganization(orgID, name, createdAt)
+}
+
+func (r *OrganizationRepository) FindByUser(
+	ctx context.Context,
+	userID user.UserID,
+) ([]*organization.Affiliation, error) {
+	query := `
+		SELECT o.id, o.name, o.created_at, m.role, o.personal_user_id IS NOT DISTINCT FROM m.user_id
+		FROM organizations o
+		JOIN organization_members m ON m.organization_id = o.id
+		WHERE m.user_id = $1
+		ORDER BY o.created_at ASC, o.id ASC
+	`
+
+	rows, err := r.db.Query(ctx, query, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query organizations: %w", err)
+	}
+	defer rows.Close()
+
+	var result []*organization.Affiliation
+
+	for rows.Next() {
+		var (
+			orgID     uuid.UUID
+			name      string
+			createdAt time.Time
+			role      string
+			personal  bool
+		)
+
+		if err := rows.Scan(&orgID, &name, &createdAt, &role, &personal); err != nil {
+			return nil, fmt.Errorf("failed to scan organization: %w", err)
+		}
+
+		org, err := organization.RehydrateOrganization(orgID, name, createdAt)
+		if err != nil {
+			return nil, fmt.Errorf("failed to rehydrate organization: %w", err)
+		}
+
+		memberRole, err := authz.NewRole(role)
+		if err != nil {
+			return nil, fmt.Errorf("corrupt membership role: %w", err)
+		}
+
+		result = append(result, &organization.Affiliation{
+			Organization: org,
+			Role:         memberRole,
+			Personal:     personal,
+		})
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("rows error: %w", err)
+	}
+
+	return result, nil
+}
+
+func (r *OrganizationRepository) FindSoleOwnerships(
+	ctx context.Context,
+	userID user.UserID,
+) ([]*organization.Organization, error) {
+	query := `
+		SELECT o.id, o.name, o.created_at
+		FROM organizations o
+		JOIN organization_members m ON m.organization_id = o.id
+		WHERE m.user_id = $1
+		  AND m.role = 'owner'
+		  AND NOT EXISTS (
+			SELECT 1 FROM organization_members other
+			WHERE other.organization_id = o.id
+			  AND other.user_id <> $1
+			  AND other.role = 'owner'
+		  )
+		  AND EXISTS (
+			SELECT 1 FROM organization_members other
+			WHERE other.organization_id = o.id
+			  AND other.user_id <> $1
+		  )
+		ORDER BY o.created_at ASC, o.id ASC
+	`
+
+	rows, err := r.db.Query(ctx, query, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query organizations: %w", err)
+	}
+	defer rows.Close()
+
+	var result []*organization.Organization
+
+	for rows.Next() {
+		var (
+			orgID     uuid.UUID
+			name      string
+			createdAt time.Time
+		)
+
+		if err := rows.Scan(&orgID, &name, &createdAt); err != nil {
+			return nil, fmt.Errorf("failed to scan organization: %w", err)
+		}
+
+		org, err := organization.RehydrateOrganization(orgID, name, createdAt)
+		if err != nil {
+			return nil, fmt.Errorf("failed to rehydrate organization: %w", err)
+		}
+
+		result = append(result, org)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("rows error: %w", err)
+	}
+
+	return result, nil
+}
+
+func (r *OrganizationRepository) FindMembership(
+	ctx context.Context,
+	id organization.OrganizationID,
+	userID user.UserID,
+) (*organization.Membership, error) {
+	var (
+		orgID     uuid.UUID
+		uID       uuid.UUID
+		role      string
+		createdAt time.Time
+	)
+
+	query := `
+		SELECT organization_id, user_id, role, created_at
+		FROM organization_members
+		WHERE organization_id = $1 AND user_id = $2
+	`
+
+	err := r.db.QueryRow(ctx, query, id, userID).Scan(&orgID, &uID, &role, &createdAt)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, organization.ErrMemberNotFound
+		}
+		return nil, fmt.Errorf("failed to find membership: %w", err)
+	}
+
+	return organization.RehydrateMembership(orgID, uID, role, createdAt)
+}
+
+// FindRole implements authz.MembershipFinder.
+func (r *OrganizationRepository) FindRole(
+	ctx context.Context,
+	organizationID uuid.UUID,
+	userID user.UserID,
+) (authz.Role, error) {
+	var role string
+
+	query := `
+		SELECT role
+		FROM organization_members
+		WHERE organization_id = $1 AND user_id = $2
+	`
+
+	err := r.db.QueryRow(ctx, query, organizationID, userID).Scan(&role)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return authz.Role{}, authz.ErrNotMember
+		}
+		return authz.Role{}, fmt.Errorf("failed to find membership role: %w", err)
+	}
+
+	return authz.NewRole(role)
+}
+
+func (r *OrganizationRepository) FindMembers(
+	ctx context.Context,
+	id organization.OrganizationID,
+) ([]*organization.Membership, error) {
+	query := `
+		SELECT organization_id, user_id, role, created_at
+		FROM organization_members
+		WHERE organization_id = $1
+		ORDER BY created_at ASC, user_id ASC
+	`
+
+	rows, err := r.db.Query(ctx, query, id)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query members: %w", err)
+	}
+	defer rows.Close()
+
+	var result []*organization.Membership
+
+	for rows.Next() {
+		var (
+			orgID     uuid.UUID
+			uID       uuid.UUID
+			role      string
+			createdAt time.Time
+		)
+
+		if err := rows.Scan(&orgID, &uID, &role, &createdAt); err != nil {
+			return nil, fmt.Errorf("failed to scan member: %w", err)
+		}
+
+		m, err := organization.RehydrateMembership(orgID, uID, role, createdAt)
+		if err != nil {
+			return nil, fmt.Errorf("failed to rehydrate member: %w", err)
+		}
+
+		result = append(result, m)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("rows error: %w", err)
+	}
+
+	return result, nil
+}
+
+func (r *OrganizationRepository) AddMember(ctx context.Context, m *organization.Membership) error {
+	return insertMember(ctx, r.db, m)
+}
+
+func (r *OrganizationRepository) UpdateMemberRole(ctx context.Context, m *organization.Membership) error {
+	tx, err := r.db.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer func() { _ = tx.Rollback(ctx) }()
+
+	current, err := lockMember(ctx, tx, m.OrganizationID, m.UserID)
+	if err != nil {
+		return err
+	}
+
+	if current == authz.RoleOwner && m.Role != authz.RoleOwner {
+		if err := ensureAnotherOwner(ctx, tx, m.OrganizationID); err != nil {
+			return err
+		}
+	}
+
+	query := `
+		UPDATE organization_members
+		SET role = $1
+		WHERE organization_id = $2 AND user_id = $3
+	`
+
+	if _, err := tx.Exec(ctx, query, m.Role.String(), m.OrganizationID, m.UserID); err != nil {
+		return fmt.Errorf("failed to update member role: %w", err)
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return fmt.Errorf("failed to commit member role: %w", err)
+	}
+
+	return nil
+}
+
+func (r *OrganizationRepository) RemoveMember(
+	ctx context.Context,
+	id organization.OrganizationID,
+	userID user.UserID,
+) error {
+	tx, err := r.db.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer func() { _ = tx.Rollback(ctx) }()
+
+	current, err := lockMember(ctx, tx, id, userID)
+	if err != nil {
+		return err
+	}
+
+	if current == authz.RoleOwner {
+		if err := ensureAnotherOwner(ctx, tx, id); err != nil {
+			return err
+		}
+	}
+
+	query := `DELETE FROM organization_members WHERE organization_id = $1 AND user_id = $2`
+
+	if _, err := tx.Exec(ctx, query, id, userID); err != nil {
+		return fmt.Errorf("failed to remove member: %w", err)
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return fmt.Errorf("failed to commit member removal: %w", err)
+	}
+
+	return nil
+}
+
+type rowQuerier interface {
+	QueryRow(ctx context.Context, sql string, args ...any) pgx.Row
+}
+
+func insertMember(ctx context.Context, q rowQuerier, m *organization.Membership) error {
+	query := `
+		INSERT INTO organization_members (organization_id, user_id, role)
+		VALUES ($1, $2, $3)
+		RETURNING created_at
+	`
+
+	err := q.QueryRow(ctx, query, m.OrganizationID, m.UserID, m.Role.String()).Scan(&m.CreatedAt)
+	if err != nil {
+		var pgError *pgconn.PgError
+		if errors.As(err, &pgError) {
+			switch {
+			case pgError.Code == "23505":
+				return organization.ErrAlreadyMember
+			case pgError.Code == "23503" && pgError.ConstraintName == "organization_members_organization_id_fkey":
+				return organization.ErrOrganizationNotFound
+			case pgError.Code == "23503" && pgError.ConstraintName == "organization_members_user_id_fkey":
+				return user.ErrUserNotFound
+			}
+		}
+
+		return fmt.Errorf("failed to insert member: %w", err)
+	}
+
+	return nil
+}
+
+// lockMember locks the organization row, serializing owner changes so two
+// owners cannot demote each other at once, and returns the member's role.
+func lockMember(
+	ctx context.Context,
+	tx pgx.Tx,
+	id organization.OrganizationID,
+	userID user.UserID,
+) (authz.Role, error) {
+	var orgID uuid.UUID
+	err := tx.QueryRow(ctx, `SELECT id FROM organizations WHERE id = $1 FOR UPDATE`, id).Scan(&orgID)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return authz.Role{}, organization.ErrOrganizationNotFound
+		}
+		return authz.Role{}, fmt.Errorf("failed to lock organization: %w", err)
+	}
+
+	var role string
+	query := `
+		SELECT role
+		FROM organization_members
+		WHERE organization_id = $1 AND user_id = $2
+	`
+
+	err = tx.QueryRow(ctx, query, id, userID).Scan(&role)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return authz.Role{}, organization.ErrMemberNotFound
+		}
+		return authz.Role{}, fmt.Errorf("failed to find membership: %w", err)
+	}
+
+	return authz.NewRole(role)
+}
+
+func ensureAnotherOwner(ctx context.Context, tx pgx.Tx, id organization.OrganizationID) error {
+	var owners int
+	query := `
+		SELECT COUNT(*)
+		FROM organization_members
+		WHERE organization_id = $1 AND role = $2
+	`
+
+	if err := tx.QueryRow(ctx, query, id, authz.RoleOwner.String()).Scan(&owners); err != nil {
+		return fmt.Errorf("failed to count owners: %w", err)
+	}
+
+	if owners <= 1 {
+		return organization.ErrLastOwner
+	}
+
+	return nil
+}
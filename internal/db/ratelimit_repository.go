@@ -0,0 +1,154 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/raphico/go-device-telemetry-api/internal/ratelimit"
+)
+
+// RateLimitRepository keeps buckets and quotas in Postgres so every replica
+// shares them.
+type RateLimitRepository struct {
+	db *pgxpool.Pool
+}
+
+func NewRateLimitRepository(db *pgxpool.Pool) *RateLimitRepository {
+	return &RateLimitRepository{db: db}
+}
+
+func (r *RateLimitRepository) Take(
+	ctx context.Context,
+	key string,
+	limit ratelimit.Limit,
+	now time.Time,
+) (ratelimit.Result, error) {
+	// the update only happens when the request fits; otherwise the second
+	// branch returns the unchanged tat so the caller can tell how long to
+	// wait
+	query := `
+		WITH taken AS (
+			INSERT INTO rate_limit_buckets AS b (bucket_key, tat)
+			VALUES ($1, $2::timestamptz + $3::bigint * INTERVAL '1 microsecond')
+			ON CONFLICT (bucket_key) DO UPDATE
+			SET tat = GREATEST(b.tat, $2::timestamptz) + $3::bigint * INTERVAL '1 microsecond'
+			WHERE GREATEST(b.tat, $2::timestamptz) + $3::bigint * INTERVAL '1 microsecond'
+				<= $2::timestamptz + $4::bigint * INTERVAL '1 microsecond'
+			RETURNING tat
+		)
+		SELECT tat, TRUE FROM taken
+		UNION ALL
+		SELECT tat, FALSE FROM rate_limit_buckets
+		WHERE bucket_key = $1 AND NOT EXISTS (SELECT 1 FROM taken)
+	`
+
+	// a purge can delete the bucket between the two branches, so give the
+	// statement a second chance
+	for range 2 {
+		var (
+			tat     time.Time
+			allowed bool
+		)
+
+		err := r.db.QueryRow(
+			ctx,
+			query,
+			key,
+			now,
+			limit.Interval().Microseconds(),
+			limit.Period.Microseconds(),
+		).Scan(&tat, &allowed)
+
+		if errors.Is(err, pgx.ErrNoRows) {
+			continue
+		}
+		if err != nil {
+			return ratelimit.Result{}, fmt.Errorf("failed to take rate limit token: %w", err)
+		}
+
+		if allowed {
+			return limit.Admitted(tat, now), nil
+		}
+
+		_, res := limit.Take(tat, now)
+		return res, nil
+	}
+
+	return ratelimit.Result{}, errors.New("failed to take rate limit token: bucket kept disappearing")
+}
+
+func (r *RateLimitRepository) AddUsage(
+	ctx context.Context,
+	key string,
+	day time.Time,
+	n, ceiling int64,
+) (int64, bool, error) {
+	if ceiling >= 0 && n > ceiling {
+		used, err := r.usage(ctx, key, day)
+		return used, false, err
+	}
+
+	query := `
+		WITH added AS (
+			INSERT INTO quota_usage AS q (quota_key, day, used)
+			VALUES ($1, $2, $3)
+			ON CONFLICT (quota_key, day) DO UPDATE
+			SET used = q.used + $3
+			WHERE $4 < 0 OR q.used + $3 <= $4
+			RETURNING used
+		)
+		SELECT used, TRUE FROM added
+		UNION ALL
+		SELECT used, FALSE FROM quota_usage
+		WHERE quota_key = $1 AND day = $2 AND NOT EXISTS (SELECT 1 FROM added)
+	`
+
+	var (
+		used  int64
+		added bool
+	)
+
+	err := r.db.QueryRow(ctx, query, key, day, n, ceiling).Scan(&used, &added)
+	if err != nil {
+		return 0, false, fmt.Errorf("failed to add quota usage: %w", err)
+	}
+
+	return used, added, nil
+}
+
+func (r *RateLimitRepository) usage(ctx context.Context, key string, day time.Time) (int64, error) {
+	var used int64
+
+	err := r.db.QueryRow(
+		ctx,
+		`SELECT used FROM quota_usage WHERE quota_key = $1 AND day = $2`,
+		key,
+		day,
+	).Scan(&used)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed to read quota usage: %w", err)
+	}
+
+	return used, nil
+}
+
+func (r *RateLimitRepository) DeleteExpired(ctx context.Context, now time.Time) (int64, error) {
+	buckets, err := r.db.Exec(ctx, `DELETE FROM rate_limit_buckets WHERE tat < $1`, now)
+	if err != nil {
+		return 0, fmt.Errorf("failed to purge rate limit buckets: %w", err)
+	}
+
+	usage, err := r.db.Exec(ctx, `DELETE FROM quota_usage WHERE day < $1`, ratelimit.QuotaDay(now))
+	if err != nil {
+		return 0, fmt.Errorf("failed to purge quota usage: %w", err)
+	}
+
+	return buckets.RowsAffected() + usage.RowsAffected(), nil
+}
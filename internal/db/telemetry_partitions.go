@@ -0,0 +1,232 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/raphico/go-device-telemetry-api/internal/logger"
+)
+
+const telemetryPartitionLayout = "telemetry_2006_01"
+
+// TelemetryPartitionManager keeps the monthly range partitions of the
+// telemetry table in shape: it creates partitions ahead of time, drains rows
+// that fell into the default partition and drops partitions that are past
+// the retention window.
+type TelemetryPartitionManager struct {
+	db        *pgxpool.Pool
+	log       *logger.Logger
+	retention time.Duration
+	premake   int
+}
+
+func NewTelemetryPartitionManager(
+	db *pgxpool.Pool,
+	log *logger.Logger,
+	retention time.Duration,
+	premake int,
+) *TelemetryPartitionManager {
+	return &TelemetryPartitionManager{
+		db:        db,
+		log:       log,
+		retention: retention,
+		premake:   premake,
+	}
+}
+
+func (m *TelemetryPartitionManager) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		if err := m.Maintain(ctx); err != nil {
+			m.log.Error(fmt.Sprintf("telemetry partition maintenance failed: %v", err))
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (m *TelemetryPartitionManager) Maintain(ctx context.Context) error {
+	now := time.Now().UTC()
+	current := monthStart(now)
+
+	for i := 0; i <= m.premake; i++ {
+		if err := m.createPartition(ctx, current.AddDate(0, i, 0)); err != nil {
+			return err
+		}
+	}
+
+	if err := m.drainDefaultPartition(ctx, now); err != nil {
+		return err
+	}
+
+	if m.retention <= 0 {
+		return nil
+	}
+
+	return m.dropExpiredPartitions(ctx, now.Add(-m.retention))
+}
+
+func (m *TelemetryPartitionManager) createPartition(ctx context.Context, month time.Time) error {
+	_, err := m.db.Exec(ctx, `SELECT telemetry_create_partition($1)`, month)
+	if err != nil {
+		return fmt.Errorf("failed to create telemetry partition for %s: %w", month.Format("2006-01"), err)
+	}
+
+	return nil
+}
+
+// drainDefaultPartition gives rows that arrived for months without a
+// partition (late or backfilled readings) a proper home, or discards them
+// when they are already past retention.
+func (m *TelemetryPartitionManager) drainDefaultPartition(ctx context.Context, now time.Time) error {
+	query := `
+		SELECT DISTINCT date_trunc('month', recorded_at AT TIME ZONE 'UTC')
+		FROM telemetry_default
+	`
+
+	rows, err := m.db.Query(ctx, query)
+	if err != nil {
+		return fmt.Errorf("failed to query default telemetry partition: %w", err)
+	}
+
+	months, err := pgx.CollectRows(rows, pgx.RowTo[time.Time])
+	if err != nil {
+		return fmt.Errorf("failed to scan default telemetry partition: %w", err)
+	}
+
+	for _, month := range months {
+		month = monthStart(month)
+
+		if m.retention > 0 && !month.AddDate(0, 1, 0).After(now.Add(-m.retention)) {
+			if err := m.purgeDefaultMonth(ctx, month); err != nil {
+				return err
+			}
+			continue
+		}
+
+		if err := m.createPartition(ctx, month); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (m *TelemetryPartitionManager) purgeDefaultMonth(ctx context.Context, month time.Time) error {
+	tx, err := m.db.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer func() { _ = tx.Rollback(ctx) }()
+
+	if err := purgeMessageIDs(ctx, tx, month); err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(
+		ctx,
+		`DELETE FROM telemetry_default WHERE recorded_at >= $1 AND recorded_at < $2`,
+		month,
+		month.AddDate(0, 1, 0),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to purge expired default telemetry: %w", err)
+	}
+
+	return tx.Commit(ctx)
+}
+
+func (m *TelemetryPartitionManager) dropExpiredPartitions(ctx context.Context, cutoff time.Time) error {
+	query := `
+		SELECT c.relname
+		FROM pg_inherits i
+		JOIN pg_class c ON c.oid = i.inhrelid
+		JOIN pg_class p ON p.oid = i.inhparent
+		WHERE p.relname = 'telemetry'
+			AND c.relname ~ '^telemetry_[0-9]{4}_[0-9]{2}$'
+		ORDER BY c.relname
+	`
+
+	rows, err := m.db.Query(ctx, query)
+	if err != nil {
+		return fmt.Errorf("failed to list telemetry partitions: %w", err)
+	}
+
+	names, err := pgx.CollectRows(rows, pgx.RowTo[string])
+	if err != nil {
+		return fmt.Errorf("failed to scan telemetry partitions: %w", err)
+	}
+
+	for _, name := range names {
+		month, err := time.Parse(telemetryPartitionLayout, name)
+		if err != nil {
+			continue
+		}
+
+		if month.AddDate(0, 1, 0).After(cutoff) {
+			continue
+		}
+
+		if err := m.dropPartition(ctx, name, month); err != nil {
+			return err
+		}
+
+		m.log.Info(fmt.Sprintf("dropped expired telemetry partition %s", name))
+	}
+
+	return nil
+}
+
+func (m *TelemetryPartitionManager) dropPartition(ctx context.Context, name string, month time.Time) error {
+	tx, err := m.db.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer func() { _ = tx.Rollback(ctx) }()
+
+	if err := purgeMessageIDs(ctx, tx, month); err != nil {
+		return err
+	}
+
+	ident := pgx.Identifier{name}.Sanitize()
+
+	if _, err := tx.Exec(ctx, `ALTER TABLE telemetry DETACH PARTITION `+ident); err != nil {
+		return fmt.Errorf("failed to detach telemetry partition %s: %w", name, err)
+	}
+
+	if _, err := tx.Exec(ctx, `DROP TABLE `+ident); err != nil {
+		return fmt.Errorf("failed to drop telemetry partition %s: %w", name, err)
+	}
+
+	return tx.Commit(ctx)
+}
+
+// purgeMessageIDs forgets the message ids of a month whose readings are
+// about to be dropped, so the ids table does not outgrow retention.
+func purgeMessageIDs(ctx context.Context, tx pgx.Tx, month time.Time) error {
+	_, err := tx.Exec(
+		ctx,
+		`DELETE FROM telemetry_message_ids WHERE recorded_at >= $1 AND recorded_at < $2`,
+		month,
+		month.AddDate(0, 1, 0),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to purge telemetry message ids: %w", err)
+	}
+
+	return nil
+}
+
+func monthStart(t time.Time) time.Time {
+	t = t.UTC()
+	return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, time.UTC)
+}
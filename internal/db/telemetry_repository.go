@@ -8,6 +8,7 @@ import (
 	"time"
 
 	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgconn"
 	"github.com/jackc/pgx/v5/pgxpool"
 	"github.com/raphico/go-device-telemetry-api/internal/common/pagination"
@@ -15,6 +16,11 @@ import (
 	"github.com/raphico/go-device-telemetry-api/internal/telemetry"
 )
 
+const telemetryColumns = `
+	t.id, t.device_id, t.telemetry_type, t.payload, t.recorded_at, t.created_at,
+	t.device_recorded_at, t.clock_skew_flagged
+`
+
 type TelemetryRepository struct {
 	db *pgxpool.Pool
 }
@@ -26,60 +32,198 @@ func NewTelemetryRepository(db *pgxpool.Pool) *TelemetryRepository {
 }
 
 func (r *TelemetryRepository) Create(ctx context.Context, t *telemetry.Telemetry) error {
+	return insertTelemetry(ctx, r.db, t)
+}
+
+func (r *TelemetryRepository) CreateBatch(
+	ctx context.Context,
+	readings []*telemetry.Telemetry,
+) ([]telemetry.ReadingResult, error) {
+	tx, err := r.db.Begin(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer func() { _ = tx.Rollback(ctx) }()
+
+	results := make([]telemetry.ReadingResult, 0, len(readings))
+	for i, t := range readings {
+		err := insertTelemetry(ctx, tx, t)
+		if errors.Is(err, telemetry.ErrDuplicateMessageID) {
+			original, err := findTelemetryByMessageID(ctx, tx, t.DeviceID, *t.MessageID)
+			if err != nil {
+				return nil, fmt.Errorf("readings[%d]: %w", i, err)
+			}
+			results = append(results, telemetry.ReadingResult{Telemetry: original, Replayed: true})
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("readings[%d]: %w", i, err)
+		}
+		results = append(results, telemetry.ReadingResult{Telemetry: t})
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return nil, fmt.Errorf("failed to commit telemetry batch: %w", err)
+	}
+
+	return results, nil
+}
+
+func insertTelemetry(ctx context.Context, q rowQuerier, t *telemetry.Telemetry) error {
 	jsonPayload, err := json.Marshal(t.Payload)
 	if err != nil {
 		return fmt.Errorf("failed to marshal payload: %w", err)
 	}
 
+	if t.MessageID != nil {
+		return insertTelemetryWithMessageID(ctx, q, t, jsonPayload)
+	}
+
 	query := `
-		INSERT INTO telemetry (device_id, telemetry_type, payload)	
-		VALUES ($1, $2, $3)
+		INSERT INTO telemetry (device_id, telemetry_type, payload, recorded_at, device_recorded_at, clock_skew_flagged)
+		VALUES ($1, $2, $3, $4, $5, $6)
 		RETURNING id, created_at
 	`
 
-	err = r.db.QueryRow(ctx, query, t.DeviceID, t.TelemetryType, jsonPayload).Scan(&t.ID, &t.CreatedAt)
+	err = q.QueryRow(
+		ctx,
+		query,
+		t.DeviceID,
+		t.TelemetryType,
+		jsonPayload,
+		t.RecordedAt.Time(),
+		t.DeviceRecordedAt,
+		t.ClockSkewFlagged,
+	).Scan(&t.ID, &t.CreatedAt)
 
 	if err != nil {
-		var pgError *pgconn.PgError
-		if errors.As(err, &pgError) {
-			if pgError.Code == "23503" && pgError.ConstraintName == "telemetry_device_id_fkey" {
-				return device.ErrDeviceNotFound
-			}
-		}
-		return fmt.Errorf("failed to insert telemetry: %w", err)
+		return mapTelemetryInsertError(err)
 	}
 
 	return nil
 }
 
+// insertTelemetryWithMessageID claims the message id and inserts the
+// reading in one statement. A concurrent retry blocks on the claim until
+// the first insert commits, then finds the id taken and inserts nothing.
+func insertTelemetryWithMessageID(
+	ctx context.Context,
+	q rowQuerier,
+	t *telemetry.Telemetry,
+	jsonPayload []byte,
+) error {
+	query := `
+		WITH claimed AS (
+			INSERT INTO telemetry_message_ids (device_id, message_id, telemetry_id, recorded_at)
+			VALUES ($1, $5, gen_random_uuid(), $4)
+			ON CONFLICT (device_id, message_id) DO NOTHING
+			RETURNING telemetry_id
+		)
+		INSERT INTO telemetry (
+			id, device_id, telemetry_type, payload, recorded_at, device_recorded_at, clock_skew_flagged
+		)
+		SELECT telemetry_id, $1, $2, $3, $4, $6, $7
+		FROM claimed
+		RETURNING id, created_at
+	`
+
+	err := q.QueryRow(
+		ctx,
+		query,
+		t.DeviceID,
+		t.TelemetryType,
+		jsonPayload,
+		t.RecordedAt.Time(),
+		t.MessageID.String(),
+		t.DeviceRecordedAt,
+		t.ClockSkewFlagged,
+	).Scan(&t.ID, &t.CreatedAt)
+
+	if errors.Is(err, pgx.ErrNoRows) {
+		return telemetry.ErrDuplicateMessageID
+	}
+
+	if err != nil {
+		return mapTelemetryInsertError(err)
+	}
+
+	return nil
+}
+
+func mapTelemetryInsertError(err error) error {
+	var pgError *pgconn.PgError
+	if errors.As(err, &pgError) {
+		if pgError.Code == "23503" &&
+			(pgError.ConstraintName == "telemetry_device_id_fkey" ||
+				pgError.ConstraintName == "telemetry_message_ids_device_id_fkey") {
+			return device.ErrDeviceNotFound
+		}
+	}
+
+	return fmt.Errorf("failed to insert telemetry: %w", err)
+}
+
+func (r *TelemetryRepository) FindByMessageID(
+	ctx context.Context,
+	deviceID device.DeviceID,
+	messageID telemetry.MessageID,
+) (*telemetry.Telemetry, error) {
+	return findTelemetryByMessageID(ctx, r.db, deviceID, messageID)
+}
+
+func findTelemetryByMessageID(
+	ctx context.Context,
+	q rowQuerier,
+	deviceID device.DeviceID,
+	messageID telemetry.MessageID,
+) (*telemetry.Telemetry, error) {
+	query := `
+		SELECT ` + telemetryColumns + `
+		FROM telemetry_message_ids m
+		JOIN telemetry t ON t.id = m.telemetry_id AND t.recorded_at = m.recorded_at
+		WHERE m.device_id = $1 AND m.message_id = $2
+	`
+
+	t, err := scanTelemetry(q.QueryRow(ctx, query, deviceID, messageID.String()))
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, telemetry.ErrTelemetryNotFound
+		}
+		return nil, fmt.Errorf("failed to find telemetry by message id: %w", err)
+	}
+	t.MessageID = &messageID
+
+	return t, nil
+}
+
 func (r *TelemetryRepository) FindTelemetry(
 	ctx context.Context,
 	deviceID device.DeviceID,
 	limit int,
-	cursor *pagination.Cursor,
-) ([]*telemetry.Telemetry, *pagination.Cursor, error) {
+	cursor *pagination.RecordedAtCursor,
+) ([]*telemetry.Telemetry, *pagination.RecordedAtCursor, error) {
 	var query string
 	var args []any
 
 	if cursor == nil {
 		query = `
-    		SELECT id, device_id, telemetry_type, payload, recorded_at, created_at
-    		FROM telemetry
+    		SELECT ` + telemetryColumns + `
+    		FROM telemetry t
     		WHERE device_id = $1
-    		ORDER BY created_at ASC, id ASC
+    		ORDER BY recorded_at ASC, id ASC
     		LIMIT $2
     	`
 		args = []any{deviceID, limit + 1}
 	} else {
 		query = `
-    		SELECT id, device_id, telemetry_type, payload, recorded_at, created_at
-    		FROM telemetry
+    		SELECT ` + telemetryColumns + `
+    		FROM telemetry t
     		WHERE device_id = $1
-				AND (created_at, id) > ($2, $3)
-    		ORDER BY created_at ASC, id ASC
+				AND (recorded_at, id) > ($2, $3)
+    		ORDER BY recorded_at ASC, id ASC
     		LIMIT $4
 		`
-		args = []any{deviceID, cursor.CreatedAt, cursor.ID, limit + 1}
+		args = []any{deviceID, cursor.RecordedAt, cursor.ID, limit + 1}
 	}
 
 	rows, err := r.db.Query(ctx, query, args...)
@@ -88,45 +232,389 @@ func (r *TelemetryRepository) FindTelemetry(
 	}
 
 	var result []*telemetry.Telemetry
+	for rows.Next() {
+		t, err := scanTelemetry(rows)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		result = append(result, t)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, nil, fmt.Errorf("rows error: %w", err)
+	}
+
+	var nextCur *pagination.RecordedAtCursor
+	if len(result) > limit {
+		lastVisible := result[limit-1]
+		result = result[:limit]
+		nextCur = pagination.NewRecordedAtCursor(uuid.UUID(lastVisible.ID), lastVisible.RecordedAt.Time())
+	}
+
+	return result, nextCur, nil
+}
+
+func (r *TelemetryRepository) FindRollups(
+	ctx context.Context,
+	deviceID device.DeviceID,
+	resolution telemetry.Resolution,
+	field *telemetry.RollupField,
+	timeRange telemetry.TimeRange,
+) ([]*telemetry.Rollup, error) {
+	table := rollupTable(resolution)
+
+	query := `
+		SELECT telemetry_type, field, bucket, sample_count, sum, min, max
+		FROM ` + table + `
+		WHERE device_id = $1
+			AND bucket >= $2
+			AND bucket < $3
+			AND ($4::text IS NULL OR field = $4)
+		ORDER BY field ASC, bucket ASC, telemetry_type ASC
+	`
+
+	var fieldArg *string
+	if field != nil {
+		f := field.String()
+		fieldArg = &f
+	}
+
+	rows, err := r.db.Query(ctx, query, deviceID, timeRange.From(), timeRange.To(), fieldArg)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query telemetry rollups: %w", err)
+	}
+	defer rows.Close()
+
+	var result []*telemetry.Rollup
 	for rows.Next() {
 		var (
-			id            uuid.UUID
-			deviceID      uuid.UUID
 			telemetryType string
-			payload       []byte
-			recordedAt    time.Time
-			createdAt     time.Time
+			fieldName     string
+			bucket        time.Time
+			count         int64
+			sum           float64
+			minValue      float64
+			maxValue      float64
 		)
 
-		if err := rows.Scan(&id, &deviceID, &telemetryType, &payload, &recordedAt, &createdAt); err != nil {
-			return nil, nil, fmt.Errorf("failed to scan telemetry: %w", err)
+		if err := rows.Scan(&telemetryType, &fieldName, &bucket, &count, &sum, &minValue, &maxValue); err != nil {
+			return nil, fmt.Errorf("failed to scan telemetry rollup: %w", err)
 		}
 
-		t, err := telemetry.RehydrateTelemetry(
-			id,
+		rollup, err := telemetry.RehydrateRollup(
 			deviceID,
 			telemetryType,
-			payload,
-			recordedAt,
-			createdAt,
+			fieldName,
+			resolution,
+			bucket,
+			count,
+			sum,
+			minValue,
+			maxValue,
 		)
 		if err != nil {
-			return nil, nil, fmt.Errorf("failed to rehydrate telemetry: %w", err)
+			return nil, fmt.Errorf("failed to rehydrate telemetry rollup: %w", err)
 		}
 
-		result = append(result, t)
+		result = append(result, rollup)
 	}
 
 	if err := rows.Err(); err != nil {
-		return nil, nil, fmt.Errorf("rows error: %w", err)
+		return nil, fmt.Errorf("rows error: %w", err)
 	}
 
-	var nextCur *pagination.Cursor
-	if len(result) > limit {
-		lastVisible := result[limit-1]
-		result = result[:limit]
-		nextCur = pagination.NewCursor(uuid.UUID(lastVisible.ID), lastVisible.CreatedAt)
+	return result, nil
+}
+
+// exportBatchSize is how many rows each FETCH pulls from the export cursor.
+const exportBatchSize = 1000
+
+func exportFilterArgs(filter telemetry.ExportFilter) []any {
+	var deviceIDs []uuid.UUID
+	if len(filter.DeviceIDs) > 0 {
+		deviceIDs = make([]uuid.UUID, 0, len(filter.DeviceIDs))
+		for _, id := range filter.DeviceIDs {
+			deviceIDs = append(deviceIDs, uuid.UUID(id))
+		}
 	}
 
-	return result, nextCur, nil
+	var from, to *time.Time
+	if filter.TimeRange != nil {
+		f, t := filter.TimeRange.From(), filter.TimeRange.To()
+		from, to = &f, &t
+	}
+
+	return []any{filter.UserID, deviceIDs, from, to}
+}
+
+const exportFilterClause = `
+	EXISTS (
+		SELECT 1
+		FROM organization_members m
+		WHERE m.organization_id = d.organization_id AND m.user_id = $1
+	)
+	AND ($2::uuid[] IS NULL OR t.device_id = ANY($2::uuid[]))
+	AND ($3::timestamptz IS NULL OR t.recorded_at >= $3)
+	AND ($4::timestamptz IS NULL OR t.recorded_at < $4)
+`
+
+func findPayloadKeys(ctx context.Context, tx pgx.Tx, filter telemetry.ExportFilter) ([]string, error) {
+	query := `
+		WITH RECURSIVE kv(path, value) AS (
+			SELECT f.key, f.value
+			FROM telemetry t
+			JOIN devices d ON d.id = t.device_id
+			CROSS JOIN LATERAL jsonb_each(t.payload) AS f(key, value)
+			WHERE jsonb_typeof(t.payload) = 'object' AND ` + exportFilterClause + `
+			UNION ALL
+			SELECT kv.path || '.' || e.key, e.value
+			FROM kv
+			CROSS JOIN LATERAL jsonb_each(kv.value) AS e(key, value)
+			WHERE jsonb_typeof(kv.value) = 'object'
+		)
+		SELECT DISTINCT path
+		FROM kv
+		WHERE jsonb_typeof(value) <> 'object'
+		ORDER BY path
+	`
+
+	rows, err := tx.Query(ctx, query, exportFilterArgs(filter)...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query payload keys: %w", err)
+	}
+
+	keys, err := pgx.CollectRows(rows, pgx.RowTo[string])
+	if err != nil {
+		return nil, fmt.Errorf("failed to scan payload keys: %w", err)
+	}
+
+	return keys, nil
+}
+
+func (r *TelemetryRepository) CountTelemetry(ctx context.Context, filter telemetry.ExportFilter) (int64, error) {
+	query := `
+		SELECT count(*)
+		FROM telemetry t
+		JOIN devices d ON d.id = t.device_id
+		WHERE ` + exportFilterClause
+
+	var count int64
+	if err := r.db.QueryRow(ctx, query, exportFilterArgs(filter)...).Scan(&count); err != nil {
+		return 0, fmt.Errorf("failed to count telemetry: %w", err)
+	}
+
+	return count, nil
+}
+
+// StreamTelemetry reads matching telemetry through a server-side cursor and
+// hands each row to fn, so memory use does not grow with the export size.
+func (r *TelemetryRepository) StreamTelemetry(
+	ctx context.Context,
+	filter telemetry.ExportFilter,
+	fn func(*telemetry.Telemetry) error,
+) error {
+	return r.streamTelemetry(ctx, filter, nil, fn)
+}
+
+func (r *TelemetryRepository) StreamTelemetryWithKeys(
+	ctx context.Context,
+	filter telemetry.ExportFilter,
+	start func(keys []string) error,
+	fn func(*telemetry.Telemetry) error,
+) error {
+	return r.streamTelemetry(ctx, filter, start, fn)
+}
+
+// streamTelemetry runs under REPEATABLE READ, so the payload keys handed to
+// start and the rows handed to fn come from the same snapshot.
+func (r *TelemetryRepository) streamTelemetry(
+	ctx context.Context,
+	filter telemetry.ExportFilter,
+	start func(keys []string) error,
+	fn func(*telemetry.Telemetry) error,
+) error {
+	tx, err := r.db.BeginTx(ctx, pgx.TxOptions{IsoLevel: pgx.RepeatableRead, AccessMode: pgx.ReadOnly})
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer func() { _ = tx.Rollback(ctx) }()
+
+	if start != nil {
+		keys, err := findPayloadKeys(ctx, tx, filter)
+		if err != nil {
+			return err
+		}
+
+		if err := start(keys); err != nil {
+			return err
+		}
+	}
+
+	declare := `
+		DECLARE telemetry_export NO SCROLL CURSOR FOR
+		SELECT ` + telemetryColumns + `
+		FROM telemetry t
+		JOIN devices d ON d.id = t.device_id
+		WHERE ` + exportFilterClause + `
+		ORDER BY t.recorded_at ASC, t.id ASC
+	`
+
+	if _, err := tx.Exec(ctx, declare, exportFilterArgs(filter)...); err != nil {
+		return fmt.Errorf("failed to declare export cursor: %w", err)
+	}
+
+	fetch := fmt.Sprintf("FETCH FORWARD %d FROM telemetry_export", exportBatchSize)
+
+	for {
+		rows, err := tx.Query(ctx, fetch)
+		if err != nil {
+			return fmt.Errorf("failed to fetch telemetry: %w", err)
+		}
+
+		n := 0
+		for rows.Next() {
+			t, err := scanTelemetry(rows)
+			if err != nil {
+				rows.Close()
+				return err
+			}
+
+			if err := fn(t); err != nil {
+				rows.Close()
+				return err
+			}
+
+			n++
+		}
+
+		if err := rows.Err(); err != nil {
+			return fmt.Errorf("rows error: %w", err)
+		}
+
+		if n < exportBatchSize {
+			break
+		}
+	}
+
+	return tx.Commit(ctx)
+}
+
+// ImportBatch copies rows into a temporary staging table and moves the ones
+// not already present (same device, type, recorded_at and payload) into
+// telemetry in a single statement.
+func (r *TelemetryRepository) ImportBatch(
+	ctx context.Context,
+	deviceID device.DeviceID,
+	rows []*telemetry.Telemetry,
+) (int64, error) {
+	tx, err := r.db.Begin(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer func() { _ = tx.Rollback(ctx) }()
+
+	_, err = tx.Exec(ctx, `
+		CREATE TEMP TABLE telemetry_import (
+			telemetry_type VARCHAR(50) NOT NULL,
+			payload JSONB NOT NULL,
+			recorded_at TIMESTAMPTZ NOT NULL
+		) ON COMMIT DROP
+	`)
+	if err != nil {
+		return 0, fmt.Errorf("failed to create import staging table: %w", err)
+	}
+
+	_, err = tx.CopyFrom(
+		ctx,
+		pgx.Identifier{"telemetry_import"},
+		[]string{"telemetry_type", "payload", "recorded_at"},
+		pgx.CopyFromSlice(len(rows), func(i int) ([]any, error) {
+			payload, err := json.Marshal(rows[i].Payload)
+			if err != nil {
+				return nil, fmt.Errorf("failed to marshal payload: %w", err)
+			}
+			return []any{rows[i].TelemetryType.String(), payload, rows[i].RecordedAt.Time()}, nil
+		}),
+	)
+	if err != nil {
+		return 0, fmt.Errorf("failed to copy telemetry: %w", err)
+	}
+
+	query := `
+		INSERT INTO telemetry (device_id, telemetry_type, payload, recorded_at)
+		SELECT DISTINCT $1::uuid, s.telemetry_type, s.payload, s.recorded_at
+		FROM telemetry_import s
+		WHERE NOT EXISTS (
+			SELECT 1
+			FROM telemetry t
+			WHERE t.device_id = $1
+				AND t.recorded_at = s.recorded_at
+				AND t.telemetry_type = s.telemetry_type
+				AND t.payload = s.payload
+		)
+	`
+
+	tag, err := tx.Exec(ctx, query, deviceID)
+	if err != nil {
+		var pgError *pgconn.PgError
+		if errors.As(err, &pgError) {
+			if pgError.Code == "23503" && pgError.ConstraintName == "telemetry_device_id_fkey" {
+				return 0, device.ErrDeviceNotFound
+			}
+		}
+		return 0, fmt.Errorf("failed to import telemetry: %w", err)
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return 0, fmt.Errorf("failed to commit import: %w", err)
+	}
+
+	return tag.RowsAffected(), nil
+}
+
+func scanTelemetry(row pgx.Row) (*telemetry.Telemetry, error) {
+	var (
+		id               uuid.UUID
+		deviceID         uuid.UUID
+		telemetryType    string
+		payload          []byte
+		recordedAt       time.Time
+		createdAt        time.Time
+		deviceRecordedAt *time.Time
+		clockSkewFlagged bool
+	)
+
+	err := row.Scan(
+		&id,
+		&deviceID,
+		&telemetryType,
+		&payload,
+		&recordedAt,
+		&createdAt,
+		&deviceRecordedAt,
+		&clockSkewFlagged,
+	)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, err
+		}
+		return nil, fmt.Errorf("failed to scan telemetry: %w", err)
+	}
+
+	t, err := telemetry.RehydrateTelemetry(
+		id,
+		deviceID,
+		telemetryType,
+		payload,
+		recordedAt,
+		createdAt,
+		deviceRecordedAt,
+		clockSkewFlagged,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to rehydrate telemetry: %w", err)
+	}
+
+	return t, nil
 }
@@ -0,0 +1,192 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/raphico/go-device-telemetry-api/internal/logger"
+	"github.com/raphico/go-device-telemetry-api/internal/telemetry"
+)
+
+const (
+	// rollupSettleDelay keeps the aggregation window behind transactions
+	// that have stamped created_at but not yet committed.
+	rollupSettleDelay = time.Minute
+	// rollupMaxWindow bounds how much ingestion time one pass aggregates, so
+	// catching up on a large backlog happens in several short transactions.
+	rollupMaxWindow = time.Hour
+)
+
+// TelemetryRollupWorker continuously folds numeric payload fields from the
+// raw telemetry table into the 1m/1h/1d rollup tables and enforces each
+// tier's retention.
+//
+// Raw rows are picked up by ingestion time (created_at) and bucketed by
+// device time (recorded_at); buckets are merged additively, so late and
+// out-of-order readings land in the right bucket.
+type TelemetryRollupWorker struct {
+	db    *pgxpool.Pool
+	log   *logger.Logger
+	tiers []telemetry.RollupTier
+}
+
+func NewTelemetryRollupWorker(
+	db *pgxpool.Pool,
+	log *logger.Logger,
+	tiers []telemetry.RollupTier,
+) *TelemetryRollupWorker {
+	return &TelemetryRollupWorker{
+		db:    db,
+		log:   log,
+		tiers: tiers,
+	}
+}
+
+func (w *TelemetryRollupWorker) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		for _, tier := range w.tiers {
+			if err := w.aggregate(ctx, tier.Resolution); err != nil {
+				w.log.Error(fmt.Sprintf("telemetry rollup %s failed: %v", tier.Resolution, err))
+			}
+
+			if err := w.purge(ctx, tier); err != nil {
+				w.log.Error(fmt.Sprintf("telemetry rollup %s retention failed: %v", tier.Resolution, err))
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// aggregate processes windows until the tier has caught up with the settle
+// horizon.
+func (w *TelemetryRollupWorker) aggregate(ctx context.Context, resolution telemetry.Resolution) error {
+	for {
+		done, err := w.aggregateWindow(ctx, resolution)
+		if err != nil {
+			return err
+		}
+
+		if done {
+			return nil
+		}
+	}
+}
+
+func (w *TelemetryRollupWorker) aggregateWindow(ctx context.Context, resolution telemetry.Resolution) (bool, error) {
+	tx, err := w.db.Begin(ctx)
+	if err != nil {
+		return false, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer func() { _ = tx.Rollback(ctx) }()
+
+	_, err = tx.Exec(ctx, `
+		INSERT INTO telemetry_rollup_watermarks (resolution, processed_until)
+		VALUES ($1, COALESCE((SELECT min(created_at) FROM telemetry), now()))
+		ON CONFLICT (resolution) DO NOTHING
+	`, resolution.String())
+	if err != nil {
+		return false, fmt.Errorf("failed to initialise watermark: %w", err)
+	}
+
+	var from time.Time
+	err = tx.QueryRow(ctx, `
+		SELECT processed_until
+		FROM telemetry_rollup_watermarks
+		WHERE resolution = $1
+		FOR UPDATE SKIP LOCKED
+	`, resolution.String()).Scan(&from)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			// another replica is aggregating this tier
+			return true, nil
+		}
+		return false, fmt.Errorf("failed to read watermark: %w", err)
+	}
+
+	horizon := time.Now().UTC().Add(-rollupSettleDelay)
+	if !from.Before(horizon) {
+		return true, nil
+	}
+
+	to := from.Add(rollupMaxWindow)
+	done := false
+	if !to.Before(horizon) {
+		to = horizon
+		done = true
+	}
+
+	table := rollupTable(resolution)
+	query := `
+		INSERT INTO ` + table + ` AS r (device_id, telemetry_type, field, bucket, sample_count, sum, min, max)
+		SELECT
+			t.device_id,
+			t.telemetry_type,
+			f.key,
+			date_bin($1::interval, t.recorded_at, TIMESTAMPTZ '2000-01-01 00:00:00+00'),
+			count(*),
+			sum(f.value::float8),
+			min(f.value::float8),
+			max(f.value::float8)
+		FROM telemetry t
+		CROSS JOIN LATERAL jsonb_each(t.payload) AS f(key, value)
+		WHERE t.created_at >= $2
+			AND t.created_at < $3
+			AND jsonb_typeof(t.payload) = 'object'
+			AND jsonb_typeof(f.value) = 'number'
+		GROUP BY 1, 2, 3, 4
+		ON CONFLICT (device_id, field, bucket, telemetry_type) DO UPDATE SET
+			sample_count = r.sample_count + EXCLUDED.sample_count,
+			sum = r.sum + EXCLUDED.sum,
+			min = LEAST(r.min, EXCLUDED.min),
+			max = GREATEST(r.max, EXCLUDED.max)
+	`
+
+	if _, err := tx.Exec(ctx, query, resolution.Width(), from, to); err != nil {
+		return false, fmt.Errorf("failed to aggregate telemetry: %w", err)
+	}
+
+	_, err = tx.Exec(ctx, `
+		UPDATE telemetry_rollup_watermarks
+		SET processed_until = $2
+		WHERE resolution = $1
+	`, resolution.String(), to)
+	if err != nil {
+		return false, fmt.Errorf("failed to advance watermark: %w", err)
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return false, fmt.Errorf("failed to commit rollup: %w", err)
+	}
+
+	return done, nil
+}
+
+func (w *TelemetryRollupWorker) purge(ctx context.Context, tier telemetry.RollupTier) error {
+	if tier.Retention <= 0 {
+		return nil
+	}
+
+	query := `DELETE FROM ` + rollupTable(tier.Resolution) + ` WHERE bucket < $1`
+	_, err := w.db.Exec(ctx, query, time.Now().UTC().Add(-tier.Retention))
+	if err != nil {
+		return fmt.Errorf("failed to purge rollups: %w", err)
+	}
+
+	return nil
+}
+
+func rollupTable(resolution telemetry.Resolution) string {
+	return pgx.Identifier{"telemetry_rollup_" + resolution.String()}.Sanitize()
+}
@@ -23,20 +23,64 @@ func NewTokenRepository(db *pgxpool.Pool) *TokenRepository {
 }
 
 func (r *TokenRepository) Create(ctx context.Context, t *token.Token) error {
+	return insertToken(ctx, r.db, t)
+}
+
+func insertToken(ctx context.Context, q rowQuerier, t *token.Token) error {
+	var expiresAt *time.Time
+	if !t.ExpiresAt.IsZero() {
+		expiresAt = &t.ExpiresAt
+	}
+
+	var (
+		familyID         *token.TokenID
+		userAgent        *string
+		ip               *string
+		sessionStartedAt *time.Time
+	)
+	if t.Scope == token.ScopeAuth {
+		familyID = &t.FamilyID
+		userAgent, ip = &t.Client.UserAgent, &t.Client.IP
+		sessionStartedAt = &t.SessionStartedAt
+	}
+
+	var (
+		name   *string
+		prefix *string
+		scopes = make([]string, 0, len(t.AccessScopes))
+	)
+	if t.Scope == token.ScopePersonalAccess {
+		n := t.Name.String()
+		name, prefix = &n, &t.Prefix
+	}
+	for _, s := range t.AccessScopes {
+		scopes = append(scopes, s.String())
+	}
+
 	query := `
-		INSERT INTO tokens(user_id, token_hash, scope, expires_at)	
-		VALUES ($1, $2, $3, $4)
-		RETURNING id
+		INSERT INTO tokens(
+			user_id, token_hash, scope, expires_at, name, prefix, access_scopes,
+			family_id, user_agent, ip, session_started_at
+		)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)
+		RETURNING id, created_at
 	`
 
-	err := r.db.QueryRow(
+	err := q.QueryRow(
 		ctx,
 		query,
 		t.UserID,
 		t.Hash,
 		t.Scope,
-		t.ExpiresAt,
-	).Scan(&t.ID)
+		expiresAt,
+		name,
+		prefix,
+		scopes,
+		familyID,
+		userAgent,
+		ip,
+		sessionStartedAt,
+	).Scan(&t.ID, &t.CreatedAt)
 
 	if err != nil {
 		var pgErr *pgconn.PgError
@@ -59,6 +103,150 @@ func (r *TokenRepository) Create(ctx context.Context, t *token.Token) error {
 	return nil
 }
 
+func (r *TokenRepository) RotateRefreshToken(
+	ctx context.Context,
+	hash []byte,
+	next *token.Token,
+	grace time.Duration,
+) (*token.Token, error) {
+	tx, err := r.db.Begin(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer func() { _ = tx.Rollback(ctx) }()
+
+	var (
+		id               uuid.UUID
+		userID           user.UserID
+		revoked          bool
+		rotatedAt        *time.Time
+		familyID         uuid.UUID
+		sessionStartedAt time.Time
+		expiresAt        time.Time
+		lastUsedAt       *time.Time
+		createdAt        time.Time
+		inGrace          bool
+		reissued         bool
+		sessionLive      bool
+	)
+
+	// the row lock makes concurrent rotations of one token queue up, so the
+	// second sees it rotated instead of rotating it again; the grace window
+	// is measured on the database clock that set rotated_at
+	query := `
+		SELECT id, user_id, revoked, rotated_at, family_id, session_started_at,
+			expires_at, last_used_at, created_at,
+			COALESCE(rotated_at > now() - make_interval(secs => $3), false),
+			reissued_at IS NOT NULL,
+			EXISTS (
+				SELECT 1
+				FROM tokens f
+				WHERE f.family_id = tokens.family_id
+					AND f.revoked = false
+					AND f.expires_at > now()
+			)
+		FROM tokens
+		WHERE token_hash = $1 AND scope = $2
+		FOR UPDATE
+	`
+
+	err = tx.QueryRow(ctx, query, hash, token.ScopeAuth, grace.Seconds()).Scan(
+		&id,
+		&userID,
+		&revoked,
+		&rotatedAt,
+		&familyID,
+		&sessionStartedAt,
+		&expiresAt,
+		&lastUsedAt,
+		&createdAt,
+		&inGrace,
+		&reissued,
+		&sessionLive,
+	)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, token.ErrTokenNotFound
+		}
+		return nil, fmt.Errorf("failed to find refresh token: %w", err)
+	}
+
+	presented := token.RehydrateToken(
+		token.TokenID(id),
+		hash,
+		userID,
+		token.ScopeAuth,
+		revoked,
+		token.TokenID(familyID),
+		expiresAt,
+		lastUsedAt,
+		createdAt,
+	)
+
+	// a client that refreshed twice at once, or lost the response to its
+	// first refresh, is not treated as a thief; rotated_at is left alone so
+	// the window does not stretch. Only one more successor is issued, so
+	// replaying the token inside the window cannot mint live ones at will.
+	if rotatedAt != nil && inGrace {
+		if reissued || !sessionLive || !expiresAt.After(time.Now()) {
+			return nil, token.ErrTokenNotFound
+		}
+
+		next.UserID = userID
+		next.FamilyID = token.TokenID(familyID)
+		next.SessionStartedAt = sessionStartedAt
+		if err := insertToken(ctx, tx, next); err != nil {
+			return nil, err
+		}
+
+		query := `UPDATE tokens SET reissued_at = now() WHERE id = $1`
+		if _, err := tx.Exec(ctx, query, id); err != nil {
+			return nil, fmt.Errorf("failed to mark refresh token reissued: %w", err)
+		}
+
+		if err := tx.Commit(ctx); err != nil {
+			return nil, fmt.Errorf("failed to commit token rotation: %w", err)
+		}
+
+		return presented, nil
+	}
+
+	if rotatedAt != nil {
+		query := `UPDATE tokens SET revoked = true WHERE family_id = $1 AND revoked = false`
+		if _, err := tx.Exec(ctx, query, familyID); err != nil {
+			return nil, fmt.Errorf("failed to revoke token family: %w", err)
+		}
+
+		if err := tx.Commit(ctx); err != nil {
+			return nil, fmt.Errorf("failed to commit token family revocation: %w", err)
+		}
+
+		return presented, token.ErrTokenReused
+	}
+
+	if revoked || !expiresAt.After(time.Now()) {
+		return nil, token.ErrTokenNotFound
+	}
+
+	query = `UPDATE tokens SET revoked = true, rotated_at = now(), last_used_at = now() WHERE id = $1`
+	if _, err := tx.Exec(ctx, query, id); err != nil {
+		return nil, fmt.Errorf("failed to revoke refresh token: %w", err)
+	}
+
+	next.UserID = userID
+	next.FamilyID = token.TokenID(familyID)
+	next.SessionStartedAt = sessionStartedAt
+	if err := insertToken(ctx, tx, next); err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return nil, fmt.Errorf("failed to commit token rotation: %w", err)
+	}
+
+	return presented, nil
+}
+
 func (r *TokenRepository) FindValidTokenByHash(ctx context.Context, hash []byte, scope string) (*token.Token, error) {
 	var (
 		id         uuid.UUID
@@ -66,18 +254,23 @@ func (r *TokenRepository) FindValidTokenByHash(ctx context.Context, hash []byte,
 		userID     user.UserID
 		dbScope    string
 		revoked    bool
-		expiresAt  time.Time
+		expiresAt  *time.Time
 		lastUsedAt *time.Time
 		createdAt  time.Time
+		name       *string
+		prefix     *string
+		scopes     []string
+		dbFamilyID *uuid.UUID
 	)
 
 	query := `
-		SELECT id, token_hash, user_id, scope, revoked, expires_at, last_used_at, created_at
+		SELECT id, token_hash, user_id, scope, revoked, expires_at, last_used_at, created_at,
+			name, prefix, access_scopes, family_id
 		FROM tokens
 		WHERE token_hash = $1 
 			AND scope = $2
 			AND revoked = false 
-			AND expires_at > now()
+			AND (expires_at IS NULL OR expires_at > now())
 	`
 
 	err := r.db.QueryRow(ctx, query, hash, scope).Scan(
@@ -89,6 +282,10 @@ func (r *TokenRepository) FindValidTokenByHash(ctx context.Context, hash []byte,
 		&expiresAt,
 		&lastUsedAt,
 		&createdAt,
+		&name,
+		&prefix,
+		&scopes,
+		&dbFamilyID,
 	)
 	if err != nil {
 		if errors.Is(err, pgx.ErrNoRows) {
@@ -97,7 +294,370 @@ func (r *TokenRepository) FindValidTokenByHash(ctx context.Context, hash []byte,
 		return nil, fmt.Errorf("failed to find token: %w", err)
 	}
 
-	return token.RehydrateToken(token.TokenID(id), dbHash, userID, dbScope, revoked, expiresAt, lastUsedAt, createdAt), nil
+	if dbScope == token.ScopePersonalAccess {
+		t, err := rehydratePersonalAccessToken(id, userID, name, prefix, scopes, revoked, expiresAt, lastUsedAt, createdAt)
+		if err != nil {
+			return nil, err
+		}
+		t.Hash = dbHash
+		return t, nil
+	}
+
+	if expiresAt == nil {
+		return nil, fmt.Errorf("corrupt %s token: missing expiry", dbScope)
+	}
+
+	var familyID token.TokenID
+	if dbFamilyID != nil {
+		familyID = token.TokenID(*dbFamilyID)
+	}
+
+	return token.RehydrateToken(
+		token.TokenID(id),
+		dbHash,
+		userID,
+		dbScope,
+		revoked,
+		familyID,
+		*expiresAt,
+		lastUsedAt,
+		createdAt,
+	), nil
+}
+
+func (r *TokenRepository) FindByUser(ctx context.Context, userID user.UserID, scope string) ([]*token.Token, error) {
+	query := `
+		SELECT id, revoked, expires_at, last_used_at, created_at, name, prefix, access_scopes
+		FROM tokens
+		WHERE user_id = $1 AND scope = $2 AND revoked = false
+		ORDER BY created_at ASC, id ASC
+	`
+
+	rows, err := r.db.Query(ctx, query, userID, scope)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query tokens: %w", err)
+	}
+	defer rows.Close()
+
+	var result []*token.Token
+
+	for rows.Next() {
+		var (
+			id         uuid.UUID
+			revoked    bool
+			expiresAt  *time.Time
+			lastUsedAt *time.Time
+			createdAt  time.Time
+			name       *string
+			prefix     *string
+			scopes     []string
+		)
+
+		if err := rows.Scan(&id, &revoked, &expiresAt, &lastUsedAt, &createdAt, &name, &prefix, &scopes); err != nil {
+			return nil, fmt.Errorf("failed to scan token: %w", err)
+		}
+
+		t, err := rehydratePersonalAccessToken(id, userID, name, prefix, scopes, revoked, expiresAt, lastUsedAt, createdAt)
+		if err != nil {
+			return nil, err
+		}
+
+		result = append(result, t)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("rows error: %w", err)
+	}
+
+	return result, nil
+}
+
+func (r *TokenRepository) RevokeByID(ctx context.Context, id token.TokenID, userID user.UserID, scope string) error {
+	query := `
+		UPDATE tokens
+		SET revoked = true
+		WHERE id = $1
+			AND user_id = $2
+			AND scope = $3
+			AND revoked = false
+	`
+
+	tag, err := r.db.Exec(ctx, query, id, userID, scope)
+	if err != nil {
+		return fmt.Errorf("failed to revoke token: %w", err)
+	}
+
+	if tag.RowsAffected() == 0 {
+		return token.ErrTokenNotFound
+	}
+
+	return nil
+}
+
+func (r *TokenRepository) RevokeAllForUser(ctx context.Context, userID user.UserID, scope string) error {
+	query := `
+		UPDATE tokens
+		SET revoked = true
+		WHERE user_id = $1
+			AND scope = $2
+			AND revoked = false
+	`
+
+	if _, err := r.db.Exec(ctx, query, userID, scope); err != nil {
+		return fmt.Errorf("failed to revoke tokens: %w", err)
+	}
+
+	return nil
+}
+
+func (r *TokenRepository) FindSessions(ctx context.Context, userID user.UserID) ([]*token.Session, error) {
+	query := `
+		SELECT family_id, user_agent, ip, session_started_at, created_at, expires_at
+		FROM tokens
+		WHERE user_id = $1
+			AND scope = $2
+			AND revoked = false
+			AND expires_at > now()
+		ORDER BY session_started_at DESC, family_id ASC
+	`
+
+	rows, err := r.db.Query(ctx, query, userID, token.ScopeAuth)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query sessions: %w", err)
+	}
+	defer rows.Close()
+
+	var result []*token.Session
+
+	for rows.Next() {
+		var (
+			familyID         uuid.UUID
+			userAgent        *string
+			ip               *string
+			sessionStartedAt time.Time
+			createdAt        time.Time
+			expiresAt        time.Time
+		)
+
+		if err := rows.Scan(&familyID, &userAgent, &ip, &sessionStartedAt, &createdAt, &expiresAt); err != nil {
+			return nil, fmt.Errorf("failed to scan session: %w", err)
+		}
+
+		s := &token.Session{
+			ID:           token.TokenID(familyID),
+			CreatedAt:    sessionStartedAt,
+			LastActiveAt: createdAt,
+			ExpiresAt:    expiresAt,
+		}
+		if userAgent != nil {
+			s.Client.UserAgent = *userAgent
+		}
+		if ip != nil {
+			s.Client.IP = *ip
+		}
+
+		result = append(result, s)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("rows error: %w", err)
+	}
+
+	return result, nil
+}
+
+func (r *TokenRepository) RevokeSession(ctx context.Context, userID user.UserID, familyID token.TokenID) error {
+	query := `
+		UPDATE tokens
+		SET revoked = true
+		WHERE family_id = $1
+			AND user_id = $2
+			AND scope = $3
+			AND revoked = false
+			AND expires_at > now()
+	`
+
+	tag, err := r.db.Exec(ctx, query, familyID, userID, token.ScopeAuth)
+	if err != nil {
+		return fmt.Errorf("failed to revoke session: %w", err)
+	}
+
+	if tag.RowsAffected() == 0 {
+		return token.ErrSessionNotFound
+	}
+
+	return nil
+}
+
+func (r *TokenRepository) RevokeOtherSessions(
+	ctx context.Context,
+	userID user.UserID,
+	keep *token.TokenID,
+) ([]token.TokenID, error) {
+	query := `
+		WITH revoked AS (
+			UPDATE tokens
+			SET revoked = true
+			WHERE user_id = $1
+				AND scope = $2
+				AND revoked = false
+				AND ($3::uuid IS NULL OR family_id <> $3)
+			RETURNING family_id
+		)
+		SELECT DISTINCT family_id FROM revoked
+	`
+
+	rows, err := r.db.Query(ctx, query, userID, token.ScopeAuth, keep)
+	if err != nil {
+		return nil, fmt.Errorf("failed to revoke sessions: %w", err)
+	}
+
+	families, err := pgx.CollectRows(rows, pgx.RowTo[uuid.UUID])
+	if err != nil {
+		return nil, fmt.Errorf("failed to revoke sessions: %w", err)
+	}
+
+	result := make([]token.TokenID, 0, len(families))
+	for _, id := range families {
+		result = append(result, token.TokenID(id))
+	}
+
+	return result, nil
+}
+
+func (r *TokenRepository) DenyAccessToken(
+	ctx context.Context,
+	jti uuid.UUID,
+	expiresAt time.Time,
+	revokedBy user.UserID,
+) error {
+	query := `
+		INSERT INTO access_token_denylist (jti, expires_at, revoked_by)
+		VALUES ($1, $2, $3)
+		ON CONFLICT (jti) DO NOTHING
+	`
+
+	if _, err := r.db.Exec(ctx, query, jti, expiresAt, revokedBy); err != nil {
+		return fmt.Errorf("failed to deny access token: %w", err)
+	}
+
+	return nil
+}
+
+func (r *TokenRepository) DenySession(
+	ctx context.Context,
+	sessionID token.TokenID,
+	expiresAt time.Time,
+	revokedBy user.UserID,
+) error {
+	query := `
+		INSERT INTO session_denylist (session_id, expires_at, revoked_by)
+		VALUES ($1, $2, $3)
+		ON CONFLICT (session_id) DO UPDATE
+		SET expires_at = GREATEST(session_denylist.expires_at, EXCLUDED.expires_at)
+	`
+
+	if _, err := r.db.Exec(ctx, query, sessionID, expiresAt, revokedBy); err != nil {
+		return fmt.Errorf("failed to deny session: %w", err)
+	}
+
+	return nil
+}
+
+func (r *TokenRepository) FindDenials(ctx context.Context, now time.Time) (*token.Denials, error) {
+	query := `
+		SELECT false, jti, expires_at FROM access_token_denylist WHERE expires_at > $1
+		UNION ALL
+		SELECT true, session_id, expires_at FROM session_denylist WHERE expires_at > $1
+	`
+
+	rows, err := r.db.Query(ctx, query, now)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query denylist: %w", err)
+	}
+	defer rows.Close()
+
+	denials := &token.Denials{
+		AccessTokens: map[uuid.UUID]time.Time{},
+		Sessions:     map[uuid.UUID]time.Time{},
+	}
+
+	for rows.Next() {
+		var (
+			session   bool
+			id        uuid.UUID
+			expiresAt time.Time
+		)
+
+		if err := rows.Scan(&session, &id, &expiresAt); err != nil {
+			return nil, fmt.Errorf("failed to scan denylist entry: %w", err)
+		}
+
+		if session {
+			denials.Sessions[id] = expiresAt
+		} else {
+			denials.AccessTokens[id] = expiresAt
+		}
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("rows error: %w", err)
+	}
+
+	return denials, nil
+}
+
+func (r *TokenRepository) DeleteExpired(ctx context.Context, before time.Time) (int64, error) {
+	query := `
+		DELETE FROM tokens
+		WHERE expires_at < $1
+			OR (revoked = true AND scope <> $2)
+	`
+
+	tag, err := r.db.Exec(ctx, query, before, token.ScopeAuth)
+	if err != nil {
+		return 0, fmt.Errorf("failed to purge tokens: %w", err)
+	}
+
+	denied, err := r.db.Exec(ctx, `DELETE FROM access_token_denylist WHERE expires_at < $1`, before)
+	if err != nil {
+		return 0, fmt.Errorf("failed to purge access token denylist: %w", err)
+	}
+
+	sessions, err := r.db.Exec(ctx, `DELETE FROM session_denylist WHERE expires_at < $1`, before)
+	if err != nil {
+		return 0, fmt.Errorf("failed to purge session denylist: %w", err)
+	}
+
+	return tag.RowsAffected() + denied.RowsAffected() + sessions.RowsAffected(), nil
+}
+
+func rehydratePersonalAccessToken(
+	id uuid.UUID,
+	userID user.UserID,
+	name *string,
+	prefix *string,
+	scopes []string,
+	revoked bool,
+	expiresAt *time.Time,
+	lastUsedAt *time.Time,
+	createdAt time.Time,
+) (*token.Token, error) {
+	if name == nil || prefix == nil {
+		return nil, errors.New("corrupt personal access token: missing name or prefix")
+	}
+
+	return token.RehydratePersonalAccessToken(
+		token.TokenID(id),
+		userID,
+		*name,
+		*prefix,
+		scopes,
+		revoked,
+		expiresAt,
+		lastUsedAt,
+		createdAt,
+	)
 }
 
 func (r *TokenRepository) Revoke(ctx context.Context, scope string, hash []byte) error {
@@ -10,6 +10,8 @@ import (
 	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgconn"
 	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/raphico/go-device-telemetry-api/internal/authz"
+	"github.com/raphico/go-device-telemetry-api/internal/organization"
 	"github.com/raphico/go-device-telemetry-api/internal/user"
 )
 
@@ -24,18 +26,25 @@ func NewUserRepository(db *pgxpool.Pool) *UserRepository {
 }
 
 func (r *UserRepository) Create(ctx context.Context, u *user.User) error {
+	tx, err := r.db.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer func() { _ = tx.Rollback(ctx) }()
+
 	query := `
-		INSERT INTO users (username, email, password_hash)
-		VALUES ($1, $2, $3)
+		INSERT INTO users (username, email, password_hash, email_verified_at)
+		VALUES ($1, $2, NULLIF($3, ''), $4)
 		RETURNING id, created_at, updated_at
 	`
 
-	err := r.db.QueryRow(
+	err = tx.QueryRow(
 		ctx,
 		query,
 		u.Username.String(),
 		u.Email.String(),
 		u.Password.Hash(),
+		u.EmailVerifiedAt,
 	).Scan(&u.ID, &u.CreatedAt, &u.UpdatedAt)
 
 	if err != nil {
@@ -52,29 +61,262 @@ func (r *UserRepository) Create(ctx context.Context, u *user.User) error {
 		return fmt.Errorf("failed to insert user: %w", err)
 	}
 
+	// usernames are valid organization names, so the personal one is named
+	// after its owner
+	var orgID uuid.UUID
+	query = `
+		INSERT INTO organizations (name, personal_user_id)
+		VALUES ($1, $2)
+		RETURNING id
+	`
+
+	if err := tx.QueryRow(ctx, query, u.Username.String(), u.ID).Scan(&orgID); err != nil {
+		return fmt.Errorf("failed to insert personal organization: %w", err)
+	}
+
+	owner := organization.NewMembership(organization.OrganizationID(orgID), u.ID, authz.RoleOwner)
+	if err := insertMember(ctx, tx, owner); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return fmt.Errorf("failed to commit user: %w", err)
+	}
+
 	return nil
 }
 
 func (r *UserRepository) FindByEmail(ctx context.Context, email user.Email) (*user.User, error) {
+	query := `
+		SELECT id, email, username, password_hash, email_verified_at, pending_email, is_admin, created_at, updated_at
+		FROM users
+		WHERE users.email = $1
+	`
+
+	return scanUser(r.db.QueryRow(ctx, query, email.String()))
+}
+
+func (r *UserRepository) FindById(ctx context.Context, id user.UserID) (*user.User, error) {
+	query := `
+		SELECT id, email, username, password_hash, email_verified_at, pending_email, is_admin, created_at, updated_at
+		FROM users
+		WHERE users.id = $1
+	`
+
+	return scanUser(r.db.QueryRow(ctx, query, id))
+}
+
+func (r *UserRepository) MarkEmailVerified(ctx context.Context, id user.UserID) error {
+	query := `
+		UPDATE users
+		SET email_verified_at = COALESCE(email_verified_at, now()), updated_at = now()
+		WHERE id = $1
+	`
+
+	tag, err := r.db.Exec(ctx, query, id)
+	if err != nil {
+		return fmt.Errorf("failed to mark email verified: %w", err)
+	}
+
+	if tag.RowsAffected() == 0 {
+		return user.ErrUserNotFound
+	}
+
+	return nil
+}
+
+func (r *UserRepository) UpdatePassword(ctx context.Context, id user.UserID, password user.Password) error {
+	query := `UPDATE users SET password_hash = $1, updated_at = now() WHERE id = $2`
+
+	tag, err := r.db.Exec(ctx, query, password.Hash(), id)
+	if err != nil {
+		return fmt.Errorf("failed to update password: %w", err)
+	}
+
+	if tag.RowsAffected() == 0 {
+		return user.ErrUserNotFound
+	}
+
+	return nil
+}
+
+func (r *UserRepository) ConfirmEmail(ctx context.Context, id user.UserID) (user.Email, error) {
+	// SET sees the row as it was, and so does the locked copy RETURNING
+	// reads the previous email from
+	query := `
+		WITH previous AS (
+			SELECT id, email FROM users WHERE id = $1 FOR UPDATE
+		)
+		UPDATE users u
+		SET email = COALESCE(u.pending_email, u.email),
+			pending_email = NULL,
+			email_verified_at = CASE
+				WHEN u.pending_email IS NULL THEN COALESCE(u.email_verified_at, now())
+				ELSE now()
+			END,
+			updated_at = now()
+		FROM previous
+		WHERE u.id = previous.id
+		RETURNING previous.email
+	`
+
+	var previous string
+	if err := r.db.QueryRow(ctx, query, id).Scan(&previous); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return user.Email{}, user.ErrUserNotFound
+		}
+
+		var pgErr *pgconn.PgError
+		if errors.As(err, &pgErr) && pgErr.Code == "23505" && pgErr.ConstraintName == "users_email_key" {
+			return user.Email{}, user.ErrEmailAlreadyExists
+		}
+
+		return user.Email{}, fmt.Errorf("failed to confirm email: %w", err)
+	}
+
+	email, err := user.NewEmail(previous)
+	if err != nil {
+		return user.Email{}, fmt.Errorf("corrupt email: %w", err)
+	}
+
+	return email, nil
+}
+
+func (r *UserRepository) UpdateProfile(ctx context.Context, u *user.User) error {
+	query := `
+		UPDATE users
+		SET username = $1, pending_email = $2, updated_at = now()
+		WHERE id = $3
+		RETURNING updated_at
+	`
+
+	var pendingEmail *string
+	if u.PendingEmail != nil {
+		v := u.PendingEmail.String()
+		pendingEmail = &v
+	}
+
+	err := r.db.QueryRow(ctx, query, u.Username.String(), pendingEmail, u.ID).Scan(&u.UpdatedAt)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return user.ErrUserNotFound
+		}
+
+		var pgErr *pgconn.PgError
+		if errors.As(err, &pgErr) && pgErr.Code == "23505" && pgErr.ConstraintName == "users_username_key" {
+			return user.ErrUsernameTaken
+		}
+
+		return fmt.Errorf("failed to update user: %w", err)
+	}
+
+	return nil
+}
+
+func (r *UserRepository) Delete(ctx context.Context, id user.UserID) error {
+	tx, err := r.db.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer func() { _ = tx.Rollback(ctx) }()
+
+	// lock the user's organizations so nobody joins one between the checks
+	// and the deletes
+	lockQuery := `
+		SELECT o.id
+		FROM organizations o
+		JOIN organization_members m ON m.organization_id = o.id
+		WHERE m.user_id = $1
+		ORDER BY o.id
+		FOR UPDATE OF o
+	`
+
+	if _, err := tx.Exec(ctx, lockQuery, id); err != nil {
+		return fmt.Errorf("failed to lock organizations: %w", err)
+	}
+
+	ownerQuery := `
+		SELECT EXISTS (
+			SELECT 1
+			FROM organization_members m
+			WHERE m.user_id = $1
+			  AND m.role = 'owner'
+			  AND NOT EXISTS (
+				SELECT 1 FROM organization_members o
+				WHERE o.organization_id = m.organization_id
+				  AND o.user_id <> $1
+				  AND o.role = 'owner'
+			  )
+			  AND EXISTS (
+				SELECT 1 FROM organization_members o
+				WHERE o.organization_id = m.organization_id
+				  AND o.user_id <> $1
+			  )
+		)
+	`
+
+	var soleOwner bool
+	if err := tx.QueryRow(ctx, ownerQuery, id).Scan(&soleOwner); err != nil {
+		return fmt.Errorf("failed to check organization ownership: %w", err)
+	}
+	if soleOwner {
+		return organization.ErrLastOwner
+	}
+
+	// devices, and through them telemetry and commands, go with their
+	// organization
+	orgQuery := `
+		DELETE FROM organizations o
+		USING organization_members m
+		WHERE m.organization_id = o.id
+		  AND m.user_id = $1
+		  AND NOT EXISTS (
+			SELECT 1 FROM organization_members other
+			WHERE other.organization_id = o.id
+			  AND other.user_id <> $1
+		  )
+	`
+
+	if _, err := tx.Exec(ctx, orgQuery, id); err != nil {
+		return fmt.Errorf("failed to delete organizations: %w", err)
+	}
+
+	tag, err := tx.Exec(ctx, `DELETE FROM users WHERE id = $1`, id)
+	if err != nil {
+		return fmt.Errorf("failed to delete user: %w", err)
+	}
+
+	if tag.RowsAffected() == 0 {
+		return user.ErrUserNotFound
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return fmt.Errorf("failed to commit user deletion: %w", err)
+	}
+
+	return nil
+}
+
+func scanUser(row pgx.Row) (*user.User, error) {
 	var (
 		id                   uuid.UUID
 		emailStr             string
 		usernameStr          string
 		passwordHash         []byte
+		emailVerifiedAt      *time.Time
+		pendingEmail         *string
+		isAdmin              bool
 		createdAt, updatedAt time.Time
 	)
 
-	query := `
-		SELECT id, email, username, password_hash, created_at, updated_at 
-		FROM users
-		WHERE users.email = $1
-	`
-
-	err := r.db.QueryRow(ctx, query, email.String()).Scan(
+	err := row.Scan(
 		&id,
 		&emailStr,
 		&usernameStr,
 		&passwordHash,
+		&emailVerifiedAt,
+		&pendingEmail,
+		&isAdmin,
 		&createdAt,
 		&updatedAt,
 	)
@@ -87,5 +329,15 @@ func (r *UserRepository) FindByEmail(ctx context.Context, email user.Email) (*us
 		return nil, fmt.Errorf("failed to find user: %w", err)
 	}
 
-	return user.RehydrateUser(id, emailStr, usernameStr, passwordHash, createdAt, updatedAt)
+	return user.RehydrateUser(
+		id,
+		emailStr,
+		usernameStr,
+		passwordHash,
+		emailVerifiedAt,
+		pendingEmail,
+		isAdmin,
+		createdAt,
+		updatedAt,
+	)
 }
@@ -9,7 +9,7 @@ import (
 	"time"
 
 	"github.com/google/uuid"
-	"github.com/raphico/go-device-telemetry-api/internal/user"
+	"github.com/raphico/go-device-telemetry-api/internal/organization"
 )
 
 var (
@@ -38,15 +38,23 @@ type DeviceType struct {
 
 type Metadata map[string]any
 
+// ClockSkew is the server's estimate of how far a device clock runs ahead of
+// (positive Offset) or behind (negative) server time.
+type ClockSkew struct {
+	Offset      time.Duration
+	EstimatedAt time.Time
+}
+
 type Device struct {
-	ID         DeviceID
-	UserID     user.UserID
-	Name       Name
-	Status     Status
-	DeviceType DeviceType
-	Metadata   map[string]any
-	CreatedAt  time.Time
-	UpdatedAt  time.Time
+	ID             DeviceID
+	OrganizationID organization.OrganizationID
+	Name           Name
+	Status         Status
+	DeviceType     DeviceType
+	Metadata       map[string]any
+	ClockSkew      *ClockSkew
+	CreatedAt      time.Time
+	UpdatedAt      time.Time
 }
 
 // ---------- DeviceID ----------
@@ -158,7 +166,7 @@ func (t DeviceType) String() string {
 // ---------- Device ----------
 
 func NewDevice(
-	userId user.UserID,
+	organizationID organization.OrganizationID,
 	name Name,
 	status Status,
 	deviceType DeviceType,
@@ -169,11 +177,11 @@ func NewDevice(
 	}
 
 	return &Device{
-		UserID:     userId,
-		Name:       name,
-		Status:     status,
-		DeviceType: deviceType,
-		Metadata:   metadata,
+		OrganizationID: organizationID,
+		Name:           name,
+		Status:         status,
+		DeviceType:     deviceType,
+		Metadata:       metadata,
 	}
 }
 
@@ -193,11 +201,12 @@ func (d *Device) UpdateMetadata(m map[string]any) {
 
 func RehydrateDevice(
 	id uuid.UUID,
-	userID uuid.UUID,
+	organizationID uuid.UUID,
 	name string,
 	deviceType string,
 	status string,
 	metadataBytes []byte,
+	clockSkew *ClockSkew,
 	createdAt time.Time,
 	updatedAt time.Time,
 ) (*Device, error) {
@@ -227,13 +236,14 @@ func RehydrateDevice(
 	}
 
 	return &Device{
-		ID:         DeviceID(id),
-		UserID:     user.UserID(userID),
-		Name:       n,
-		Status:     s,
-		DeviceType: dt,
-		Metadata:   metadata,
-		UpdatedAt:  updatedAt,
-		CreatedAt:  createdAt,
+		ID:             DeviceID(id),
+		OrganizationID: organization.OrganizationID(organizationID),
+		Name:           n,
+		Status:         s,
+		DeviceType:     dt,
+		Metadata:       metadata,
+		ClockSkew:      clockSkew,
+		UpdatedAt:      updatedAt,
+		CreatedAt:      createdAt,
 	}, nil
 }
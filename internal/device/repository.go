@@ -2,14 +2,28 @@ package device
 
 import (
 	"context"
+	"time"
 
 	"github.com/raphico/go-device-telemetry-api/internal/common/pagination"
+	"github.com/raphico/go-device-telemetry-api/internal/organization"
 	"github.com/raphico/go-device-telemetry-api/internal/user"
 )
 
 type Repository interface {
 	Create(ctx context.Context, device *Device) error
-	FindById(ctx context.Context, id DeviceID, userId user.UserID) (*Device, error)
-	FindDevices(ctx context.Context, userId user.UserID, limit int, cursor *pagination.Cursor) ([]*Device, *pagination.Cursor, error)
+	FindById(ctx context.Context, id DeviceID) (*Device, error)
+	// FindDevices lists the devices of every organization userID belongs to,
+	// or only of organizationID when it is set.
+	FindDevices(
+		ctx context.Context,
+		userID user.UserID,
+		organizationID *organization.OrganizationID,
+		limit int,
+		cursor *pagination.Cursor,
+	) ([]*Device, *pagination.Cursor, error)
 	Update(ctx context.Context, dev *Device) error
+	// UpdateClockSkew folds sample into the device's skew estimate, letting
+	// the previous estimate decay by decayPerHour of elapsed time first, and
+	// returns the new estimate.
+	UpdateClockSkew(ctx context.Context, id DeviceID, sample time.Duration, decayPerHour time.Duration) (*ClockSkew, error)
 }
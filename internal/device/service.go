@@ -2,13 +2,36 @@ package device
 
 import (
 	"context"
+	"errors"
+	"time"
 
+	"github.com/google/uuid"
+	"github.com/raphico/go-device-telemetry-api/internal/authz"
 	"github.com/raphico/go-device-telemetry-api/internal/common/pagination"
-	"github.com/raphico/go-device-telemetry-api/internal/user"
+	"github.com/raphico/go-device-telemetry-api/internal/organization"
 )
 
+// clockSkewDecay is how fast a skew estimate drifts back towards fresh
+// samples. The estimate tracks the largest recorded_at - received_at gap
+// seen, since network delay only ever makes a sample smaller; without decay
+// a clock that gets fixed would keep its old estimate forever.
+const clockSkewDecay = time.Minute
+
+// clockSkewWriteInterval is how long a stored skew estimate is kept while
+// samples do not raise it, so steady telemetry does not write the device
+// row on every reading.
+const clockSkewWriteInterval = time.Minute
+
+// OrganizationResolver picks the organization a device is created in when
+// the caller does not name one.
+type OrganizationResolver interface {
+	DefaultOrganization(ctx context.Context, actor authz.Actor) (organization.OrganizationID, error)
+}
+
 type Service struct {
-	repo Repository
+	repo          Repository
+	authz         *authz.Authorizer
+	organizations OrganizationResolver
 }
 
 type UpdateDeviceInput struct {
@@ -17,54 +40,86 @@ type UpdateDeviceInput struct {
 	Metadata   *Metadata
 }
 
-func NewService(repo Repository) *Service {
+func NewService(repo Repository, authorizer *authz.Authorizer, organizations OrganizationResolver) *Service {
 	return &Service{
-		repo: repo,
+		repo:          repo,
+		authz:         authorizer,
+		organizations: organizations,
 	}
 }
 
+// CreateDevice registers a device in organizationID, or in the actor's only
+// organization when it is nil.
 func (s *Service) CreateDevice(
 	ctx context.Context,
-	userId user.UserID,
+	actor authz.Actor,
+	organizationID *organization.OrganizationID,
 	name Name,
 	status Status,
 	deviceType DeviceType,
 	metadata Metadata,
 ) (*Device, error) {
-	dev := NewDevice(userId, name, status, deviceType, metadata)
+	var orgID organization.OrganizationID
+	if organizationID != nil {
+		orgID = *organizationID
+	} else {
+		id, err := s.organizations.DefaultOrganization(ctx, actor)
+		if err != nil {
+			return nil, err
+		}
+		orgID = id
+	}
 
-	if err := s.repo.Create(ctx, dev); err != nil {
+	_, err := s.authz.Authorize(ctx, actor, uuid.UUID(orgID), authz.PermDeviceWrite)
+	if errors.Is(err, authz.ErrNotMember) {
+		return nil, organization.ErrOrganizationNotFound
+	}
+	if err != nil {
 		return nil, err
 	}
 
-	return dev, nil
-}
+	dev := NewDevice(orgID, name, status, deviceType, metadata)
 
-func (s *Service) GetDevice(ctx context.Context, id DeviceID, userId user.UserID) (*Device, error) {
-	device, err := s.repo.FindById(ctx, id, userId)
-	if err != nil {
+	if err := s.repo.Create(ctx, dev); err != nil {
 		return nil, err
 	}
 
-	return device, nil
+	return dev, nil
+}
+
+func (s *Service) GetDevice(ctx context.Context, actor authz.Actor, id DeviceID) (*Device, error) {
+	return s.AuthorizeDevice(ctx, actor, id, authz.PermDeviceRead)
 }
 
-func (s *Service) ListUserDevices(
+// ListDevices lists the devices of every organization the actor belongs to,
+// or only of organizationID when it is set.
+func (s *Service) ListDevices(
 	ctx context.Context,
-	userID user.UserID,
+	actor authz.Actor,
+	organizationID *organization.OrganizationID,
 	limit int,
 	cursor *pagination.Cursor,
 ) ([]*Device, *pagination.Cursor, error) {
-	return s.repo.FindDevices(ctx, userID, limit, cursor)
+	if organizationID != nil {
+		_, err := s.authz.Authorize(ctx, actor, uuid.UUID(*organizationID), authz.PermDeviceRead)
+		if errors.Is(err, authz.ErrNotMember) {
+			return nil, nil, organization.ErrOrganizationNotFound
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	return s.repo.FindDevices(ctx, actor.UserID, organizationID, limit, cursor)
 }
 
 func (s *Service) UpdateDevice(
 	ctx context.Context,
+	actor authz.Actor,
 	id DeviceID,
-	userId user.UserID,
 	update UpdateDeviceInput,
 ) (*Device, error) {
-	dev, err := s.repo.FindById(ctx, id, userId)
+	dev, err := s.AuthorizeDevice(ctx, actor, id, authz.PermDeviceWrite)
 	if err != nil {
 		return nil, err
 	}
@@ -88,3 +143,51 @@ func (s *Service) UpdateDevice(
 
 	return dev, nil
 }
+
+// AuthorizeDevice loads a device and checks the actor's role in its
+// organization grants perm. Devices outside the actor's organizations are
+// reported as ErrDeviceNotFound so their existence does not leak; a role
+// that is too low fails with authz.ErrForbidden.
+func (s *Service) AuthorizeDevice(
+	ctx context.Context,
+	actor authz.Actor,
+	id DeviceID,
+	perm authz.Permission,
+) (*Device, error) {
+	dev, err := s.repo.FindById(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = s.authz.Authorize(ctx, actor, uuid.UUID(dev.OrganizationID), perm)
+	if errors.Is(err, authz.ErrNotMember) {
+		return nil, ErrDeviceNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return dev, nil
+}
+
+// ObserveClockSkew folds the gap between a reading's recorded_at and the
+// time the server received it into dev's clock skew, and returns the
+// updated estimate. The estimate is only stored, and dev.ClockSkew
+// replaced, when sample raises it or clockSkewWriteInterval has passed.
+func (s *Service) ObserveClockSkew(ctx context.Context, dev *Device, sample time.Duration) (time.Duration, error) {
+	if prev := dev.ClockSkew; prev != nil {
+		elapsed := time.Since(prev.EstimatedAt)
+		if sample <= prev.Offset && elapsed < clockSkewWriteInterval {
+			decayed := prev.Offset - time.Duration(elapsed.Hours()*float64(clockSkewDecay))
+			return max(sample, decayed), nil
+		}
+	}
+
+	skew, err := s.repo.UpdateClockSkew(ctx, dev.ID, sample, clockSkewDecay)
+	if err != nil {
+		return 0, err
+	}
+	dev.ClockSkew = skew
+
+	return skew.Offset, nil
+}
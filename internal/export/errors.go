@@ -0,0 +1,12 @@
+package export
+
+import "errors"
+
+var (
+	ErrJobNotFound      = errors.New("export job not found")
+	ErrJobNotReady      = errors.New("export job has not completed")
+	ErrJobExpired       = errors.New("export artifact has expired")
+	ErrNoJobAvailable   = errors.New("no export job available")
+	ErrArtifactNotFound = errors.New("export artifact not found")
+	ErrInvalidSignature = errors.New("invalid or expired signature")
+)
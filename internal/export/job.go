@@ -0,0 +1,226 @@
+package export
+
+import (
+	"fmt"
+	"path"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/raphico/go-device-telemetry-api/internal/device"
+	"github.com/raphico/go-device-telemetry-api/internal/telemetry"
+	"github.com/raphico/go-device-telemetry-api/internal/user"
+)
+
+var (
+	StatusPending   = Status{"pending"}
+	StatusRunning   = Status{"running"}
+	StatusCompleted = Status{"completed"}
+	StatusFailed    = Status{"failed"}
+	// StatusExpired is a completed job whose artifact has been deleted.
+	StatusExpired = Status{"expired"}
+)
+
+// ---------- Types ----------
+
+type JobID uuid.UUID
+
+type Status struct {
+	value string
+}
+
+type Job struct {
+	ID           JobID
+	UserID       user.UserID
+	DeviceIDs    []device.DeviceID
+	TimeRange    *telemetry.TimeRange
+	Format       telemetry.FileFormat
+	Gzip         bool
+	Status       Status
+	RowsExported int64
+	TotalRows    *int64
+	ArtifactKey  string
+	ArtifactSize int64
+	Error        string
+	CreatedAt    time.Time
+	StartedAt    *time.Time
+	CompletedAt  *time.Time
+}
+
+// ---------- JobID ----------
+
+func NewJobID(id string) (JobID, error) {
+	parsed, err := uuid.Parse(id)
+	if err != nil {
+		return JobID(uuid.Nil), err
+	}
+
+	return JobID(parsed), nil
+}
+
+func (j JobID) String() string {
+	return uuid.UUID(j).String()
+}
+
+// ---------- Status ----------
+
+func NewStatus(value string) (Status, error) {
+	switch value {
+	case StatusPending.value:
+		return StatusPending, nil
+	case StatusRunning.value:
+		return StatusRunning, nil
+	case StatusCompleted.value:
+		return StatusCompleted, nil
+	case StatusFailed.value:
+		return StatusFailed, nil
+	case StatusExpired.value:
+		return StatusExpired, nil
+	default:
+		return Status{}, fmt.Errorf("invalid export status: %s", value)
+	}
+}
+
+func (s Status) String() string {
+	return s.value
+}
+
+// ---------- Job ----------
+
+func NewJob(
+	userID user.UserID,
+	deviceIDs []device.DeviceID,
+	timeRange *telemetry.TimeRange,
+	format telemetry.FileFormat,
+	gzip bool,
+) *Job {
+	return &Job{
+		UserID:    userID,
+		DeviceIDs: deviceIDs,
+		TimeRange: timeRange,
+		Format:    format,
+		Gzip:      gzip,
+		Status:    StatusPending,
+	}
+}
+
+func (j *Job) Filter() telemetry.ExportFilter {
+	return telemetry.ExportFilter{
+		UserID:    j.UserID,
+		DeviceIDs: j.DeviceIDs,
+		TimeRange: j.TimeRange,
+	}
+}
+
+// Filename is the name the artifact is offered for download under.
+func (j *Job) Filename() string {
+	name := "telemetry-" + j.ID.String() + "." + j.Format.Extension()
+	if j.Gzip {
+		name += ".gz"
+	}
+
+	return name
+}
+
+func (j *Job) artifactKey() string {
+	return path.Join("exports", j.Filename())
+}
+
+func (j *Job) ContentType() string {
+	if j.Gzip {
+		return "application/gzip"
+	}
+
+	return j.Format.ContentType()
+}
+
+// Progress returns the fraction of rows exported, or nil while the total is
+// unknown.
+func (j *Job) Progress() *float64 {
+	if j.Status == StatusCompleted || j.Status == StatusExpired {
+		p := 1.0
+		return &p
+	}
+
+	if j.TotalRows == nil {
+		return nil
+	}
+
+	p := 1.0
+	if *j.TotalRows > 0 {
+		p = min(float64(j.RowsExported)/float64(*j.TotalRows), 1)
+	}
+
+	return &p
+}
+
+// ---------- Rehydration ----------
+
+func RehydrateJob(
+	id uuid.UUID,
+	userID uuid.UUID,
+	deviceIDs []uuid.UUID,
+	rangeFrom, rangeTo *time.Time,
+	format string,
+	gzip bool,
+	status string,
+	rowsExported int64,
+	totalRows *int64,
+	artifactKey *string,
+	artifactSize *int64,
+	errMsg *string,
+	createdAt time.Time,
+	startedAt, completedAt *time.Time,
+) (*Job, error) {
+	f, err := telemetry.NewFileFormat(format)
+	if err != nil {
+		return nil, fmt.Errorf("corrupt export format: %w", err)
+	}
+
+	s, err := NewStatus(status)
+	if err != nil {
+		return nil, fmt.Errorf("corrupt export status: %w", err)
+	}
+
+	var timeRange *telemetry.TimeRange
+	if rangeFrom != nil && rangeTo != nil {
+		tr, err := telemetry.NewTimeRange(*rangeFrom, *rangeTo)
+		if err != nil {
+			return nil, fmt.Errorf("corrupt export time range: %w", err)
+		}
+		timeRange = &tr
+	}
+
+	var devices []device.DeviceID
+	for _, id := range deviceIDs {
+		devices = append(devices, device.DeviceID(id))
+	}
+
+	job := &Job{
+		ID:           JobID(id),
+		UserID:       user.UserID(userID),
+		DeviceIDs:    devices,
+		TimeRange:    timeRange,
+		Format:       f,
+		Gzip:         gzip,
+		Status:       s,
+		RowsExported: rowsExported,
+		TotalRows:    totalRows,
+		CreatedAt:    createdAt,
+		StartedAt:    startedAt,
+		CompletedAt:  completedAt,
+	}
+
+	if artifactKey != nil {
+		job.ArtifactKey = *artifactKey
+	}
+
+	if artifactSize != nil {
+		job.ArtifactSize = *artifactSize
+	}
+
+	if errMsg != nil {
+		job.Error = *errMsg
+	}
+
+	return job, nil
+}
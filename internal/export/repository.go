@@ -0,0 +1,29 @@
+package export
+
+import (
+	"context"
+	"time"
+
+	"github.com/raphico/go-device-telemetry-api/internal/user"
+)
+
+type Repository interface {
+	Create(ctx context.Context, j *Job) error
+	FindById(ctx context.Context, id JobID, userID user.UserID) (*Job, error)
+	// ClaimNext marks the oldest pending job (or a running job whose worker
+	// stopped heart-beating before staleBefore) as running and returns it.
+	ClaimNext(ctx context.Context, staleBefore time.Time) (*Job, error)
+	// Heartbeat records that the worker running the job is still alive.
+	Heartbeat(ctx context.Context, id JobID) error
+	SetTotalRows(ctx context.Context, id JobID, total int64) error
+	UpdateProgress(ctx context.Context, id JobID, rows int64) error
+	Complete(ctx context.Context, j *Job) error
+	Fail(ctx context.Context, id JobID, reason string) error
+	// FindExpired returns up to limit completed jobs that completed before
+	// completedBefore, oldest first.
+	FindExpired(ctx context.Context, completedBefore time.Time, limit int) ([]*Job, error)
+	// Expire marks a completed job as expired and forgets its artifact key.
+	Expire(ctx context.Context, id JobID) error
+	// ArtifactKeys returns the keys of every artifact userID's jobs stored.
+	ArtifactKeys(ctx context.Context, userID user.UserID) ([]string, error)
+}
@@ -0,0 +1,140 @@
+package export
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/raphico/go-device-telemetry-api/internal/device"
+	"github.com/raphico/go-device-telemetry-api/internal/telemetry"
+	"github.com/raphico/go-device-telemetry-api/internal/user"
+)
+
+// expiredBatchSize is how many expired jobs PurgeExpiredArtifacts loads at
+// a time.
+const expiredBatchSize = 100
+
+type Service struct {
+	repo        Repository
+	store       ArtifactStore
+	urlTTL      time.Duration
+	artifactTTL time.Duration
+}
+
+func NewService(repo Repository, store ArtifactStore, urlTTL, artifactTTL time.Duration) *Service {
+	return &Service{
+		repo:        repo,
+		store:       store,
+		urlTTL:      urlTTL,
+		artifactTTL: artifactTTL,
+	}
+}
+
+func (s *Service) CreateJob(
+	ctx context.Context,
+	userID user.UserID,
+	deviceIDs []device.DeviceID,
+	timeRange *telemetry.TimeRange,
+	format telemetry.FileFormat,
+	gzip bool,
+) (*Job, error) {
+	job := NewJob(userID, deviceIDs, timeRange, format, gzip)
+
+	if err := s.repo.Create(ctx, job); err != nil {
+		return nil, err
+	}
+
+	return job, nil
+}
+
+func (s *Service) GetJob(ctx context.Context, id JobID, userID user.UserID) (*Job, error) {
+	return s.repo.FindById(ctx, id, userID)
+}
+
+// DownloadURL returns a signed link to the job's artifact and when it
+// expires. The link never outlives the artifact.
+func (s *Service) DownloadURL(ctx context.Context, job *Job) (string, time.Time, error) {
+	switch job.Status {
+	case StatusCompleted:
+	case StatusExpired:
+		return "", time.Time{}, ErrJobExpired
+	default:
+		return "", time.Time{}, ErrJobNotReady
+	}
+
+	ttl := s.urlTTL
+	if expiresAt := s.ArtifactExpiresAt(job); expiresAt != nil {
+		ttl = min(ttl, time.Until(*expiresAt))
+	}
+	if ttl <= 0 {
+		return "", time.Time{}, ErrJobExpired
+	}
+
+	url, err := s.store.SignedURL(ctx, job.ArtifactKey, job.Filename(), ttl)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+
+	return url, time.Now().Add(ttl), nil
+}
+
+// ArtifactExpiresAt returns when a completed job's artifact is deleted, or
+// nil for jobs without one.
+func (s *Service) ArtifactExpiresAt(job *Job) *time.Time {
+	if job.Status != StatusCompleted || job.CompletedAt == nil {
+		return nil
+	}
+
+	expiresAt := job.CompletedAt.Add(s.artifactTTL)
+	return &expiresAt
+}
+
+// PurgeExpiredArtifacts deletes the artifacts of jobs that completed more
+// than the artifact TTL ago and marks those jobs as expired. A job is only
+// marked once its artifact is gone, so a failed delete is retried on the
+// next run.
+func (s *Service) PurgeExpiredArtifacts(ctx context.Context) (int64, error) {
+	before := time.Now().Add(-s.artifactTTL)
+
+	var purged int64
+	for {
+		jobs, err := s.repo.FindExpired(ctx, before, expiredBatchSize)
+		if err != nil {
+			return purged, err
+		}
+
+		for _, job := range jobs {
+			if err := s.store.Delete(ctx, job.ArtifactKey); err != nil {
+				return purged, err
+			}
+
+			if err := s.repo.Expire(ctx, job.ID); err != nil && !errors.Is(err, ErrJobNotFound) {
+				return purged, err
+			}
+			purged++
+		}
+
+		if len(jobs) < expiredBatchSize {
+			return purged, nil
+		}
+	}
+}
+
+// ArtifactKeys returns the keys of the artifacts userID's jobs produced, so
+// they can be deleted along with the user.
+func (s *Service) ArtifactKeys(ctx context.Context, userID user.UserID) ([]string, error) {
+	return s.repo.ArtifactKeys(ctx, userID)
+}
+
+// DeleteArtifacts removes the artifacts stored under keys, trying every key
+// before reporting the first failure.
+func (s *Service) DeleteArtifacts(ctx context.Context, keys []string) error {
+	var firstErr error
+	for _, key := range keys {
+		if err := s.store.Delete(ctx, key); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+
+	return firstErr
+}
@@ -0,0 +1,23 @@
+package export
+
+import (
+	"context"
+	"io"
+	"time"
+)
+
+// ArtifactStore persists finished export files and hands out short-lived
+// download links for them.
+type ArtifactStore interface {
+	Put(ctx context.Context, key string, r io.Reader, size int64, contentType string) error
+	SignedURL(ctx context.Context, key string, filename string, ttl time.Duration) (string, error)
+	// Delete removes the artifact stored under key; a missing one is not
+	// an error.
+	Delete(ctx context.Context, key string) error
+}
+
+// ArtifactServer is implemented by stores whose signed URLs point back at
+// this API instead of an external object store.
+type ArtifactServer interface {
+	Open(key string, filename string, expires int64, signature string) (io.ReadSeekCloser, error)
+}
@@ -0,0 +1,197 @@
+package export
+
+import (
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/raphico/go-device-telemetry-api/internal/authz"
+	"github.com/raphico/go-device-telemetry-api/internal/logger"
+	"github.com/raphico/go-device-telemetry-api/internal/telemetry"
+)
+
+const (
+	// staleJobTimeout is how long a running job may go without a heartbeat
+	// before another worker takes it over.
+	staleJobTimeout = 5 * time.Minute
+	// heartbeatInterval keeps a running job's heartbeat well inside
+	// staleJobTimeout, even while a slow query or upload reports no
+	// progress.
+	heartbeatInterval = time.Minute
+)
+
+// TelemetryExporter is the part of telemetry.Service the worker relies on.
+type TelemetryExporter interface {
+	CountTelemetry(ctx context.Context, actor authz.Actor, filter telemetry.ExportFilter) (int64, error)
+	ExportTelemetry(
+		ctx context.Context,
+		actor authz.Actor,
+		filter telemetry.ExportFilter,
+		format telemetry.FileFormat,
+		w io.Writer,
+		progress func(rows int64),
+	) (int64, error)
+}
+
+type Worker struct {
+	repo      Repository
+	store     ArtifactStore
+	telemetry TelemetryExporter
+	log       *logger.Logger
+}
+
+func NewWorker(
+	repo Repository,
+	store ArtifactStore,
+	telemetry TelemetryExporter,
+	log *logger.Logger,
+) *Worker {
+	return &Worker{
+		repo:      repo,
+		store:     store,
+		telemetry: telemetry,
+		log:       log,
+	}
+}
+
+func (w *Worker) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		// drain the queue before going back to sleep
+		for {
+			job, err := w.repo.ClaimNext(ctx, time.Now().Add(-staleJobTimeout))
+			if err != nil {
+				if !errors.Is(err, ErrNoJobAvailable) {
+					w.log.Error(fmt.Sprintf("failed to claim export job: %v", err))
+				}
+				break
+			}
+
+			w.process(ctx, job)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (w *Worker) process(ctx context.Context, job *Job) {
+	hbCtx, stop := context.WithCancel(ctx)
+	defer stop()
+	go w.heartbeat(hbCtx, job.ID)
+
+	if err := w.execute(ctx, job); err != nil {
+		w.log.Error(fmt.Sprintf("export job %s failed: %v", job.ID, err))
+
+		if err := w.repo.Fail(ctx, job.ID, err.Error()); err != nil {
+			w.log.Error(fmt.Sprintf("failed to mark export job %s as failed: %v", job.ID, err))
+		}
+		return
+	}
+
+	w.log.Info(fmt.Sprintf("export job %s completed with %d rows", job.ID, job.RowsExported))
+}
+
+// heartbeat keeps job claimed until ctx is done.
+func (w *Worker) heartbeat(ctx context.Context, id JobID) {
+	ticker := time.NewTicker(heartbeatInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := w.repo.Heartbeat(ctx, id); err != nil && ctx.Err() == nil {
+				w.log.Error(fmt.Sprintf("failed to record export job %s heartbeat: %v", id, err))
+			}
+		}
+	}
+}
+
+func (w *Worker) execute(ctx context.Context, job *Job) error {
+	// checked again when the job runs, since the user may have lost access
+	// to its devices since requesting it
+	actor := authz.NewActor(job.UserID)
+	filter := job.Filter()
+
+	total, err := w.telemetry.CountTelemetry(ctx, actor, filter)
+	if err != nil {
+		return err
+	}
+
+	if err := w.repo.SetTotalRows(ctx, job.ID, total); err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp("", "telemetry-export-*")
+	if err != nil {
+		return fmt.Errorf("failed to create temp file: %w", err)
+	}
+	defer func() {
+		_ = tmp.Close()
+		_ = os.Remove(tmp.Name())
+	}()
+
+	var out io.Writer = tmp
+	var gz *gzip.Writer
+	if job.Gzip {
+		gz = gzip.NewWriter(tmp)
+		out = gz
+	}
+
+	rows, err := w.telemetry.ExportTelemetry(ctx, actor, filter, job.Format, out, func(rows int64) {
+		if err := w.repo.UpdateProgress(ctx, job.ID, rows); err != nil {
+			w.log.Error(fmt.Sprintf("failed to update export job %s progress: %v", job.ID, err))
+		}
+	})
+	if err != nil {
+		return err
+	}
+
+	if gz != nil {
+		if err := gz.Close(); err != nil {
+			return fmt.Errorf("failed to finish gzip stream: %w", err)
+		}
+	}
+
+	size, err := tmp.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return fmt.Errorf("failed to size artifact: %w", err)
+	}
+
+	if _, err := tmp.Seek(0, io.SeekStart); err != nil {
+		return fmt.Errorf("failed to rewind artifact: %w", err)
+	}
+
+	key := job.artifactKey()
+	if err := w.store.Put(ctx, key, tmp, size, job.ContentType()); err != nil {
+		return err
+	}
+
+	job.RowsExported = rows
+	job.ArtifactKey = key
+	job.ArtifactSize = size
+
+	if err := w.repo.Complete(ctx, job); err != nil {
+		// the job is gone with its deleted user, who must not leave the
+		// artifact behind
+		if errors.Is(err, ErrJobNotFound) {
+			if err := w.store.Delete(ctx, key); err != nil {
+				w.log.Error(fmt.Sprintf("failed to delete artifact of deleted export job %s: %v", job.ID, err))
+			}
+		}
+		return err
+	}
+
+	return nil
+}
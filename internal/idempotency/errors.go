@@ -0,0 +1,8 @@
+package idempotency
+
+import "errors"
+
+var (
+	ErrFingerprintMismatch = errors.New("idempotency key reused with a different request")
+	ErrRequestInProgress   = errors.New("request with this idempotency key is still in progress")
+)
@@ -0,0 +1,119 @@
+package idempotency
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/raphico/go-device-telemetry-api/internal/user"
+)
+
+// ---------- Types ----------
+
+type Key struct {
+	value string
+}
+
+// Fingerprint identifies the request a key was first used with, so a reused
+// key can be told apart from a genuine retry.
+type Fingerprint struct {
+	value string
+}
+
+// Response is what a completed request answered; it is replayed verbatim to
+// retries.
+type Response struct {
+	StatusCode int
+	Headers    map[string]string
+	Body       []byte
+}
+
+// Record is a key reservation. Response stays nil while the first request is
+// still being handled.
+type Record struct {
+	UserID      user.UserID
+	Key         Key
+	Fingerprint Fingerprint
+	Response    *Response
+	CreatedAt   time.Time
+	ExpiresAt   time.Time
+}
+
+// ---------- Key ----------
+
+func NewKey(raw string) (Key, error) {
+	raw = strings.TrimSpace(raw)
+
+	if raw == "" {
+		return Key{}, errors.New("idempotency key cannot be empty")
+	}
+
+	if len(raw) > 255 {
+		return Key{}, errors.New("idempotency key must be at most 255 characters")
+	}
+
+	for _, c := range raw {
+		if c < 0x21 || c > 0x7e {
+			return Key{}, errors.New("idempotency key can only contain printable ASCII characters")
+		}
+	}
+
+	return Key{value: raw}, nil
+}
+
+func (k Key) String() string {
+	return k.value
+}
+
+// ---------- Fingerprint ----------
+
+func NewFingerprint(method, target string, body []byte) Fingerprint {
+	h := sha256.New()
+	h.Write([]byte(method))
+	h.Write([]byte{'\n'})
+	h.Write([]byte(target))
+	h.Write([]byte{'\n'})
+	h.Write(body)
+
+	return Fingerprint{value: hex.EncodeToString(h.Sum(nil))}
+}
+
+func (f Fingerprint) String() string {
+	return f.value
+}
+
+// ---------- Record ----------
+
+func NewRecord(userID user.UserID, key Key, fingerprint Fingerprint, ttl time.Duration) *Record {
+	now := time.Now().UTC()
+
+	return &Record{
+		UserID:      userID,
+		Key:         key,
+		Fingerprint: fingerprint,
+		CreatedAt:   now,
+		ExpiresAt:   now.Add(ttl),
+	}
+}
+
+// ---------- Rehydration ----------
+
+func RehydrateRecord(
+	userID user.UserID,
+	key string,
+	fingerprint string,
+	response *Response,
+	createdAt time.Time,
+	expiresAt time.Time,
+) *Record {
+	return &Record{
+		UserID:      userID,
+		Key:         Key{value: key},
+		Fingerprint: Fingerprint{value: fingerprint},
+		Response:    response,
+		CreatedAt:   createdAt,
+		ExpiresAt:   expiresAt,
+	}
+}
@@ -0,0 +1,18 @@
+package idempotency
+
+import (
+	"context"
+	"time"
+
+	"github.com/raphico/go-device-telemetry-api/internal/user"
+)
+
+type Repository interface {
+	// Reserve stores rec unless the key is already held. An expired record,
+	// or an unfinished one created before staleBefore, is taken over. It
+	// returns the record that holds the key and whether that is rec.
+	Reserve(ctx context.Context, rec *Record, staleBefore time.Time) (*Record, bool, error)
+	Complete(ctx context.Context, userID user.UserID, key Key, res *Response) error
+	Release(ctx context.Context, userID user.UserID, key Key) error
+	DeleteExpired(ctx context.Context, before time.Time) (int64, error)
+}
@@ -0,0 +1,71 @@
+package idempotency
+
+import (
+	"context"
+	"time"
+
+	"github.com/raphico/go-device-telemetry-api/internal/user"
+)
+
+// inProgressTimeout is how long an unfinished reservation blocks retries
+// before it is assumed abandoned (e.g. the server restarted mid-request).
+const inProgressTimeout = 5 * time.Minute
+
+type Service struct {
+	repo Repository
+	ttl  time.Duration
+}
+
+func NewService(repo Repository, ttl time.Duration) *Service {
+	return &Service{
+		repo: repo,
+		ttl:  ttl,
+	}
+}
+
+// Begin reserves the key for a new request. It returns the stored response
+// when the request was already handled, ErrFingerprintMismatch when the key
+// was used for a different request and ErrRequestInProgress while the first
+// attempt is still running. A nil response means the caller owns the key and
+// must Complete or Release it.
+func (s *Service) Begin(
+	ctx context.Context,
+	userID user.UserID,
+	key Key,
+	fingerprint Fingerprint,
+) (*Response, error) {
+	rec := NewRecord(userID, key, fingerprint, s.ttl)
+
+	held, reserved, err := s.repo.Reserve(ctx, rec, time.Now().Add(-inProgressTimeout))
+	if err != nil {
+		return nil, err
+	}
+
+	if reserved {
+		return nil, nil
+	}
+
+	if held.Fingerprint != fingerprint {
+		return nil, ErrFingerprintMismatch
+	}
+
+	if held.Response == nil {
+		return nil, ErrRequestInProgress
+	}
+
+	return held.Response, nil
+}
+
+func (s *Service) Complete(ctx context.Context, userID user.UserID, key Key, res *Response) error {
+	return s.repo.Complete(ctx, userID, key, res)
+}
+
+// Release frees the key so the request can be retried, used when it failed
+// in a way that should not be replayed.
+func (s *Service) Release(ctx context.Context, userID user.UserID, key Key) error {
+	return s.repo.Release(ctx, userID, key)
+}
+
+func (s *Service) PurgeExpired(ctx context.Context) (int64, error) {
+	return s.repo.DeleteExpired(ctx, time.Now())
+}
@@ -0,0 +1,15 @@
+package identity
+
+import "errors"
+
+var (
+	ErrProviderNotFound = errors.New("identity provider not found")
+	ErrInvalidState     = errors.New("login request is invalid or expired")
+	ErrExchangeFailed   = errors.New("identity provider rejected the authorization code")
+	ErrInvalidIDToken   = errors.New("invalid id token")
+	ErrEmailRequired    = errors.New("identity provider did not share an email address")
+	ErrIdentityNotFound = errors.New("identity not found")
+	ErrIdentityLinked   = errors.New("identity is already linked to an account")
+	ErrAccountExists    = errors.New("an account with this email already exists; sign in and link the provider instead")
+	ErrLastSignInMethod = errors.New("cannot unlink the only way to sign in; set a password first")
+)
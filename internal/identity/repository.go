@@ -0,0 +1,26 @@
+package identity
+
+import (
+	"context"
+	"time"
+
+	"github.com/raphico/go-device-telemetry-api/internal/user"
+)
+
+type Repository interface {
+	CreateState(ctx context.Context, s *LoginState) error
+	// ConsumeState deletes and returns the unexpired state matching hash,
+	// failing with ErrInvalidState when there is none, so every state is
+	// used at most once.
+	ConsumeState(ctx context.Context, hash []byte) (*LoginState, error)
+	// Create fails with ErrIdentityLinked when the provider and subject
+	// already belong to an account.
+	Create(ctx context.Context, i *Identity) error
+	// RecordLogin returns the identity matching provider and subject and
+	// sets its last login time, failing with ErrIdentityNotFound when
+	// there is none.
+	RecordLogin(ctx context.Context, provider string, subject string) (*Identity, error)
+	FindByUser(ctx context.Context, userID user.UserID) ([]*Identity, error)
+	Delete(ctx context.Context, id IdentityID, userID user.UserID) error
+	DeleteExpiredStates(ctx context.Context, before time.Time) (int64, error)
+}
@@ -0,0 +1,124 @@
+package identity
+
+import (
+	"context"
+	"slices"
+	"time"
+
+	"github.com/raphico/go-device-telemetry-api/internal/token"
+	"github.com/raphico/go-device-telemetry-api/internal/user"
+)
+
+type Service struct {
+	repo      Repository
+	providers map[string]*Provider
+	stateTTL  time.Duration
+}
+
+func NewService(repo Repository, providers []*Provider, stateTTL time.Duration) *Service {
+	byName := make(map[string]*Provider, len(providers))
+	for _, p := range providers {
+		byName[p.Name()] = p
+	}
+
+	return &Service{
+		repo:      repo,
+		providers: byName,
+		stateTTL:  stateTTL,
+	}
+}
+
+// Providers lists the configured provider names in alphabetical order.
+func (s *Service) Providers() []string {
+	names := make([]string, 0, len(s.providers))
+	for name := range s.providers {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+
+	return names
+}
+
+// StartLogin records a new authorization request for provider and returns
+// the URL to send the user to together with the request's state. A non-nil
+// linkUserID links the identity to that user when the flow completes
+// instead of signing in.
+func (s *Service) StartLogin(ctx context.Context, provider string, linkUserID *user.UserID) (string, string, error) {
+	p, ok := s.providers[provider]
+	if !ok {
+		return "", "", ErrProviderNotFound
+	}
+
+	state, err := NewLoginState(p.Name(), linkUserID, s.stateTTL)
+	if err != nil {
+		return "", "", err
+	}
+
+	// build the URL first so a failed discovery leaves no state behind
+	authURL, err := p.AuthCodeURL(ctx, state)
+	if err != nil {
+		return "", "", err
+	}
+
+	if err := s.repo.CreateState(ctx, state); err != nil {
+		return "", "", err
+	}
+
+	return authURL, state.State, nil
+}
+
+// CompleteLogin consumes the state of a provider callback and exchanges code
+// for the verified claims of the user, returning the state so the caller
+// can tell sign-ins from link requests.
+func (s *Service) CompleteLogin(ctx context.Context, provider, state, code string) (*Claims, *LoginState, error) {
+	p, ok := s.providers[provider]
+	if !ok {
+		return nil, nil, ErrProviderNotFound
+	}
+
+	loginState, err := s.repo.ConsumeState(ctx, token.HashPlaintext(state))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if loginState.Provider != p.Name() {
+		return nil, nil, ErrInvalidState
+	}
+
+	claims, err := p.Exchange(ctx, code, loginState)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return claims, loginState, nil
+}
+
+// RecordLogin returns the identity matching claims, failing with
+// ErrIdentityNotFound when it is not linked to any account yet.
+func (s *Service) RecordLogin(ctx context.Context, claims *Claims) (*Identity, error) {
+	return s.repo.RecordLogin(ctx, claims.Provider, claims.Subject)
+}
+
+// Link attaches the identity described by claims to userID.
+func (s *Service) Link(ctx context.Context, userID user.UserID, claims *Claims) (*Identity, error) {
+	i := NewIdentity(userID, claims)
+	if err := s.repo.Create(ctx, i); err != nil {
+		return nil, err
+	}
+
+	return i, nil
+}
+
+func (s *Service) ListIdentities(ctx context.Context, userID user.UserID) ([]*Identity, error) {
+	return s.repo.FindByUser(ctx, userID)
+}
+
+func (s *Service) Unlink(ctx context.Context, userID user.UserID, id IdentityID) error {
+	return s.repo.Delete(ctx, id, userID)
+}
+
+// PurgeExpiredStates deletes authorization requests that were never
+// completed.
+func (s *Service) PurgeExpiredStates(ctx context.Context) (int64, error) {
+	return s.repo.DeleteExpiredStates(ctx, time.Now())
+}
@@ -0,0 +1,22 @@
+package lockout
+
+import (
+	"errors"
+	"time"
+)
+
+var ErrLocked = errors.New("too many failed login attempts")
+
+// LockedError is ErrLocked with the time until the next attempt is
+// allowed.
+type LockedError struct {
+	RetryAfter time.Duration
+}
+
+func (e *LockedError) Error() string {
+	return ErrLocked.Error()
+}
+
+func (e *LockedError) Unwrap() error {
+	return ErrLocked
+}
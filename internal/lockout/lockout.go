@@ -0,0 +1,77 @@
+// Package lockout slows down and then blocks password guessing by counting
+// failed logins per account and per client IP.
+package lockout
+
+import (
+	"strings"
+	"time"
+)
+
+// ---------- Types ----------
+
+// Policy decides how failures turn into delays and lockouts. Failures
+// older than Window are forgotten. From an account's DelayAfter-th failure
+// on, each one blocks the next attempt for BaseDelay, doubling per further
+// failure up to MaxDelay, until AccountThreshold failures lock it for
+// LockoutDuration. IPs, which many users may share, are not delayed and
+// only locked after IPThreshold failures.
+type Policy struct {
+	Window           time.Duration
+	DelayAfter       int
+	BaseDelay        time.Duration
+	MaxDelay         time.Duration
+	AccountThreshold int
+	IPThreshold      int
+	LockoutDuration  time.Duration
+}
+
+// Attempt is a credential check in progress, already counted as a failed
+// login. AccountLocked and IPLocked are only set on the attempt that
+// started the lockout, so each lockout is reported once, and only once the
+// attempt has actually failed.
+type Attempt struct {
+	AccountLocked bool
+	IPLocked      bool
+	LockedUntil   time.Time
+
+	email string
+	ip    string
+	// the blocks this attempt set on each key, lifted again when it
+	// succeeds
+	accountBlockedUntil time.Time
+	ipBlockedUntil      time.Time
+}
+
+// ---------- Keys ----------
+
+// accountKey identifies an account by the email address it was tried
+// with, whether or not such an account exists, so unknown addresses behave
+// exactly like known ones.
+func accountKey(email string) string {
+	return "account:" + strings.ToLower(strings.TrimSpace(email))
+}
+
+func ipKey(ip string) string {
+	return "ip:" + ip
+}
+
+// ---------- Policy ----------
+
+// blockFor returns how long the failures-th failure blocks the next
+// attempt for and whether that is a lockout.
+func (p Policy) blockFor(failures int, threshold int, progressive bool) (time.Duration, bool) {
+	if threshold > 0 && failures >= threshold {
+		return p.LockoutDuration, true
+	}
+
+	if !progressive || p.DelayAfter <= 0 || failures < p.DelayAfter {
+		return 0, false
+	}
+
+	delay := p.BaseDelay
+	for i := p.DelayAfter; i < failures && delay < p.MaxDelay; i++ {
+		delay *= 2
+	}
+
+	return min(delay, p.MaxDelay), false
+}
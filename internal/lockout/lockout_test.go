@@ -0,0 +1,61 @@
+package lockout
+
+import (
+	"testing"
+	"time"
+)
+
+func TestPolicyBlockFor(t *testing.T) {
+	policy := Policy{
+		DelayAfter:      3,
+		BaseDelay:       time.Second,
+		MaxDelay:        30 * time.Second,
+		LockoutDuration: 15 * time.Minute,
+	}
+
+	tests := []struct {
+		name        string
+		policy      Policy
+		failures    int
+		threshold   int
+		progressive bool
+		wantBlock   time.Duration
+		wantLocked  bool
+	}{
+		{"no failures", policy, 0, 10, true, 0, false},
+		{"below delay", policy, 2, 10, true, 0, false},
+		{"first delay", policy, 3, 10, true, time.Second, false},
+		{"doubles", policy, 4, 10, true, 2 * time.Second, false},
+		{"doubles again", policy, 6, 10, true, 8 * time.Second, false},
+		{"capped at max delay", policy, 8, 10, true, 30 * time.Second, false},
+		{"just below threshold", policy, 9, 10, true, 30 * time.Second, false},
+		{"at threshold", policy, 10, 10, true, 15 * time.Minute, true},
+		{"past threshold", policy, 11, 10, true, 15 * time.Minute, true},
+		{"many failures stay capped", policy, 1000, 0, true, 30 * time.Second, false},
+		{"zero threshold never locks", policy, 50, 0, true, 30 * time.Second, false},
+		{"not progressive below threshold", policy, 99, 100, false, 0, false},
+		{"not progressive at threshold", policy, 100, 100, false, 15 * time.Minute, true},
+		{
+			"zero delay after disables delays",
+			Policy{BaseDelay: time.Second, MaxDelay: 30 * time.Second, LockoutDuration: time.Minute},
+			5, 10, true, 0, false,
+		},
+		{
+			"max delay not a doubling of base",
+			Policy{DelayAfter: 1, BaseDelay: 3 * time.Second, MaxDelay: 10 * time.Second},
+			3, 0, true, 10 * time.Second, false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			block, locked := tt.policy.blockFor(tt.failures, tt.threshold, tt.progressive)
+			if block != tt.wantBlock || locked != tt.wantLocked {
+				t.Errorf(
+					"blockFor(%d, %d, %v) = %s, %v, want %s, %v",
+					tt.failures, tt.threshold, tt.progressive, block, locked, tt.wantBlock, tt.wantLocked,
+				)
+			}
+		})
+	}
+}
@@ -0,0 +1,30 @@
+package lockout
+
+import (
+	"context"
+	"time"
+)
+
+type Repository interface {
+	// LockedUntil returns the latest time any of keys is blocked until,
+	// which is in the past or zero when none is blocked.
+	LockedUntil(ctx context.Context, keys []string) (time.Time, error)
+	// RecordAttempt counts a failure for key at now unless key is blocked,
+	// restarting the count when the previous failure is older than window,
+	// atomically with respect to other callers. It returns the new count or,
+	// when key is blocked, the time it is blocked until without counting.
+	RecordAttempt(ctx context.Context, key string, now time.Time, window time.Duration) (int, time.Time, error)
+	// Forgive takes one failure of key back and lifts its block if it ends
+	// no later than blockedUntil.
+	Forgive(ctx context.Context, key string, blockedUntil time.Time) error
+	// Block makes key blocked until the given time unless it already is
+	// for longer.
+	Block(ctx context.Context, key string, until time.Time) error
+	// Lock blocks key like Block and restarts its count, so failures counted
+	// before a lockout do not count again once it ends.
+	Lock(ctx context.Context, key string, until time.Time) error
+	Reset(ctx context.Context, key string) error
+	// DeleteExpired drops keys that are not blocked at now and whose last
+	// failure is older than window.
+	DeleteExpired(ctx context.Context, now time.Time, window time.Duration) (int64, error)
+}
@@ -0,0 +1,142 @@
+package lockout
+
+import (
+	"context"
+	"time"
+)
+
+type Service struct {
+	repo   Repository
+	policy Policy
+}
+
+func NewService(repo Repository, policy Policy) *Service {
+	return &Service{
+		repo:   repo,
+		policy: policy,
+	}
+}
+
+// Begin starts checking credentials given for the account tried as email
+// from ip, failing with a *LockedError while either is blocked. The
+// attempt is counted as failed before the credentials are checked, so
+// concurrent guesses see each other, and blocks further attempts as the
+// policy demands. An attempt past a threshold is refused as well, since
+// attempts still in progress have used up what was left.
+//
+// Call Succeed or Forgive when the credentials turn out to be right, and
+// leave the attempt counted when they are wrong.
+func (s *Service) Begin(ctx context.Context, email string, ip string) (*Attempt, error) {
+	now := time.Now()
+	attempt := &Attempt{email: email, ip: ip}
+
+	until, locked, err := s.recordAttempt(ctx, accountKey(email), s.policy.AccountThreshold, true, now, attempt)
+	if err != nil {
+		return nil, err
+	}
+	attempt.accountBlockedUntil = until
+	attempt.AccountLocked = locked
+
+	if ip != "" {
+		until, locked, err := s.recordAttempt(ctx, ipKey(ip), s.policy.IPThreshold, false, now, attempt)
+		if err != nil {
+			// the attempt never happens, so the account gets it back
+			_ = s.repo.Forgive(ctx, accountKey(email), attempt.accountBlockedUntil)
+			return nil, err
+		}
+		attempt.ipBlockedUntil = until
+		attempt.IPLocked = locked
+	}
+
+	return attempt, nil
+}
+
+// Succeed ends an attempt whose credentials were right, forgetting the
+// account's failures. The client IP only gets this attempt back, so an
+// attacker cannot clear its count by logging into an account of their own
+// between guesses.
+func (s *Service) Succeed(ctx context.Context, attempt *Attempt) error {
+	if err := s.repo.Reset(ctx, accountKey(attempt.email)); err != nil {
+		return err
+	}
+
+	return s.forgiveIP(ctx, attempt)
+}
+
+// Forgive takes back an attempt whose credentials were right without
+// forgetting earlier failures, for a first factor that still needs a
+// second one, or an attempt that could not be checked at all.
+func (s *Service) Forgive(ctx context.Context, attempt *Attempt) error {
+	if err := s.repo.Forgive(ctx, accountKey(attempt.email), attempt.accountBlockedUntil); err != nil {
+		return err
+	}
+
+	return s.forgiveIP(ctx, attempt)
+}
+
+// Forget drops the failures counted for the account tried as email, for
+// an account being deleted.
+func (s *Service) Forget(ctx context.Context, email string) error {
+	return s.repo.Reset(ctx, accountKey(email))
+}
+
+// PurgeExpired deletes counters that no longer block or count anything.
+func (s *Service) PurgeExpired(ctx context.Context) (int64, error) {
+	return s.repo.DeleteExpired(ctx, time.Now(), s.policy.Window)
+}
+
+func (s *Service) forgiveIP(ctx context.Context, attempt *Attempt) error {
+	if attempt.ip == "" {
+		return nil
+	}
+
+	return s.repo.Forgive(ctx, ipKey(attempt.ip), attempt.ipBlockedUntil)
+}
+
+// recordAttempt counts an attempt for key and blocks it, returning the block
+// it set and whether that started a lockout.
+func (s *Service) recordAttempt(
+	ctx context.Context,
+	key string,
+	threshold int,
+	progressive bool,
+	now time.Time,
+	attempt *Attempt,
+) (time.Time, bool, error) {
+	failures, lockedUntil, err := s.repo.RecordAttempt(ctx, key, now, s.policy.Window)
+	if err != nil {
+		return time.Time{}, false, err
+	}
+
+	if wait := lockedUntil.Sub(now); wait > 0 {
+		return time.Time{}, false, &LockedError{RetryAfter: wait}
+	}
+
+	// the attempt that reached the threshold sets the lockout, which may not
+	// be stored yet; storing it restarts the count, so this only refuses
+	// attempts racing that one
+	if threshold > 0 && failures > threshold {
+		return time.Time{}, false, &LockedError{RetryAfter: s.policy.LockoutDuration}
+	}
+
+	block, locked := s.policy.blockFor(failures, threshold, progressive)
+	if block <= 0 {
+		return time.Time{}, false, nil
+	}
+
+	until := now.Add(block)
+	if locked {
+		err = s.repo.Lock(ctx, key, until)
+	} else {
+		err = s.repo.Block(ctx, key, until)
+	}
+	if err != nil {
+		return time.Time{}, false, err
+	}
+
+	if until.After(attempt.LockedUntil) {
+		attempt.LockedUntil = until
+	}
+
+	return until, locked, nil
+}
@@ -0,0 +1,23 @@
+package mail
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/raphico/go-device-telemetry-api/internal/logger"
+)
+
+// LogMailer writes messages to the application log instead of sending them,
+// for local development.
+type LogMailer struct {
+	log *logger.Logger
+}
+
+func NewLogMailer(log *logger.Logger) *LogMailer {
+	return &LogMailer{log: log}
+}
+
+func (m *LogMailer) Send(_ context.Context, msg Message) error {
+	m.log.Info(fmt.Sprintf("mail to=%s subject=%q\n%s", msg.To, msg.Subject, msg.Body))
+	return nil
+}
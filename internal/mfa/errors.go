@@ -0,0 +1,9 @@
+package mfa
+
+import "errors"
+
+var (
+	ErrNotEnrolled    = errors.New("two-factor authentication is not set up")
+	ErrAlreadyEnabled = errors.New("two-factor authentication is already enabled")
+	ErrInvalidCode    = errors.New("invalid two-factor authentication code")
+)
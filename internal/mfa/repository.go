@@ -0,0 +1,28 @@
+package mfa
+
+import (
+	"context"
+
+	"github.com/raphico/go-device-telemetry-api/internal/user"
+)
+
+type Repository interface {
+	// SaveEnrollment stores a pending enrollment, replacing an unconfirmed
+	// one, and fails with ErrAlreadyEnabled when a confirmed one exists.
+	SaveEnrollment(ctx context.Context, e *Enrollment) error
+	// FindEnrollment fails with ErrNotEnrolled when userID has none.
+	FindEnrollment(ctx context.Context, userID user.UserID) (*Enrollment, error)
+	// Confirm marks userID's pending enrollment confirmed, with step used
+	// up, and replaces their recovery codes in one transaction. It fails
+	// with ErrNotEnrolled when there is no pending enrollment.
+	Confirm(ctx context.Context, userID user.UserID, step int64, recoveryCodeHashes [][]byte) error
+	// UseStep records step as used, failing with ErrInvalidCode when it or
+	// a later step was used already.
+	UseStep(ctx context.Context, userID user.UserID, step int64) error
+	// UseRecoveryCode marks the unused recovery code matching hash as used,
+	// failing with ErrInvalidCode when there is none.
+	UseRecoveryCode(ctx context.Context, userID user.UserID, hash []byte) error
+	CountRecoveryCodes(ctx context.Context, userID user.UserID) (int, error)
+	// Delete removes userID's enrollment and recovery codes.
+	Delete(ctx context.Context, userID user.UserID) error
+}
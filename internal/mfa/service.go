@@ -0,0 +1,142 @@
+package mfa
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/raphico/go-device-telemetry-api/internal/audit"
+	"github.com/raphico/go-device-telemetry-api/internal/user"
+)
+
+type Service struct {
+	repo  Repository
+	audit *audit.Service
+	// issuer names the service in authenticator apps.
+	issuer string
+}
+
+func NewService(repo Repository, auditService *audit.Service, issuer string) *Service {
+	return &Service{
+		repo:   repo,
+		audit:  auditService,
+		issuer: issuer,
+	}
+}
+
+func (s *Service) Status(ctx context.Context, userID user.UserID) (*Status, error) {
+	e, err := s.repo.FindEnrollment(ctx, userID)
+	if err != nil {
+		if errors.Is(err, ErrNotEnrolled) {
+			return &Status{}, nil
+		}
+		return nil, err
+	}
+
+	if !e.IsConfirmed() {
+		return &Status{}, nil
+	}
+
+	remaining, err := s.repo.CountRecoveryCodes(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Status{Enabled: true, EnabledAt: e.ConfirmedAt, RecoveryCodesRemaining: remaining}, nil
+}
+
+// IsEnabled reports whether logins of userID need a second factor.
+func (s *Service) IsEnabled(ctx context.Context, userID user.UserID) (bool, error) {
+	status, err := s.Status(ctx, userID)
+	if err != nil {
+		return false, err
+	}
+
+	return status.Enabled, nil
+}
+
+// Enroll starts setting up TOTP for u, replacing an unconfirmed enrollment,
+// and returns it with its provisioning URI. It has no effect on logins
+// until confirmed.
+func (s *Service) Enroll(ctx context.Context, u *user.User) (*Enrollment, string, error) {
+	e, err := NewEnrollment(u.ID)
+	if err != nil {
+		return nil, "", err
+	}
+
+	if err := s.repo.SaveEnrollment(ctx, e); err != nil {
+		return nil, "", err
+	}
+
+	return e, ProvisioningURI(s.issuer, u.Email.String(), e.Secret), nil
+}
+
+// Confirm enables two-factor authentication once code shows the
+// authenticator app has the secret, returning fresh recovery codes. They
+// are only available here.
+func (s *Service) Confirm(ctx context.Context, userID user.UserID, code string) ([]string, error) {
+	e, err := s.repo.FindEnrollment(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	if e.IsConfirmed() {
+		return nil, ErrAlreadyEnabled
+	}
+
+	step, ok := matchTOTP(e.Secret, code, time.Now())
+	if !ok {
+		return nil, ErrInvalidCode
+	}
+
+	codes, hashes, err := newRecoveryCodes()
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.repo.Confirm(ctx, userID, step, hashes); err != nil {
+		return nil, err
+	}
+
+	s.audit.Record(ctx, audit.EventMFAEnabled, &userID, nil)
+
+	return codes, nil
+}
+
+// Verify checks a TOTP or recovery code of a user with two-factor
+// authentication enabled. Each code is accepted once; anything else fails
+// with ErrInvalidCode.
+func (s *Service) Verify(ctx context.Context, userID user.UserID, code string) error {
+	e, err := s.repo.FindEnrollment(ctx, userID)
+	if err != nil {
+		return err
+	}
+
+	if !e.IsConfirmed() {
+		return ErrNotEnrolled
+	}
+
+	if step, ok := matchTOTP(e.Secret, code, time.Now()); ok {
+		return s.repo.UseStep(ctx, userID, step)
+	}
+
+	if err := s.repo.UseRecoveryCode(ctx, userID, hashRecoveryCode(code)); err != nil {
+		return err
+	}
+
+	s.audit.Record(ctx, audit.EventMFARecoveryCodeUsed, &userID, nil)
+
+	return nil
+}
+
+// Disable removes userID's enrollment and recovery codes. Callers must
+// have re-authenticated the user.
+func (s *Service) Disable(ctx context.Context, userID user.UserID) error {
+	if err := s.repo.Delete(ctx, userID); err != nil {
+		return err
+	}
+
+	s.audit.Record(ctx, audit.EventMFADisabled, &userID, nil)
+
+	return nil
+}
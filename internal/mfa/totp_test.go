@@ -0,0 +1,118 @@
+package mfa
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// rfcSecret is the SHA-1 key shared by the RFC 4226 and RFC 6238 test
+// vectors, base32 encoded the way secrets are stored.
+var rfcSecret = secretEncoding.EncodeToString([]byte("12345678901234567890"))
+
+func TestTOTPCodeMatchesRFC4226(t *testing.T) {
+	// RFC 4226 appendix D
+	want := []string{
+		"755224", "287082", "359152", "969429", "338314",
+		"254676", "287922", "162583", "399871", "520489",
+	}
+
+	for step, code := range want {
+		if got := totpCode([]byte("12345678901234567890"), int64(step)); got != code {
+			t.Errorf("totpCode(step %d) = %s, want %s", step, got, code)
+		}
+	}
+}
+
+func TestMatchTOTPMatchesRFC6238(t *testing.T) {
+	// RFC 6238 appendix B, SHA-1, truncated to the six digits used here
+	tests := []struct {
+		unix int64
+		code string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1111111111, "050471"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+		{20000000000, "353130"},
+	}
+
+	for _, tt := range tests {
+		now := time.Unix(tt.unix, 0)
+
+		step, ok := matchTOTP(rfcSecret, tt.code, now)
+		if !ok {
+			t.Errorf("matchTOTP(%s at %d) did not match", tt.code, tt.unix)
+			continue
+		}
+
+		if want := tt.unix / 30; step != want {
+			t.Errorf("matchTOTP(%s at %d) step = %d, want %d", tt.code, tt.unix, step, want)
+		}
+	}
+}
+
+func TestMatchTOTPSkew(t *testing.T) {
+	key, err := secretEncoding.DecodeString(rfcSecret)
+	if err != nil {
+		t.Fatalf("decode secret: %v", err)
+	}
+
+	now := time.Unix(1111111111, 0)
+	current := now.Unix() / 30
+
+	tests := []struct {
+		name   string
+		step   int64
+		wantOK bool
+	}{
+		{"previous period", current - 1, true},
+		{"current period", current, true},
+		{"next period", current + 1, true},
+		{"two periods ago", current - 2, false},
+		{"two periods ahead", current + 2, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			step, ok := matchTOTP(rfcSecret, totpCode(key, tt.step), now)
+			if ok != tt.wantOK {
+				t.Fatalf("matchTOTP ok = %v, want %v", ok, tt.wantOK)
+			}
+			if ok && step != tt.step {
+				t.Errorf("matchTOTP step = %d, want %d", step, tt.step)
+			}
+		})
+	}
+}
+
+func TestMatchTOTPRejectsMalformedInput(t *testing.T) {
+	now := time.Unix(59, 0)
+
+	tests := []struct {
+		name   string
+		secret string
+		code   string
+	}{
+		{"wrong code", rfcSecret, "000000"},
+		{"short code", rfcSecret, "28708"},
+		{"long code", rfcSecret, "2870820"},
+		{"eight digit code", rfcSecret, "94287082"},
+		{"secret not base32", "not-base32!", "287082"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, ok := matchTOTP(tt.secret, tt.code, now); ok {
+				t.Errorf("matchTOTP(%q, %q) matched", tt.secret, tt.code)
+			}
+		})
+	}
+}
+
+func TestMatchTOTPAcceptsLowerCaseSecret(t *testing.T) {
+	if _, ok := matchTOTP(strings.ToLower(rfcSecret), "287082", time.Unix(59, 0)); !ok {
+		t.Error("matchTOTP did not match with a lower-case secret")
+	}
+}
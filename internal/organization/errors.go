@@ -0,0 +1,11 @@
+package organization
+
+import "errors"
+
+var (
+	ErrOrganizationNotFound = errors.New("organization not found")
+	ErrMemberNotFound       = errors.New("member not found")
+	ErrAlreadyMember        = errors.New("user is already a member")
+	ErrLastOwner            = errors.New("an organization must keep at least one owner")
+	ErrOrganizationRequired = errors.New("organization_id is required when belonging to several organizations")
+)
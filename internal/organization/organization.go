@@ -0,0 +1,131 @@
+package organization
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/google/uuid"
+	"github.com/raphico/go-device-telemetry-api/internal/authz"
+	"github.com/raphico/go-device-telemetry-api/internal/user"
+)
+
+// ---------- Types ----------
+
+type OrganizationID uuid.UUID
+
+type Name struct {
+	value string
+}
+
+type Organization struct {
+	ID        OrganizationID
+	Name      Name
+	CreatedAt time.Time
+}
+
+type Membership struct {
+	OrganizationID OrganizationID
+	UserID         user.UserID
+	Role           authz.Role
+	CreatedAt      time.Time
+}
+
+// Affiliation is an organization seen from one of its members.
+type Affiliation struct {
+	Organization *Organization
+	Role         authz.Role
+	// Personal is set on the organization the member got at registration.
+	Personal bool
+}
+
+// ---------- OrganizationID ----------
+
+func NewOrganizationID(id string) (OrganizationID, error) {
+	parsed, err := uuid.Parse(id)
+	if err != nil {
+		return OrganizationID(uuid.Nil), err
+	}
+
+	return OrganizationID(parsed), nil
+}
+
+func (id OrganizationID) String() string {
+	return uuid.UUID(id).String()
+}
+
+// ---------- Name ----------
+
+func NewName(value string) (Name, error) {
+	value = strings.TrimSpace(value)
+
+	if value == "" {
+		return Name{}, errors.New("organization name is required")
+	}
+
+	if len(value) > 100 {
+		return Name{}, errors.New("organization name must be at most 100 characters")
+	}
+
+	if strings.IndexFunc(value, unicode.IsControl) >= 0 {
+		return Name{}, errors.New("organization name cannot contain control characters")
+	}
+
+	return Name{value: value}, nil
+}
+
+func (n Name) String() string {
+	return n.value
+}
+
+// ---------- Organization ----------
+
+func NewOrganization(name Name) *Organization {
+	return &Organization{Name: name}
+}
+
+// ---------- Membership ----------
+
+func NewMembership(organizationID OrganizationID, userID user.UserID, role authz.Role) *Membership {
+	return &Membership{
+		OrganizationID: organizationID,
+		UserID:         userID,
+		Role:           role,
+	}
+}
+
+// ---------- Rehydration ----------
+
+func RehydrateOrganization(id uuid.UUID, name string, createdAt time.Time) (*Organization, error) {
+	n, err := NewName(name)
+	if err != nil {
+		return nil, fmt.Errorf("corrupt organization name: %w", err)
+	}
+
+	return &Organization{
+		ID:        OrganizationID(id),
+		Name:      n,
+		CreatedAt: createdAt,
+	}, nil
+}
+
+func RehydrateMembership(
+	organizationID uuid.UUID,
+	userID uuid.UUID,
+	role string,
+	createdAt time.Time,
+) (*Membership, error) {
+	r, err := authz.NewRole(role)
+	if err != nil {
+		return nil, fmt.Errorf("corrupt membership role: %w", err)
+	}
+
+	return &Membership{
+		OrganizationID: OrganizationID(organizationID),
+		UserID:         user.UserID(userID),
+		Role:           r,
+		CreatedAt:      createdAt,
+	}, nil
+}
@@ -0,0 +1,24 @@
+package organization
+
+import (
+	"context"
+
+	"github.com/raphico/go-device-telemetry-api/internal/user"
+)
+
+type Repository interface {
+	// Create stores org together with its first member.
+	Create(ctx context.Context, org *Organization, owner *Membership) error
+	FindById(ctx context.Context, id OrganizationID) (*Organization, error)
+	FindByUser(ctx context.Context, userID user.UserID) ([]*Affiliation, error)
+	// FindSoleOwnerships lists the organizations userID is the only owner of
+	// that still have other members.
+	FindSoleOwnerships(ctx context.Context, userID user.UserID) ([]*Organization, error)
+	FindMembership(ctx context.Context, id OrganizationID, userID user.UserID) (*Membership, error)
+	FindMembers(ctx context.Context, id OrganizationID) ([]*Membership, error)
+	AddMember(ctx context.Context, m *Membership) error
+	// UpdateMemberRole and RemoveMember fail with ErrLastOwner rather than
+	// leave the organization without an owner.
+	UpdateMemberRole(ctx context.Context, m *Membership) error
+	RemoveMember(ctx context.Context, id OrganizationID, userID user.UserID) error
+}
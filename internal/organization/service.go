@@ -0,0 +1,201 @@
+package organization
+
+import (
+	"context"
+	"errors"
+
+	"github.com/google/uuid"
+	"github.com/raphico/go-device-telemetry-api/internal/authz"
+	"github.com/raphico/go-device-telemetry-api/internal/user"
+)
+
+// UserFinder resolves the user being added to an organization.
+type UserFinder interface {
+	GetUserByEmail(ctx context.Context, email user.Email) (*user.User, error)
+}
+
+type Service struct {
+	repo  Repository
+	users UserFinder
+	authz *authz.Authorizer
+}
+
+func NewService(repo Repository, users UserFinder, authorizer *authz.Authorizer) *Service {
+	return &Service{
+		repo:  repo,
+		users: users,
+		authz: authorizer,
+	}
+}
+
+// CreateOrganization creates an organization owned by actor.
+func (s *Service) CreateOrganization(ctx context.Context, actor authz.Actor, name Name) (*Organization, error) {
+	org := NewOrganization(name)
+	owner := NewMembership(org.ID, actor.UserID, authz.RoleOwner)
+
+	if err := s.repo.Create(ctx, org, owner); err != nil {
+		return nil, err
+	}
+
+	return org, nil
+}
+
+func (s *Service) ListOrganizations(ctx context.Context, actor authz.Actor) ([]*Affiliation, error) {
+	return s.repo.FindByUser(ctx, actor.UserID)
+}
+
+func (s *Service) GetOrganization(ctx context.Context, actor authz.Actor, id OrganizationID) (*Affiliation, error) {
+	role, err := s.authorize(ctx, actor, id, authz.PermOrganizationRead)
+	if err != nil {
+		return nil, err
+	}
+
+	org, err := s.repo.FindById(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Affiliation{Organization: org, Role: role}, nil
+}
+
+// DefaultOrganization is the organization to use when a request names none:
+// the actor's only organization, or else their personal one. It fails with
+// ErrOrganizationRequired when they have left their personal organization
+// and belong to several others.
+func (s *Service) DefaultOrganization(ctx context.Context, actor authz.Actor) (OrganizationID, error) {
+	affiliations, err := s.repo.FindByUser(ctx, actor.UserID)
+	if err != nil {
+		return OrganizationID{}, err
+	}
+
+	if len(affiliations) == 1 {
+		return affiliations[0].Organization.ID, nil
+	}
+
+	for _, a := range affiliations {
+		if a.Personal {
+			return a.Organization.ID, nil
+		}
+	}
+
+	return OrganizationID{}, ErrOrganizationRequired
+}
+
+func (s *Service) ListMembers(ctx context.Context, actor authz.Actor, id OrganizationID) ([]*Membership, error) {
+	if _, err := s.authorize(ctx, actor, id, authz.PermOrganizationRead); err != nil {
+		return nil, err
+	}
+
+	return s.repo.FindMembers(ctx, id)
+}
+
+// AddMember adds the user registered under email. Admins may add any role
+// but owner, which only owners can grant.
+func (s *Service) AddMember(
+	ctx context.Context,
+	actor authz.Actor,
+	id OrganizationID,
+	email user.Email,
+	role authz.Role,
+) (*Membership, error) {
+	actorRole, err := s.authorize(ctx, actor, id, authz.PermMembersManage)
+	if err != nil {
+		return nil, err
+	}
+
+	if role == authz.RoleOwner && actorRole != authz.RoleOwner {
+		return nil, authz.ErrForbidden
+	}
+
+	u, err := s.users.GetUserByEmail(ctx, email)
+	if err != nil {
+		return nil, err
+	}
+
+	m := NewMembership(id, u.ID, role)
+	if err := s.repo.AddMember(ctx, m); err != nil {
+		return nil, err
+	}
+
+	return m, nil
+}
+
+// UpdateMemberRole changes a member's role. Only owners can promote to or
+// demote from owner.
+func (s *Service) UpdateMemberRole(
+	ctx context.Context,
+	actor authz.Actor,
+	id OrganizationID,
+	userID user.UserID,
+	role authz.Role,
+) (*Membership, error) {
+	actorRole, err := s.authorize(ctx, actor, id, authz.PermMembersManage)
+	if err != nil {
+		return nil, err
+	}
+
+	m, err := s.repo.FindMembership(ctx, id, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	if (m.Role == authz.RoleOwner || role == authz.RoleOwner) && actorRole != authz.RoleOwner {
+		return nil, authz.ErrForbidden
+	}
+
+	m.Role = role
+	if err := s.repo.UpdateMemberRole(ctx, m); err != nil {
+		return nil, err
+	}
+
+	return m, nil
+}
+
+// RemoveMember removes userID from the organization. Any member may leave;
+// removing someone else takes an admin, or an owner when they are an owner.
+func (s *Service) RemoveMember(ctx context.Context, actor authz.Actor, id OrganizationID, userID user.UserID) error {
+	if userID == actor.UserID {
+		if _, err := s.authorize(ctx, actor, id, authz.PermOrganizationRead); err != nil {
+			return err
+		}
+		return s.repo.RemoveMember(ctx, id, userID)
+	}
+
+	actorRole, err := s.authorize(ctx, actor, id, authz.PermMembersManage)
+	if err != nil {
+		return err
+	}
+
+	m, err := s.repo.FindMembership(ctx, id, userID)
+	if err != nil {
+		return err
+	}
+
+	if m.Role == authz.RoleOwner && actorRole != authz.RoleOwner {
+		return authz.ErrForbidden
+	}
+
+	return s.repo.RemoveMember(ctx, id, userID)
+}
+
+// SoleOwnerships lists the organizations that would be left without an
+// owner if actor went away: those they alone own that have other members.
+func (s *Service) SoleOwnerships(ctx context.Context, actor authz.Actor) ([]*Organization, error) {
+	return s.repo.FindSoleOwnerships(ctx, actor.UserID)
+}
+
+// authorize runs the permission check, reporting organizations the actor is
+// not in as not found.
+func (s *Service) authorize(
+	ctx context.Context,
+	actor authz.Actor,
+	id OrganizationID,
+	perm authz.Permission,
+) (authz.Role, error) {
+	role, err := s.authz.Authorize(ctx, actor, uuid.UUID(id), perm)
+	if errors.Is(err, authz.ErrNotMember) {
+		return authz.Role{}, ErrOrganizationNotFound
+	}
+
+	return role, err
+}
@@ -0,0 +1,7 @@
+package ratelimit
+
+import "errors"
+
+var (
+	ErrInvalidLimit = errors.New("rate limit must look like <count>/<period>, e.g. 600/1m, or off")
+)
@@ -0,0 +1,132 @@
+package ratelimit
+
+import (
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ---------- Types ----------
+
+// Group names a set of routes that share a limit.
+type Group string
+
+const (
+	// GroupAuth covers the unauthenticated auth routes, keyed by client IP.
+	GroupAuth Group = "auth"
+	// GroupAPI covers every authenticated request, keyed by user.
+	GroupAPI Group = "api"
+	// GroupIngest covers telemetry ingestion, keyed by user.
+	GroupIngest Group = "ingest"
+	// GroupDevice covers telemetry ingestion, keyed by device.
+	GroupDevice Group = "device"
+)
+
+// Limit is a token bucket holding up to Burst tokens that refills Burst
+// tokens every Period. The zero Limit is disabled.
+type Limit struct {
+	Burst  int
+	Period time.Duration
+}
+
+// Result is the outcome of taking one token from a bucket.
+type Result struct {
+	Allowed   bool
+	Limit     int
+	Remaining int
+	// Reset is how long until the bucket is full again.
+	Reset time.Duration
+	// RetryAfter is how long until the next token, set when not allowed.
+	RetryAfter time.Duration
+}
+
+// QuotaResult is the outcome of charging a daily quota.
+type QuotaResult struct {
+	Allowed   bool
+	Limit     int64
+	Remaining int64
+	// Reset is how long until the quota window rolls over.
+	Reset time.Duration
+}
+
+// ---------- Limit ----------
+
+// ParseLimit reads "<count>/<period>" such as "600/1m". "off" or an empty
+// string disables the limit.
+func ParseLimit(raw string) (Limit, error) {
+	raw = strings.TrimSpace(raw)
+	if raw == "" || raw == "off" {
+		return Limit{}, nil
+	}
+
+	count, period, ok := strings.Cut(raw, "/")
+	if !ok {
+		return Limit{}, ErrInvalidLimit
+	}
+
+	burst, err := strconv.Atoi(count)
+	if err != nil || burst < 1 {
+		return Limit{}, ErrInvalidLimit
+	}
+
+	d, err := time.ParseDuration(period)
+	if err != nil || d <= 0 {
+		return Limit{}, ErrInvalidLimit
+	}
+
+	return Limit{Burst: burst, Period: d}, nil
+}
+
+func (l Limit) Enabled() bool {
+	return l.Burst > 0 && l.Period > 0
+}
+
+// Interval is the time it takes to refill one token.
+func (l Limit) Interval() time.Duration {
+	return l.Period / time.Duration(l.Burst)
+}
+
+// Take applies one request to a bucket and returns the bucket's new state
+// along with the outcome. Buckets are stored as the theoretical arrival time
+// (tat) of the next request: the bucket is full once tat is in the past and
+// empty once tat is a whole Period ahead. A zero tat is a full bucket.
+func (l Limit) Take(tat, now time.Time) (time.Time, Result) {
+	if tat.Before(now) {
+		tat = now
+	}
+
+	next := tat.Add(l.Interval())
+	if next.After(now.Add(l.Period)) {
+		return tat, Result{
+			Allowed:    false,
+			Limit:      l.Burst,
+			Remaining:  0,
+			Reset:      tat.Sub(now),
+			RetryAfter: next.Sub(now.Add(l.Period)),
+		}
+	}
+
+	return next, l.Admitted(next, now)
+}
+
+// Admitted describes an allowed request that moved the bucket to tat.
+func (l Limit) Admitted(tat, now time.Time) Result {
+	headroom := now.Add(l.Period).Sub(tat)
+
+	return Result{
+		Allowed:   true,
+		Limit:     l.Burst,
+		Remaining: int(math.Floor(float64(headroom) / float64(l.Interval()))),
+		Reset:     tat.Sub(now),
+	}
+}
+
+// ---------- Quota ----------
+
+// QuotaDay returns the UTC day now falls in, which is the daily quota
+// window.
+func QuotaDay(now time.Time) time.Time {
+	y, m, d := now.UTC().Date()
+	return time.Date(y, m, d, 0, 0, 0, 0, time.UTC)
+}
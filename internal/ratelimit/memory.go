@@ -0,0 +1,76 @@
+package ratelimit
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// MemoryRepository keeps buckets and quotas in process memory. It is exact
+// for a single instance; replicas each count separately.
+type MemoryRepository struct {
+	mu      sync.Mutex
+	buckets map[string]time.Time
+	usage   map[usageKey]int64
+}
+
+type usageKey struct {
+	key string
+	day time.Time
+}
+
+func NewMemoryRepository() *MemoryRepository {
+	return &MemoryRepository{
+		buckets: make(map[string]time.Time),
+		usage:   make(map[usageKey]int64),
+	}
+}
+
+func (m *MemoryRepository) Take(_ context.Context, key string, limit Limit, now time.Time) (Result, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	tat, res := limit.Take(m.buckets[key], now)
+	m.buckets[key] = tat
+
+	return res, nil
+}
+
+func (m *MemoryRepository) AddUsage(_ context.Context, key string, day time.Time, n, ceiling int64) (int64, bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	k := usageKey{key: key, day: day}
+	used := m.usage[k]
+
+	if ceiling >= 0 && used+n > ceiling {
+		return used, false, nil
+	}
+
+	m.usage[k] = used + n
+	return used + n, true, nil
+}
+
+func (m *MemoryRepository) DeleteExpired(_ context.Context, now time.Time) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var deleted int64
+
+	for key, tat := range m.buckets {
+		if tat.Before(now) {
+			delete(m.buckets, key)
+			deleted++
+		}
+	}
+
+	today := QuotaDay(now)
+	for k := range m.usage {
+		if k.day.Before(today) {
+			delete(m.usage, k)
+			deleted++
+		}
+	}
+
+	return deleted, nil
+}
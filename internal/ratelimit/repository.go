@@ -0,0 +1,18 @@
+package ratelimit
+
+import (
+	"context"
+	"time"
+)
+
+type Repository interface {
+	// Take removes one token from the bucket stored under key, atomically
+	// with respect to other callers.
+	Take(ctx context.Context, key string, limit Limit, now time.Time) (Result, error)
+	// AddUsage adds n to the usage counted for key on day unless that would
+	// exceed ceiling, and returns the resulting usage and whether n was added.
+	// A negative ceiling never refuses.
+	AddUsage(ctx context.Context, key string, day time.Time, n, ceiling int64) (int64, bool, error)
+	// DeleteExpired drops full buckets and usage of days before now.
+	DeleteExpired(ctx context.Context, now time.Time) (int64, error)
+}
@@ -0,0 +1,93 @@
+package ratelimit
+
+import (
+	"context"
+	"time"
+)
+
+type Service struct {
+	repo       Repository
+	limits     map[Group]Limit
+	dailyQuota int64
+}
+
+// NewService limits each group in limits; groups without an enabled Limit
+// are not limited. A dailyQuota of 0 disables the ingestion quota.
+func NewService(repo Repository, limits map[Group]Limit, dailyQuota int64) *Service {
+	return &Service{
+		repo:       repo,
+		limits:     limits,
+		dailyQuota: dailyQuota,
+	}
+}
+
+// Allow takes a token from subject's bucket in group. A disabled group
+// always allows and returns a zero Limit.
+func (s *Service) Allow(ctx context.Context, group Group, subject string) (Result, error) {
+	limit := s.limits[group]
+	if !limit.Enabled() {
+		return Result{Allowed: true}, nil
+	}
+
+	return s.repo.Take(ctx, string(group)+":"+subject, limit, time.Now())
+}
+
+// ConsumeQuota charges n ingested readings to subject's daily quota, all or
+// nothing.
+func (s *Service) ConsumeQuota(ctx context.Context, subject string, n int64) (QuotaResult, error) {
+	if s.dailyQuota <= 0 {
+		return QuotaResult{Allowed: true}, nil
+	}
+
+	now := time.Now()
+	used, ok, err := s.repo.AddUsage(ctx, quotaKey(subject), QuotaDay(now), n, s.dailyQuota)
+	if err != nil {
+		return QuotaResult{}, err
+	}
+
+	return s.quotaResult(ok, used, now), nil
+}
+
+// CheckQuota reports whether subject has any quota left without charging
+// it, for uploads whose size is only known once they have been read.
+func (s *Service) CheckQuota(ctx context.Context, subject string) (QuotaResult, error) {
+	if s.dailyQuota <= 0 {
+		return QuotaResult{Allowed: true}, nil
+	}
+
+	now := time.Now()
+	used, _, err := s.repo.AddUsage(ctx, quotaKey(subject), QuotaDay(now), 0, -1)
+	if err != nil {
+		return QuotaResult{}, err
+	}
+
+	return s.quotaResult(used < s.dailyQuota, used, now), nil
+}
+
+// ChargeQuota records n readings that were already ingested, even past the
+// quota, so the next request is refused instead.
+func (s *Service) ChargeQuota(ctx context.Context, subject string, n int64) error {
+	if s.dailyQuota <= 0 || n == 0 {
+		return nil
+	}
+
+	_, _, err := s.repo.AddUsage(ctx, quotaKey(subject), QuotaDay(time.Now()), n, -1)
+	return err
+}
+
+func (s *Service) PurgeExpired(ctx context.Context) (int64, error) {
+	return s.repo.DeleteExpired(ctx, time.Now())
+}
+
+func (s *Service) quotaResult(allowed bool, used int64, now time.Time) QuotaResult {
+	return QuotaResult{
+		Allowed:   allowed,
+		Limit:     s.dailyQuota,
+		Remaining: max(s.dailyQuota-used, 0),
+		Reset:     QuotaDay(now).AddDate(0, 0, 1).Sub(now),
+	}
+}
+
+func quotaKey(subject string) string {
+	return "ingest:" + subject
+}
@@ -0,0 +1,138 @@
+package storage
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/raphico/go-device-telemetry-api/internal/export"
+)
+
+// LocalStore keeps artifacts on the local filesystem and serves them back
+// through the API using HMAC-signed, expiring URLs.
+type LocalStore struct {
+	dir     string
+	baseURL string
+	secret  []byte
+}
+
+func NewLocalStore(dir string, baseURL string, secret []byte) (*LocalStore, error) {
+	if err := os.MkdirAll(dir, 0o750); err != nil {
+		return nil, fmt.Errorf("failed to create artifact directory: %w", err)
+	}
+
+	return &LocalStore{
+		dir:     dir,
+		baseURL: strings.TrimSuffix(baseURL, "/"),
+		secret:  secret,
+	}, nil
+}
+
+func (s *LocalStore) Put(ctx context.Context, key string, r io.Reader, size int64, contentType string) error {
+	path, err := s.path(key)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0o750); err != nil {
+		return fmt.Errorf("failed to create artifact directory: %w", err)
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".upload-*")
+	if err != nil {
+		return fmt.Errorf("failed to create artifact: %w", err)
+	}
+	defer func() { _ = os.Remove(tmp.Name()) }()
+
+	if _, err := io.Copy(tmp, r); err != nil {
+		_ = tmp.Close()
+		return fmt.Errorf("failed to write artifact: %w", err)
+	}
+
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write artifact: %w", err)
+	}
+
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("failed to store artifact: %w", err)
+	}
+
+	return nil
+}
+
+func (s *LocalStore) SignedURL(ctx context.Context, key string, filename string, ttl time.Duration) (string, error) {
+	expires := time.Now().Add(ttl).Unix()
+
+	q := url.Values{}
+	q.Set("filename", filename)
+	q.Set("expires", strconv.FormatInt(expires, 10))
+	q.Set("signature", s.sign(key, filename, expires))
+
+	return s.baseURL + "/api/v1/exports/artifacts/" + key + "?" + q.Encode(), nil
+}
+
+func (s *LocalStore) Delete(ctx context.Context, key string) error {
+	path, err := s.path(key)
+	if err != nil {
+		return err
+	}
+
+	if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("failed to delete artifact: %w", err)
+	}
+
+	return nil
+}
+
+func (s *LocalStore) Open(key string, filename string, expires int64, signature string) (io.ReadSeekCloser, error) {
+	if time.Now().Unix() > expires {
+		return nil, export.ErrInvalidSignature
+	}
+
+	expected := s.sign(key, filename, expires)
+	if !hmac.Equal([]byte(expected), []byte(signature)) {
+		return nil, export.ErrInvalidSignature
+	}
+
+	path, err := s.path(key)
+	if err != nil {
+		return nil, err
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, export.ErrArtifactNotFound
+		}
+		return nil, fmt.Errorf("failed to open artifact: %w", err)
+	}
+
+	return f, nil
+}
+
+func (s *LocalStore) sign(key string, filename string, expires int64) string {
+	mac := hmac.New(sha256.New, s.secret)
+	mac.Write([]byte(key + "\n" + filename + "\n" + strconv.FormatInt(expires, 10)))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// path resolves key inside the store directory, refusing keys that would
+// escape it.
+func (s *LocalStore) path(key string) (string, error) {
+	clean := filepath.Clean(filepath.FromSlash(key))
+	if clean == "." || filepath.IsAbs(clean) || strings.HasPrefix(clean, "..") {
+		return "", export.ErrArtifactNotFound
+	}
+
+	return filepath.Join(s.dir, clean), nil
+}
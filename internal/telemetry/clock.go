@@ -0,0 +1,101 @@
+package telemetry
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/raphico/go-device-telemetry-api/internal/device"
+)
+
+var (
+	// ClockSkewReject refuses readings stamped further in the future than the
+	// tolerance.
+	ClockSkewReject = ClockSkewPolicy{value: "reject"}
+	// ClockSkewCorrect shifts readings of skewed devices back onto server
+	// time, keeping the original timestamp alongside.
+	ClockSkewCorrect = ClockSkewPolicy{value: "correct"}
+	// ClockSkewFlag stores readings as sent and marks those from skewed
+	// devices.
+	ClockSkewFlag = ClockSkewPolicy{value: "flag"}
+)
+
+// ---------- Types ----------
+
+type ClockSkewPolicy struct {
+	value string
+}
+
+// ClockSkewConfig controls how ingestion treats device clocks that are off
+// by more than Tolerance. Readings recorded more than MaxSampleAge before
+// they arrive were buffered rather than skewed, and leave the estimate
+// alone.
+type ClockSkewConfig struct {
+	Policy       ClockSkewPolicy
+	Tolerance    time.Duration
+	MaxSampleAge time.Duration
+}
+
+// ClockSkewEstimator keeps a running per-device estimate of clock offset,
+// updating dev.ClockSkew as it goes.
+type ClockSkewEstimator interface {
+	ObserveClockSkew(ctx context.Context, dev *device.Device, sample time.Duration) (time.Duration, error)
+}
+
+// ---------- ClockSkewPolicy ----------
+
+func NewClockSkewPolicy(value string) (ClockSkewPolicy, error) {
+	switch value {
+	case ClockSkewReject.value:
+		return ClockSkewReject, nil
+	case ClockSkewCorrect.value:
+		return ClockSkewCorrect, nil
+	case ClockSkewFlag.value:
+		return ClockSkewFlag, nil
+	default:
+		return ClockSkewPolicy{}, fmt.Errorf("invalid clock skew policy: %s", value)
+	}
+}
+
+func (p ClockSkewPolicy) String() string {
+	return p.value
+}
+
+// ---------- ClockSkewConfig ----------
+
+// IsBacklog reports whether sample, a recorded_at - received_at gap, is
+// too old to say anything about the device clock.
+func (c ClockSkewConfig) IsBacklog(sample time.Duration) bool {
+	return c.MaxSampleAge > 0 && sample < -c.MaxSampleAge
+}
+
+// Apply adjusts t, received at receivedAt from a device whose clock is
+// estimated to be off by skew.
+func (c ClockSkewConfig) Apply(t *Telemetry, skew time.Duration, receivedAt time.Time) error {
+	recordedAt := t.RecordedAt.Time()
+	ahead := recordedAt.Sub(receivedAt) > c.Tolerance
+	skewed := skew > c.Tolerance || skew < -c.Tolerance
+
+	switch c.Policy {
+	case ClockSkewCorrect:
+		if !skewed {
+			break
+		}
+
+		corrected := recordedAt.Add(-skew)
+		if corrected.After(receivedAt) {
+			corrected = receivedAt
+		}
+
+		t.DeviceRecordedAt = &recordedAt
+		t.RecordedAt = RecordedAt{value: corrected}
+	case ClockSkewFlag:
+		t.ClockSkewFlagged = skewed || ahead
+	default:
+		if ahead {
+			return ErrRecordedAtInFuture
+		}
+	}
+
+	return nil
+}
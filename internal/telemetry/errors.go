@@ -0,0 +1,11 @@
+package telemetry
+
+import "errors"
+
+var (
+	ErrInvalidImport      = errors.New("invalid import file")
+	ErrTelemetryNotFound  = errors.New("telemetry not found")
+	ErrDuplicateMessageID = errors.New("message id already used")
+	ErrRecordedAtInFuture = errors.New("telemetry recorded_at cannot be in the future")
+	ErrNoRollupTiers      = errors.New("no rollup tiers configured")
+)
@@ -0,0 +1,220 @@
+package telemetry
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/raphico/go-device-telemetry-api/internal/device"
+	"github.com/raphico/go-device-telemetry-api/internal/user"
+)
+
+var (
+	FileFormatCSV    = FileFormat{value: "csv", contentType: "text/csv"}
+	FileFormatNDJSON = FileFormat{value: "ndjson", contentType: "application/x-ndjson"}
+
+	// csvBaseColumns precede the flattened payload keys in CSV exports.
+	csvBaseColumns = []string{"id", "device_id", "telemetry_type", "recorded_at", "created_at"}
+)
+
+// ---------- Types ----------
+
+type FileFormat struct {
+	value       string
+	contentType string
+}
+
+// ExportFilter scopes an export to the devices of every organization UserID
+// belongs to, optionally narrowed to a set of devices and a recorded_at
+// range.
+type ExportFilter struct {
+	UserID    user.UserID
+	DeviceIDs []device.DeviceID
+	TimeRange *TimeRange
+}
+
+// ExportWriter serialises telemetry rows one at a time so exports never hold
+// more than a single row in memory.
+type ExportWriter interface {
+	Write(t *Telemetry) error
+	Flush() error
+}
+
+type csvExportWriter struct {
+	w       *csv.Writer
+	columns []string
+	record  []string
+	header  bool
+}
+
+type ndjsonExportWriter struct {
+	enc *json.Encoder
+}
+
+type exportRow struct {
+	ID            string    `json:"id"`
+	DeviceID      string    `json:"device_id"`
+	TelemetryType string    `json:"telemetry_type"`
+	Payload       Payload   `json:"payload"`
+	RecordedAt    time.Time `json:"recorded_at"`
+	CreatedAt     time.Time `json:"created_at"`
+}
+
+// ---------- FileFormat ----------
+
+func NewFileFormat(value string) (FileFormat, error) {
+	switch value {
+	case FileFormatCSV.value:
+		return FileFormatCSV, nil
+	case FileFormatNDJSON.value:
+		return FileFormatNDJSON, nil
+	default:
+		return FileFormat{}, fmt.Errorf("invalid file format: %s", value)
+	}
+}
+
+// FileFormatFromMediaType maps a Content-Type or Accept media type to a file
+// format.
+func FileFormatFromMediaType(mediaType string) (FileFormat, bool) {
+	switch mediaType {
+	case "text/csv":
+		return FileFormatCSV, true
+	case "application/x-ndjson", "application/ndjson", "application/jsonl":
+		return FileFormatNDJSON, true
+	default:
+		return FileFormat{}, false
+	}
+}
+
+func (f FileFormat) String() string {
+	return f.value
+}
+
+func (f FileFormat) ContentType() string {
+	return f.contentType
+}
+
+func (f FileFormat) Extension() string {
+	return f.value
+}
+
+// ---------- Payload ----------
+
+// Flatten returns the payload with nested objects collapsed into dotted
+// keys, e.g. {"gps": {"lat": 1}} becomes {"gps.lat": 1}.
+func (p Payload) Flatten() map[string]any {
+	out := make(map[string]any, len(p))
+	flattenInto(out, "", p)
+	return out
+}
+
+func flattenInto(out map[string]any, prefix string, m map[string]any) {
+	for k, v := range m {
+		key := k
+		if prefix != "" {
+			key = prefix + "." + k
+		}
+
+		if nested, ok := v.(map[string]any); ok {
+			flattenInto(out, key, nested)
+			continue
+		}
+
+		out[key] = v
+	}
+}
+
+// ---------- ExportWriter ----------
+
+func NewExportWriter(format FileFormat, w io.Writer, payloadKeys []string) ExportWriter {
+	if format == FileFormatNDJSON {
+		return &ndjsonExportWriter{enc: json.NewEncoder(w)}
+	}
+
+	keys := append([]string(nil), payloadKeys...)
+	sort.Strings(keys)
+
+	return &csvExportWriter{
+		w:       csv.NewWriter(w),
+		columns: keys,
+		record:  make([]string, len(csvBaseColumns)+len(keys)),
+	}
+}
+
+func (c *csvExportWriter) writeHeader() error {
+	if c.header {
+		return nil
+	}
+
+	c.header = true
+	header := append(append([]string(nil), csvBaseColumns...), c.columns...)
+	return c.w.Write(header)
+}
+
+func (c *csvExportWriter) Write(t *Telemetry) error {
+	if err := c.writeHeader(); err != nil {
+		return err
+	}
+
+	c.record[0] = t.ID.String()
+	c.record[1] = t.DeviceID.String()
+	c.record[2] = t.TelemetryType.String()
+	c.record[3] = t.RecordedAt.Time().Format(time.RFC3339Nano)
+	c.record[4] = t.CreatedAt.UTC().Format(time.RFC3339Nano)
+
+	flat := t.Payload.Flatten()
+	for i, key := range c.columns {
+		c.record[len(csvBaseColumns)+i] = formatCSVValue(flat[key])
+	}
+
+	return c.w.Write(c.record)
+}
+
+func (c *csvExportWriter) Flush() error {
+	if err := c.writeHeader(); err != nil {
+		return err
+	}
+
+	c.w.Flush()
+	return c.w.Error()
+}
+
+func (n *ndjsonExportWriter) Write(t *Telemetry) error {
+	return n.enc.Encode(exportRow{
+		ID:            t.ID.String(),
+		DeviceID:      t.DeviceID.String(),
+		TelemetryType: t.TelemetryType.String(),
+		Payload:       t.Payload,
+		RecordedAt:    t.RecordedAt.Time(),
+		CreatedAt:     t.CreatedAt.UTC(),
+	})
+}
+
+func (n *ndjsonExportWriter) Flush() error {
+	return nil
+}
+
+func formatCSVValue(v any) string {
+	switch val := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return val
+	case bool:
+		return strconv.FormatBool(val)
+	case float64:
+		return strconv.FormatFloat(val, 'f', -1, 64)
+	case json.Number:
+		return val.String()
+	default:
+		b, err := json.Marshal(val)
+		if err != nil {
+			return fmt.Sprint(val)
+		}
+		return string(b)
+	}
+}
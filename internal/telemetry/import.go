@@ -0,0 +1,300 @@
+package telemetry
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/raphico/go-device-telemetry-api/internal/device"
+)
+
+const (
+	// ImportBatchSize is how many validated rows are loaded per COPY.
+	ImportBatchSize = 5000
+	// MaxImportErrors caps how many rejected lines the report lists; the
+	// rejected count keeps going past it.
+	MaxImportErrors = 1000
+	// maxImportLineBytes bounds a single NDJSON line.
+	maxImportLineBytes = 1 << 20
+)
+
+// csvIgnoredColumns are produced by exports but ignored on import, so an
+// exported file can be loaded into another device as-is.
+var csvIgnoredColumns = map[string]bool{"id": true, "device_id": true, "created_at": true}
+
+// ---------- Types ----------
+
+// ImportReader yields validated telemetry from an uploaded file. Rows that
+// fail validation come back as *ImportLineError so the caller can keep
+// reading.
+type ImportReader interface {
+	Next() (*Telemetry, error)
+}
+
+type ImportLineError struct {
+	Line int
+	Err  error
+}
+
+type ImportReport struct {
+	Accepted   int64
+	Duplicates int64
+	Rejected   int64
+	Errors     []ImportLineError
+	Truncated  bool
+}
+
+type csvImportReader struct {
+	r        *csv.Reader
+	deviceID device.DeviceID
+	header   []string
+	line     int
+}
+
+type ndjsonImportReader struct {
+	s        *bufio.Scanner
+	deviceID device.DeviceID
+	line     int
+}
+
+type importRow struct {
+	TelemetryType string `json:"telemetry_type"`
+	Payload       any    `json:"payload"`
+	RecordedAt    string `json:"recorded_at"`
+}
+
+// ---------- ImportLineError ----------
+
+func (e *ImportLineError) Error() string {
+	return fmt.Sprintf("line %d: %v", e.Line, e.Err)
+}
+
+func (e *ImportLineError) Unwrap() error {
+	return e.Err
+}
+
+// ---------- ImportReport ----------
+
+func (r *ImportReport) Reject(lineErr *ImportLineError) {
+	r.Rejected++
+
+	if len(r.Errors) >= MaxImportErrors {
+		r.Truncated = true
+		return
+	}
+
+	r.Errors = append(r.Errors, *lineErr)
+}
+
+// ---------- ImportReader ----------
+
+func NewImportReader(format FileFormat, r io.Reader, deviceID device.DeviceID) ImportReader {
+	if format == FileFormatNDJSON {
+		s := bufio.NewScanner(r)
+		s.Buffer(make([]byte, 0, 64*1024), maxImportLineBytes)
+		return &ndjsonImportReader{s: s, deviceID: deviceID}
+	}
+
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = -1
+	cr.ReuseRecord = true
+
+	return &csvImportReader{r: cr, deviceID: deviceID}
+}
+
+func (c *csvImportReader) Next() (*Telemetry, error) {
+	if c.header == nil {
+		header, err := c.r.Read()
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				return nil, io.EOF
+			}
+			return nil, fmt.Errorf("invalid csv header: %w", err)
+		}
+
+		c.line++
+		c.header = make([]string, len(header))
+		for i, h := range header {
+			c.header[i] = strings.TrimSpace(h)
+		}
+
+		if !slices.Contains(c.header, "telemetry_type") || !slices.Contains(c.header, "recorded_at") {
+			return nil, errors.New("csv header must include telemetry_type and recorded_at")
+		}
+	}
+
+	record, err := c.r.Read()
+	if err != nil {
+		if errors.Is(err, io.EOF) {
+			return nil, io.EOF
+		}
+
+		var parseErr *csv.ParseError
+		if errors.As(err, &parseErr) {
+			return nil, &ImportLineError{Line: parseErr.StartLine, Err: parseErr.Err}
+		}
+
+		return nil, err
+	}
+
+	c.line, _ = c.r.FieldPos(0)
+
+	if len(record) != len(c.header) {
+		return nil, &ImportLineError{
+			Line: c.line,
+			Err:  fmt.Errorf("expected %d columns, got %d", len(c.header), len(record)),
+		}
+	}
+
+	var (
+		telemetryType string
+		recordedAt    string
+		payload       = map[string]any{}
+	)
+
+	for i, column := range c.header {
+		value := record[i]
+
+		switch {
+		case column == "telemetry_type":
+			telemetryType = value
+		case column == "recorded_at":
+			recordedAt = value
+		case column == "payload":
+			if strings.TrimSpace(value) == "" {
+				continue
+			}
+			var obj map[string]any
+			if err := json.Unmarshal([]byte(value), &obj); err != nil {
+				return nil, &ImportLineError{Line: c.line, Err: errors.New("payload column must be a JSON object")}
+			}
+			for k, v := range obj {
+				payload[k] = v
+			}
+		case csvIgnoredColumns[column]:
+		case value == "":
+		default:
+			payload[column] = parseCSVValue(value)
+		}
+	}
+
+	t, err := buildImportedTelemetry(c.deviceID, telemetryType, Unflatten(payload), recordedAt)
+	if err != nil {
+		return nil, &ImportLineError{Line: c.line, Err: err}
+	}
+
+	return t, nil
+}
+
+func (n *ndjsonImportReader) Next() (*Telemetry, error) {
+	for n.s.Scan() {
+		n.line++
+
+		line := bytes.TrimSpace(n.s.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+
+		var row importRow
+		if err := json.Unmarshal(line, &row); err != nil {
+			return nil, &ImportLineError{Line: n.line, Err: errors.New("invalid JSON")}
+		}
+
+		t, err := buildImportedTelemetry(n.deviceID, row.TelemetryType, row.Payload, row.RecordedAt)
+		if err != nil {
+			return nil, &ImportLineError{Line: n.line, Err: err}
+		}
+
+		return t, nil
+	}
+
+	if err := n.s.Err(); err != nil {
+		if errors.Is(err, bufio.ErrTooLong) {
+			return nil, fmt.Errorf("line %d exceeds %d bytes", n.line+1, maxImportLineBytes)
+		}
+		return nil, err
+	}
+
+	return nil, io.EOF
+}
+
+// buildImportedTelemetry runs an imported row through the same value objects
+// as the create endpoint.
+func buildImportedTelemetry(
+	deviceID device.DeviceID,
+	rawType string,
+	rawPayload any,
+	rawRecordedAt string,
+) (*Telemetry, error) {
+	telemetryType, err := NewTelemetryType(rawType)
+	if err != nil {
+		return nil, err
+	}
+
+	payload, err := NewPayload(rawPayload)
+	if err != nil {
+		return nil, err
+	}
+
+	recordedAt, err := NewRecordedAt(rawRecordedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	return NewTelemetry(deviceID, telemetryType, payload, recordedAt), nil
+}
+
+// ---------- Payload ----------
+
+// Unflatten reverses Flatten, turning dotted keys back into nested objects.
+func Unflatten(flat map[string]any) map[string]any {
+	out := make(map[string]any, len(flat))
+
+	for key, value := range flat {
+		parts := strings.Split(key, ".")
+		node := out
+
+		for _, part := range parts[:len(parts)-1] {
+			next, ok := node[part].(map[string]any)
+			if !ok {
+				next = map[string]any{}
+				node[part] = next
+			}
+			node = next
+		}
+
+		node[parts[len(parts)-1]] = value
+	}
+
+	return out
+}
+
+// parseCSVValue recovers the JSON type of a CSV cell written by an export.
+func parseCSVValue(v string) any {
+	switch v {
+	case "true":
+		return true
+	case "false":
+		return false
+	}
+
+	if f, err := strconv.ParseFloat(v, 64); err == nil {
+		return f
+	}
+
+	if strings.HasPrefix(v, "{") || strings.HasPrefix(v, "[") {
+		var decoded any
+		if err := json.Unmarshal([]byte(v), &decoded); err == nil {
+			return decoded
+		}
+	}
+
+	return v
+}
@@ -8,11 +8,39 @@ import (
 )
 
 type Repository interface {
+	// Create returns ErrDuplicateMessageID when t carries a message ID the
+	// device has already used.
 	Create(ctx context.Context, t *Telemetry) error
+	// CreateBatch stores readings in one transaction. A reading carrying a
+	// message ID the device has already used, earlier in the batch
+	// included, is not stored; its result is the original reading.
+	CreateBatch(ctx context.Context, readings []*Telemetry) ([]ReadingResult, error)
+	FindByMessageID(ctx context.Context, deviceID device.DeviceID, messageID MessageID) (*Telemetry, error)
 	FindTelemetry(
 		ctx context.Context,
 		deviceID device.DeviceID,
 		limit int,
-		cursor *pagination.Cursor,
-	) ([]*Telemetry, *pagination.Cursor, error)
+		cursor *pagination.RecordedAtCursor,
+	) ([]*Telemetry, *pagination.RecordedAtCursor, error)
+	FindRollups(
+		ctx context.Context,
+		deviceID device.DeviceID,
+		resolution Resolution,
+		field *RollupField,
+		timeRange TimeRange,
+	) ([]*Rollup, error)
+	CountTelemetry(ctx context.Context, filter ExportFilter) (int64, error)
+	StreamTelemetry(ctx context.Context, filter ExportFilter, fn func(*Telemetry) error) error
+	// StreamTelemetryWithKeys is StreamTelemetry that first hands start the
+	// sorted payload keys of the matching rows, read from the same snapshot
+	// as the rows themselves.
+	StreamTelemetryWithKeys(
+		ctx context.Context,
+		filter ExportFilter,
+		start func(keys []string) error,
+		fn func(*Telemetry) error,
+	) error
+	// ImportBatch loads rows for one device, skipping rows identical to ones
+	// already stored, and returns how many were inserted.
+	ImportBatch(ctx context.Context, deviceID device.DeviceID, rows []*Telemetry) (int64, error)
 }
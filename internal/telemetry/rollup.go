@@ -0,0 +1,212 @@
+package telemetry
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/raphico/go-device-telemetry-api/internal/device"
+)
+
+// MinRollupPoints is how many buckets a chosen resolution must split the
+// range into, so a short range is not flattened into a single bucket.
+const MinRollupPoints = 24
+
+var (
+	ResolutionMinute = Resolution{value: "1m", width: time.Minute}
+	ResolutionHour   = Resolution{value: "1h", width: time.Hour}
+	ResolutionDay    = Resolution{value: "1d", width: 24 * time.Hour}
+
+	// Resolutions lists the rollup tiers from finest to coarsest.
+	Resolutions = []Resolution{ResolutionMinute, ResolutionHour, ResolutionDay}
+)
+
+// ---------- Types ----------
+
+type Resolution struct {
+	value string
+	width time.Duration
+}
+
+type RollupField struct {
+	value string
+}
+
+type TimeRange struct {
+	from time.Time
+	to   time.Time
+}
+
+// RollupTier describes one rollup table and how long its buckets are kept.
+// A zero Retention keeps buckets forever.
+type RollupTier struct {
+	Resolution Resolution
+	Retention  time.Duration
+}
+
+type Rollup struct {
+	DeviceID      device.DeviceID
+	TelemetryType TelemetryType
+	Field         RollupField
+	Resolution    Resolution
+	Bucket        time.Time
+	Count         int64
+	Sum           float64
+	Min           float64
+	Max           float64
+}
+
+// ---------- Resolution ----------
+
+func NewResolution(value string) (Resolution, error) {
+	for _, r := range Resolutions {
+		if r.value == value {
+			return r, nil
+		}
+	}
+
+	return Resolution{}, fmt.Errorf("invalid resolution: %s", value)
+}
+
+func (r Resolution) String() string {
+	return r.value
+}
+
+func (r Resolution) Width() time.Duration {
+	return r.width
+}
+
+// ---------- RollupField ----------
+
+func NewRollupField(value string) (RollupField, error) {
+	value = strings.TrimSpace(value)
+
+	if value == "" {
+		return RollupField{}, errors.New("rollup field is required")
+	}
+
+	if len(value) > 100 {
+		return RollupField{}, errors.New("rollup field must be at most 100 characters")
+	}
+
+	return RollupField{value: value}, nil
+}
+
+func (f RollupField) String() string {
+	return f.value
+}
+
+// ---------- TimeRange ----------
+
+func NewTimeRange(from, to time.Time) (TimeRange, error) {
+	if from.IsZero() || to.IsZero() {
+		return TimeRange{}, errors.New("time range requires both from and to")
+	}
+
+	if !from.Before(to) {
+		return TimeRange{}, errors.New("time range from must be before to")
+	}
+
+	return TimeRange{from: from.UTC(), to: to.UTC()}, nil
+}
+
+func (t TimeRange) From() time.Time {
+	return t.from
+}
+
+func (t TimeRange) To() time.Time {
+	return t.to
+}
+
+func (t TimeRange) Duration() time.Duration {
+	return t.to.Sub(t.from)
+}
+
+// ---------- RollupTier ----------
+
+// Covers reports whether the tier still holds buckets for the whole range.
+func (t RollupTier) Covers(r TimeRange, now time.Time) bool {
+	if t.Retention <= 0 {
+		return true
+	}
+
+	return !r.From().Before(now.Add(-t.Retention))
+}
+
+// SelectResolution picks the coarsest tier that retains the whole range and
+// still splits it into at least MinRollupPoints buckets, so long ranges read
+// as few rows as possible. When every covering tier is too coarse it falls
+// back to the finest one that covers the range, and when none covers it to
+// the coarsest tier. tiers are ordered finest first; without any it fails
+// with ErrNoRollupTiers.
+func SelectResolution(tiers []RollupTier, r TimeRange, now time.Time) (Resolution, error) {
+	if len(tiers) == 0 {
+		return Resolution{}, ErrNoRollupTiers
+	}
+
+	var finest *RollupTier
+
+	for i := len(tiers) - 1; i >= 0; i-- {
+		tier := tiers[i]
+		if !tier.Covers(r, now) {
+			continue
+		}
+
+		if int64(r.Duration()/tier.Resolution.Width()) >= MinRollupPoints {
+			return tier.Resolution, nil
+		}
+
+		finest = &tiers[i]
+	}
+
+	if finest != nil {
+		return finest.Resolution, nil
+	}
+
+	return tiers[len(tiers)-1].Resolution, nil
+}
+
+// ---------- Rollup ----------
+
+func (r *Rollup) Mean() float64 {
+	if r.Count == 0 {
+		return 0
+	}
+
+	return r.Sum / float64(r.Count)
+}
+
+// ---------- Rehydration ----------
+
+func RehydrateRollup(
+	deviceID device.DeviceID,
+	telemetryType string,
+	field string,
+	resolution Resolution,
+	bucket time.Time,
+	count int64,
+	sum, minValue, maxValue float64,
+) (*Rollup, error) {
+	t, err := NewTelemetryType(telemetryType)
+	if err != nil {
+		return nil, fmt.Errorf("corrupt telemetry type: %w", err)
+	}
+
+	f, err := NewRollupField(field)
+	if err != nil {
+		return nil, fmt.Errorf("corrupt rollup field: %w", err)
+	}
+
+	return &Rollup{
+		DeviceID:      deviceID,
+		TelemetryType: t,
+		Field:         f,
+		Resolution:    resolution,
+		Bucket:        bucket,
+		Count:         count,
+		Sum:           sum,
+		Min:           minValue,
+		Max:           maxValue,
+	}, nil
+}
@@ -2,30 +2,171 @@ package telemetry
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"io"
+	"time"
 
+	"github.com/raphico/go-device-telemetry-api/internal/authz"
 	"github.com/raphico/go-device-telemetry-api/internal/common/pagination"
 	"github.com/raphico/go-device-telemetry-api/internal/device"
 )
 
+// DeviceAuthorizer checks an actor's permission on a device's organization,
+// failing with device.ErrDeviceNotFound or authz.ErrForbidden.
+type DeviceAuthorizer interface {
+	AuthorizeDevice(
+		ctx context.Context,
+		actor authz.Actor,
+		id device.DeviceID,
+		perm authz.Permission,
+	) (*device.Device, error)
+}
+
 type Service struct {
-	repo Repository
+	repo        Repository
+	devices     DeviceAuthorizer
+	rollupTiers []RollupTier
+	clock       ClockSkewEstimator
+	clockSkew   ClockSkewConfig
 }
 
-func NewService(repo Repository) *Service {
-	return &Service{repo: repo}
+func NewService(
+	repo Repository,
+	devices DeviceAuthorizer,
+	rollupTiers []RollupTier,
+	clock ClockSkewEstimator,
+	clockSkew ClockSkewConfig,
+) *Service {
+	return &Service{
+		repo:        repo,
+		devices:     devices,
+		rollupTiers: rollupTiers,
+		clock:       clock,
+		clockSkew:   clockSkew,
+	}
 }
 
+// CreateTelemetry stores a reading. The gap between recordedAt and now feeds
+// the device's clock skew estimate, and the configured policy decides
+// whether a skewed timestamp is rejected, corrected or flagged. When
+// messageID was already used by the device, the original reading is
+// returned instead and replayed is true.
 func (s *Service) CreateTelemetry(
 	ctx context.Context,
+	actor authz.Actor,
 	deviceID device.DeviceID,
 	telemetryType TelemetryType,
 	payload Payload,
 	recordedAt RecordedAt,
-) (*Telemetry, error) {
-	telemetry := NewTelemetry(deviceID, telemetryType, payload, recordedAt)
+	messageID *MessageID,
+) (t *Telemetry, replayed bool, err error) {
+	dev, err := s.devices.AuthorizeDevice(ctx, actor, deviceID, authz.PermTelemetryWrite)
+	if err != nil {
+		return nil, false, err
+	}
+
+	return s.createTelemetry(ctx, dev, telemetryType, payload, recordedAt, messageID)
+}
+
+// Reading is one entry of a telemetry batch.
+type Reading struct {
+	TelemetryType TelemetryType
+	Payload       Payload
+	RecordedAt    RecordedAt
+	MessageID     *MessageID
+}
+
+// ReadingResult is the stored or, for a reused message id, replayed reading
+// for one batch entry.
+type ReadingResult struct {
+	Telemetry *Telemetry
+	Replayed  bool
+}
+
+// CreateTelemetryBatch authorizes the actor once and then stores readings as
+// CreateTelemetry would, in one transaction: the first failure stores
+// none of them.
+func (s *Service) CreateTelemetryBatch(
+	ctx context.Context,
+	actor authz.Actor,
+	deviceID device.DeviceID,
+	readings []Reading,
+) ([]ReadingResult, error) {
+	dev, err := s.devices.AuthorizeDevice(ctx, actor, deviceID, authz.PermTelemetryWrite)
+	if err != nil {
+		return nil, err
+	}
+
+	batch := make([]*Telemetry, 0, len(readings))
+	for i, r := range readings {
+		t, err := s.prepareTelemetry(ctx, dev, r.TelemetryType, r.Payload, r.RecordedAt, r.MessageID)
+		if err != nil {
+			return nil, fmt.Errorf("readings[%d]: %w", i, err)
+		}
+		batch = append(batch, t)
+	}
+
+	return s.repo.CreateBatch(ctx, batch)
+}
+
+func (s *Service) createTelemetry(
+	ctx context.Context,
+	dev *device.Device,
+	telemetryType TelemetryType,
+	payload Payload,
+	recordedAt RecordedAt,
+	messageID *MessageID,
+) (*Telemetry, bool, error) {
+	telemetry, err := s.prepareTelemetry(ctx, dev, telemetryType, payload, recordedAt, messageID)
+	if err != nil {
+		return nil, false, err
+	}
+
+	err = s.repo.Create(ctx, telemetry)
+	if errors.Is(err, ErrDuplicateMessageID) {
+		original, err := s.repo.FindByMessageID(ctx, dev.ID, *messageID)
+		if err != nil {
+			return nil, false, err
+		}
+		return original, true, nil
+	}
 
-	err := s.repo.Create(ctx, telemetry)
 	if err != nil {
+		return nil, false, err
+	}
+
+	return telemetry, false, nil
+}
+
+// prepareTelemetry builds a reading of dev with the clock skew policy
+// applied, keeping dev's skew estimate up to date so a batch can reuse it.
+func (s *Service) prepareTelemetry(
+	ctx context.Context,
+	dev *device.Device,
+	telemetryType TelemetryType,
+	payload Payload,
+	recordedAt RecordedAt,
+	messageID *MessageID,
+) (*Telemetry, error) {
+	receivedAt := time.Now().UTC()
+	sample := recordedAt.Time().Sub(receivedAt)
+
+	var skew time.Duration
+	switch {
+	case !s.clockSkew.IsBacklog(sample):
+		var err error
+		if skew, err = s.clock.ObserveClockSkew(ctx, dev, sample); err != nil {
+			return nil, err
+		}
+	case dev.ClockSkew != nil:
+		skew = dev.ClockSkew.Offset
+	}
+
+	telemetry := NewTelemetry(dev.ID, telemetryType, payload, recordedAt)
+	telemetry.MessageID = messageID
+
+	if err := s.clockSkew.Apply(telemetry, skew, receivedAt); err != nil {
 		return nil, err
 	}
 
@@ -34,9 +175,206 @@ func (s *Service) CreateTelemetry(
 
 func (s *Service) ListDeviceTelemetry(
 	ctx context.Context,
+	actor authz.Actor,
 	deviceID device.DeviceID,
 	limit int,
-	cursor *pagination.Cursor,
-) ([]*Telemetry, *pagination.Cursor, error) {
+	cursor *pagination.RecordedAtCursor,
+) ([]*Telemetry, *pagination.RecordedAtCursor, error) {
+	if _, err := s.devices.AuthorizeDevice(ctx, actor, deviceID, authz.PermTelemetryRead); err != nil {
+		return nil, nil, err
+	}
+
 	return s.repo.FindTelemetry(ctx, deviceID, limit, cursor)
 }
+
+// ListDeviceRollups returns aggregated buckets for the range. When no
+// resolution is requested the tier is chosen with SelectResolution.
+func (s *Service) ListDeviceRollups(
+	ctx context.Context,
+	actor authz.Actor,
+	deviceID device.DeviceID,
+	field *RollupField,
+	timeRange TimeRange,
+	resolution *Resolution,
+) ([]*Rollup, Resolution, error) {
+	if _, err := s.devices.AuthorizeDevice(ctx, actor, deviceID, authz.PermTelemetryRead); err != nil {
+		return nil, Resolution{}, err
+	}
+
+	var res Resolution
+	if resolution != nil {
+		res = *resolution
+	} else {
+		var err error
+		if res, err = SelectResolution(s.rollupTiers, timeRange, time.Now().UTC()); err != nil {
+			return nil, Resolution{}, err
+		}
+	}
+
+	rollups, err := s.repo.FindRollups(ctx, deviceID, res, field, timeRange)
+	if err != nil {
+		return nil, Resolution{}, err
+	}
+
+	return rollups, res, nil
+}
+
+// exportProgressInterval is how many rows pass between progress callbacks.
+const exportProgressInterval = 1000
+
+// ExportTelemetry streams every reading matching the filter to w and returns
+// the number of rows written. progress, when set, is called periodically with
+// the running row count. Nothing is written unless actor may read the
+// telemetry of every device the filter names.
+func (s *Service) ExportTelemetry(
+	ctx context.Context,
+	actor authz.Actor,
+	filter ExportFilter,
+	format FileFormat,
+	w io.Writer,
+	progress func(rows int64),
+) (int64, error) {
+	filter, err := s.authorizeExport(ctx, actor, filter)
+	if err != nil {
+		return 0, err
+	}
+
+	var writer ExportWriter
+	var rows int64
+	write := func(t *Telemetry) error {
+		if err := writer.Write(t); err != nil {
+			return err
+		}
+		rows++
+		if progress != nil && rows%exportProgressInterval == 0 {
+			progress(rows)
+		}
+		return nil
+	}
+
+	// the CSV header needs every payload key up front; reading the keys and
+	// the rows from one snapshot keeps readings stored in between from
+	// carrying keys the header lacks
+	if format == FileFormatCSV {
+		err = s.repo.StreamTelemetryWithKeys(ctx, filter, func(keys []string) error {
+			writer = NewExportWriter(format, w, keys)
+			return nil
+		}, write)
+	} else {
+		writer = NewExportWriter(format, w, nil)
+		err = s.repo.StreamTelemetry(ctx, filter, write)
+	}
+	if err != nil {
+		return rows, err
+	}
+
+	return rows, writer.Flush()
+}
+
+// StreamTelemetry calls fn with every reading matching the filter, one at a
+// time, for callers that serialise telemetry themselves. It is authorized
+// like ExportTelemetry.
+func (s *Service) StreamTelemetry(
+	ctx context.Context,
+	actor authz.Actor,
+	filter ExportFilter,
+	fn func(*Telemetry) error,
+) error {
+	filter, err := s.authorizeExport(ctx, actor, filter)
+	if err != nil {
+		return err
+	}
+
+	return s.repo.StreamTelemetry(ctx, filter, fn)
+}
+
+func (s *Service) CountTelemetry(ctx context.Context, actor authz.Actor, filter ExportFilter) (int64, error) {
+	filter, err := s.authorizeExport(ctx, actor, filter)
+	if err != nil {
+		return 0, err
+	}
+
+	return s.repo.CountTelemetry(ctx, filter)
+}
+
+// authorizeExport checks that actor may read the telemetry of each device
+// the filter names, failing with device.ErrDeviceNotFound or
+// authz.ErrForbidden, and scopes the filter to actor. A filter without
+// devices covers those of actor's organizations, which the query itself
+// restricts to.
+func (s *Service) authorizeExport(ctx context.Context, actor authz.Actor, filter ExportFilter) (ExportFilter, error) {
+	for _, id := range filter.DeviceIDs {
+		if _, err := s.devices.AuthorizeDevice(ctx, actor, id, authz.PermTelemetryRead); err != nil {
+			return ExportFilter{}, err
+		}
+	}
+
+	filter.UserID = actor.UserID
+	return filter, nil
+}
+
+// ImportTelemetry validates every row of an uploaded file and loads the
+// valid ones in batches. Invalid rows are collected in the report instead of
+// aborting the import; only unreadable input or storage failures return an
+// error, in which case earlier batches stay committed.
+func (s *Service) ImportTelemetry(
+	ctx context.Context,
+	actor authz.Actor,
+	deviceID device.DeviceID,
+	format FileFormat,
+	r io.Reader,
+) (*ImportReport, error) {
+	if _, err := s.devices.AuthorizeDevice(ctx, actor, deviceID, authz.PermTelemetryWrite); err != nil {
+		return nil, err
+	}
+
+	report := &ImportReport{}
+	reader := NewImportReader(format, r, deviceID)
+	batch := make([]*Telemetry, 0, ImportBatchSize)
+
+	flush := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+
+		inserted, err := s.repo.ImportBatch(ctx, deviceID, batch)
+		if err != nil {
+			return err
+		}
+
+		report.Accepted += inserted
+		report.Duplicates += int64(len(batch)) - inserted
+		batch = batch[:0]
+		return nil
+	}
+
+	for {
+		t, err := reader.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+
+		var lineErr *ImportLineError
+		if errors.As(err, &lineErr) {
+			report.Reject(lineErr)
+			continue
+		}
+
+		if err != nil {
+			return report, fmt.Errorf("%w: %w", ErrInvalidImport, err)
+		}
+
+		batch = append(batch, t)
+		if len(batch) == ImportBatchSize {
+			if err := flush(); err != nil {
+				return report, err
+			}
+		}
+	}
+
+	if err := flush(); err != nil {
+		return report, err
+	}
+
+	return report, nil
+}
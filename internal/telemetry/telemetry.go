@@ -16,6 +16,15 @@ var (
 	telemetryTypeRegex = regexp.MustCompile(`^[a-zA-Z0-9_-]+$`)
 )
 
+const (
+	// MaxPayloadDepth bounds how deeply objects and arrays may nest inside a
+	// payload; the payload object itself is depth 1.
+	MaxPayloadDepth = 8
+	// MaxPayloadKeys bounds the number of object keys across the whole
+	// payload, nested objects included.
+	MaxPayloadKeys = 256
+)
+
 // ---------- Types ----------
 
 type TelemetryID uuid.UUID
@@ -30,13 +39,27 @@ type RecordedAt struct {
 	value time.Time
 }
 
+// MessageID is a client-chosen identifier that makes telemetry ingestion
+// idempotent: a device may retry a POST with the same ID without creating a
+// second reading.
+type MessageID struct {
+	value string
+}
+
 type Telemetry struct {
 	ID            TelemetryID
 	DeviceID      device.DeviceID
 	TelemetryType TelemetryType
 	Payload       Payload
 	RecordedAt    RecordedAt
+	MessageID     *MessageID
 	CreatedAt     time.Time
+	// DeviceRecordedAt keeps the timestamp the device sent when RecordedAt
+	// was corrected for clock skew.
+	DeviceRecordedAt *time.Time
+	// ClockSkewFlagged marks readings accepted from a device whose clock is
+	// off by more than the tolerance.
+	ClockSkewFlagged bool
 }
 
 // ---------- TelemetryID ----------
@@ -98,14 +121,67 @@ func NewPayload(raw any) (Payload, error) {
 		return Payload{}, errors.New("telemetry payload cannot be empty")
 	}
 
+	keys := 0
+	if err := checkPayloadShape(p, 1, &keys); err != nil {
+		return Payload{}, err
+	}
+
 	return Payload(p), nil
 }
 
+// checkPayloadShape walks the payload enforcing MaxPayloadDepth and
+// MaxPayloadKeys, so a small body cannot expand into a document that is
+// expensive to store, index and flatten.
+func checkPayloadShape(v any, depth int, keys *int) error {
+	switch node := v.(type) {
+	case map[string]any:
+		if depth > MaxPayloadDepth {
+			return fmt.Errorf("telemetry payload cannot nest deeper than %d levels", MaxPayloadDepth)
+		}
+
+		*keys += len(node)
+		if *keys > MaxPayloadKeys {
+			return fmt.Errorf("telemetry payload cannot have more than %d keys", MaxPayloadKeys)
+		}
+
+		for _, child := range node {
+			if err := checkPayloadShape(child, depth+1, keys); err != nil {
+				return err
+			}
+		}
+	case []any:
+		if depth > MaxPayloadDepth {
+			return fmt.Errorf("telemetry payload cannot nest deeper than %d levels", MaxPayloadDepth)
+		}
+
+		for _, child := range node {
+			if err := checkPayloadShape(child, depth+1, keys); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
 // ---------- RecordedAt ----------
 
 func NewRecordedAt(raw string) (RecordedAt, error) {
-	now := time.Now().UTC()
+	r, err := ParseRecordedAt(raw)
+	if err != nil {
+		return RecordedAt{}, err
+	}
 
+	if r.value.After(time.Now().UTC().Add(5 * time.Minute)) { // reject suspicious future values
+		return RecordedAt{}, ErrRecordedAtInFuture
+	}
+
+	return r, nil
+}
+
+// ParseRecordedAt accepts any valid timestamp, leaving future values to the
+// clock skew policy applied at ingestion.
+func ParseRecordedAt(raw string) (RecordedAt, error) {
 	raw = strings.TrimSpace(raw)
 	if raw == "" {
 		return RecordedAt{}, errors.New("telemetry recorded_at is required")
@@ -116,10 +192,6 @@ func NewRecordedAt(raw string) (RecordedAt, error) {
 		return RecordedAt{}, errors.New("invalid recorded_at")
 	}
 
-	if t.After(now.Add(5 * time.Minute)) { // reject suspicious future values
-		return RecordedAt{}, errors.New("telemetry recorded_at cannot be in the future")
-	}
-
 	return RecordedAt{value: t.UTC()}, nil
 }
 
@@ -131,7 +203,7 @@ func RecordedAtFromTime(t time.Time) (RecordedAt, error) {
 	}
 
 	if t.After(now.Add(5 * time.Minute)) { // reject suspicious future values
-		return RecordedAt{}, errors.New("telemetry recorded_at cannot be in the future")
+		return RecordedAt{}, ErrRecordedAtInFuture
 	}
 
 	return RecordedAt{value: t.UTC()}, nil
@@ -141,6 +213,32 @@ func (r RecordedAt) Time() time.Time {
 	return r.value
 }
 
+// ---------- MessageID ----------
+
+func NewMessageID(raw string) (MessageID, error) {
+	raw = strings.TrimSpace(raw)
+
+	if raw == "" {
+		return MessageID{}, errors.New("message id cannot be empty")
+	}
+
+	if len(raw) > 128 {
+		return MessageID{}, errors.New("message id must be at most 128 characters")
+	}
+
+	for _, c := range raw {
+		if c < 0x21 || c > 0x7e {
+			return MessageID{}, errors.New("message id can only contain printable ASCII characters")
+		}
+	}
+
+	return MessageID{value: raw}, nil
+}
+
+func (m MessageID) String() string {
+	return m.value
+}
+
 // ---------- Telemetry ----------
 
 func NewTelemetry(
@@ -166,6 +264,8 @@ func RehydrateTelemetry(
 	payloadBytes []byte,
 	recordedAt time.Time,
 	createdAt time.Time,
+	deviceRecordedAt *time.Time,
+	clockSkewFlagged bool,
 ) (*Telemetry, error) {
 	t, err := NewTelemetryType(telemetryType)
 	if err != nil {
@@ -177,17 +277,20 @@ func RehydrateTelemetry(
 		return nil, fmt.Errorf("corrupt payload: %w", err)
 	}
 
-	r, err := RecordedAtFromTime(recordedAt)
-	if err != nil {
-		return nil, fmt.Errorf("corrupt recorded_at: %w", err)
+	// stored readings may lie in the future when the skew policy flags
+	// rather than rejects them
+	if recordedAt.IsZero() {
+		return nil, errors.New("corrupt recorded_at: zero time")
 	}
 
 	return &Telemetry{
-		ID:            TelemetryID(id),
-		DeviceID:      device.DeviceID(deviceID),
-		TelemetryType: t,
-		Payload:       payload,
-		RecordedAt:    r,
-		CreatedAt:     createdAt,
+		ID:               TelemetryID(id),
+		DeviceID:         device.DeviceID(deviceID),
+		TelemetryType:    t,
+		Payload:          payload,
+		RecordedAt:       RecordedAt{value: recordedAt.UTC()},
+		CreatedAt:        createdAt,
+		DeviceRecordedAt: deviceRecordedAt,
+		ClockSkewFlagged: clockSkewFlagged,
 	}, nil
 }
@@ -0,0 +1,119 @@
+package token
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// denylistRefresh is how often the denylist is reloaded, which bounds how
+// long a revocation made by another instance takes to apply here.
+const denylistRefresh = 5 * time.Second
+
+// Denials are the access tokens, by jti, and the sessions, by refresh token
+// family, that may not be used until the time each maps to.
+type Denials struct {
+	AccessTokens map[uuid.UUID]time.Time
+	Sessions     map[uuid.UUID]time.Time
+}
+
+// denylist keeps the denials in memory so validating an access token does
+// not take a database round trip. Denials made here apply at once.
+type denylist struct {
+	repo Repository
+
+	mu       sync.Mutex
+	denials  *Denials
+	loadedAt time.Time
+	// loading is set while a reload runs, so stale denials are reloaded by
+	// one caller at a time while the others keep using them.
+	loading bool
+}
+
+func newDenylist(repo Repository) *denylist {
+	return &denylist{repo: repo}
+}
+
+// denied reports whether the access token jti, or the session sid it was
+// issued to, is denied.
+func (d *denylist) denied(ctx context.Context, jti uuid.UUID, sid uuid.UUID) (bool, error) {
+	now := time.Now()
+	if err := d.reload(ctx, now); err != nil {
+		return false, err
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if until, ok := d.denials.AccessTokens[jti]; ok && now.Before(until) {
+		return true, nil
+	}
+	if until, ok := d.denials.Sessions[sid]; ok && now.Before(until) {
+		return true, nil
+	}
+
+	return false, nil
+}
+
+// reload reads the denials again when there are none yet or they are older
+// than denylistRefresh. The query runs without the lock, so validations do
+// not queue behind it, and the result is swapped in afterwards.
+func (d *denylist) reload(ctx context.Context, now time.Time) error {
+	d.mu.Lock()
+	if d.denials != nil && (d.loading || now.Sub(d.loadedAt) <= denylistRefresh) {
+		d.mu.Unlock()
+		return nil
+	}
+	d.loading = true
+	d.mu.Unlock()
+
+	denials, err := d.repo.FindDenials(ctx, now)
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.loading = false
+	if err != nil {
+		return err
+	}
+
+	// denials made here during the query may have been stored too late for
+	// it to see them
+	if d.denials != nil {
+		keepDenials(denials.AccessTokens, d.denials.AccessTokens, now)
+		keepDenials(denials.Sessions, d.denials.Sessions, now)
+	}
+	d.denials, d.loadedAt = denials, now
+
+	return nil
+}
+
+// keepDenials copies the entries of from that are still in force at now
+// and missing from into.
+func keepDenials(into map[uuid.UUID]time.Time, from map[uuid.UUID]time.Time, now time.Time) {
+	for id, until := range from {
+		if _, ok := into[id]; !ok && now.Before(until) {
+			into[id] = until
+		}
+	}
+}
+
+func (d *denylist) denyAccessToken(jti uuid.UUID, until time.Time) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.denials != nil {
+		d.denials.AccessTokens[jti] = until
+	}
+}
+
+func (d *denylist) denySession(sid uuid.UUID, until time.Time) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.denials != nil {
+		d.denials.Sessions[sid] = until
+	}
+}
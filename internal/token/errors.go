@@ -5,8 +5,11 @@ import "errors"
 var (
 	ErrTokenGenerationFailed = errors.New("failed to generate token")
 	ErrTokenNotFound         = errors.New("token not found")
+	ErrTokenReused           = errors.New("refresh token reused")
+	ErrSessionNotFound       = errors.New("session not found")
 	ErrTokenAlreadyExists    = errors.New("token already exists")
 	ErrInvalidToken          = errors.New("invalid token")
+	ErrTokenRevoked          = errors.New("token revoked")
 	ErrExpiredToken          = errors.New("token expired")
 	ErrWrongTokenType        = errors.New("wrong signing method")
 )
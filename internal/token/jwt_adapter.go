@@ -5,35 +5,49 @@ import (
 	"time"
 
 	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
 )
 
+// jwtLeeway absorbs clock drift between the API and other verifiers when
+// checking exp, nbf and iat.
+const jwtLeeway = 30 * time.Second
+
 type JWTGenerator interface {
-	Generate(userID string, exp time.Duration) (string, error)
+	// Generate issues a token for userID, as part of the session sessionID
+	// when that is not empty.
+	Generate(userID string, sessionID string, exp time.Duration) (string, error)
 	Validate(tokenStr string) (*claims, error)
+	// JWKS returns the public keys tokens can be verified with; it is empty
+	// for shared-secret signing.
+	JWKS() JWKSet
+}
+
+// ClaimsConfig names who issues access tokens and who they are for; both
+// are set on every token and required when validating one.
+type ClaimsConfig struct {
+	Issuer   string
+	Audience string
 }
 
 type JWTAdapter struct {
 	secret []byte
+	claims ClaimsConfig
 }
 
+// claims are the registered claims plus sid: the user is the subject, ID is
+// the jti a token can be denylisted by and SessionID the refresh token
+// family it was issued to, which ending the session denylists.
 type claims struct {
-	UserID string `json:"user_id"`
 	jwt.RegisteredClaims
+	SessionID string `json:"sid,omitempty"`
 }
 
-func NewJWTAdapter(secret []byte) JWTGenerator {
-	return &JWTAdapter{secret: secret}
+func NewJWTAdapter(secret []byte, claimsConfig ClaimsConfig) JWTGenerator {
+	return &JWTAdapter{secret: secret, claims: claimsConfig}
 }
 
-func (j *JWTAdapter) Generate(userID string, exp time.Duration) (string, error) {
-	c := claims{
-		UserID: userID,
-		RegisteredClaims: jwt.RegisteredClaims{
-			ExpiresAt: jwt.NewNumericDate(time.Now().Add(exp)),
-		},
-	}
-
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, c)
+func (j *JWTAdapter) Generate(userID string, sessionID string, exp time.Duration) (string, error) {
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, newClaims(j.claims, userID, sessionID, exp))
 	return token.SignedString(j.secret)
 }
 
@@ -47,7 +61,7 @@ func (j *JWTAdapter) Validate(tokenStr string) (*claims, error) {
 		}
 
 		return j.secret, nil
-	})
+	}, parserOptions(j.claims)...)
 
 	if err != nil {
 		return nil, fmt.Errorf("%w: %v", ErrInvalidToken, err)
@@ -59,3 +73,35 @@ func (j *JWTAdapter) Validate(tokenStr string) (*claims, error) {
 
 	return &claims, nil
 }
+
+// JWKS is empty: an HMAC secret must never be published.
+func (j *JWTAdapter) JWKS() JWKSet {
+	return JWKSet{Keys: []JWK{}}
+}
+
+func newClaims(cfg ClaimsConfig, userID string, sessionID string, exp time.Duration) claims {
+	now := time.Now()
+
+	return claims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    cfg.Issuer,
+			Subject:   userID,
+			Audience:  jwt.ClaimStrings{cfg.Audience},
+			ExpiresAt: jwt.NewNumericDate(now.Add(exp)),
+			NotBefore: jwt.NewNumericDate(now),
+			IssuedAt:  jwt.NewNumericDate(now),
+			ID:        uuid.NewString(),
+		},
+		SessionID: sessionID,
+	}
+}
+
+func parserOptions(cfg ClaimsConfig) []jwt.ParserOption {
+	return []jwt.ParserOption{
+		jwt.WithIssuer(cfg.Issuer),
+		jwt.WithAudience(cfg.Audience),
+		jwt.WithExpirationRequired(),
+		jwt.WithIssuedAt(),
+		jwt.WithLeeway(jwtLeeway),
+	}
+}
@@ -0,0 +1,86 @@
+package token
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// KeySetAdapter signs access tokens with the first of several asymmetric
+// keys and accepts tokens signed by any of them, so a new key can be put in
+// front while tokens signed with the old one run out.
+type KeySetAdapter struct {
+	signing *SigningKey
+	keys    map[string]*SigningKey
+	claims  ClaimsConfig
+}
+
+func NewKeySetAdapter(keys []*SigningKey, claimsConfig ClaimsConfig) (JWTGenerator, error) {
+	if len(keys) == 0 {
+		return nil, errors.New("at least one signing key is required")
+	}
+
+	byID := make(map[string]*SigningKey, len(keys))
+	for _, k := range keys {
+		if _, dup := byID[k.ID]; dup {
+			return nil, fmt.Errorf("signing key %s is configured twice", k.ID)
+		}
+		byID[k.ID] = k
+	}
+
+	return &KeySetAdapter{signing: keys[0], keys: byID, claims: claimsConfig}, nil
+}
+
+func (a *KeySetAdapter) Generate(userID string, sessionID string, exp time.Duration) (string, error) {
+	token := jwt.NewWithClaims(a.signing.Method, newClaims(a.claims, userID, sessionID, exp))
+	token.Header["kid"] = a.signing.ID
+
+	return token.SignedString(a.signing.Private)
+}
+
+func (a *KeySetAdapter) Validate(tokenStr string) (*claims, error) {
+	claims := claims{}
+
+	token, err := jwt.ParseWithClaims(tokenStr, &claims, func(t *jwt.Token) (any, error) {
+		kid, _ := t.Header["kid"].(string)
+		key, ok := a.keys[kid]
+		if !ok {
+			return nil, fmt.Errorf("unknown signing key %q", kid)
+		}
+
+		// the key decides the algorithm, never the token
+		if t.Method.Alg() != key.Method.Alg() {
+			return nil, ErrWrongTokenType
+		}
+
+		return key.Public, nil
+	}, parserOptions(a.claims)...)
+
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidToken, err)
+	}
+
+	if !token.Valid {
+		return nil, ErrInvalidToken
+	}
+
+	return &claims, nil
+}
+
+func (a *KeySetAdapter) JWKS() JWKSet {
+	set := JWKSet{Keys: make([]JWK, 0, len(a.keys))}
+
+	// signing key first, the rest in a stable order
+	set.Keys = append(set.Keys, a.signing.JWK())
+	for _, id := range slices.Sorted(maps.Keys(a.keys)) {
+		if id != a.signing.ID {
+			set.Keys = append(set.Keys, a.keys[id].JWK())
+		}
+	}
+
+	return set
+}
@@ -2,11 +2,51 @@ package token
 
 import (
 	"context"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/raphico/go-device-telemetry-api/internal/user"
 )
 
 type Repository interface {
 	Create(ctx context.Context, t *Token) error
 	FindValidTokenByHash(ctx context.Context, hash []byte, scope string) (*Token, error)
 	Revoke(ctx context.Context, scope string, hash []byte) error
+	// RotateRefreshToken atomically revokes the refresh token matching hash
+	// and stores next in the same family, copying its user, family and
+	// session start from the presented token. A token rotated less than
+	// grace ago is rotated again, once, as long as its session is still
+	// live, so concurrent refreshes with one cookie both succeed; any further
+	// attempt inside the window fails with ErrTokenNotFound. If the presented
+	// token was rotated before that the whole family is revoked instead,
+	// and the presented token is returned with ErrTokenReused. Unknown,
+	// expired and otherwise revoked tokens fail with ErrTokenNotFound.
+	RotateRefreshToken(ctx context.Context, hash []byte, next *Token, grace time.Duration) (*Token, error)
 	UpdateLastUsed(ctx context.Context, hash []byte) error
+	// FindByUser lists userID's unrevoked tokens of the given scope,
+	// expired ones included.
+	FindByUser(ctx context.Context, userID user.UserID, scope string) ([]*Token, error)
+	RevokeByID(ctx context.Context, id TokenID, userID user.UserID, scope string) error
+	// RevokeAllForUser revokes every live token of scope held by userID.
+	RevokeAllForUser(ctx context.Context, userID user.UserID, scope string) error
+	// FindSessions lists userID's live refresh token families, newest
+	// login first.
+	FindSessions(ctx context.Context, userID user.UserID) ([]*Session, error)
+	// RevokeSession revokes every token in a family owned by userID,
+	// failing with ErrSessionNotFound when none is live.
+	RevokeSession(ctx context.Context, userID user.UserID, familyID TokenID) error
+	// RevokeOtherSessions revokes userID's refresh tokens outside the keep
+	// family, or all of them when keep is nil, returning the families
+	// that had tokens revoked.
+	RevokeOtherSessions(ctx context.Context, userID user.UserID, keep *TokenID) ([]TokenID, error)
+	// DenyAccessToken puts the access token jti on the denylist until
+	// expiresAt, and DenySession every access token issued to the session
+	// sessionID. Denying twice is not an error.
+	DenyAccessToken(ctx context.Context, jti uuid.UUID, expiresAt time.Time, revokedBy user.UserID) error
+	DenySession(ctx context.Context, sessionID TokenID, expiresAt time.Time, revokedBy user.UserID) error
+	// FindDenials lists the denials that have not expired by now.
+	FindDenials(ctx context.Context, now time.Time) (*Denials, error)
+	// DeleteExpired removes tokens and denylist entries that expired before
+	// the given time, and revoked tokens other than refresh tokens.
+	DeleteExpired(ctx context.Context, before time.Time) (int64, error)
 }
@@ -2,35 +2,57 @@ package token
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"time"
 
+	"github.com/google/uuid"
+	"github.com/raphico/go-device-telemetry-api/internal/audit"
 	"github.com/raphico/go-device-telemetry-api/internal/user"
 )
 
 type Service struct {
-	repo            Repository
-	jwtGen          JWTGenerator
-	accessTokenTTL  time.Duration
-	refreshTokenTTL time.Duration
+	repo                 Repository
+	denylist             *denylist
+	audit                *audit.Service
+	jwtGen               JWTGenerator
+	accessTokenTTL       time.Duration
+	refreshTokenTTL      time.Duration
+	refreshReuseGrace    time.Duration
+	emailVerificationTTL time.Duration
+	passwordResetTTL     time.Duration
+	mfaChallengeTTL      time.Duration
 }
 
 func NewService(
 	jwtGen JWTGenerator,
 	repo Repository,
+	auditService *audit.Service,
 	accessTokenTTL time.Duration,
 	refreshTokenTTL time.Duration,
+	refreshReuseGrace time.Duration,
+	emailVerificationTTL time.Duration,
+	passwordResetTTL time.Duration,
+	mfaChallengeTTL time.Duration,
 ) *Service {
 	return &Service{
-		repo:            repo,
-		jwtGen:          jwtGen,
-		accessTokenTTL:  accessTokenTTL,
-		refreshTokenTTL: refreshTokenTTL,
+		repo:                 repo,
+		denylist:             newDenylist(repo),
+		audit:                auditService,
+		jwtGen:               jwtGen,
+		accessTokenTTL:       accessTokenTTL,
+		refreshTokenTTL:      refreshTokenTTL,
+		refreshReuseGrace:    refreshReuseGrace,
+		emailVerificationTTL: emailVerificationTTL,
+		passwordResetTTL:     passwordResetTTL,
+		mfaChallengeTTL:      mfaChallengeTTL,
 	}
 }
 
-func (s *Service) GenerateAccessToken(userId user.UserID) (string, error) {
-	token, err := s.jwtGen.Generate(userId.String(), s.accessTokenTTL)
+// GenerateAccessToken issues an access token for the session sessionID,
+// the family of the refresh token it comes with.
+func (s *Service) GenerateAccessToken(userId user.UserID, sessionID TokenID) (string, error) {
+	token, err := s.jwtGen.Generate(userId.String(), sessionID.String(), s.accessTokenTTL)
 	if err != nil {
 		return "", err
 	}
@@ -38,22 +60,83 @@ func (s *Service) GenerateAccessToken(userId user.UserID) (string, error) {
 	return token, nil
 }
 
-func (s *Service) ValidateAccessToken(tokenStr string) (user.UserID, error) {
+// ValidateAccessToken checks an access token's signature and claims and
+// returns its subject. Tokens on the denylist, or issued to a session on
+// it, fail with ErrTokenRevoked.
+func (s *Service) ValidateAccessToken(ctx context.Context, tokenStr string) (user.UserID, error) {
 	claims, err := s.jwtGen.Validate(tokenStr)
 	if err != nil {
 		return user.UserID{}, err
 	}
 
-	UserId, err := user.NewUserID(claims.UserID)
+	jti, err := uuid.Parse(claims.ID)
+	if err != nil {
+		return user.UserID{}, fmt.Errorf("%w: invalid jti", ErrInvalidToken)
+	}
+
+	// tokens issued before sessions were tracked have no sid
+	var sid uuid.UUID
+	if claims.SessionID != "" {
+		if sid, err = uuid.Parse(claims.SessionID); err != nil {
+			return user.UserID{}, fmt.Errorf("%w: invalid sid", ErrInvalidToken)
+		}
+	}
+
+	denied, err := s.denylist.denied(ctx, jti, sid)
+	if err != nil {
+		return user.UserID{}, err
+	}
+	if denied {
+		return user.UserID{}, ErrTokenRevoked
+	}
+
+	userId, err := user.NewUserID(claims.Subject)
 	if err != nil {
 		return user.UserID{}, fmt.Errorf("invalid user ID in token: %w", err)
 	}
 
-	return UserId, nil
+	return userId, nil
 }
 
-func (s *Service) CreateRefreshToken(ctx context.Context, userId user.UserID) (*Token, error) {
-	token, err := NewToken(userId, s.refreshTokenTTL, "auth")
+// RevokeAccessToken puts an access token on the denylist until it would
+// have expired. The token itself gives the exact expiry; with only its jti
+// the longest possible lifetime is assumed.
+func (s *Service) RevokeAccessToken(
+	ctx context.Context,
+	tokenStr string,
+	jti string,
+	revokedBy user.UserID,
+) error {
+	expiresAt := time.Now().Add(s.accessTokenTTL + jwtLeeway)
+
+	if tokenStr != "" {
+		claims, err := s.jwtGen.Validate(tokenStr)
+		if err != nil {
+			return err
+		}
+		jti = claims.ID
+		expiresAt = claims.ExpiresAt.Add(jwtLeeway)
+	}
+
+	id, err := uuid.Parse(jti)
+	if err != nil {
+		return fmt.Errorf("%w: invalid jti", ErrInvalidToken)
+	}
+
+	if err := s.repo.DenyAccessToken(ctx, id, expiresAt, revokedBy); err != nil {
+		return err
+	}
+	s.denylist.denyAccessToken(id, expiresAt)
+
+	s.audit.Record(ctx, audit.EventAccessTokenRevoked, &revokedBy, map[string]any{
+		"jti": id.String(),
+	})
+
+	return nil
+}
+
+func (s *Service) CreateRefreshToken(ctx context.Context, userId user.UserID, client ClientInfo) (*Token, error) {
+	token, err := NewRefreshToken(userId, s.refreshTokenTTL, client)
 	if err != nil {
 		return nil, err
 	}
@@ -65,42 +148,376 @@ func (s *Service) CreateRefreshToken(ctx context.Context, userId user.UserID) (*
 	return token, nil
 }
 
+// EndAccessTokenSession denylists an access token and ends the session it
+// was issued to, if that is still live.
+func (s *Service) EndAccessTokenSession(ctx context.Context, tokenStr string) error {
+	claims, err := s.jwtGen.Validate(tokenStr)
+	if err != nil {
+		return err
+	}
+
+	userID, err := user.NewUserID(claims.Subject)
+	if err != nil {
+		return fmt.Errorf("invalid user ID in token: %w", err)
+	}
+
+	jti, err := uuid.Parse(claims.ID)
+	if err != nil {
+		return fmt.Errorf("%w: invalid jti", ErrInvalidToken)
+	}
+
+	expiresAt := claims.ExpiresAt.Add(jwtLeeway)
+	if err := s.repo.DenyAccessToken(ctx, jti, expiresAt, userID); err != nil {
+		return err
+	}
+	s.denylist.denyAccessToken(jti, expiresAt)
+
+	if claims.SessionID == "" {
+		return nil
+	}
+
+	sid, err := uuid.Parse(claims.SessionID)
+	if err != nil {
+		return fmt.Errorf("%w: invalid sid", ErrInvalidToken)
+	}
+
+	err = s.RevokeSession(ctx, userID, TokenID(sid))
+	if err != nil && !errors.Is(err, ErrSessionNotFound) {
+		return err
+	}
+
+	return nil
+}
+
+// RevokeRefreshToken ends the session refreshTok belongs to, access tokens
+// included, failing with ErrTokenNotFound when it is not live.
 func (s *Service) RevokeRefreshToken(ctx context.Context, refreshTok string) error {
 	hash := HashPlaintext(refreshTok)
 
-	if err := s.repo.Revoke(ctx, "auth", hash); err != nil {
+	t, err := s.repo.FindValidTokenByHash(ctx, hash, ScopeAuth)
+	if err != nil {
 		return err
 	}
 
+	// the whole family, since a refresh inside the reuse grace window can
+	// leave more than one live token in it
+	if err := s.repo.RevokeSession(ctx, t.UserID, t.FamilyID); err != nil {
+		if errors.Is(err, ErrSessionNotFound) {
+			return ErrTokenNotFound
+		}
+		return err
+	}
+
+	return s.denySessions(ctx, t.UserID, t.FamilyID)
+}
+
+// RevokeAllRefreshTokens signs userID out of every session, access tokens
+// included.
+func (s *Service) RevokeAllRefreshTokens(ctx context.Context, userID user.UserID) error {
+	families, err := s.repo.RevokeOtherSessions(ctx, userID, nil)
+	if err != nil {
+		return err
+	}
+
+	return s.denySessions(ctx, userID, families...)
+}
+
+// denySessions denylists the access tokens issued to sessions until the
+// last of them would have expired.
+func (s *Service) denySessions(ctx context.Context, revokedBy user.UserID, sessionIDs ...TokenID) error {
+	expiresAt := time.Now().Add(s.accessTokenTTL + jwtLeeway)
+
+	for _, id := range sessionIDs {
+		if err := s.repo.DenySession(ctx, id, expiresAt, revokedBy); err != nil {
+			return err
+		}
+		s.denylist.denySession(uuid.UUID(id), expiresAt)
+	}
+
 	return nil
 }
 
-func (s *Service) RotateTokens(ctx context.Context, refreshTok string) (string, *Token, error) {
+// RotateTokens exchanges a refresh token for a new access token and the
+// next refresh token in its family. Presenting a token that was already
+// rotated means it has been copied, so the family and its access tokens
+// are revoked, the event is recorded and ErrTokenReused is returned: the
+// user has to log in again.
+func (s *Service) RotateTokens(ctx context.Context, refreshTok string, client ClientInfo) (string, *Token, error) {
 	hash := HashPlaintext(refreshTok)
 
-	tokenRecord, err := s.repo.FindValidTokenByHash(ctx, hash, "auth")
+	next, err := NewToken(user.UserID{}, s.refreshTokenTTL, ScopeAuth)
 	if err != nil {
-		fmt.Println(err)
 		return "", nil, err
 	}
+	next.Client = client
 
-	if err := s.repo.UpdateLastUsed(ctx, hash); err != nil {
+	presented, err := s.repo.RotateRefreshToken(ctx, hash, next, s.refreshReuseGrace)
+	if err != nil {
+		if errors.Is(err, ErrTokenReused) {
+			s.audit.Record(ctx, audit.EventRefreshTokenReused, &presented.UserID, map[string]any{
+				"token_id":  presented.ID.String(),
+				"family_id": presented.FamilyID.String(),
+			})
+			if denyErr := s.denySessions(ctx, presented.UserID, presented.FamilyID); denyErr != nil {
+				return "", nil, denyErr
+			}
+		}
 		return "", nil, err
 	}
 
-	if err := s.repo.Revoke(ctx, "auth", tokenRecord.Hash); err != nil {
+	accessToken, err := s.GenerateAccessToken(next.UserID, next.FamilyID)
+	if err != nil {
 		return "", nil, err
 	}
 
-	accessToken, err := s.GenerateAccessToken(tokenRecord.UserID)
+	return accessToken, next, nil
+}
+
+func (s *Service) ListSessions(ctx context.Context, userID user.UserID) ([]*Session, error) {
+	return s.repo.FindSessions(ctx, userID)
+}
+
+// CurrentSessionID returns the session a refresh token belongs to, failing
+// with ErrTokenNotFound when it is not a live refresh token of userID.
+func (s *Service) CurrentSessionID(ctx context.Context, userID user.UserID, refreshTok string) (TokenID, error) {
+	t, err := s.repo.FindValidTokenByHash(ctx, HashPlaintext(refreshTok), ScopeAuth)
 	if err != nil {
-		return "", nil, err
+		return TokenID{}, err
 	}
 
-	token, err := s.CreateRefreshToken(ctx, tokenRecord.UserID)
+	if t.UserID != userID {
+		return TokenID{}, ErrTokenNotFound
+	}
+
+	return t.FamilyID, nil
+}
+
+// SessionStartedAt returns when the session accessToken was issued to
+// began, that is when its user last logged in on it. Tokens without a
+// session, or whose session is no longer live, fail with
+// ErrSessionNotFound.
+func (s *Service) SessionStartedAt(ctx context.Context, accessToken string) (time.Time, error) {
+	claims, err := s.jwtGen.Validate(accessToken)
 	if err != nil {
-		return "", nil, err
+		return time.Time{}, err
+	}
+
+	userID, err := user.NewUserID(claims.Subject)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid user ID in token: %w", err)
+	}
+
+	if claims.SessionID == "" {
+		return time.Time{}, ErrSessionNotFound
+	}
+
+	sid, err := uuid.Parse(claims.SessionID)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("%w: invalid sid", ErrInvalidToken)
+	}
+
+	sessions, err := s.repo.FindSessions(ctx, userID)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	for _, session := range sessions {
+		if session.ID == TokenID(sid) {
+			return session.CreatedAt, nil
+		}
+	}
+
+	return time.Time{}, ErrSessionNotFound
+}
+
+// RevokeSession ends one of userID's sessions, access tokens included.
+func (s *Service) RevokeSession(ctx context.Context, userID user.UserID, sessionID TokenID) error {
+	if err := s.repo.RevokeSession(ctx, userID, sessionID); err != nil {
+		return err
+	}
+
+	return s.denySessions(ctx, userID, sessionID)
+}
+
+// RevokeOtherSessions signs userID out everywhere except the session
+// refreshTok belongs to, returning how many sessions ended. Without a live
+// refreshTok no session is current, so all of them end.
+func (s *Service) RevokeOtherSessions(ctx context.Context, userID user.UserID, refreshTok string) (int64, error) {
+	var keep *TokenID
+	if refreshTok != "" {
+		current, err := s.CurrentSessionID(ctx, userID, refreshTok)
+		if err != nil && !errors.Is(err, ErrTokenNotFound) {
+			return 0, err
+		}
+		if err == nil {
+			keep = &current
+		}
+	}
+
+	families, err := s.repo.RevokeOtherSessions(ctx, userID, keep)
+	if err != nil {
+		return 0, err
+	}
+
+	if err := s.denySessions(ctx, userID, families...); err != nil {
+		return 0, err
+	}
+
+	return int64(len(families)), nil
+}
+
+// PurgeExpired deletes expired and revoked tokens, and denylist entries
+// for access tokens that have expired anyway. Revoked refresh tokens are
+// kept until they expire so replaying them is still detected as reuse.
+func (s *Service) PurgeExpired(ctx context.Context) (int64, error) {
+	return s.repo.DeleteExpired(ctx, time.Now())
+}
+
+// JWKS returns the public keys access tokens are signed with.
+func (s *Service) JWKS() JWKSet {
+	return s.jwtGen.JWKS()
+}
+
+// lastUsedResolution is how stale a personal access token's last_used_at may
+// get before a request refreshes it, so busy scripts do not write on every
+// call.
+const lastUsedResolution = time.Minute
+
+// CreatePersonalAccessToken issues a named token limited to scopes. A zero
+// expiresAt makes it valid until revoked. The plaintext is only available on
+// the returned token.
+func (s *Service) CreatePersonalAccessToken(
+	ctx context.Context,
+	userID user.UserID,
+	name Name,
+	scopes []AccessScope,
+	expiresAt time.Time,
+) (*Token, error) {
+	t, err := NewPersonalAccessToken(userID, name, scopes, expiresAt)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.repo.Create(ctx, t); err != nil {
+		return nil, err
+	}
+
+	return t, nil
+}
+
+func (s *Service) ListPersonalAccessTokens(ctx context.Context, userID user.UserID) ([]*Token, error) {
+	return s.repo.FindByUser(ctx, userID, ScopePersonalAccess)
+}
+
+func (s *Service) RevokePersonalAccessToken(ctx context.Context, id TokenID, userID user.UserID) error {
+	return s.repo.RevokeByID(ctx, id, userID, ScopePersonalAccess)
+}
+
+// ValidatePersonalAccessToken returns the live token matching plaintext,
+// failing with ErrTokenNotFound for unknown, revoked or expired tokens.
+func (s *Service) ValidatePersonalAccessToken(ctx context.Context, plaintext string) (*Token, error) {
+	hash := HashPlaintext(plaintext)
+
+	t, err := s.repo.FindValidTokenByHash(ctx, hash, ScopePersonalAccess)
+	if err != nil {
+		return nil, err
+	}
+
+	if t.LastUsedAt == nil || time.Since(*t.LastUsedAt) > lastUsedResolution {
+		if err := s.repo.UpdateLastUsed(ctx, hash); err != nil {
+			return nil, err
+		}
+	}
+
+	return t, nil
+}
+
+// CreateEmailVerificationToken issues a single-use token proving control
+// of userID's email address, revoking older ones so only the latest emailed
+// link works.
+func (s *Service) CreateEmailVerificationToken(ctx context.Context, userID user.UserID) (*Token, error) {
+	return s.createSingleUseToken(ctx, userID, ScopeEmailVerification, s.emailVerificationTTL)
+}
+
+// ConsumeEmailVerificationToken revokes the verification token matching
+// plaintext and returns its owner. Unknown, used and expired tokens fail
+// with ErrTokenNotFound.
+func (s *Service) ConsumeEmailVerificationToken(ctx context.Context, plaintext string) (user.UserID, error) {
+	return s.consumeSingleUseToken(ctx, plaintext, ScopeEmailVerification)
+}
+
+// CreatePasswordResetToken issues a single-use token allowing userID's
+// password to be reset, revoking older ones.
+func (s *Service) CreatePasswordResetToken(ctx context.Context, userID user.UserID) (*Token, error) {
+	return s.createSingleUseToken(ctx, userID, ScopePasswordReset, s.passwordResetTTL)
+}
+
+// ConsumePasswordResetToken revokes the reset token matching plaintext and
+// returns its owner, failing with ErrTokenNotFound like
+// ConsumeEmailVerificationToken.
+func (s *Service) ConsumePasswordResetToken(ctx context.Context, plaintext string) (user.UserID, error) {
+	return s.consumeSingleUseToken(ctx, plaintext, ScopePasswordReset)
+}
+
+// CreateMFAChallengeToken issues the token a user with two-factor
+// authentication trades, together with a code, for a session once their
+// password checked out. Older challenges are revoked.
+func (s *Service) CreateMFAChallengeToken(ctx context.Context, userID user.UserID) (*Token, error) {
+	return s.createSingleUseToken(ctx, userID, ScopeMFAChallenge, s.mfaChallengeTTL)
+}
+
+// MFAChallengeUser returns the owner of the challenge matching plaintext
+// without using it up, so a mistyped code can be retried. Unknown, used and
+// expired challenges fail with ErrTokenNotFound.
+func (s *Service) MFAChallengeUser(ctx context.Context, plaintext string) (user.UserID, error) {
+	t, err := s.repo.FindValidTokenByHash(ctx, HashPlaintext(plaintext), ScopeMFAChallenge)
+	if err != nil {
+		return user.UserID{}, err
+	}
+
+	return t.UserID, nil
+}
+
+// ConsumeMFAChallengeToken revokes the challenge matching plaintext once
+// its code was accepted.
+func (s *Service) ConsumeMFAChallengeToken(ctx context.Context, plaintext string) (user.UserID, error) {
+	return s.consumeSingleUseToken(ctx, plaintext, ScopeMFAChallenge)
+}
+
+func (s *Service) createSingleUseToken(
+	ctx context.Context,
+	userID user.UserID,
+	scope string,
+	ttl time.Duration,
+) (*Token, error) {
+	if err := s.repo.RevokeAllForUser(ctx, userID, scope); err != nil {
+		return nil, err
+	}
+
+	t, err := NewToken(userID, ttl, scope)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.repo.Create(ctx, t); err != nil {
+		return nil, err
+	}
+
+	return t, nil
+}
+
+func (s *Service) consumeSingleUseToken(ctx context.Context, plaintext string, scope string) (user.UserID, error) {
+	hash := HashPlaintext(plaintext)
+
+	t, err := s.repo.FindValidTokenByHash(ctx, hash, scope)
+	if err != nil {
+		return user.UserID{}, err
+	}
+
+	// revoking is the claim: of two concurrent uses only one succeeds
+	if err := s.repo.Revoke(ctx, scope, hash); err != nil {
+		return user.UserID{}, err
 	}
 
-	return accessToken, token, nil
+	return t.UserID, nil
 }
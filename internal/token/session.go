@@ -0,0 +1,39 @@
+package token
+
+import (
+	"time"
+	"unicode/utf8"
+)
+
+// maxUserAgentLength bounds the stored user agent; anything longer is
+// truncated rather than rejected.
+const maxUserAgentLength = 512
+
+// ClientInfo describes the client a refresh token was issued to.
+type ClientInfo struct {
+	UserAgent string
+	IP        string
+}
+
+// Session is one login: a refresh token family, described by its live
+// token. Its ID is the family id, which stays the same across rotations.
+type Session struct {
+	ID        TokenID
+	Client    ClientInfo
+	CreatedAt time.Time
+	// LastActiveAt is when the session last refreshed its tokens.
+	LastActiveAt time.Time
+	ExpiresAt    time.Time
+}
+
+func NewClientInfo(userAgent string, ip string) ClientInfo {
+	if len(userAgent) > maxUserAgentLength {
+		userAgent = userAgent[:maxUserAgentLength]
+		// do not leave half a multi-byte character behind
+		for !utf8.ValidString(userAgent) {
+			userAgent = userAgent[:len(userAgent)-1]
+		}
+	}
+
+	return ClientInfo{UserAgent: userAgent, IP: ip}
+}
@@ -0,0 +1,165 @@
+package token
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// minRSAKeyBits is the smallest RSA modulus accepted for signing.
+const minRSAKeyBits = 2048
+
+// SigningKey is an asymmetric key access tokens are signed with. Its ID is
+// sent as the kid header and is the key's RFC 7638 thumbprint, so it stays
+// the same wherever the key is loaded.
+type SigningKey struct {
+	ID      string
+	Method  jwt.SigningMethod
+	Private crypto.Signer
+	Public  crypto.PublicKey
+}
+
+// JWK is a public key in JSON Web Key form.
+type JWK struct {
+	KeyType   string `json:"kty"`
+	KeyID     string `json:"kid"`
+	Use       string `json:"use"`
+	Algorithm string `json:"alg"`
+	// RSA
+	Modulus  string `json:"n,omitempty"`
+	Exponent string `json:"e,omitempty"`
+	// Ed25519
+	Curve string `json:"crv,omitempty"`
+	X     string `json:"x,omitempty"`
+}
+
+// JWKSet is the document published at /.well-known/jwks.json.
+type JWKSet struct {
+	Keys []JWK `json:"keys"`
+}
+
+// LoadSigningKeys reads PEM-encoded RSA or Ed25519 private keys, in PKCS#8
+// or (for RSA) PKCS#1 form, from paths.
+func LoadSigningKeys(paths []string) ([]*SigningKey, error) {
+	keys := make([]*SigningKey, 0, len(paths))
+
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read signing key: %w", err)
+		}
+
+		key, err := ParseSigningKey(data)
+		if err != nil {
+			return nil, fmt.Errorf("invalid signing key %s: %w", path, err)
+		}
+
+		keys = append(keys, key)
+	}
+
+	return keys, nil
+}
+
+func ParseSigningKey(data []byte) (*SigningKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("no PEM block found")
+	}
+
+	var (
+		parsed any
+		err    error
+	)
+	switch block.Type {
+	case "PRIVATE KEY":
+		parsed, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	case "RSA PRIVATE KEY":
+		parsed, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	default:
+		return nil, fmt.Errorf("unsupported PEM block %q", block.Type)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	switch k := parsed.(type) {
+	case *rsa.PrivateKey:
+		if k.N.BitLen() < minRSAKeyBits {
+			return nil, fmt.Errorf("RSA keys must be at least %d bits", minRSAKeyBits)
+		}
+		key := &SigningKey{Method: jwt.SigningMethodRS256, Private: k, Public: &k.PublicKey}
+		key.ID = thumbprint(key.jwkMembers())
+		return key, nil
+	case ed25519.PrivateKey:
+		key := &SigningKey{Method: jwt.SigningMethodEdDSA, Private: k, Public: k.Public()}
+		key.ID = thumbprint(key.jwkMembers())
+		return key, nil
+	default:
+		return nil, fmt.Errorf("unsupported key type %T; use RSA or Ed25519", parsed)
+	}
+}
+
+// JWK returns the public half of the key.
+func (k *SigningKey) JWK() JWK {
+	jwk := JWK{
+		KeyID:     k.ID,
+		Use:       "sig",
+		Algorithm: k.Method.Alg(),
+	}
+
+	m := k.jwkMembers()
+	jwk.KeyType = m["kty"]
+	jwk.Modulus, jwk.Exponent = m["n"], m["e"]
+	jwk.Curve, jwk.X = m["crv"], m["x"]
+
+	return jwk
+}
+
+// jwkMembers returns the required public members of the key's JWK, which
+// are exactly what its thumbprint covers.
+func (k *SigningKey) jwkMembers() map[string]string {
+	switch pub := k.Public.(type) {
+	case *rsa.PublicKey:
+		return map[string]string{
+			"kty": "RSA",
+			"n":   b64(pub.N.Bytes()),
+			"e":   b64(big.NewInt(int64(pub.E)).Bytes()),
+		}
+	case ed25519.PublicKey:
+		return map[string]string{
+			"kty": "OKP",
+			"crv": "Ed25519",
+			"x":   b64(pub),
+		}
+	default:
+		return nil
+	}
+}
+
+// thumbprint computes the RFC 7638 SHA-256 thumbprint: the hash of the
+// required members serialized in lexicographic order without whitespace.
+func thumbprint(members map[string]string) string {
+	var canonical string
+	if members["kty"] == "RSA" {
+		canonical = fmt.Sprintf(`{"e":%q,"kty":"RSA","n":%q}`, members["e"], members["n"])
+	} else {
+		canonical = fmt.Sprintf(`{"crv":%q,"kty":%q,"x":%q}`, members["crv"], members["kty"], members["x"])
+	}
+
+	sum := sha256.Sum256([]byte(canonical))
+	return b64(sum[:])
+}
+
+func b64(b []byte) string {
+	return base64.RawURLEncoding.EncodeToString(b)
+}
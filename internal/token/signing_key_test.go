@@ -0,0 +1,95 @@
+package token
+
+import (
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"math/big"
+	"testing"
+)
+
+// rfc7638Modulus is the RSA modulus of the example in RFC 7638 section 3.1.
+const rfc7638Modulus = "0vx7agoebGcQSuuPiLJXZptN9nndrQmbXEps2aiAFbWhM78LhWx4cbbfAAtVT86zwu1RK7aPFFxuhDR1L6tSoc_BJECPebWKRXjBZCiFV4n3oknjhMstn64tZ_2W-5JsGY4Hc5n9yBXArwl93lqt7_RN5w6Cf0h4QyQ5v-65YGjQR0_FDW2QvzqY368QQMicAtaSqzs8KJZgnYb9c7d0zgdAZHzu6qMQvRL5hajrn1n91CbOpbISD08qNLyrdkt-bFTWhAI4vMQFh6WeZu0fM4lFd2NcRwr3XPksINHaQ-G_xBniIqbw0Ls1jF44-csFCur-kEgU8awapJzKnqDKgw"
+
+func decodeB64(t *testing.T, s string) []byte {
+	t.Helper()
+
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		t.Fatalf("decode %q: %v", s, err)
+	}
+
+	return b
+}
+
+func TestThumbprint(t *testing.T) {
+	tests := []struct {
+		name    string
+		members map[string]string
+		want    string
+	}{
+		{
+			// RFC 7638 section 3.1
+			name:    "RSA",
+			members: map[string]string{"kty": "RSA", "n": rfc7638Modulus, "e": "AQAB"},
+			want:    "NzbLsXh8uDCcd-6MNwXF4W_7noWXFZAfHkxZsRGC9Xs",
+		},
+		{
+			// RFC 8037 appendix A.3
+			name:    "Ed25519",
+			members: map[string]string{"kty": "OKP", "crv": "Ed25519", "x": "11qYAYKxCrfVS_7TyWQHOg7hcvPapiMlrwIaaPcHURo"},
+			want:    "kPrK_qmxVWaYVA9wwBF6Iuo3vVzz7TxHCTwXBygrS4k",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := thumbprint(tt.members); got != tt.want {
+				t.Errorf("thumbprint = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestJWKMembers(t *testing.T) {
+	rsaKey := &SigningKey{Public: &rsa.PublicKey{
+		N: new(big.Int).SetBytes(decodeB64(t, rfc7638Modulus)),
+		E: 65537,
+	}}
+
+	got := rsaKey.jwkMembers()
+	if got["kty"] != "RSA" || got["n"] != rfc7638Modulus || got["e"] != "AQAB" {
+		t.Errorf("RSA jwkMembers = %v", got)
+	}
+	if id := thumbprint(got); id != "NzbLsXh8uDCcd-6MNwXF4W_7noWXFZAfHkxZsRGC9Xs" {
+		t.Errorf("RSA thumbprint = %s", id)
+	}
+}
+
+func TestParseSigningKeyUsesThumbprintAsID(t *testing.T) {
+	// RFC 8037 appendix A.1
+	seed := decodeB64(t, "nWGxne_9WmC6hEr0kuwsxERJxWl7MmkZcDusAxyuf2A")
+	private := ed25519.NewKeyFromSeed(seed)
+
+	der, err := x509.MarshalPKCS8PrivateKey(private)
+	if err != nil {
+		t.Fatalf("marshal: %v", err)
+	}
+
+	key, err := ParseSigningKey(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}))
+	if err != nil {
+		t.Fatalf("ParseSigningKey: %v", err)
+	}
+
+	if want := "kPrK_qmxVWaYVA9wwBF6Iuo3vVzz7TxHCTwXBygrS4k"; key.ID != want {
+		t.Errorf("ID = %s, want %s", key.ID, want)
+	}
+
+	jwk := key.JWK()
+	if jwk.KeyID != key.ID || jwk.KeyType != "OKP" || jwk.Curve != "Ed25519" ||
+		jwk.X != "11qYAYKxCrfVS_7TyWQHOg7hcvPapiMlrwIaaPcHURo" || jwk.Algorithm != "EdDSA" {
+		t.Errorf("JWK = %+v", jwk)
+	}
+}
@@ -11,18 +11,52 @@ import (
 	"github.com/raphico/go-device-telemetry-api/internal/user"
 )
 
+// Token types, stored in the scope column.
+const (
+	ScopeAuth              = "auth"
+	ScopeEmailVerification = "email_verification"
+	ScopePasswordReset     = "password_reset"
+	ScopePersonalAccess    = "personal_access"
+	ScopeMFAChallenge      = "mfa_challenge"
+)
+
 type TokenID uuid.UUID
 
 type Token struct {
-	ID         TokenID
-	UserID     user.UserID
-	Plaintext  string
-	Hash       []byte
-	Scope      string
-	Revoked    bool
+	ID        TokenID
+	UserID    user.UserID
+	Plaintext string
+	Hash      []byte
+	Scope     string
+	Revoked   bool
+	// FamilyID groups a refresh token with the tokens it was rotated from
+	// and into; it, Client and SessionStartedAt are only set on refresh
+	// tokens.
+	FamilyID         TokenID
+	Client           ClientInfo
+	SessionStartedAt time.Time
+	// ExpiresAt is zero for personal access tokens that never expire.
 	ExpiresAt  time.Time
 	LastUsedAt *time.Time
 	CreatedAt  time.Time
+
+	// Name, Prefix and AccessScopes are only set on personal access tokens.
+	Name         Name
+	Prefix       string
+	AccessScopes []AccessScope
+}
+
+func NewTokenID(id string) (TokenID, error) {
+	parsed, err := uuid.Parse(id)
+	if err != nil {
+		return TokenID(uuid.Nil), err
+	}
+
+	return TokenID(parsed), nil
+}
+
+func (id TokenID) String() string {
+	return uuid.UUID(id).String()
 }
 
 func NewToken(userId user.UserID, ttl time.Duration, scope string) (*Token, error) {
@@ -44,6 +78,21 @@ func NewToken(userId user.UserID, ttl time.Duration, scope string) (*Token, erro
 	}, nil
 }
 
+// NewRefreshToken generates a refresh token starting a new family, that is
+// a new session.
+func NewRefreshToken(userID user.UserID, ttl time.Duration, client ClientInfo) (*Token, error) {
+	t, err := NewToken(userID, ttl, ScopeAuth)
+	if err != nil {
+		return nil, err
+	}
+
+	t.FamilyID = TokenID(uuid.New())
+	t.Client = client
+	t.SessionStartedAt = time.Now()
+
+	return t, nil
+}
+
 func HashPlaintext(plaintext string) []byte {
 	hash := sha256.Sum256([]byte(plaintext))
 	return hash[:]
@@ -55,6 +104,7 @@ func RehydrateToken(
 	userID user.UserID,
 	scope string,
 	revoked bool,
+	familyID TokenID,
 	expiresAt time.Time,
 	lastUsedAt *time.Time,
 	createdAt time.Time,
@@ -65,8 +115,53 @@ func RehydrateToken(
 		UserID:     userID,
 		Scope:      scope,
 		Revoked:    revoked,
+		FamilyID:   familyID,
 		ExpiresAt:  expiresAt,
 		LastUsedAt: lastUsedAt,
 		CreatedAt:  createdAt,
 	}
 }
+
+func RehydratePersonalAccessToken(
+	id TokenID,
+	userID user.UserID,
+	name string,
+	prefix string,
+	scopes []string,
+	revoked bool,
+	expiresAt *time.Time,
+	lastUsedAt *time.Time,
+	createdAt time.Time,
+) (*Token, error) {
+	n, err := NewName(name)
+	if err != nil {
+		return nil, fmt.Errorf("corrupt token name: %w", err)
+	}
+
+	accessScopes := make([]AccessScope, 0, len(scopes))
+	for _, v := range scopes {
+		s, err := NewAccessScope(v)
+		if err != nil {
+			return nil, fmt.Errorf("corrupt token scope: %w", err)
+		}
+		accessScopes = append(accessScopes, s)
+	}
+
+	t := &Token{
+		ID:           id,
+		UserID:       userID,
+		Scope:        ScopePersonalAccess,
+		Revoked:      revoked,
+		LastUsedAt:   lastUsedAt,
+		CreatedAt:    createdAt,
+		Name:         n,
+		Prefix:       prefix,
+		AccessScopes: accessScopes,
+	}
+
+	if expiresAt != nil {
+		t.ExpiresAt = *expiresAt
+	}
+
+	return t, nil
+}
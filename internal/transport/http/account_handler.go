@@ -0,0 +1,248 @@
+package http
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/raphico/go-device-telemetry-api/internal/account"
+	"github.com/raphico/go-device-telemetry-api/internal/auth"
+	"github.com/raphico/go-device-telemetry-api/internal/config"
+	"github.com/raphico/go-device-telemetry-api/internal/lockout"
+	"github.com/raphico/go-device-telemetry-api/internal/logger"
+	"github.com/raphico/go-device-telemetry-api/internal/mfa"
+	"github.com/raphico/go-device-telemetry-api/internal/organization"
+	"github.com/raphico/go-device-telemetry-api/internal/token"
+	"github.com/raphico/go-device-telemetry-api/internal/user"
+)
+
+type AccountHandler struct {
+	log     *logger.Logger
+	cfg     config.Config
+	auth    *auth.Service
+	account *account.Service
+}
+
+func NewAccountHandler(
+	log *logger.Logger,
+	cfg config.Config,
+	authService *auth.Service,
+	accountService *account.Service,
+) *AccountHandler {
+	return &AccountHandler{
+		log:     log,
+		cfg:     cfg,
+		auth:    authService,
+		account: accountService,
+	}
+}
+
+type profileResponse struct {
+	ID            string    `json:"id"`
+	Username      string    `json:"username"`
+	Email         string    `json:"email"`
+	EmailVerified bool      `json:"email_verified"`
+	PendingEmail  *string   `json:"pending_email"`
+	CreatedAt     time.Time `json:"created_at"`
+	UpdatedAt     time.Time `json:"updated_at"`
+}
+
+type updateProfileRequest struct {
+	Username *string `json:"username"`
+	Email    *string `json:"email"`
+	// CurrentPassword is required to change the email of an account that
+	// has a password.
+	CurrentPassword string `json:"current_password"`
+}
+
+type deleteAccountRequest struct {
+	Password string `json:"password"`
+	Code     string `json:"code"`
+}
+
+func (h *AccountHandler) HandleGetProfile(w http.ResponseWriter, r *http.Request) {
+	userId, ok := GetUserID(r.Context())
+	if !ok {
+		h.log.Debug(fmt.Sprint("missing user id in context", "path", r.URL.Path))
+		WriteUnauthorizedError(w)
+		return
+	}
+
+	u, err := h.auth.Profile(r.Context(), userId)
+	if err != nil {
+		switch {
+		case errors.Is(err, user.ErrUserNotFound):
+			WriteJSONError(w, http.StatusUnauthorized, unauthorized, "User does not exist")
+		default:
+			h.log.Error(fmt.Sprintf("failed to get profile: %v", err))
+			WriteInternalError(w)
+		}
+		return
+	}
+
+	WriteJSON(w, http.StatusOK, toProfileResponse(u), nil)
+}
+
+func (h *AccountHandler) HandleUpdateProfile(w http.ResponseWriter, r *http.Request) {
+	userId, ok := GetUserID(r.Context())
+	if !ok {
+		h.log.Debug(fmt.Sprint("missing user id in context", "path", r.URL.Path))
+		WriteUnauthorizedError(w)
+		return
+	}
+
+	var req updateProfileRequest
+	if !DecodeJSON(w, r, &req, maxAuthBodyBytes) {
+		return
+	}
+
+	if req.Username == nil && req.Email == nil {
+		WriteJSONError(w, http.StatusBadRequest, invalidRequest, "username or email is required")
+		return
+	}
+
+	var username *user.Username
+	if req.Username != nil {
+		v, err := user.NewUsername(*req.Username)
+		if err != nil {
+			WriteJSONError(w, http.StatusBadRequest, invalidRequest, err.Error())
+			return
+		}
+		username = &v
+	}
+
+	var email *user.Email
+	if req.Email != nil {
+		v, err := user.NewEmail(*req.Email)
+		if err != nil {
+			WriteJSONError(w, http.StatusBadRequest, invalidRequest, err.Error())
+			return
+		}
+		email = &v
+	}
+
+	accessToken := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+
+	client := token.NewClientInfo(r.UserAgent(), clientIP(r))
+
+	u, err := h.auth.UpdateProfile(r.Context(), userId, accessToken, username, email, req.CurrentPassword, client)
+	if err != nil {
+		var locked *lockout.LockedError
+		switch {
+		case errors.Is(err, user.ErrInvalidCredentials):
+			WriteJSONError(w, http.StatusBadRequest, invalidRequest, "current password is incorrect")
+		case errors.Is(err, auth.ErrRecentLoginRequired):
+			WriteJSONError(w, http.StatusForbidden, forbidden, "Log in again to change the email address")
+		case errors.As(err, &locked):
+			writeRateLimited(w, locked.RetryAfter, "Too many failed attempts; try again later")
+		case errors.Is(err, user.ErrEmailAlreadyExists),
+			errors.Is(err, user.ErrUsernameTaken):
+			WriteJSONError(w, http.StatusConflict, conflict, err.Error())
+		case errors.Is(err, user.ErrUserNotFound):
+			WriteJSONError(w, http.StatusUnauthorized, unauthorized, "User does not exist")
+		default:
+			h.log.Error(fmt.Sprintf("failed to update profile: %v", err))
+			WriteInternalError(w)
+		}
+		return
+	}
+
+	WriteJSON(w, http.StatusOK, toProfileResponse(u), nil)
+}
+
+// HandleDeleteAccount deletes the caller's account after checking their
+// password and, with two-factor authentication on, a TOTP or recovery code.
+func (h *AccountHandler) HandleDeleteAccount(w http.ResponseWriter, r *http.Request) {
+	userId, ok := GetUserID(r.Context())
+	if !ok {
+		h.log.Debug(fmt.Sprint("missing user id in context", "path", r.URL.Path))
+		WriteUnauthorizedError(w)
+		return
+	}
+
+	var req deleteAccountRequest
+	if !DecodeJSON(w, r, &req, maxAuthBodyBytes) {
+		return
+	}
+
+	accessToken := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+
+	client := token.NewClientInfo(r.UserAgent(), clientIP(r))
+
+	if err := h.auth.DeleteAccount(r.Context(), userId, accessToken, req.Password, req.Code, client); err != nil {
+		var locked *lockout.LockedError
+		switch {
+		case errors.Is(err, user.ErrInvalidCredentials):
+			WriteJSONError(w, http.StatusBadRequest, invalidRequest, "password is incorrect")
+		case errors.Is(err, mfa.ErrInvalidCode):
+			WriteJSONError(w, http.StatusBadRequest, invalidRequest, err.Error())
+		case errors.Is(err, auth.ErrRecentLoginRequired):
+			WriteJSONError(w, http.StatusForbidden, forbidden, "Log in again to delete the account")
+		case errors.As(err, &locked):
+			writeRateLimited(w, locked.RetryAfter, "Too many failed attempts; try again later")
+		case errors.Is(err, organization.ErrLastOwner):
+			WriteJSONError(w, http.StatusConflict, conflict, err.Error())
+		case errors.Is(err, user.ErrUserNotFound):
+			WriteJSONError(w, http.StatusUnauthorized, unauthorized, "User does not exist")
+		default:
+			h.log.Error(fmt.Sprintf("failed to delete account: %v", err))
+			WriteInternalError(w)
+		}
+		return
+	}
+
+	clearRefreshCookie(w, h.cfg)
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// HandleExportAccount streams everything the caller owns as NDJSON.
+func (h *AccountHandler) HandleExportAccount(w http.ResponseWriter, r *http.Request) {
+	userId, ok := GetUserID(r.Context())
+	if !ok {
+		h.log.Debug(fmt.Sprint("missing user id in context", "path", r.URL.Path))
+		WriteUnauthorizedError(w)
+		return
+	}
+
+	// a large account takes longer than the server's write timeout and the
+	// router's request timeout allow, so the export gets its own deadline;
+	// a client that goes away still ends it through the failing writes
+	deadline := time.Now().Add(h.cfg.AccountExportTimeout)
+	if err := http.NewResponseController(w).SetWriteDeadline(deadline); err != nil {
+		h.log.Error(fmt.Sprintf("failed to extend account export deadline: %v", err))
+	}
+
+	ctx, cancel := context.WithDeadline(context.WithoutCancel(r.Context()), deadline)
+	defer cancel()
+
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.Header().Set("Content-Disposition", `attachment; filename="account.ndjson"`)
+	w.WriteHeader(http.StatusOK)
+
+	// headers are already on the wire, so a failure can only be logged
+	if err := h.account.Export(ctx, userId, w); err != nil {
+		h.log.Error(fmt.Sprintf("failed to export account: %v", err))
+	}
+}
+
+func toProfileResponse(u *user.User) profileResponse {
+	var pendingEmail *string
+	if u.PendingEmail != nil {
+		v := u.PendingEmail.String()
+		pendingEmail = &v
+	}
+
+	return profileResponse{
+		ID:            u.ID.String(),
+		Username:      u.Username.String(),
+		Email:         u.Email.String(),
+		EmailVerified: u.IsEmailVerified(),
+		PendingEmail:  pendingEmail,
+		CreatedAt:     u.CreatedAt,
+		UpdatedAt:     u.UpdatedAt,
+	}
+}
@@ -0,0 +1,59 @@
+package http
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/raphico/go-device-telemetry-api/internal/logger"
+	"github.com/raphico/go-device-telemetry-api/internal/token"
+)
+
+type AdminHandler struct {
+	log   *logger.Logger
+	token *token.Service
+}
+
+func NewAdminHandler(log *logger.Logger, tokenService *token.Service) *AdminHandler {
+	return &AdminHandler{
+		log:   log,
+		token: tokenService,
+	}
+}
+
+type revokeAccessTokenRequest struct {
+	Token string `json:"token"`
+	JTI   string `json:"jti"`
+}
+
+func (h *AdminHandler) HandleRevokeAccessToken(w http.ResponseWriter, r *http.Request) {
+	userId, ok := GetUserID(r.Context())
+	if !ok {
+		h.log.Debug(fmt.Sprint("missing user id in context", "path", r.URL.Path))
+		WriteUnauthorizedError(w)
+		return
+	}
+
+	var req revokeAccessTokenRequest
+	if !DecodeJSON(w, r, &req, maxAuthBodyBytes) {
+		return
+	}
+
+	if (req.Token == "") == (req.JTI == "") {
+		WriteJSONError(w, http.StatusBadRequest, invalidRequest, "exactly one of token or jti is required")
+		return
+	}
+
+	if err := h.token.RevokeAccessToken(r.Context(), req.Token, req.JTI, userId); err != nil {
+		switch {
+		case errors.Is(err, token.ErrInvalidToken):
+			WriteJSONError(w, http.StatusBadRequest, invalidRequest, "token is invalid or already expired")
+		default:
+			h.log.Error(fmt.Sprintf("failed to revoke access token: %v", err))
+			WriteInternalError(w)
+		}
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -1,14 +1,16 @@
 package http
 
 import (
-	"encoding/json"
 	"errors"
 	"fmt"
+	"html/template"
 	"net/http"
+	"strings"
 	"time"
 
 	"github.com/raphico/go-device-telemetry-api/internal/auth"
 	"github.com/raphico/go-device-telemetry-api/internal/config"
+	"github.com/raphico/go-device-telemetry-api/internal/lockout"
 	"github.com/raphico/go-device-telemetry-api/internal/logger"
 	"github.com/raphico/go-device-telemetry-api/internal/token"
 	"github.com/raphico/go-device-telemetry-api/internal/user"
@@ -46,8 +48,7 @@ type registerUserResponse struct {
 
 func (h *AuthHandler) HandleRegisterUser(w http.ResponseWriter, r *http.Request) {
 	var req registerUserRequest
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		WriteJSONError(w, http.StatusBadRequest, invalidRequest, "Invalid request body")
+	if !DecodeJSON(w, r, &req, maxAuthBodyBytes) {
 		return
 	}
 
@@ -101,10 +102,25 @@ type tokenResponse struct {
 	ExpiresIn   int    `json:"expires_in"`
 }
 
+type mfaChallengeResponse struct {
+	MFARequired bool   `json:"mfa_required"`
+	MFAToken    string `json:"mfa_token"`
+	ExpiresIn   int    `json:"expires_in"`
+}
+
+func writeMFAChallenge(w http.ResponseWriter, challenge *token.Token) {
+	resp := mfaChallengeResponse{
+		MFARequired: true,
+		MFAToken:    challenge.Plaintext,
+		ExpiresIn:   int(time.Until(challenge.ExpiresAt).Seconds()),
+	}
+
+	WriteJSON(w, http.StatusOK, resp, nil)
+}
+
 func (h *AuthHandler) HandleLoginUser(w http.ResponseWriter, r *http.Request) {
 	var req loginUserRequest
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		WriteJSONError(w, http.StatusBadRequest, invalidRequest, "Invalid request body")
+	if !DecodeJSON(w, r, &req, maxAuthBodyBytes) {
 		return
 	}
 
@@ -114,31 +130,35 @@ func (h *AuthHandler) HandleLoginUser(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	accessToken, refreshToken, err := h.auth.Login(r.Context(), email, req.Password)
+	client := token.NewClientInfo(r.UserAgent(), clientIP(r))
+
+	result, err := h.auth.Login(r.Context(), email, req.Password, client)
 	if err != nil {
 		if errors.Is(err, user.ErrInvalidCredentials) {
 			WriteJSONError(w, http.StatusUnauthorized, invalidRequest, "Invalid credentials")
 			return
 		}
 
+		var locked *lockout.LockedError
+		if errors.As(err, &locked) {
+			writeRateLimited(w, locked.RetryAfter, "Too many failed login attempts; try again later")
+			return
+		}
+
 		h.log.Error(fmt.Sprintf("failed to authenticate user: %v", err))
 		WriteInternalError(w)
 		return
 	}
 
-	cookie := &http.Cookie{
-		Name:     "refresh_token",
-		Value:    refreshToken.Plaintext,
-		Path:     "/",
-		HttpOnly: true,
-		Expires:  refreshToken.ExpiresAt,
-		SameSite: http.SameSiteLaxMode,
-		Secure:   h.cfg.Env == "production",
+	if result.MFAChallenge != nil {
+		writeMFAChallenge(w, result.MFAChallenge)
+		return
 	}
-	http.SetCookie(w, cookie)
+
+	setRefreshCookie(w, h.cfg, result.RefreshToken)
 
 	resp := tokenResponse{
-		AccessToken: accessToken,
+		AccessToken: result.AccessToken,
 		ExpiresIn:   int(h.cfg.AccessTokenTTL.Seconds()),
 	}
 
@@ -153,11 +173,16 @@ func (h *AuthHandler) HandleRefreshAccessToken(w http.ResponseWriter, r *http.Re
 	}
 
 	refreshTok := cookie.Value
-	accessToken, refreshToken, err := h.auth.Refresh(r.Context(), refreshTok)
+	client := token.NewClientInfo(r.UserAgent(), clientIP(r))
+
+	accessToken, refreshToken, err := h.auth.Refresh(r.Context(), refreshTok, client)
 	if err != nil {
 		switch {
 		case errors.Is(err, token.ErrTokenNotFound):
 			WriteJSONError(w, http.StatusUnauthorized, unauthorized, "Invalid or expired refresh token")
+		case errors.Is(err, token.ErrTokenReused):
+			clearRefreshCookie(w, h.cfg)
+			WriteJSONError(w, http.StatusUnauthorized, unauthorized, "Refresh token was already used; log in again")
 		default:
 			h.log.Error(fmt.Sprintf("failed to refresh access token: %v", err))
 			WriteInternalError(w)
@@ -165,16 +190,7 @@ func (h *AuthHandler) HandleRefreshAccessToken(w http.ResponseWriter, r *http.Re
 		return
 	}
 
-	cookie = &http.Cookie{
-		Name:     "refresh_token",
-		Value:    refreshToken.Plaintext,
-		Path:     "/",
-		HttpOnly: true,
-		Expires:  refreshToken.ExpiresAt,
-		SameSite: http.SameSiteLaxMode,
-		Secure:   h.cfg.Env == "production",
-	}
-	http.SetCookie(w, cookie)
+	setRefreshCookie(w, h.cfg, refreshToken)
 
 	resp := tokenResponse{
 		AccessToken: accessToken,
@@ -185,14 +201,21 @@ func (h *AuthHandler) HandleRefreshAccessToken(w http.ResponseWriter, r *http.Re
 }
 
 func (h *AuthHandler) HandleLogoutUser(w http.ResponseWriter, r *http.Request) {
-	cookie, err := r.Cookie("refresh_token")
-	if err != nil {
-		w.WriteHeader(http.StatusNoContent)
+	// personal access tokens are not part of a session and are refused here
+	if _, ok := GetUserID(r.Context()); !ok {
+		h.log.Debug(fmt.Sprint("missing user id in context", "path", r.URL.Path))
+		WriteUnauthorizedError(w)
 		return
 	}
 
-	refreshToken := cookie.Value
-	err = h.auth.Logout(r.Context(), refreshToken)
+	accessToken := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+
+	var refreshToken string
+	if cookie, err := r.Cookie("refresh_token"); err == nil {
+		refreshToken = cookie.Value
+	}
+
+	err := h.auth.Logout(r.Context(), refreshToken, accessToken)
 	if err != nil {
 		switch {
 		case errors.Is(err, token.ErrTokenNotFound):
@@ -204,6 +227,24 @@ func (h *AuthHandler) HandleLogoutUser(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	clearRefreshCookie(w, h.cfg)
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func setRefreshCookie(w http.ResponseWriter, cfg config.Config, refreshToken *token.Token) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     "refresh_token",
+		Value:    refreshToken.Plaintext,
+		Path:     "/",
+		HttpOnly: true,
+		Expires:  refreshToken.ExpiresAt,
+		SameSite: http.SameSiteLaxMode,
+		Secure:   cfg.Env == "production",
+	})
+}
+
+func clearRefreshCookie(w http.ResponseWriter, cfg config.Config) {
 	http.SetCookie(w, &http.Cookie{
 		Name:     "refresh_token",
 		Value:    "",
@@ -211,8 +252,255 @@ func (h *AuthHandler) HandleLogoutUser(w http.ResponseWriter, r *http.Request) {
 		SameSite: http.SameSiteLaxMode,
 		HttpOnly: true,
 		Expires:  time.Unix(0, 0),
-		Secure:   h.cfg.Env == "production",
+		Secure:   cfg.Env == "production",
 	})
+}
+
+type verifyEmailRequest struct {
+	Token string `json:"token"`
+}
+
+// verifyEmailPage confirms a verification link with a button instead of on
+// open, so mail scanners and link previews that fetch it do not use it up.
+var verifyEmailPage = template.Must(template.New("verify-email").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>Verify your email address</title>
+</head>
+<body>
+{{if .Token}}<form method="post">
+<input type="hidden" name="token" value="{{.Token}}">
+<button type="submit">Verify my email address</button>
+</form>{{else}}<p>{{.Message}}</p>{{end}}
+</body>
+</html>
+`))
+
+type verifyEmailPageData struct {
+	Token   string
+	Message string
+}
+
+// HandleVerifyEmailPage is what the emailed link opens. It only shows a
+// form posting the token back; opening the link verifies nothing.
+func (h *AuthHandler) HandleVerifyEmailPage(w http.ResponseWriter, r *http.Request) {
+	data := verifyEmailPageData{Token: r.URL.Query().Get("token")}
+	if data.Token == "" {
+		data.Message = "This verification link is incomplete."
+	}
+
+	writeVerifyEmailPage(w, http.StatusOK, data)
+}
+
+// HandleVerifyEmail consumes a verification token posted as JSON by clients
+// that handle the link themselves, or by the form HandleVerifyEmailPage
+// serves, which gets a page back instead of JSON.
+func (h *AuthHandler) HandleVerifyEmail(w http.ResponseWriter, r *http.Request) {
+	fromForm := strings.HasPrefix(r.Header.Get("Content-Type"), "application/x-www-form-urlencoded")
+
+	var plaintext string
+	if fromForm {
+		r.Body = http.MaxBytesReader(w, r.Body, maxAuthBodyBytes)
+		if err := r.ParseForm(); err != nil {
+			writeDecodeError(w, err)
+			return
+		}
+		plaintext = r.PostForm.Get("token")
+	} else {
+		var req verifyEmailRequest
+		if !DecodeJSON(w, r, &req, maxAuthBodyBytes) {
+			return
+		}
+		plaintext = req.Token
+	}
+
+	if plaintext == "" {
+		if fromForm {
+			writeVerifyEmailPage(w, http.StatusBadRequest, verifyEmailPageData{Message: "This verification link is incomplete."})
+			return
+		}
+		WriteJSONError(w, http.StatusBadRequest, invalidRequest, "token is required")
+		return
+	}
+
+	if err := h.auth.VerifyEmail(r.Context(), plaintext); err != nil {
+		switch {
+		case errors.Is(err, token.ErrTokenNotFound), errors.Is(err, user.ErrUserNotFound):
+			if fromForm {
+				writeVerifyEmailPage(w, http.StatusBadRequest, verifyEmailPageData{
+					Message: "This verification link is invalid or has expired. Request a new one and try again.",
+				})
+				return
+			}
+			WriteJSONError(w, http.StatusBadRequest, invalidRequest, "Invalid or expired verification token")
+		case errors.Is(err, user.ErrEmailAlreadyExists):
+			if fromForm {
+				writeVerifyEmailPage(w, http.StatusConflict, verifyEmailPageData{
+					Message: "This email address is already used by another account.",
+				})
+				return
+			}
+			WriteJSONError(w, http.StatusConflict, conflict, err.Error())
+		default:
+			h.log.Error(fmt.Sprintf("failed to verify email: %v", err))
+			WriteInternalError(w)
+		}
+		return
+	}
+
+	if fromForm {
+		writeVerifyEmailPage(w, http.StatusOK, verifyEmailPageData{Message: "Your email address is verified."})
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func writeVerifyEmailPage(w http.ResponseWriter, status int, data verifyEmailPageData) {
+	h := w.Header()
+	h.Set("Content-Type", "text/html; charset=utf-8")
+	h.Set("Cache-Control", "no-store")
+	// the page URL carries the token
+	h.Set("Referrer-Policy", "no-referrer")
+	h.Set("X-Frame-Options", "DENY")
+	w.WriteHeader(status)
+
+	_ = verifyEmailPage.Execute(w, data)
+}
+
+type resendVerificationRequest struct {
+	Email string `json:"email"`
+}
+
+// HandleResendVerification always answers 202 for a well-formed email so
+// the response does not reveal whether an account exists.
+func (h *AuthHandler) HandleResendVerification(w http.ResponseWriter, r *http.Request) {
+	var req resendVerificationRequest
+	if !DecodeJSON(w, r, &req, maxAuthBodyBytes) {
+		return
+	}
+
+	email, err := user.NewEmail(req.Email)
+	if err != nil {
+		WriteJSONError(w, http.StatusBadRequest, invalidRequest, err.Error())
+		return
+	}
+
+	h.auth.ResendVerification(r.Context(), email)
+
+	w.WriteHeader(http.StatusAccepted)
+}
+
+type forgotPasswordRequest struct {
+	Email string `json:"email"`
+}
+
+// HandleForgotPassword answers 202 whether or not the email is registered.
+func (h *AuthHandler) HandleForgotPassword(w http.ResponseWriter, r *http.Request) {
+	var req forgotPasswordRequest
+	if !DecodeJSON(w, r, &req, maxAuthBodyBytes) {
+		return
+	}
+
+	email, err := user.NewEmail(req.Email)
+	if err != nil {
+		WriteJSONError(w, http.StatusBadRequest, invalidRequest, err.Error())
+		return
+	}
+
+	h.auth.ForgotPassword(r.Context(), email)
+
+	w.WriteHeader(http.StatusAccepted)
+}
+
+type resetPasswordRequest struct {
+	Token    string `json:"token"`
+	Password string `json:"password"`
+}
+
+func (h *AuthHandler) HandleResetPassword(w http.ResponseWriter, r *http.Request) {
+	var req resetPasswordRequest
+	if !DecodeJSON(w, r, &req, maxAuthBodyBytes) {
+		return
+	}
+
+	if req.Token == "" {
+		WriteJSONError(w, http.StatusBadRequest, invalidRequest, "token is required")
+		return
+	}
+
+	password, err := user.NewPassword(req.Password)
+	if err != nil {
+		WriteJSONError(w, http.StatusBadRequest, invalidRequest, err.Error())
+		return
+	}
+
+	if err := h.auth.ResetPassword(r.Context(), req.Token, password); err != nil {
+		switch {
+		case errors.Is(err, token.ErrTokenNotFound), errors.Is(err, user.ErrUserNotFound):
+			WriteJSONError(w, http.StatusBadRequest, invalidRequest, "Invalid or expired reset token")
+		default:
+			h.log.Error(fmt.Sprintf("failed to reset password: %v", err))
+			WriteInternalError(w)
+		}
+		return
+	}
+
+	clearRefreshCookie(w, h.cfg)
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+type changePasswordRequest struct {
+	CurrentPassword string `json:"current_password"`
+	NewPassword     string `json:"new_password"`
+}
+
+func (h *AuthHandler) HandleChangePassword(w http.ResponseWriter, r *http.Request) {
+	userId, ok := GetUserID(r.Context())
+	if !ok {
+		h.log.Debug(fmt.Sprint("missing user id in context", "path", r.URL.Path))
+		WriteUnauthorizedError(w)
+		return
+	}
+
+	var req changePasswordRequest
+	if !DecodeJSON(w, r, &req, maxAuthBodyBytes) {
+		return
+	}
+
+	if req.CurrentPassword == "" {
+		WriteJSONError(w, http.StatusBadRequest, invalidRequest, "current_password is required")
+		return
+	}
+
+	newPassword, err := user.NewPassword(req.NewPassword)
+	if err != nil {
+		WriteJSONError(w, http.StatusBadRequest, invalidRequest, err.Error())
+		return
+	}
+
+	client := token.NewClientInfo(r.UserAgent(), clientIP(r))
+
+	if err := h.auth.ChangePassword(r.Context(), userId, req.CurrentPassword, newPassword, client); err != nil {
+		var locked *lockout.LockedError
+		switch {
+		case errors.Is(err, user.ErrInvalidCredentials):
+			WriteJSONError(w, http.StatusBadRequest, invalidRequest, "current password is incorrect")
+		case errors.As(err, &locked):
+			writeRateLimited(w, locked.RetryAfter, "Too many failed attempts; try again later")
+		case errors.Is(err, user.ErrUserNotFound):
+			WriteJSONError(w, http.StatusUnauthorized, unauthorized, "User does not exist")
+		default:
+			h.log.Error(fmt.Sprintf("failed to change password: %v", err))
+			WriteInternalError(w)
+		}
+		return
+	}
+
+	clearRefreshCookie(w, h.cfg)
 
 	w.WriteHeader(http.StatusNoContent)
 }
@@ -0,0 +1,214 @@
+package http
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"mime"
+	"net/http"
+	"reflect"
+	"strings"
+
+	"github.com/fxamacker/cbor/v2"
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+// codec is a wire format request and response bodies can be exchanged in.
+// CBOR and MessagePack reuse the json struct tags, so every handler type
+// works in all of them; protobuf needs a schema and is handled per route.
+// Decoding is strict in every format: unknown fields and anything after the
+// first value are rejected.
+type codec struct {
+	contentType string
+	decode      func(r io.Reader, v any) error
+	encode      func(w io.Writer, v any) error
+}
+
+var (
+	jsonCodec = &codec{
+		contentType: "application/json",
+		decode: func(r io.Reader, v any) error {
+			dec := json.NewDecoder(r)
+			dec.DisallowUnknownFields()
+			if err := dec.Decode(v); err != nil {
+				return err
+			}
+			return checkTrailingJSON(dec)
+		},
+		encode: func(w io.Writer, v any) error {
+			return json.NewEncoder(w).Encode(v)
+		},
+	}
+
+	cborCodec = &codec{
+		contentType: "application/cbor",
+		decode: func(r io.Reader, v any) error {
+			data, err := io.ReadAll(r)
+			if err != nil {
+				return err
+			}
+			if len(data) == 0 {
+				return io.EOF
+			}
+			// Unmarshal, unlike Decoder, fails on data after the first item
+			var extra *cbor.ExtraneousDataError
+			if err := cborDecMode.Unmarshal(data, v); errors.As(err, &extra) {
+				return errTrailingData
+			} else if err != nil {
+				return err
+			}
+			return nil
+		},
+		encode: func(w io.Writer, v any) error {
+			return cborEncMode.NewEncoder(w).Encode(v)
+		},
+	}
+
+	msgpackCodec = &codec{
+		contentType: "application/msgpack",
+		decode: func(r io.Reader, v any) error {
+			data, err := io.ReadAll(r)
+			if err != nil {
+				return err
+			}
+			br := bytes.NewReader(data)
+			dec := msgpack.NewDecoder(br)
+			dec.SetCustomStructTag("json")
+			dec.DisallowUnknownFields(true)
+			if err := dec.Decode(v); err != nil {
+				return err
+			}
+			if br.Len() > 0 {
+				return errTrailingData
+			}
+			return nil
+		},
+		encode: func(w io.Writer, v any) error {
+			enc := msgpack.NewEncoder(w)
+			enc.SetCustomStructTag("json")
+			enc.SetOmitEmpty(true)
+			return enc.Encode(v)
+		},
+	}
+
+	// protobufCodec only marks the format; messages are encoded by the
+	// routes that have a schema, see telemetry_proto.go.
+	protobufCodec = &codec{
+		contentType: "application/x-protobuf",
+		decode: func(io.Reader, any) error {
+			return errUnsupportedMediaType
+		},
+		encode: func(io.Writer, any) error {
+			return errUnsupportedMediaType
+		},
+	}
+
+	// decode nested maps as map[string]any so payloads validate the same way
+	// as JSON ones
+	cborDecMode, _ = cbor.DecOptions{
+		DefaultMapType:    reflect.TypeOf(map[string]any(nil)),
+		ExtraReturnErrors: cbor.ExtraDecErrorUnknownField,
+	}.DecMode()
+
+	cborEncMode, _ = cbor.EncOptions{
+		Time: cbor.TimeRFC3339Nano,
+	}.EncMode()
+
+	errUnsupportedMediaType = errors.New("unsupported media type")
+	errTrailingData         = errors.New("request body must contain a single value")
+)
+
+func codecForMediaType(mediaType string) (*codec, bool) {
+	switch mediaType {
+	case "application/json":
+		return jsonCodec, true
+	case "application/cbor":
+		return cborCodec, true
+	case "application/msgpack", "application/x-msgpack", "application/vnd.msgpack":
+		return msgpackCodec, true
+	case "application/x-protobuf", "application/protobuf", "application/vnd.google.protobuf":
+		return protobufCodec, true
+	default:
+		return nil, false
+	}
+}
+
+// requestCodec returns the codec for the request's Content-Type, treating a
+// missing header as JSON.
+func requestCodec(r *http.Request) (*codec, error) {
+	ct := r.Header.Get("Content-Type")
+	if ct == "" {
+		return jsonCodec, nil
+	}
+
+	mediaType, _, err := mime.ParseMediaType(ct)
+	if err != nil {
+		return nil, errUnsupportedMediaType
+	}
+
+	c, ok := codecForMediaType(mediaType)
+	if !ok {
+		return nil, errUnsupportedMediaType
+	}
+
+	return c, nil
+}
+
+// negotiateCodec picks the first acceptable response codec from Accept,
+// falling back to JSON for wildcards or a missing header. Protobuf is only
+// offered by routes with a published schema.
+func negotiateCodec(r *http.Request, protobuf bool) (*codec, bool) {
+	accept := r.Header.Get("Accept")
+	if accept == "" {
+		return jsonCodec, true
+	}
+
+	for _, part := range strings.Split(accept, ",") {
+		mediaType, _, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+
+		if mediaType == "*/*" || mediaType == "application/*" {
+			return jsonCodec, true
+		}
+
+		c, ok := codecForMediaType(mediaType)
+		if !ok || (c == protobufCodec && !protobuf) {
+			continue
+		}
+
+		return c, true
+	}
+
+	return nil, false
+}
+
+// writeNotAcceptable lists the formats negotiateCodec would have accepted,
+// protobuf only on routes that offer it.
+func writeNotAcceptable(w http.ResponseWriter, protobuf bool) {
+	formats := "application/json, application/cbor and application/msgpack"
+	if protobuf {
+		formats = "application/json, application/cbor, application/msgpack and application/x-protobuf"
+	}
+
+	WriteJSONError(w, http.StatusNotAcceptable, invalidRequest, "supported formats are "+formats)
+}
+
+// WriteEncoded is WriteJSON for a negotiated codec.
+func WriteEncoded(w http.ResponseWriter, c *codec, status int, data any, meta any) {
+	w.Header().Set("Content-Type", c.contentType)
+	w.Header().Add("Vary", "Accept")
+	w.WriteHeader(status)
+
+	res := responseEnvelope{
+		Success: true,
+		Data:    data,
+		Meta:    meta,
+	}
+
+	if err := c.encode(w, res); err != nil {
+		http.Error(w, "internal server error", http.StatusInternalServerError)
+	}
+}
@@ -1,7 +1,6 @@
 package http
 
 import (
-	"encoding/json"
 	"errors"
 	"fmt"
 	"net/http"
@@ -9,6 +8,7 @@ import (
 	"time"
 
 	"github.com/go-chi/chi/v5"
+	"github.com/raphico/go-device-telemetry-api/internal/authz"
 	"github.com/raphico/go-device-telemetry-api/internal/command"
 	"github.com/raphico/go-device-telemetry-api/internal/common/pagination"
 	"github.com/raphico/go-device-telemetry-api/internal/device"
@@ -41,6 +41,13 @@ type commandResponse struct {
 }
 
 func (h *CommandHandler) HandleCreateCommand(w http.ResponseWriter, r *http.Request) {
+	userId, ok := GetUserID(r.Context())
+	if !ok {
+		h.log.Debug(fmt.Sprint("missing user id in context", "path", r.URL.Path))
+		WriteUnauthorizedError(w)
+		return
+	}
+
 	deviceIDStr := chi.URLParam(r, "device_id")
 	deviceID, err := device.NewDeviceID(deviceIDStr)
 	if err != nil {
@@ -49,8 +56,7 @@ func (h *CommandHandler) HandleCreateCommand(w http.ResponseWriter, r *http.Requ
 	}
 
 	var req createCommandRequest
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		WriteJSONError(w, http.StatusBadRequest, invalidRequest, "invalid request body")
+	if !DecodeJSON(w, r, &req, maxResourceBodyBytes) {
 		return
 	}
 
@@ -66,11 +72,13 @@ func (h *CommandHandler) HandleCreateCommand(w http.ResponseWriter, r *http.Requ
 		return
 	}
 
-	cmd, err := h.command.CreateCommand(r.Context(), deviceID, commandName, payload)
+	cmd, err := h.command.CreateCommand(r.Context(), authz.NewActor(userId), deviceID, commandName, payload)
 	if err != nil {
 		switch {
 		case errors.Is(err, device.ErrDeviceNotFound):
 			WriteJSONError(w, http.StatusNotFound, notfound, "device not found")
+		case errors.Is(err, authz.ErrForbidden):
+			WriteJSONError(w, http.StatusForbidden, forbidden, "your role does not allow sending commands to this device")
 		default:
 			h.log.Error(fmt.Sprintf("failed to add command: %v", err))
 			WriteInternalError(w)
@@ -93,6 +101,13 @@ func (h *CommandHandler) HandleCreateCommand(w http.ResponseWriter, r *http.Requ
 }
 
 func (h *CommandHandler) HandleGetDeviceCommands(w http.ResponseWriter, r *http.Request) {
+	userId, ok := GetUserID(r.Context())
+	if !ok {
+		h.log.Debug(fmt.Sprint("missing user id in context", "path", r.URL.Path))
+		WriteUnauthorizedError(w)
+		return
+	}
+
 	deviceIDStr := chi.URLParam(r, "device_id")
 	deviceID, err := device.NewDeviceID(deviceIDStr)
 	if err != nil {
@@ -100,6 +115,12 @@ func (h *CommandHandler) HandleGetDeviceCommands(w http.ResponseWriter, r *http.
 		return
 	}
 
+	resCodec, ok := negotiateCodec(r, false)
+	if !ok {
+		writeNotAcceptable(w, false)
+		return
+	}
+
 	limit := pagination.DefaultLimit
 	if limitStr := r.URL.Query().Get("limit"); limitStr != "" {
 		if v, err := strconv.Atoi(limitStr); err != nil || v < 0 {
@@ -120,10 +141,17 @@ func (h *CommandHandler) HandleGetDeviceCommands(w http.ResponseWriter, r *http.
 		}
 	}
 
-	commands, next, err := h.command.ListDeviceCommands(r.Context(), deviceID, limit, cur)
+	commands, next, err := h.command.ListDeviceCommands(r.Context(), authz.NewActor(userId), deviceID, limit, cur)
 	if err != nil {
-		h.log.Error(fmt.Sprintf("failed to get device commands: %v", err))
-		WriteInternalError(w)
+		switch {
+		case errors.Is(err, device.ErrDeviceNotFound):
+			WriteJSONError(w, http.StatusNotFound, notfound, "device not found")
+		case errors.Is(err, authz.ErrForbidden):
+			WriteJSONError(w, http.StatusForbidden, forbidden, "your role does not allow reading this device's commands")
+		default:
+			h.log.Error(fmt.Sprintf("failed to get device commands: %v", err))
+			WriteInternalError(w)
+		}
 		return
 	}
 
@@ -151,7 +179,7 @@ func (h *CommandHandler) HandleGetDeviceCommands(w http.ResponseWriter, r *http.
 		Limit:      limit,
 	}
 
-	WriteJSON(w, http.StatusOK, out, meta)
+	WriteEncoded(w, resCodec, http.StatusOK, out, meta)
 }
 
 type updateCommandStatusRequest struct {
@@ -160,6 +188,13 @@ type updateCommandStatusRequest struct {
 }
 
 func (h *CommandHandler) HandleUpdateCommandStatus(w http.ResponseWriter, r *http.Request) {
+	userId, ok := GetUserID(r.Context())
+	if !ok {
+		h.log.Debug(fmt.Sprint("missing user id in context", "path", r.URL.Path))
+		WriteUnauthorizedError(w)
+		return
+	}
+
 	deviceIDStr := chi.URLParam(r, "device_id")
 	deviceID, err := device.NewDeviceID(deviceIDStr)
 	if err != nil {
@@ -175,8 +210,7 @@ func (h *CommandHandler) HandleUpdateCommandStatus(w http.ResponseWriter, r *htt
 	}
 
 	var req updateCommandStatusRequest
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		WriteJSONError(w, http.StatusBadRequest, invalidRequest, "Invalid request body")
+	if !DecodeJSON(w, r, &req, maxResourceBodyBytes) {
 		return
 	}
 
@@ -192,11 +226,22 @@ func (h *CommandHandler) HandleUpdateCommandStatus(w http.ResponseWriter, r *htt
 		return
 	}
 
-	cmd, err := h.command.UpdateCommandStatus(r.Context(), commandID, deviceID, status, executedAt)
+	cmd, err := h.command.UpdateCommandStatus(
+		r.Context(),
+		authz.NewActor(userId),
+		commandID,
+		deviceID,
+		status,
+		executedAt,
+	)
 	if err != nil {
 		switch {
+		case errors.Is(err, device.ErrDeviceNotFound):
+			WriteJSONError(w, http.StatusNotFound, notfound, "device not found")
 		case errors.Is(err, command.ErrCommandNotFound):
 			WriteJSONError(w, http.StatusNotFound, notfound, "command not found")
+		case errors.Is(err, authz.ErrForbidden):
+			WriteJSONError(w, http.StatusForbidden, forbidden, "your role does not allow updating this device's commands")
 		default:
 			h.log.Error(fmt.Sprintf("failed to update command: %v", err))
 			WriteInternalError(w)
@@ -0,0 +1,155 @@
+package http
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	chimw "github.com/go-chi/chi/v5/middleware"
+	"github.com/klauspost/compress/zstd"
+	"github.com/raphico/go-device-telemetry-api/internal/logger"
+)
+
+const (
+	// compressionLevel is the gzip level handed to the compressor; zstd maps
+	// it to the nearest encoder level.
+	compressionLevel = 5
+	// maxZstdWindow bounds the decoder window so a crafted frame header
+	// cannot make it allocate more than this up front.
+	maxZstdWindow = 8 << 20
+)
+
+// compressibleTypes are the response types worth compressing. Export
+// artifacts are served outside the compressor so range requests keep
+// working.
+var compressibleTypes = []string{
+	"application/json",
+	"application/cbor",
+	"application/msgpack",
+	"application/x-protobuf",
+	"application/x-ndjson",
+	"text/csv",
+}
+
+type CompressionMiddleware struct {
+	maxDecompressedBytes int64
+	compressor           *chimw.Compressor
+}
+
+func NewCompressionMiddleware(log *logger.Logger, maxDecompressedBytes int64) *CompressionMiddleware {
+	compressor := chimw.NewCompressor(compressionLevel, compressibleTypes...)
+
+	// chi labels a response with the encoding it picked before the encoder
+	// runs, so zstd is only offered once an encoder has been built; without
+	// it clients get gzip, deflate or identity, whichever else they accept
+	if enc, err := newZstdEncoder(io.Discard, compressionLevel); err != nil {
+		log.Error(fmt.Sprintf("zstd response compression disabled: %v", err))
+	} else {
+		_ = enc.Close()
+		compressor.SetEncoder("zstd", encoderZstd)
+	}
+
+	return &CompressionMiddleware{
+		maxDecompressedBytes: maxDecompressedBytes,
+		compressor:           compressor,
+	}
+}
+
+// Decompress transparently unpacks gzip and zstd request bodies. The
+// decompressed stream is capped at maxDecompressedBytes, so a small body
+// that inflates into gigabytes fails with a read error instead of filling
+// memory.
+func (cm *CompressionMiddleware) Decompress(next http.Handler) http.Handler {
+	return cm.decompress(next, cm.maxDecompressedBytes)
+}
+
+// DecompressLimit is Decompress with its own cap, for routes that accept
+// larger bodies than the rest of the API.
+func (cm *CompressionMiddleware) DecompressLimit(maxBytes int64) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return cm.decompress(next, maxBytes)
+	}
+}
+
+func (cm *CompressionMiddleware) decompress(next http.Handler, maxBytes int64) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		encoding := strings.ToLower(strings.TrimSpace(r.Header.Get("Content-Encoding")))
+
+		var body io.ReadCloser
+		switch encoding {
+		case "", "identity":
+			next.ServeHTTP(w, r)
+			return
+		case "gzip", "x-gzip":
+			gz, err := gzip.NewReader(r.Body)
+			if err != nil {
+				WriteJSONError(w, http.StatusBadRequest, invalidRequest, "invalid gzip body")
+				return
+			}
+			body = gz
+		case "zstd":
+			zr, err := zstd.NewReader(
+				r.Body,
+				zstd.WithDecoderConcurrency(1),
+				zstd.WithDecoderMaxWindow(maxZstdWindow),
+				zstd.WithDecoderMaxMemory(uint64(maxBytes)),
+			)
+			if err != nil {
+				WriteJSONError(w, http.StatusBadRequest, invalidRequest, "invalid zstd body")
+				return
+			}
+			body = zr.IOReadCloser()
+		default:
+			WriteJSONError(w, http.StatusUnsupportedMediaType, invalidRequest, "unsupported content encoding")
+			return
+		}
+		defer func() { _ = body.Close() }()
+
+		r.Body = http.MaxBytesReader(w, body, maxBytes)
+		r.Header.Del("Content-Encoding")
+		r.Header.Del("Content-Length")
+		r.ContentLength = -1
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// Compress encodes compressible responses with zstd when Accept-Encoding
+// allows it, falling back to gzip and then deflate.
+func (cm *CompressionMiddleware) Compress(next http.Handler) http.Handler {
+	return cm.compressor.Handler(next)
+}
+
+func newZstdEncoder(w io.Writer, level int) (*zstd.Encoder, error) {
+	return zstd.NewWriter(
+		w,
+		zstd.WithEncoderLevel(zstd.EncoderLevelFromZstd(level)),
+		zstd.WithEncoderConcurrency(1),
+	)
+}
+
+// encoderZstd is only registered once newZstdEncoder has succeeded with the
+// same options, so it should not fail. If it does, the response fails too:
+// chi would send a nil encoder's output uncompressed yet labelled zstd, or
+// panic when pooling it.
+func encoderZstd(w io.Writer, level int) io.Writer {
+	enc, err := newZstdEncoder(w, level)
+	if err != nil {
+		return errWriter{err: err}
+	}
+	return enc
+}
+
+// errWriter fails every write with err. Reset lets chi pool it like the
+// encoder it stands in for.
+type errWriter struct {
+	err error
+}
+
+func (e errWriter) Write([]byte) (int, error) {
+	return 0, e.err
+}
+
+func (e errWriter) Reset(io.Writer) {}
@@ -0,0 +1,124 @@
+package http
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/fxamacker/cbor/v2"
+	"github.com/klauspost/compress/zstd"
+)
+
+// Per-route request body limits. They apply to the decoded body, after any
+// Content-Encoding has been undone.
+const (
+	// maxAuthBodyBytes covers credentials and other tiny bodies.
+	maxAuthBodyBytes = 16 << 10
+	// maxResourceBodyBytes covers devices, commands and exports, whose
+	// metadata and payload fields are free-form JSON.
+	maxResourceBodyBytes = 256 << 10
+	// maxTelemetryBodyBytes covers a single reading.
+	maxTelemetryBodyBytes = 256 << 10
+	// maxBatchBodyBytes covers a batch of readings in any format.
+	maxBatchBodyBytes = 4 << 20
+	// maxImportBodyBytes covers an uploaded import file.
+	maxImportBodyBytes = 1 << 30
+)
+
+// DecodeJSON is DecodeBody for routes that only take JSON.
+func DecodeJSON(w http.ResponseWriter, r *http.Request, v any, maxBytes int64) bool {
+	return DecodeBody(w, r, jsonCodec, v, maxBytes)
+}
+
+// DecodeBody reads at most maxBytes of the request body into v with codec c.
+// On failure it writes a 413 or 400 error and returns false, so handlers can
+// simply return.
+func DecodeBody(w http.ResponseWriter, r *http.Request, c *codec, v any, maxBytes int64) bool {
+	r.Body = http.MaxBytesReader(w, r.Body, maxBytes)
+
+	if err := c.decode(r.Body, v); err != nil {
+		writeDecodeError(w, err)
+		return false
+	}
+
+	return true
+}
+
+// readBody reads a whole request body of at most maxBytes for routes that
+// decode it themselves.
+func readBody(w http.ResponseWriter, r *http.Request, maxBytes int64) ([]byte, error) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxBytes)
+	return io.ReadAll(r.Body)
+}
+
+// writeDecodeError turns a body read or decode failure into a 413 when a
+// size limit was hit and a 400 describing the problem otherwise.
+func writeDecodeError(w http.ResponseWriter, err error) {
+	if isBodyTooLarge(err) {
+		writeBodyTooLarge(w, err)
+		return
+	}
+
+	WriteJSONError(w, http.StatusBadRequest, invalidRequest, decodeErrorMessage(err))
+}
+
+func isBodyTooLarge(err error) bool {
+	var maxErr *http.MaxBytesError
+	return errors.As(err, &maxErr) || errors.Is(err, zstd.ErrDecoderSizeExceeded)
+}
+
+func writeBodyTooLarge(w http.ResponseWriter, err error) {
+	msg := "request body too large"
+
+	var maxErr *http.MaxBytesError
+	if errors.As(err, &maxErr) {
+		msg = fmt.Sprintf("request body must not exceed %d bytes", maxErr.Limit)
+	}
+
+	WriteJSONError(w, http.StatusRequestEntityTooLarge, tooLarge, msg)
+}
+
+func decodeErrorMessage(err error) string {
+	var (
+		syntaxErr    *json.SyntaxError
+		typeErr      *json.UnmarshalTypeError
+		cborFieldErr *cbor.UnknownFieldError
+	)
+
+	switch {
+	case errors.Is(err, io.EOF):
+		return "request body must not be empty"
+	case errors.Is(err, io.ErrUnexpectedEOF):
+		return "request body is truncated"
+	case errors.Is(err, errTrailingData):
+		return err.Error()
+	case errors.As(err, &syntaxErr):
+		return fmt.Sprintf("request body is malformed at byte %d", syntaxErr.Offset)
+	case errors.As(err, &typeErr) && typeErr.Field != "":
+		return fmt.Sprintf("field %q must be of type %s", typeErr.Field, typeErr.Type)
+	case errors.As(err, &cborFieldErr):
+		return "request body has an unknown field"
+	case strings.Contains(err.Error(), ": unknown field "):
+		// json and msgpack only expose this as text
+		_, field, _ := strings.Cut(err.Error(), ": unknown field ")
+		return "unknown field " + field
+	default:
+		return "invalid request body"
+	}
+}
+
+// checkTrailingJSON rejects anything but whitespace after the decoded value.
+func checkTrailingJSON(dec *json.Decoder) error {
+	_, err := dec.Token()
+	switch {
+	case errors.Is(err, io.EOF):
+		return nil
+	case isBodyTooLarge(err):
+		return err
+	default:
+		return errTrailingData
+	}
+}
@@ -1,17 +1,18 @@
 package http
 
 import (
-	"encoding/json"
 	"errors"
 	"fmt"
 	"net/http"
 	"strconv"
+	"time"
 
 	"github.com/go-chi/chi/v5"
+	"github.com/raphico/go-device-telemetry-api/internal/authz"
 	"github.com/raphico/go-device-telemetry-api/internal/common/pagination"
 	"github.com/raphico/go-device-telemetry-api/internal/device"
 	"github.com/raphico/go-device-telemetry-api/internal/logger"
-	"github.com/raphico/go-device-telemetry-api/internal/user"
+	"github.com/raphico/go-device-telemetry-api/internal/organization"
 )
 
 type DeviceHandler struct {
@@ -27,25 +28,32 @@ func NewDeviceHandler(log *logger.Logger, deviceService *device.Service) *Device
 }
 
 type createDeviceRequest struct {
-	Name       string `json:"name"`
-	DeviceType string `json:"device_type"`
-	Status     string `json:"status"`
-	Metadata   any    `json:"metadata"`
+	OrganizationID string `json:"organization_id"`
+	Name           string `json:"name"`
+	DeviceType     string `json:"device_type"`
+	Status         string `json:"status"`
+	Metadata       any    `json:"metadata"`
 }
 
 type deviceResponse struct {
-	ID         string         `json:"id"`
-	Name       string         `json:"name"`
-	DeviceType string         `json:"device_type"`
-	Status     string         `json:"status"`
-	Metadata   map[string]any `json:"metadata"`
+	ID             string             `json:"id"`
+	OrganizationID string             `json:"organization_id"`
+	Name           string             `json:"name"`
+	DeviceType     string             `json:"device_type"`
+	Status         string             `json:"status"`
+	Metadata       map[string]any     `json:"metadata"`
+	ClockSkew      *clockSkewResponse `json:"clock_skew"`
+}
+
+type clockSkewResponse struct {
+	OffsetMs    int64     `json:"offset_ms"`
+	EstimatedAt time.Time `json:"estimated_at"`
 }
 
 func (h *DeviceHandler) HandleCreateDevice(w http.ResponseWriter, r *http.Request) {
 	var req createDeviceRequest
 
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		WriteJSONError(w, http.StatusBadRequest, invalidRequest, "Invalid request body")
+	if !DecodeJSON(w, r, &req, maxResourceBodyBytes) {
 		return
 	}
 
@@ -56,6 +64,16 @@ func (h *DeviceHandler) HandleCreateDevice(w http.ResponseWriter, r *http.Reques
 		return
 	}
 
+	var orgID *organization.OrganizationID
+	if req.OrganizationID != "" {
+		id, err := organization.NewOrganizationID(req.OrganizationID)
+		if err != nil {
+			WriteJSONError(w, http.StatusBadRequest, invalidRequest, "invalid organization id")
+			return
+		}
+		orgID = &id
+	}
+
 	name, err := device.NewName(req.Name)
 	if err != nil {
 		WriteJSONError(w, http.StatusBadRequest, invalidRequest, err.Error())
@@ -80,11 +98,15 @@ func (h *DeviceHandler) HandleCreateDevice(w http.ResponseWriter, r *http.Reques
 		return
 	}
 
-	dev, err := h.device.CreateDevice(r.Context(), userId, name, status, deviceType, metadata)
+	dev, err := h.device.CreateDevice(r.Context(), authz.NewActor(userId), orgID, name, status, deviceType, metadata)
 	if err != nil {
 		switch {
-		case errors.Is(err, user.ErrUserNotFound):
-			WriteJSONError(w, http.StatusUnauthorized, unauthorized, "User does not exist")
+		case errors.Is(err, organization.ErrOrganizationNotFound):
+			WriteJSONError(w, http.StatusNotFound, notfound, "organization not found")
+		case errors.Is(err, organization.ErrOrganizationRequired):
+			WriteJSONError(w, http.StatusBadRequest, invalidRequest, err.Error())
+		case errors.Is(err, authz.ErrForbidden):
+			WriteJSONError(w, http.StatusForbidden, forbidden, "your role does not allow adding devices to this organization")
 		default:
 			h.log.Error(fmt.Sprint("failed to create device", "error", err))
 			WriteInternalError(w)
@@ -92,13 +114,7 @@ func (h *DeviceHandler) HandleCreateDevice(w http.ResponseWriter, r *http.Reques
 		return
 	}
 
-	res := deviceResponse{
-		ID:         dev.ID.String(),
-		Name:       dev.Name.String(),
-		DeviceType: dev.DeviceType.String(),
-		Status:     dev.Status.String(),
-		Metadata:   dev.Metadata,
-	}
+	res := toDeviceResponse(dev)
 
 	WriteJSON(w, http.StatusCreated, res, nil)
 }
@@ -118,7 +134,7 @@ func (h *DeviceHandler) HandleGetDevice(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	dev, err := h.device.GetDevice(r.Context(), deviceID, userId)
+	dev, err := h.device.GetDevice(r.Context(), authz.NewActor(userId), deviceID)
 	if err != nil {
 		switch {
 		case errors.Is(err, device.ErrDeviceNotFound):
@@ -130,13 +146,7 @@ func (h *DeviceHandler) HandleGetDevice(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	res := deviceResponse{
-		ID:         dev.ID.String(),
-		Name:       dev.Name.String(),
-		DeviceType: dev.DeviceType.String(),
-		Status:     dev.Status.String(),
-		Metadata:   dev.Metadata,
-	}
+	res := toDeviceResponse(dev)
 
 	WriteJSON(w, http.StatusOK, res, nil)
 }
@@ -149,6 +159,12 @@ func (h *DeviceHandler) HandleListDevices(w http.ResponseWriter, r *http.Request
 		return
 	}
 
+	resCodec, ok := negotiateCodec(r, false)
+	if !ok {
+		writeNotAcceptable(w, false)
+		return
+	}
+
 	limit := pagination.DefaultLimit
 	if limitStr := r.URL.Query().Get("limit"); limitStr != "" {
 		if v, err := strconv.Atoi(limitStr); err != nil || v < 0 {
@@ -169,21 +185,31 @@ func (h *DeviceHandler) HandleListDevices(w http.ResponseWriter, r *http.Request
 		}
 	}
 
-	devs, next, err := h.device.ListUserDevices(r.Context(), userId, limit, cur)
+	var orgID *organization.OrganizationID
+	if v := r.URL.Query().Get("organization_id"); v != "" {
+		id, err := organization.NewOrganizationID(v)
+		if err != nil {
+			WriteJSONError(w, http.StatusBadRequest, invalidRequest, "invalid organization id")
+			return
+		}
+		orgID = &id
+	}
+
+	devs, next, err := h.device.ListDevices(r.Context(), authz.NewActor(userId), orgID, limit, cur)
 	if err != nil {
-		WriteInternalError(w)
+		switch {
+		case errors.Is(err, organization.ErrOrganizationNotFound):
+			WriteJSONError(w, http.StatusNotFound, notfound, "organization not found")
+		default:
+			h.log.Error(fmt.Sprintf("failed to list devices: %v", err))
+			WriteInternalError(w)
+		}
 		return
 	}
 
 	out := make([]deviceResponse, 0, len(devs))
 	for _, d := range devs {
-		out = append(out, deviceResponse{
-			ID:         d.ID.String(),
-			Name:       d.Name.String(),
-			DeviceType: d.DeviceType.String(),
-			Status:     d.Status.String(),
-			Metadata:   d.Metadata,
-		})
+		out = append(out, toDeviceResponse(d))
 	}
 
 	var nextStr string
@@ -197,7 +223,7 @@ func (h *DeviceHandler) HandleListDevices(w http.ResponseWriter, r *http.Request
 		Limit:      limit,
 	}
 
-	WriteJSON(w, http.StatusOK, out, meta)
+	WriteEncoded(w, resCodec, http.StatusOK, out, meta)
 }
 
 type updateDeviceRequest struct {
@@ -222,8 +248,7 @@ func (h *DeviceHandler) HandleUpdateDevice(w http.ResponseWriter, r *http.Reques
 	}
 
 	var req updateDeviceRequest
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		WriteJSONError(w, http.StatusBadRequest, invalidRequest, "Invalid request body")
+	if !DecodeJSON(w, r, &req, maxResourceBodyBytes) {
 		return
 	}
 
@@ -260,11 +285,13 @@ func (h *DeviceHandler) HandleUpdateDevice(w http.ResponseWriter, r *http.Reques
 		update.Metadata = &m
 	}
 
-	dev, err := h.device.UpdateDevice(r.Context(), deviceID, userId, update)
+	dev, err := h.device.UpdateDevice(r.Context(), authz.NewActor(userId), deviceID, update)
 	if err != nil {
 		switch {
 		case errors.Is(err, device.ErrDeviceNotFound):
 			WriteJSONError(w, http.StatusNotFound, notfound, "device not found")
+		case errors.Is(err, authz.ErrForbidden):
+			WriteJSONError(w, http.StatusForbidden, forbidden, "your role does not allow changing this device")
 		default:
 			h.log.Error(fmt.Sprintf("failed to update device: %v", err))
 			WriteInternalError(w)
@@ -272,13 +299,27 @@ func (h *DeviceHandler) HandleUpdateDevice(w http.ResponseWriter, r *http.Reques
 		return
 	}
 
+	res := toDeviceResponse(dev)
+
+	WriteJSON(w, http.StatusOK, res, nil)
+}
+
+func toDeviceResponse(dev *device.Device) deviceResponse {
 	res := deviceResponse{
-		ID:         dev.ID.String(),
-		Name:       dev.Name.String(),
-		DeviceType: dev.DeviceType.String(),
-		Status:     dev.Status.String(),
-		Metadata:   dev.Metadata,
+		ID:             dev.ID.String(),
+		OrganizationID: dev.OrganizationID.String(),
+		Name:           dev.Name.String(),
+		DeviceType:     dev.DeviceType.String(),
+		Status:         dev.Status.String(),
+		Metadata:       dev.Metadata,
 	}
 
-	WriteJSON(w, http.StatusOK, res, nil)
+	if dev.ClockSkew != nil {
+		res.ClockSkew = &clockSkewResponse{
+			OffsetMs:    dev.ClockSkew.Offset.Milliseconds(),
+			EstimatedAt: dev.ClockSkew.EstimatedAt,
+		}
+	}
+
+	return res
 }
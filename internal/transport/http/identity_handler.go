@@ -0,0 +1,259 @@
+package http
+
+import (
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/raphico/go-device-telemetry-api/internal/auth"
+	"github.com/raphico/go-device-telemetry-api/internal/config"
+	"github.com/raphico/go-device-telemetry-api/internal/identity"
+	"github.com/raphico/go-device-telemetry-api/internal/logger"
+	"github.com/raphico/go-device-telemetry-api/internal/token"
+	"github.com/raphico/go-device-telemetry-api/internal/user"
+)
+
+const (
+	oidcStateCookie = "oidc_state"
+	// oidcCallbackPath covers every provider's callback.
+	oidcCallbackPath = "/api/v1/auth/oidc"
+)
+
+type IdentityHandler struct {
+	log        *logger.Logger
+	cfg        config.Config
+	auth       *auth.Service
+	identities *identity.Service
+}
+
+func NewIdentityHandler(
+	log *logger.Logger,
+	cfg config.Config,
+	authService *auth.Service,
+	identityService *identity.Service,
+) *IdentityHandler {
+	return &IdentityHandler{
+		log:        log,
+		cfg:        cfg,
+		auth:       authService,
+		identities: identityService,
+	}
+}
+
+type identityResponse struct {
+	ID          string     `json:"id"`
+	Provider    string     `json:"provider"`
+	Subject     string     `json:"subject"`
+	Email       string     `json:"email"`
+	LastLoginAt *time.Time `json:"last_login_at"`
+	CreatedAt   time.Time  `json:"created_at"`
+}
+
+type authorizationURLResponse struct {
+	AuthorizationURL string `json:"authorization_url"`
+}
+
+func (h *IdentityHandler) HandleListProviders(w http.ResponseWriter, r *http.Request) {
+	WriteJSON(w, http.StatusOK, h.identities.Providers(), nil)
+}
+
+// HandleLogin redirects the browser to the provider to sign in.
+func (h *IdentityHandler) HandleLogin(w http.ResponseWriter, r *http.Request) {
+	authURL, state, err := h.auth.StartOIDCLogin(r.Context(), chi.URLParam(r, "provider"))
+	if err != nil {
+		h.writeError(w, err, "failed to start identity provider login")
+		return
+	}
+
+	h.setStateCookie(w, state)
+
+	http.Redirect(w, r, authURL, http.StatusFound)
+}
+
+// HandleCallback is where the provider sends the browser back. Sign-ins get
+// the same response as a password login; link requests get the identity.
+func (h *IdentityHandler) HandleCallback(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+
+	if providerErr := q.Get("error"); providerErr != "" {
+		msg := "identity provider returned " + providerErr
+		if desc := q.Get("error_description"); desc != "" {
+			msg += ": " + desc
+		}
+		WriteJSONError(w, http.StatusBadRequest, invalidRequest, msg)
+		return
+	}
+
+	state, code := q.Get("state"), q.Get("code")
+	if state == "" || code == "" {
+		WriteJSONError(w, http.StatusBadRequest, invalidRequest, "state and code are required")
+		return
+	}
+
+	// a state that did not start in this browser is someone else's login,
+	// possibly planted to sign the victim into the attacker's account
+	cookie, err := r.Cookie(oidcStateCookie)
+	h.clearStateCookie(w)
+	if err != nil || subtle.ConstantTimeCompare([]byte(cookie.Value), []byte(identity.StateBinding(state))) != 1 {
+		WriteJSONError(w, http.StatusBadRequest, invalidRequest, identity.ErrInvalidState.Error())
+		return
+	}
+
+	client := token.NewClientInfo(r.UserAgent(), clientIP(r))
+
+	result, err := h.auth.CompleteOIDC(r.Context(), chi.URLParam(r, "provider"), state, code, client)
+	if err != nil {
+		h.writeError(w, err, "failed to complete identity provider login")
+		return
+	}
+
+	if result.Linked != nil {
+		WriteJSON(w, http.StatusOK, toIdentityResponse(result.Linked), nil)
+		return
+	}
+
+	if result.MFAChallenge != nil {
+		writeMFAChallenge(w, result.MFAChallenge)
+		return
+	}
+
+	setRefreshCookie(w, h.cfg, result.RefreshToken)
+
+	resp := tokenResponse{
+		AccessToken: result.AccessToken,
+		ExpiresIn:   int(h.cfg.AccessTokenTTL.Seconds()),
+	}
+
+	WriteJSON(w, http.StatusOK, resp, nil)
+}
+
+func (h *IdentityHandler) HandleListIdentities(w http.ResponseWriter, r *http.Request) {
+	userId, ok := GetUserID(r.Context())
+	if !ok {
+		h.log.Debug(fmt.Sprint("missing user id in context", "path", r.URL.Path))
+		WriteUnauthorizedError(w)
+		return
+	}
+
+	identities, err := h.auth.ListIdentities(r.Context(), userId)
+	if err != nil {
+		h.log.Error(fmt.Sprintf("failed to list identities: %v", err))
+		WriteInternalError(w)
+		return
+	}
+
+	out := make([]identityResponse, 0, len(identities))
+	for _, i := range identities {
+		out = append(out, toIdentityResponse(i))
+	}
+
+	WriteJSON(w, http.StatusOK, out, nil)
+}
+
+// HandleStartLink returns the provider URL rather than redirecting, since
+// the browser cannot send the access token along on a redirect.
+func (h *IdentityHandler) HandleStartLink(w http.ResponseWriter, r *http.Request) {
+	userId, ok := GetUserID(r.Context())
+	if !ok {
+		h.log.Debug(fmt.Sprint("missing user id in context", "path", r.URL.Path))
+		WriteUnauthorizedError(w)
+		return
+	}
+
+	authURL, state, err := h.auth.StartOIDCLink(r.Context(), userId, chi.URLParam(r, "provider"))
+	if err != nil {
+		h.writeError(w, err, "failed to start identity link")
+		return
+	}
+
+	h.setStateCookie(w, state)
+
+	WriteJSON(w, http.StatusOK, authorizationURLResponse{AuthorizationURL: authURL}, nil)
+}
+
+func (h *IdentityHandler) HandleUnlink(w http.ResponseWriter, r *http.Request) {
+	userId, ok := GetUserID(r.Context())
+	if !ok {
+		h.log.Debug(fmt.Sprint("missing user id in context", "path", r.URL.Path))
+		WriteUnauthorizedError(w)
+		return
+	}
+
+	id, err := identity.NewIdentityID(chi.URLParam(r, "identity_id"))
+	if err != nil {
+		WriteJSONError(w, http.StatusBadRequest, invalidRequest, "invalid identity id")
+		return
+	}
+
+	if err := h.auth.UnlinkIdentity(r.Context(), userId, id); err != nil {
+		h.writeError(w, err, "failed to unlink identity")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// setStateCookie ties a login to the browser that starts it. The cookie
+// only travels to the callback, and SameSite=Lax still sends it on the
+// provider's top-level redirect back.
+func (h *IdentityHandler) setStateCookie(w http.ResponseWriter, state string) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     oidcStateCookie,
+		Value:    identity.StateBinding(state),
+		Path:     oidcCallbackPath,
+		HttpOnly: true,
+		MaxAge:   int(h.cfg.OIDCStateTTL.Seconds()),
+		SameSite: http.SameSiteLaxMode,
+		Secure:   h.cfg.Env == "production",
+	})
+}
+
+func (h *IdentityHandler) clearStateCookie(w http.ResponseWriter) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     oidcStateCookie,
+		Value:    "",
+		Path:     oidcCallbackPath,
+		HttpOnly: true,
+		MaxAge:   -1,
+		SameSite: http.SameSiteLaxMode,
+		Secure:   h.cfg.Env == "production",
+	})
+}
+
+func (h *IdentityHandler) writeError(w http.ResponseWriter, err error, logMsg string) {
+	switch {
+	case errors.Is(err, identity.ErrProviderNotFound):
+		WriteJSONError(w, http.StatusNotFound, notfound, err.Error())
+	case errors.Is(err, identity.ErrIdentityNotFound):
+		WriteJSONError(w, http.StatusNotFound, notfound, err.Error())
+	case errors.Is(err, identity.ErrInvalidState),
+		errors.Is(err, identity.ErrExchangeFailed),
+		errors.Is(err, identity.ErrEmailRequired):
+		WriteJSONError(w, http.StatusBadRequest, invalidRequest, err.Error())
+	case errors.Is(err, identity.ErrInvalidIDToken):
+		h.log.Info(fmt.Sprintf("%s: %v", logMsg, err))
+		WriteJSONError(w, http.StatusUnauthorized, unauthorized, "identity provider login failed")
+	case errors.Is(err, identity.ErrAccountExists),
+		errors.Is(err, identity.ErrIdentityLinked),
+		errors.Is(err, identity.ErrLastSignInMethod),
+		errors.Is(err, user.ErrEmailAlreadyExists):
+		WriteJSONError(w, http.StatusConflict, conflict, err.Error())
+	default:
+		h.log.Error(fmt.Sprintf("%s: %v", logMsg, err))
+		WriteInternalError(w)
+	}
+}
+
+func toIdentityResponse(i *identity.Identity) identityResponse {
+	return identityResponse{
+		ID:          i.ID.String(),
+		Provider:    i.Provider,
+		Subject:     i.Subject,
+		Email:       i.Email,
+		LastLoginAt: i.LastLoginAt,
+		CreatedAt:   i.CreatedAt,
+	}
+}
@@ -0,0 +1,42 @@
+package http
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/raphico/go-device-telemetry-api/internal/logger"
+	"github.com/raphico/go-device-telemetry-api/internal/token"
+)
+
+// jwksMaxAge is how long verifiers may cache the key set. A new signing key
+// should be published at least this long before tokens are signed with it.
+const jwksMaxAge = 300
+
+type JWKSHandler struct {
+	log   *logger.Logger
+	token *token.Service
+}
+
+func NewJWKSHandler(log *logger.Logger, tokenService *token.Service) *JWKSHandler {
+	return &JWKSHandler{
+		log:   log,
+		token: tokenService,
+	}
+}
+
+// HandleJWKS serves the key set as a bare JWK Set document rather than in
+// the API envelope, since that is what JWT libraries expect.
+func (h *JWKSHandler) HandleJWKS(w http.ResponseWriter, r *http.Request) {
+	body, err := json.Marshal(h.token.JWKS())
+	if err != nil {
+		h.log.Error(fmt.Sprintf("failed to encode jwks: %v", err))
+		WriteInternalError(w)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/jwk-set+json")
+	w.Header().Set("Cache-Control", fmt.Sprintf("public, max-age=%d", jwksMaxAge))
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write(body)
+}
@@ -0,0 +1,233 @@
+package http
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/raphico/go-device-telemetry-api/internal/auth"
+	"github.com/raphico/go-device-telemetry-api/internal/config"
+	"github.com/raphico/go-device-telemetry-api/internal/lockout"
+	"github.com/raphico/go-device-telemetry-api/internal/logger"
+	"github.com/raphico/go-device-telemetry-api/internal/mfa"
+	"github.com/raphico/go-device-telemetry-api/internal/token"
+	"github.com/raphico/go-device-telemetry-api/internal/user"
+)
+
+type MFAHandler struct {
+	log  *logger.Logger
+	cfg  config.Config
+	auth *auth.Service
+	mfa  *mfa.Service
+}
+
+func NewMFAHandler(
+	log *logger.Logger,
+	cfg config.Config,
+	authService *auth.Service,
+	mfaService *mfa.Service,
+) *MFAHandler {
+	return &MFAHandler{
+		log:  log,
+		cfg:  cfg,
+		auth: authService,
+		mfa:  mfaService,
+	}
+}
+
+type mfaStatusResponse struct {
+	Enabled                bool       `json:"enabled"`
+	EnabledAt              *time.Time `json:"enabled_at"`
+	RecoveryCodesRemaining int        `json:"recovery_codes_remaining"`
+}
+
+type mfaEnrollmentResponse struct {
+	Secret          string `json:"secret"`
+	ProvisioningURI string `json:"provisioning_uri"`
+}
+
+type mfaCodeRequest struct {
+	Code string `json:"code"`
+}
+
+type mfaRecoveryCodesResponse struct {
+	RecoveryCodes []string `json:"recovery_codes"`
+}
+
+type verifyMFARequest struct {
+	MFAToken string `json:"mfa_token"`
+	Code     string `json:"code"`
+}
+
+type disableMFARequest struct {
+	Password string `json:"password"`
+	Code     string `json:"code"`
+}
+
+// HandleVerifyMFA is the second step of a login for users with two-factor
+// authentication, answering the challenge from HandleLoginUser.
+func (h *MFAHandler) HandleVerifyMFA(w http.ResponseWriter, r *http.Request) {
+	var req verifyMFARequest
+	if !DecodeJSON(w, r, &req, maxAuthBodyBytes) {
+		return
+	}
+
+	if req.MFAToken == "" || req.Code == "" {
+		WriteJSONError(w, http.StatusBadRequest, invalidRequest, "mfa_token and code are required")
+		return
+	}
+
+	client := token.NewClientInfo(r.UserAgent(), clientIP(r))
+
+	accessToken, refreshToken, err := h.auth.VerifyMFA(r.Context(), req.MFAToken, req.Code, client)
+	if err != nil {
+		var locked *lockout.LockedError
+		switch {
+		case errors.As(err, &locked):
+			writeRateLimited(w, locked.RetryAfter, "Too many failed login attempts; try again later")
+		case errors.Is(err, token.ErrTokenNotFound):
+			WriteJSONError(w, http.StatusUnauthorized, unauthorized, "Invalid or expired MFA token")
+		case errors.Is(err, mfa.ErrInvalidCode), errors.Is(err, mfa.ErrNotEnrolled):
+			WriteJSONError(w, http.StatusUnauthorized, unauthorized, mfa.ErrInvalidCode.Error())
+		default:
+			h.log.Error(fmt.Sprintf("failed to verify mfa code: %v", err))
+			WriteInternalError(w)
+		}
+		return
+	}
+
+	setRefreshCookie(w, h.cfg, refreshToken)
+
+	resp := tokenResponse{
+		AccessToken: accessToken,
+		ExpiresIn:   int(h.cfg.AccessTokenTTL.Seconds()),
+	}
+
+	WriteJSON(w, http.StatusOK, resp, nil)
+}
+
+func (h *MFAHandler) HandleGetStatus(w http.ResponseWriter, r *http.Request) {
+	userId, ok := GetUserID(r.Context())
+	if !ok {
+		h.log.Debug(fmt.Sprint("missing user id in context", "path", r.URL.Path))
+		WriteUnauthorizedError(w)
+		return
+	}
+
+	status, err := h.mfa.Status(r.Context(), userId)
+	if err != nil {
+		h.log.Error(fmt.Sprintf("failed to get mfa status: %v", err))
+		WriteInternalError(w)
+		return
+	}
+
+	resp := mfaStatusResponse{
+		Enabled:                status.Enabled,
+		EnabledAt:              status.EnabledAt,
+		RecoveryCodesRemaining: status.RecoveryCodesRemaining,
+	}
+
+	WriteJSON(w, http.StatusOK, resp, nil)
+}
+
+func (h *MFAHandler) HandleEnroll(w http.ResponseWriter, r *http.Request) {
+	userId, ok := GetUserID(r.Context())
+	if !ok {
+		h.log.Debug(fmt.Sprint("missing user id in context", "path", r.URL.Path))
+		WriteUnauthorizedError(w)
+		return
+	}
+
+	enrollment, uri, err := h.auth.EnrollMFA(r.Context(), userId)
+	if err != nil {
+		switch {
+		case errors.Is(err, mfa.ErrAlreadyEnabled):
+			WriteJSONError(w, http.StatusConflict, conflict, err.Error())
+		case errors.Is(err, user.ErrUserNotFound):
+			WriteJSONError(w, http.StatusUnauthorized, unauthorized, "User does not exist")
+		default:
+			h.log.Error(fmt.Sprintf("failed to enroll mfa: %v", err))
+			WriteInternalError(w)
+		}
+		return
+	}
+
+	resp := mfaEnrollmentResponse{
+		Secret:          enrollment.Secret,
+		ProvisioningURI: uri,
+	}
+
+	WriteJSON(w, http.StatusCreated, resp, nil)
+}
+
+func (h *MFAHandler) HandleConfirm(w http.ResponseWriter, r *http.Request) {
+	userId, ok := GetUserID(r.Context())
+	if !ok {
+		h.log.Debug(fmt.Sprint("missing user id in context", "path", r.URL.Path))
+		WriteUnauthorizedError(w)
+		return
+	}
+
+	var req mfaCodeRequest
+	if !DecodeJSON(w, r, &req, maxAuthBodyBytes) {
+		return
+	}
+
+	codes, err := h.mfa.Confirm(r.Context(), userId, req.Code)
+	if err != nil {
+		switch {
+		case errors.Is(err, mfa.ErrInvalidCode), errors.Is(err, mfa.ErrNotEnrolled):
+			WriteJSONError(w, http.StatusBadRequest, invalidRequest, err.Error())
+		case errors.Is(err, mfa.ErrAlreadyEnabled):
+			WriteJSONError(w, http.StatusConflict, conflict, err.Error())
+		default:
+			h.log.Error(fmt.Sprintf("failed to confirm mfa: %v", err))
+			WriteInternalError(w)
+		}
+		return
+	}
+
+	WriteJSON(w, http.StatusOK, mfaRecoveryCodesResponse{RecoveryCodes: codes}, nil)
+}
+
+func (h *MFAHandler) HandleDisable(w http.ResponseWriter, r *http.Request) {
+	userId, ok := GetUserID(r.Context())
+	if !ok {
+		h.log.Debug(fmt.Sprint("missing user id in context", "path", r.URL.Path))
+		WriteUnauthorizedError(w)
+		return
+	}
+
+	var req disableMFARequest
+	if !DecodeJSON(w, r, &req, maxAuthBodyBytes) {
+		return
+	}
+
+	if req.Code == "" {
+		WriteJSONError(w, http.StatusBadRequest, invalidRequest, "code is required")
+		return
+	}
+
+	client := token.NewClientInfo(r.UserAgent(), clientIP(r))
+
+	if err := h.auth.DisableMFA(r.Context(), userId, req.Password, req.Code, client); err != nil {
+		var locked *lockout.LockedError
+		switch {
+		case errors.Is(err, user.ErrInvalidCredentials):
+			WriteJSONError(w, http.StatusBadRequest, invalidRequest, "password is incorrect")
+		case errors.As(err, &locked):
+			writeRateLimited(w, locked.RetryAfter, "Too many failed attempts; try again later")
+		case errors.Is(err, mfa.ErrInvalidCode), errors.Is(err, mfa.ErrNotEnrolled):
+			WriteJSONError(w, http.StatusBadRequest, invalidRequest, err.Error())
+		case errors.Is(err, user.ErrUserNotFound):
+			WriteJSONError(w, http.StatusUnauthorized, unauthorized, "User does not exist")
+		default:
+			h.log.Error(fmt.Sprintf("failed to disable mfa: %v", err))
+			WriteInternalError(w)
+		}
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -1,29 +1,56 @@
 package http
 
 import (
+	"bytes"
 	"context"
+	"errors"
 	"fmt"
+	"io"
 	"net/http"
+	"slices"
 	"strings"
 	"time"
 
 	chimw "github.com/go-chi/chi/v5/middleware"
+	"github.com/raphico/go-device-telemetry-api/internal/idempotency"
 	"github.com/raphico/go-device-telemetry-api/internal/logger"
 	"github.com/raphico/go-device-telemetry-api/internal/token"
 	"github.com/raphico/go-device-telemetry-api/internal/user"
 )
 
 type UserMiddleware struct {
-	tokenService *token.Service
+	log              *logger.Logger
+	tokenService     *token.Service
+	userService      *user.Service
+	unverifiedAccess user.UnverifiedAccess
 }
 
 type contextKey struct{}
 
-var userCtxKey = &contextKey{}
+var (
+	userCtxKey = &contextKey{}
+	patCtxKey  = &contextKey{}
+)
+
+// patAuth is what AuthMiddleware records for a personal access token. The
+// token only stands for its user once RequireScope has found the scope its
+// route declares, so routes that declare none refuse it.
+type patAuth struct {
+	userID user.UserID
+	scopes []token.AccessScope
+}
 
-func NewUserMiddleware(tokenService *token.Service) *UserMiddleware {
+func NewUserMiddleware(
+	log *logger.Logger,
+	tokenService *token.Service,
+	userService *user.Service,
+	unverifiedAccess user.UnverifiedAccess,
+) *UserMiddleware {
 	return &UserMiddleware{
-		tokenService: tokenService,
+		log:              log,
+		tokenService:     tokenService,
+		userService:      userService,
+		unverifiedAccess: unverifiedAccess,
 	}
 }
 
@@ -38,7 +65,23 @@ func (um *UserMiddleware) AuthMiddleware(next http.Handler) http.Handler {
 		}
 
 		accessToken := strings.TrimPrefix(authHeader, "Bearer ")
-		userId, err := um.tokenService.ValidateAccessToken(accessToken)
+
+		if token.IsPersonalAccessToken(accessToken) {
+			pat, err := um.tokenService.ValidatePersonalAccessToken(r.Context(), accessToken)
+			if err != nil {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			ctx := context.WithValue(r.Context(), patCtxKey, &patAuth{
+				userID: pat.UserID,
+				scopes: pat.AccessScopes,
+			})
+			next.ServeHTTP(w, r.WithContext(ctx))
+			return
+		}
+
+		userId, err := um.tokenService.ValidateAccessToken(r.Context(), accessToken)
 		if err != nil {
 			next.ServeHTTP(w, r)
 			return
@@ -49,9 +92,52 @@ func (um *UserMiddleware) AuthMiddleware(next http.Handler) http.Handler {
 	})
 }
 
+// RequireScope declares the scope a route needs from personal access tokens
+// and lets through the ones carrying it; it is the only way a token gets
+// past GetUserID. Sessions are not scoped; the caller's organization roles
+// still apply either way.
+func (um *UserMiddleware) RequireScope(scope token.AccessScope) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			pat, ok := r.Context().Value(patCtxKey).(*patAuth)
+			if !ok {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			if !slices.Contains(pat.scopes, scope) {
+				WriteJSONError(
+					w,
+					http.StatusForbidden,
+					forbidden,
+					fmt.Sprintf("access token is missing the %s scope", scope),
+				)
+				return
+			}
+
+			ctx := context.WithValue(r.Context(), userCtxKey, pat.userID)
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+// RequireSessionMiddleware keeps personal access tokens away from routes
+// that manage credentials, so a leaked token cannot mint or revoke others.
+// Routes without RequireScope refuse them anyway; this one says why.
+func (um *UserMiddleware) RequireSessionMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, ok := r.Context().Value(patCtxKey).(*patAuth); ok {
+			WriteJSONError(w, http.StatusForbidden, forbidden, "personal access tokens cannot be used here")
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
 func (um *UserMiddleware) RequireAuthMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		_, ok := r.Context().Value(userCtxKey).(user.UserID)
+		_, ok := authenticatedUserID(r.Context())
 		if !ok {
 			WriteJSONError(w, http.StatusUnauthorized, unauthorized, "authentication required")
 			return
@@ -61,11 +147,104 @@ func (um *UserMiddleware) RequireAuthMiddleware(next http.Handler) http.Handler
 	})
 }
 
+// RequireVerifiedMiddleware applies the unverified access policy: with
+// read_only, users who have not verified their email may only make safe
+// requests; with block, they get nothing. It must run after
+// RequireAuthMiddleware.
+func (um *UserMiddleware) RequireVerifiedMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if um.unverifiedAccess == user.UnverifiedAccessAllow {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if um.unverifiedAccess == user.UnverifiedAccessReadOnly && isSafeMethod(r.Method) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		userID, ok := authenticatedUserID(r.Context())
+		if !ok {
+			WriteUnauthorizedError(w)
+			return
+		}
+
+		u, err := um.userService.GetUser(r.Context(), userID)
+		if err != nil {
+			if errors.Is(err, user.ErrUserNotFound) {
+				WriteUnauthorizedError(w)
+				return
+			}
+			um.log.Error(fmt.Sprintf("failed to load user: %v", err))
+			WriteInternalError(w)
+			return
+		}
+
+		if !u.IsEmailVerified() {
+			WriteJSONError(w, http.StatusForbidden, emailNotVerified, "verify your email address to continue")
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// RequireAdminMiddleware limits a route to admins. It must run after
+// RequireAuthMiddleware.
+func (um *UserMiddleware) RequireAdminMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		userID, ok := GetUserID(r.Context())
+		if !ok {
+			WriteUnauthorizedError(w)
+			return
+		}
+
+		u, err := um.userService.GetUser(r.Context(), userID)
+		if err != nil {
+			if errors.Is(err, user.ErrUserNotFound) {
+				WriteUnauthorizedError(w)
+				return
+			}
+			um.log.Error(fmt.Sprintf("failed to load user: %v", err))
+			WriteInternalError(w)
+			return
+		}
+
+		if !u.IsAdmin {
+			WriteJSONError(w, http.StatusForbidden, forbidden, "admin access required")
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+func isSafeMethod(method string) bool {
+	return method == http.MethodGet || method == http.MethodHead || method == http.MethodOptions
+}
+
+// GetUserID returns the user a request acts for: the session's user, or a
+// personal access token's user on routes whose RequireScope it passed.
 func GetUserID(ctx context.Context) (user.UserID, bool) {
 	userID, ok := ctx.Value(userCtxKey).(user.UserID)
 	return userID, ok
 }
 
+// authenticatedUserID is GetUserID for middleware that runs before a
+// route's RequireScope, such as rate limits: it also returns the user of a
+// personal access token whose scope has not been checked yet.
+func authenticatedUserID(ctx context.Context) (user.UserID, bool) {
+	if userID, ok := GetUserID(ctx); ok {
+		return userID, true
+	}
+
+	if pat, ok := ctx.Value(patCtxKey).(*patAuth); ok {
+		return pat.userID, true
+	}
+
+	return user.UserID{}, false
+}
+
 func LoggingMiddleware(log *logger.Logger) func(http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -78,3 +257,144 @@ func LoggingMiddleware(log *logger.Logger) func(http.Handler) http.Handler {
 		})
 	}
 }
+
+// maxIdempotentBodyBytes bounds the request body hashed into the
+// fingerprint; idempotent routes only take small JSON bodies.
+const maxIdempotentBodyBytes = 1 << 20
+
+// replayedHeaders are the response headers stored alongside the body so a
+// replay looks like the original answer.
+var replayedHeaders = []string{"Content-Type", "Location"}
+
+type IdempotencyMiddleware struct {
+	log         *logger.Logger
+	idempotency *idempotency.Service
+}
+
+func NewIdempotencyMiddleware(log *logger.Logger, idempotency *idempotency.Service) *IdempotencyMiddleware {
+	return &IdempotencyMiddleware{
+		log:         log,
+		idempotency: idempotency,
+	}
+}
+
+// Handle makes a mutating route safe to retry: a request carrying an
+// Idempotency-Key runs once per user and key, and retries get the stored
+// response back. Server errors are not stored, so those can be retried.
+func (im *IdempotencyMiddleware) Handle(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rawKey := r.Header.Get("Idempotency-Key")
+		if rawKey == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		userID, ok := GetUserID(r.Context())
+		if !ok {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		key, err := idempotency.NewKey(rawKey)
+		if err != nil {
+			WriteJSONError(w, http.StatusBadRequest, invalidRequest, err.Error())
+			return
+		}
+
+		body, err := readBody(w, r, maxIdempotentBodyBytes)
+		if err != nil {
+			writeDecodeError(w, err)
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+
+		fingerprint := idempotency.NewFingerprint(r.Method, r.URL.RequestURI(), body)
+
+		stored, err := im.idempotency.Begin(r.Context(), userID, key, fingerprint)
+		if err != nil {
+			switch {
+			case errors.Is(err, idempotency.ErrFingerprintMismatch):
+				WriteJSONError(w, http.StatusConflict, conflict, "Idempotency-Key was already used with a different request")
+			case errors.Is(err, idempotency.ErrRequestInProgress):
+				WriteJSONError(w, http.StatusConflict, conflict, "a request with this Idempotency-Key is still in progress")
+			default:
+				im.log.Error(fmt.Sprintf("failed to reserve idempotency key: %v", err))
+				WriteInternalError(w)
+			}
+			return
+		}
+
+		if stored != nil {
+			for name, value := range stored.Headers {
+				w.Header().Set(name, value)
+			}
+			w.Header().Set("Idempotent-Replayed", "true")
+			w.WriteHeader(stored.StatusCode)
+			_, _ = w.Write(stored.Body)
+			return
+		}
+
+		// the request context may be cancelled by the time the outcome is
+		// recorded, and a reservation left behind would block retries
+		ctx := context.WithoutCancel(r.Context())
+		rec := &responseRecorder{ResponseWriter: w, status: http.StatusOK}
+
+		defer func() {
+			if p := recover(); p != nil {
+				im.release(ctx, userID, key)
+				panic(p)
+			}
+
+			if rec.status >= http.StatusInternalServerError {
+				im.release(ctx, userID, key)
+				return
+			}
+
+			res := &idempotency.Response{
+				StatusCode: rec.status,
+				Headers:    make(map[string]string),
+				Body:       rec.body.Bytes(),
+			}
+			for _, name := range replayedHeaders {
+				if v := w.Header().Get(name); v != "" {
+					res.Headers[name] = v
+				}
+			}
+
+			if err := im.idempotency.Complete(ctx, userID, key, res); err != nil {
+				im.log.Error(fmt.Sprintf("failed to store idempotent response: %v", err))
+			}
+		}()
+
+		next.ServeHTTP(rec, r)
+	})
+}
+
+func (im *IdempotencyMiddleware) release(ctx context.Context, userID user.UserID, key idempotency.Key) {
+	if err := im.idempotency.Release(ctx, userID, key); err != nil {
+		im.log.Error(fmt.Sprintf("failed to release idempotency key: %v", err))
+	}
+}
+
+// responseRecorder passes the response through while keeping a copy of the
+// status and body.
+type responseRecorder struct {
+	http.ResponseWriter
+	status      int
+	body        bytes.Buffer
+	wroteHeader bool
+}
+
+func (rr *responseRecorder) WriteHeader(status int) {
+	if !rr.wroteHeader {
+		rr.status = status
+		rr.wroteHeader = true
+	}
+	rr.ResponseWriter.WriteHeader(status)
+}
+
+func (rr *responseRecorder) Write(b []byte) (int, error) {
+	rr.wroteHeader = true
+	rr.body.Write(b)
+	return rr.ResponseWriter.Write(b)
+}
@@ -0,0 +1,307 @@
+package http
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/raphico/go-device-telemetry-api/internal/authz"
+	"github.com/raphico/go-device-telemetry-api/internal/logger"
+	"github.com/raphico/go-device-telemetry-api/internal/organization"
+	"github.com/raphico/go-device-telemetry-api/internal/user"
+)
+
+type OrganizationHandler struct {
+	log           *logger.Logger
+	organizations *organization.Service
+}
+
+func NewOrganizationHandler(log *logger.Logger, organizations *organization.Service) *OrganizationHandler {
+	return &OrganizationHandler{
+		log:           log,
+		organizations: organizations,
+	}
+}
+
+type createOrganizationRequest struct {
+	Name string `json:"name"`
+}
+
+type organizationResponse struct {
+	ID        string    `json:"id"`
+	Name      string    `json:"name"`
+	Role      string    `json:"role"` // the caller's role
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type addMemberRequest struct {
+	Email string `json:"email"`
+	Role  string `json:"role"`
+}
+
+type updateMemberRequest struct {
+	Role string `json:"role"`
+}
+
+type memberResponse struct {
+	UserID    string    `json:"user_id"`
+	Role      string    `json:"role"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+func (h *OrganizationHandler) HandleCreateOrganization(w http.ResponseWriter, r *http.Request) {
+	userId, ok := GetUserID(r.Context())
+	if !ok {
+		h.log.Debug(fmt.Sprint("missing user id in context", "path", r.URL.Path))
+		WriteUnauthorizedError(w)
+		return
+	}
+
+	var req createOrganizationRequest
+	if !DecodeJSON(w, r, &req, maxResourceBodyBytes) {
+		return
+	}
+
+	name, err := organization.NewName(req.Name)
+	if err != nil {
+		WriteJSONError(w, http.StatusBadRequest, invalidRequest, err.Error())
+		return
+	}
+
+	org, err := h.organizations.CreateOrganization(r.Context(), authz.NewActor(userId), name)
+	if err != nil {
+		switch {
+		case errors.Is(err, user.ErrUserNotFound):
+			WriteJSONError(w, http.StatusUnauthorized, unauthorized, "User does not exist")
+		default:
+			h.log.Error(fmt.Sprintf("failed to create organization: %v", err))
+			WriteInternalError(w)
+		}
+		return
+	}
+
+	res := toOrganizationResponse(&organization.Affiliation{Organization: org, Role: authz.RoleOwner})
+
+	WriteJSON(w, http.StatusCreated, res, nil)
+}
+
+func (h *OrganizationHandler) HandleListOrganizations(w http.ResponseWriter, r *http.Request) {
+	userId, ok := GetUserID(r.Context())
+	if !ok {
+		h.log.Debug(fmt.Sprint("missing user id in context", "path", r.URL.Path))
+		WriteUnauthorizedError(w)
+		return
+	}
+
+	affiliations, err := h.organizations.ListOrganizations(r.Context(), authz.NewActor(userId))
+	if err != nil {
+		h.log.Error(fmt.Sprintf("failed to list organizations: %v", err))
+		WriteInternalError(w)
+		return
+	}
+
+	out := make([]organizationResponse, 0, len(affiliations))
+	for _, a := range affiliations {
+		out = append(out, toOrganizationResponse(a))
+	}
+
+	WriteJSON(w, http.StatusOK, out, nil)
+}
+
+func (h *OrganizationHandler) HandleGetOrganization(w http.ResponseWriter, r *http.Request) {
+	userId, ok := GetUserID(r.Context())
+	if !ok {
+		h.log.Debug(fmt.Sprint("missing user id in context", "path", r.URL.Path))
+		WriteUnauthorizedError(w)
+		return
+	}
+
+	orgID, err := organization.NewOrganizationID(chi.URLParam(r, "organization_id"))
+	if err != nil {
+		WriteJSONError(w, http.StatusBadRequest, invalidRequest, "invalid organization id")
+		return
+	}
+
+	affiliation, err := h.organizations.GetOrganization(r.Context(), authz.NewActor(userId), orgID)
+	if err != nil {
+		h.writeOrganizationError(w, err, "failed to get organization")
+		return
+	}
+
+	WriteJSON(w, http.StatusOK, toOrganizationResponse(affiliation), nil)
+}
+
+func (h *OrganizationHandler) HandleListMembers(w http.ResponseWriter, r *http.Request) {
+	userId, ok := GetUserID(r.Context())
+	if !ok {
+		h.log.Debug(fmt.Sprint("missing user id in context", "path", r.URL.Path))
+		WriteUnauthorizedError(w)
+		return
+	}
+
+	orgID, err := organization.NewOrganizationID(chi.URLParam(r, "organization_id"))
+	if err != nil {
+		WriteJSONError(w, http.StatusBadRequest, invalidRequest, "invalid organization id")
+		return
+	}
+
+	members, err := h.organizations.ListMembers(r.Context(), authz.NewActor(userId), orgID)
+	if err != nil {
+		h.writeOrganizationError(w, err, "failed to list members")
+		return
+	}
+
+	out := make([]memberResponse, 0, len(members))
+	for _, m := range members {
+		out = append(out, toMemberResponse(m))
+	}
+
+	WriteJSON(w, http.StatusOK, out, nil)
+}
+
+func (h *OrganizationHandler) HandleAddMember(w http.ResponseWriter, r *http.Request) {
+	userId, ok := GetUserID(r.Context())
+	if !ok {
+		h.log.Debug(fmt.Sprint("missing user id in context", "path", r.URL.Path))
+		WriteUnauthorizedError(w)
+		return
+	}
+
+	orgID, err := organization.NewOrganizationID(chi.URLParam(r, "organization_id"))
+	if err != nil {
+		WriteJSONError(w, http.StatusBadRequest, invalidRequest, "invalid organization id")
+		return
+	}
+
+	var req addMemberRequest
+	if !DecodeJSON(w, r, &req, maxAuthBodyBytes) {
+		return
+	}
+
+	email, err := user.NewEmail(req.Email)
+	if err != nil {
+		WriteJSONError(w, http.StatusBadRequest, invalidRequest, err.Error())
+		return
+	}
+
+	role, err := authz.NewRole(req.Role)
+	if err != nil {
+		WriteJSONError(w, http.StatusBadRequest, invalidRequest, err.Error())
+		return
+	}
+
+	m, err := h.organizations.AddMember(r.Context(), authz.NewActor(userId), orgID, email, role)
+	if err != nil {
+		h.writeOrganizationError(w, err, "failed to add member")
+		return
+	}
+
+	WriteJSON(w, http.StatusCreated, toMemberResponse(m), nil)
+}
+
+func (h *OrganizationHandler) HandleUpdateMember(w http.ResponseWriter, r *http.Request) {
+	userId, ok := GetUserID(r.Context())
+	if !ok {
+		h.log.Debug(fmt.Sprint("missing user id in context", "path", r.URL.Path))
+		WriteUnauthorizedError(w)
+		return
+	}
+
+	orgID, err := organization.NewOrganizationID(chi.URLParam(r, "organization_id"))
+	if err != nil {
+		WriteJSONError(w, http.StatusBadRequest, invalidRequest, "invalid organization id")
+		return
+	}
+
+	memberID, err := user.NewUserID(chi.URLParam(r, "user_id"))
+	if err != nil {
+		WriteJSONError(w, http.StatusBadRequest, invalidRequest, "invalid user id")
+		return
+	}
+
+	var req updateMemberRequest
+	if !DecodeJSON(w, r, &req, maxAuthBodyBytes) {
+		return
+	}
+
+	role, err := authz.NewRole(req.Role)
+	if err != nil {
+		WriteJSONError(w, http.StatusBadRequest, invalidRequest, err.Error())
+		return
+	}
+
+	m, err := h.organizations.UpdateMemberRole(r.Context(), authz.NewActor(userId), orgID, memberID, role)
+	if err != nil {
+		h.writeOrganizationError(w, err, "failed to update member")
+		return
+	}
+
+	WriteJSON(w, http.StatusOK, toMemberResponse(m), nil)
+}
+
+func (h *OrganizationHandler) HandleRemoveMember(w http.ResponseWriter, r *http.Request) {
+	userId, ok := GetUserID(r.Context())
+	if !ok {
+		h.log.Debug(fmt.Sprint("missing user id in context", "path", r.URL.Path))
+		WriteUnauthorizedError(w)
+		return
+	}
+
+	orgID, err := organization.NewOrganizationID(chi.URLParam(r, "organization_id"))
+	if err != nil {
+		WriteJSONError(w, http.StatusBadRequest, invalidRequest, "invalid organization id")
+		return
+	}
+
+	memberID, err := user.NewUserID(chi.URLParam(r, "user_id"))
+	if err != nil {
+		WriteJSONError(w, http.StatusBadRequest, invalidRequest, "invalid user id")
+		return
+	}
+
+	if err := h.organizations.RemoveMember(r.Context(), authz.NewActor(userId), orgID, memberID); err != nil {
+		h.writeOrganizationError(w, err, "failed to remove member")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *OrganizationHandler) writeOrganizationError(w http.ResponseWriter, err error, action string) {
+	switch {
+	case errors.Is(err, organization.ErrOrganizationNotFound):
+		WriteJSONError(w, http.StatusNotFound, notfound, "organization not found")
+	case errors.Is(err, organization.ErrMemberNotFound):
+		WriteJSONError(w, http.StatusNotFound, notfound, "member not found")
+	case errors.Is(err, user.ErrUserNotFound):
+		WriteJSONError(w, http.StatusNotFound, notfound, "no user is registered with that email")
+	case errors.Is(err, organization.ErrAlreadyMember):
+		WriteJSONError(w, http.StatusConflict, conflict, err.Error())
+	case errors.Is(err, organization.ErrLastOwner):
+		WriteJSONError(w, http.StatusConflict, conflict, err.Error())
+	case errors.Is(err, authz.ErrForbidden):
+		WriteJSONError(w, http.StatusForbidden, forbidden, "your role does not allow this action")
+	default:
+		h.log.Error(fmt.Sprintf("%s: %v", action, err))
+		WriteInternalError(w)
+	}
+}
+
+func toOrganizationResponse(a *organization.Affiliation) organizationResponse {
+	return organizationResponse{
+		ID:        a.Organization.ID.String(),
+		Name:      a.Organization.Name.String(),
+		Role:      a.Role.String(),
+		CreatedAt: a.Organization.CreatedAt,
+	}
+}
+
+func toMemberResponse(m *organization.Membership) memberResponse {
+	return memberResponse{
+		UserID:    m.UserID.String(),
+		Role:      m.Role.String(),
+		CreatedAt: m.CreatedAt,
+	}
+}
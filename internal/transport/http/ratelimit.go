@@ -0,0 +1,124 @@
+package http
+
+import (
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/raphico/go-device-telemetry-api/internal/logger"
+	"github.com/raphico/go-device-telemetry-api/internal/ratelimit"
+)
+
+type RateLimitMiddleware struct {
+	log    *logger.Logger
+	limits *ratelimit.Service
+}
+
+func NewRateLimitMiddleware(log *logger.Logger, limits *ratelimit.Service) *RateLimitMiddleware {
+	return &RateLimitMiddleware{
+		log:    log,
+		limits: limits,
+	}
+}
+
+// Limit applies group's token bucket, keyed by the authenticated user or,
+// on routes without one, the client IP. Limits stack: a request passing
+// several of them reports the one closest to running out.
+func (rm *RateLimitMiddleware) Limit(group ratelimit.Group) func(http.Handler) http.Handler {
+	return rm.limit(group, func(r *http.Request) string {
+		if userID, ok := authenticatedUserID(r.Context()); ok {
+			return userID.String()
+		}
+		return clientIP(r)
+	})
+}
+
+// LimitDevice applies the per-device bucket to routes under {device_id}.
+// It runs before the device's ownership is checked, so the bucket is the
+// caller's for that device: requests naming a device someone else owns
+// cannot drain the owner's bucket.
+func (rm *RateLimitMiddleware) LimitDevice(next http.Handler) http.Handler {
+	return rm.limit(ratelimit.GroupDevice, func(r *http.Request) string {
+		actor := clientIP(r)
+		if userID, ok := authenticatedUserID(r.Context()); ok {
+			actor = userID.String()
+		}
+		return actor + "/" + chi.URLParam(r, "device_id")
+	})(next)
+}
+
+func (rm *RateLimitMiddleware) limit(
+	group ratelimit.Group,
+	subject func(r *http.Request) string,
+) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			res, err := rm.limits.Allow(r.Context(), group, subject(r))
+			if err != nil {
+				// fail open: a broken limiter should not take the API down
+				rm.log.Error(fmt.Sprintf("failed to check %s rate limit: %v", group, err))
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			if res.Limit > 0 {
+				setRateLimitHeaders(w, int64(res.Limit), int64(res.Remaining), res.Reset)
+			}
+
+			if !res.Allowed {
+				writeRateLimited(w, res.RetryAfter, "rate limit exceeded")
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// setRateLimitHeaders writes the RateLimit-* headers unless an earlier
+// limit on the same request has less room left.
+func setRateLimitHeaders(w http.ResponseWriter, limit, remaining int64, reset time.Duration) {
+	h := w.Header()
+
+	if prev, err := strconv.ParseInt(h.Get("RateLimit-Remaining"), 10, 64); err == nil && prev < remaining {
+		return
+	}
+
+	h.Set("RateLimit-Limit", strconv.FormatInt(limit, 10))
+	h.Set("RateLimit-Remaining", strconv.FormatInt(remaining, 10))
+	h.Set("RateLimit-Reset", strconv.FormatInt(ceilSeconds(reset), 10))
+}
+
+func writeRateLimited(w http.ResponseWriter, retryAfter time.Duration, msg string) {
+	w.Header().Set("Retry-After", strconv.FormatInt(max(ceilSeconds(retryAfter), 1), 10))
+	WriteJSONError(w, http.StatusTooManyRequests, rateLimited, msg)
+}
+
+// writeQuotaExceeded answers a request refused by the daily ingestion quota.
+func writeQuotaExceeded(w http.ResponseWriter, res ratelimit.QuotaResult) {
+	setRateLimitHeaders(w, res.Limit, res.Remaining, res.Reset)
+	writeRateLimited(
+		w,
+		res.Reset,
+		fmt.Sprintf("daily ingestion quota of %d readings exceeded (%d left)", res.Limit, res.Remaining),
+	)
+}
+
+func ceilSeconds(d time.Duration) int64 {
+	return int64(math.Ceil(d.Seconds()))
+}
+
+// clientIP returns the request's address without the port; RealIP has
+// already replaced it with the forwarded address when a trusted proxy
+// sent one.
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
@@ -2,66 +2,231 @@ package http
 
 import (
 	"net/http"
+	"net/netip"
 	"time"
 
 	"github.com/go-chi/chi/v5"
 	chimw "github.com/go-chi/chi/v5/middleware"
 	"github.com/raphico/go-device-telemetry-api/internal/logger"
+	"github.com/raphico/go-device-telemetry-api/internal/ratelimit"
+	"github.com/raphico/go-device-telemetry-api/internal/token"
 )
 
 func NewRouter(
 	log *logger.Logger,
+	trustedProxies []netip.Prefix,
 	userMw *UserMiddleware,
+	idempotencyMw *IdempotencyMiddleware,
+	compressionMw *CompressionMiddleware,
+	rateLimitMw *RateLimitMiddleware,
 	authHandler *AuthHandler,
+	accountHandler *AccountHandler,
+	tokenHandler *TokenHandler,
+	sessionHandler *SessionHandler,
+	adminHandler *AdminHandler,
+	identityHandler *IdentityHandler,
+	mfaHandler *MFAHandler,
+	organizationHandler *OrganizationHandler,
 	deviceHandler *DeviceHandler,
 	telemetryHandler *TelemetryHandler,
 	commandHandler *CommandHandler,
+	exportHandler *ExportHandler,
+	jwksHandler *JWKSHandler,
 ) http.Handler {
 	r := chi.NewRouter()
 
 	r.Use(chimw.RequestID)
-	r.Use(chimw.RealIP)
+	r.Use(RealIP(trustedProxies))
 	r.Use(chimw.Recoverer)
 	r.Use(userMw.AuthMiddleware)
 	r.Use(LoggingMiddleware(log))
-	r.Use(chimw.Timeout(60 * time.Second))
 
-	r.Route("/api/v1", func(r chi.Router) {
-		r.Route("/auth", func(r chi.Router) {
-			r.Post("/register", authHandler.HandleRegisterUser)
-			r.Post("/login", authHandler.HandleLoginUser)
-			r.Post("/refresh", authHandler.HandleRefreshAccessToken)
-		})
+	r.Group(func(r chi.Router) {
+		r.Use(chimw.Timeout(60 * time.Second))
+		r.Use(compressionMw.Decompress)
+
+		r.Get("/.well-known/jwks.json", jwksHandler.HandleJWKS)
 
-		r.Group(func(r chi.Router) {
-			r.Use(userMw.RequireAuthMiddleware)
+		r.Route("/api/v1", func(r chi.Router) {
+			r.Group(func(r chi.Router) {
+				r.Use(compressionMw.Compress)
 
-			r.Post("/auth/logout", authHandler.HandleLogoutUser)
+				r.Route("/auth", func(r chi.Router) {
+					r.Use(rateLimitMw.Limit(ratelimit.GroupAuth))
 
-			r.Route("/devices", func(r chi.Router) {
-				r.Post("/", deviceHandler.HandleCreateDevice)
-				r.Get("/", deviceHandler.HandleListDevices)
-				r.Get("/{device_id}", deviceHandler.HandleGetDevice)
-				r.Post("/{device_id}", deviceHandler.HandleUpdateDevice)
+					r.Post("/register", authHandler.HandleRegisterUser)
+					r.Post("/login", authHandler.HandleLoginUser)
+					r.Post("/mfa/verify", mfaHandler.HandleVerifyMFA)
+					r.Post("/refresh", authHandler.HandleRefreshAccessToken)
+					r.Get("/verify-email", authHandler.HandleVerifyEmailPage)
+					r.Post("/verify-email", authHandler.HandleVerifyEmail)
+					r.Post("/resend-verification", authHandler.HandleResendVerification)
+					r.Post("/forgot-password", authHandler.HandleForgotPassword)
+					r.Post("/reset-password", authHandler.HandleResetPassword)
 
-				r.Route("/{device_id}/telemetry", func(r chi.Router) {
-					r.Post("/", telemetryHandler.HandleCreateTelemetry)
-					r.Get("/", telemetryHandler.HandleGetDeviceTelemetry)
+					r.Get("/oidc/providers", identityHandler.HandleListProviders)
+					r.Get("/oidc/{provider}/login", identityHandler.HandleLogin)
+					r.Get("/oidc/{provider}/callback", identityHandler.HandleCallback)
 				})
 
-				r.Route("/{device_id}/commands", func(r chi.Router) {
-					r.Post("/", commandHandler.HandleCreateCommand)
-					r.Get("/", commandHandler.HandleGetDeviceCommands)
+				r.Group(func(r chi.Router) {
+					r.Use(userMw.RequireAuthMiddleware)
+					r.Use(rateLimitMw.Limit(ratelimit.GroupAPI))
+
+					r.Post("/auth/logout", authHandler.HandleLogoutUser)
+					r.With(userMw.RequireSessionMiddleware).
+						Post("/auth/change-password", authHandler.HandleChangePassword)
+
+					// left outside RequireVerifiedMiddleware so a mistyped address
+					// can still be corrected
+					r.Route("/me", func(r chi.Router) {
+						r.Use(userMw.RequireSessionMiddleware)
+
+						r.Get("/", accountHandler.HandleGetProfile)
+						r.Patch("/", accountHandler.HandleUpdateProfile)
+						r.Delete("/", accountHandler.HandleDeleteAccount)
+						r.Get("/export", accountHandler.HandleExportAccount)
+					})
+
+					r.Route("/sessions", func(r chi.Router) {
+						r.Use(userMw.RequireSessionMiddleware)
+
+						r.Get("/", sessionHandler.HandleListSessions)
+						r.Delete("/", sessionHandler.HandleRevokeOtherSessions)
+						r.Delete("/{session_id}", sessionHandler.HandleRevokeSession)
+					})
+
+					r.Route("/mfa", func(r chi.Router) {
+						r.Use(userMw.RequireSessionMiddleware)
+
+						r.Get("/", mfaHandler.HandleGetStatus)
+						r.Post("/enroll", mfaHandler.HandleEnroll)
+						r.Post("/confirm", mfaHandler.HandleConfirm)
+						r.Post("/disable", mfaHandler.HandleDisable)
+					})
+
+					r.Route("/identities", func(r chi.Router) {
+						r.Use(userMw.RequireSessionMiddleware)
+
+						r.Get("/", identityHandler.HandleListIdentities)
+						r.Post("/{provider}/link", identityHandler.HandleStartLink)
+						r.Delete("/{identity_id}", identityHandler.HandleUnlink)
+					})
+
+					r.Group(func(r chi.Router) {
+						r.Use(userMw.RequireVerifiedMiddleware)
+
+						r.Route("/admin", func(r chi.Router) {
+							r.Use(userMw.RequireSessionMiddleware)
+							r.Use(userMw.RequireAdminMiddleware)
+
+							r.Post("/access-tokens/revoke", adminHandler.HandleRevokeAccessToken)
+						})
+
+						r.Route("/tokens", func(r chi.Router) {
+							r.Use(userMw.RequireSessionMiddleware)
+
+							r.With(idempotencyMw.Handle).Post("/", tokenHandler.HandleCreateToken)
+							r.Get("/", tokenHandler.HandleListTokens)
+							r.Delete("/{token_id}", tokenHandler.HandleRevokeToken)
+						})
+
+						r.With(userMw.RequireScope(token.AccessScopeTelemetryRead)).
+							Get("/telemetry/export", telemetryHandler.HandleExportTelemetry)
+
+						r.Route("/exports", func(r chi.Router) {
+							r.Use(userMw.RequireScope(token.AccessScopeTelemetryRead))
 
-					r.Patch("/{command_id}", commandHandler.HandleUpdateCommandStatus)
+							r.With(idempotencyMw.Handle).Post("/", exportHandler.HandleCreateExport)
+							r.Get("/{export_id}", exportHandler.HandleGetExport)
+						})
+
+						r.Route("/organizations", func(r chi.Router) {
+							read := userMw.RequireScope(token.AccessScopeOrganizationsRead)
+							write := userMw.RequireScope(token.AccessScopeOrganizationsWrite)
+
+							r.With(write, idempotencyMw.Handle).Post("/", organizationHandler.HandleCreateOrganization)
+							r.With(read).Get("/", organizationHandler.HandleListOrganizations)
+							r.With(read).Get("/{organization_id}", organizationHandler.HandleGetOrganization)
+
+							r.Route("/{organization_id}/members", func(r chi.Router) {
+								r.With(write, idempotencyMw.Handle).Post("/", organizationHandler.HandleAddMember)
+								r.With(read).Get("/", organizationHandler.HandleListMembers)
+								r.With(write).Patch("/{user_id}", organizationHandler.HandleUpdateMember)
+								r.With(write).Delete("/{user_id}", organizationHandler.HandleRemoveMember)
+							})
+						})
+
+						r.Route("/devices", func(r chi.Router) {
+							read := userMw.RequireScope(token.AccessScopeDevicesRead)
+							write := userMw.RequireScope(token.AccessScopeDevicesWrite)
+
+							r.With(write, idempotencyMw.Handle).Post("/", deviceHandler.HandleCreateDevice)
+							r.With(read).Get("/", deviceHandler.HandleListDevices)
+							r.With(read).Get("/{device_id}", deviceHandler.HandleGetDevice)
+							r.With(write, idempotencyMw.Handle).Post("/{device_id}", deviceHandler.HandleUpdateDevice)
+
+							// telemetry ingestion has its own message ids, so it does not
+							// go through idempotencyMw
+							r.Route("/{device_id}/telemetry", func(r chi.Router) {
+								r.Group(func(r chi.Router) {
+									r.Use(userMw.RequireScope(token.AccessScopeTelemetryRead))
+
+									r.Get("/", telemetryHandler.HandleGetDeviceTelemetry)
+									r.Get("/rollups", telemetryHandler.HandleGetDeviceTelemetryRollups)
+									r.Get("/export", telemetryHandler.HandleExportDeviceTelemetry)
+								})
+
+								r.Group(func(r chi.Router) {
+									r.Use(userMw.RequireScope(token.AccessScopeTelemetryWrite))
+									r.Use(rateLimitMw.Limit(ratelimit.GroupIngest))
+									r.Use(rateLimitMw.LimitDevice)
+
+									r.Post("/", telemetryHandler.HandleCreateTelemetry)
+									r.Post("/batch", telemetryHandler.HandleCreateTelemetryBatch)
+								})
+							})
+
+							r.Route("/{device_id}/commands", func(r chi.Router) {
+								read := userMw.RequireScope(token.AccessScopeCommandsRead)
+								write := userMw.RequireScope(token.AccessScopeCommandsWrite)
+
+								r.With(write, idempotencyMw.Handle).Post("/", commandHandler.HandleCreateCommand)
+								r.With(read).Get("/", commandHandler.HandleGetDeviceCommands)
+
+								r.With(write, idempotencyMw.Handle).Patch("/{command_id}", commandHandler.HandleUpdateCommandStatus)
+							})
+						})
+					})
+				})
+
+				r.Get("/health", func(w http.ResponseWriter, r *http.Request) {
+					WriteJSON(w, http.StatusOK, "OK", nil)
 				})
 			})
-		})
 
-		r.Get("/health", func(w http.ResponseWriter, r *http.Request) {
-			WriteJSON(w, http.StatusOK, "OK", nil)
+			// signed, so reachable without an access token; served uncompressed so
+			// range requests line up with the stored file
+			r.Get("/exports/artifacts/*", exportHandler.HandleDownloadArtifact)
 		})
 	})
 
+	// an import streams up to maxImportBodyBytes, which neither the request
+	// timeout nor the usual decompression cap would let through, so it sits
+	// outside both and the handler sets its own deadlines; imports are
+	// deduplicated row by row and skip idempotencyMw
+	r.Group(func(r chi.Router) {
+		r.Use(compressionMw.DecompressLimit(maxImportBodyBytes))
+		r.Use(compressionMw.Compress)
+		r.Use(userMw.RequireAuthMiddleware)
+		r.Use(rateLimitMw.Limit(ratelimit.GroupAPI))
+		r.Use(userMw.RequireVerifiedMiddleware)
+		r.Use(userMw.RequireScope(token.AccessScopeTelemetryWrite))
+		r.Use(rateLimitMw.Limit(ratelimit.GroupIngest))
+		r.Use(rateLimitMw.LimitDevice)
+
+		r.Post("/api/v1/devices/{device_id}/telemetry/import", telemetryHandler.HandleImportTelemetry)
+	})
+
 	return r
 }
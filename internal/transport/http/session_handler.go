@@ -0,0 +1,158 @@
+package http
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/raphico/go-device-telemetry-api/internal/config"
+	"github.com/raphico/go-device-telemetry-api/internal/logger"
+	"github.com/raphico/go-device-telemetry-api/internal/token"
+	"github.com/raphico/go-device-telemetry-api/internal/user"
+)
+
+type SessionHandler struct {
+	log   *logger.Logger
+	cfg   config.Config
+	token *token.Service
+}
+
+func NewSessionHandler(log *logger.Logger, cfg config.Config, tokenService *token.Service) *SessionHandler {
+	return &SessionHandler{
+		log:   log,
+		cfg:   cfg,
+		token: tokenService,
+	}
+}
+
+type sessionResponse struct {
+	ID           string    `json:"id"`
+	UserAgent    string    `json:"user_agent"`
+	IP           string    `json:"ip"`
+	Current      bool      `json:"current"`
+	CreatedAt    time.Time `json:"created_at"`
+	LastActiveAt time.Time `json:"last_active_at"`
+	ExpiresAt    time.Time `json:"expires_at"`
+}
+
+func (h *SessionHandler) HandleListSessions(w http.ResponseWriter, r *http.Request) {
+	userId, ok := GetUserID(r.Context())
+	if !ok {
+		h.log.Debug(fmt.Sprint("missing user id in context", "path", r.URL.Path))
+		WriteUnauthorizedError(w)
+		return
+	}
+
+	sessions, err := h.token.ListSessions(r.Context(), userId)
+	if err != nil {
+		h.log.Error(fmt.Sprintf("failed to list sessions: %v", err))
+		WriteInternalError(w)
+		return
+	}
+
+	current, hasCurrent, err := h.currentSession(r, userId)
+	if err != nil {
+		h.log.Error(fmt.Sprintf("failed to resolve current session: %v", err))
+		WriteInternalError(w)
+		return
+	}
+
+	out := make([]sessionResponse, 0, len(sessions))
+	for _, s := range sessions {
+		out = append(out, sessionResponse{
+			ID:           s.ID.String(),
+			UserAgent:    s.Client.UserAgent,
+			IP:           s.Client.IP,
+			Current:      hasCurrent && s.ID == current,
+			CreatedAt:    s.CreatedAt,
+			LastActiveAt: s.LastActiveAt,
+			ExpiresAt:    s.ExpiresAt,
+		})
+	}
+
+	WriteJSON(w, http.StatusOK, out, nil)
+}
+
+func (h *SessionHandler) HandleRevokeSession(w http.ResponseWriter, r *http.Request) {
+	userId, ok := GetUserID(r.Context())
+	if !ok {
+		h.log.Debug(fmt.Sprint("missing user id in context", "path", r.URL.Path))
+		WriteUnauthorizedError(w)
+		return
+	}
+
+	sessionID, err := token.NewTokenID(chi.URLParam(r, "session_id"))
+	if err != nil {
+		WriteJSONError(w, http.StatusBadRequest, invalidRequest, "invalid session id")
+		return
+	}
+
+	current, hasCurrent, err := h.currentSession(r, userId)
+	if err != nil {
+		h.log.Error(fmt.Sprintf("failed to resolve current session: %v", err))
+		WriteInternalError(w)
+		return
+	}
+
+	if err := h.token.RevokeSession(r.Context(), userId, sessionID); err != nil {
+		switch {
+		case errors.Is(err, token.ErrSessionNotFound):
+			WriteJSONError(w, http.StatusNotFound, notfound, "session not found")
+		default:
+			h.log.Error(fmt.Sprintf("failed to revoke session: %v", err))
+			WriteInternalError(w)
+		}
+		return
+	}
+
+	if hasCurrent && sessionID == current {
+		clearRefreshCookie(w, h.cfg)
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// HandleRevokeOtherSessions signs out every session except the one whose
+// refresh_token cookie came with the request.
+func (h *SessionHandler) HandleRevokeOtherSessions(w http.ResponseWriter, r *http.Request) {
+	userId, ok := GetUserID(r.Context())
+	if !ok {
+		h.log.Debug(fmt.Sprint("missing user id in context", "path", r.URL.Path))
+		WriteUnauthorizedError(w)
+		return
+	}
+
+	var refreshTok string
+	if cookie, err := r.Cookie("refresh_token"); err == nil {
+		refreshTok = cookie.Value
+	}
+
+	if _, err := h.token.RevokeOtherSessions(r.Context(), userId, refreshTok); err != nil {
+		h.log.Error(fmt.Sprintf("failed to revoke sessions: %v", err))
+		WriteInternalError(w)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// currentSession resolves the session of the request's refresh_token
+// cookie, reporting false when there is no live one.
+func (h *SessionHandler) currentSession(r *http.Request, userId user.UserID) (token.TokenID, bool, error) {
+	cookie, err := r.Cookie("refresh_token")
+	if err != nil {
+		return token.TokenID{}, false, nil
+	}
+
+	id, err := h.token.CurrentSessionID(r.Context(), userId, cookie.Value)
+	if err != nil {
+		if errors.Is(err, token.ErrTokenNotFound) {
+			return token.TokenID{}, false, nil
+		}
+		return token.TokenID{}, false, err
+	}
+
+	return id, true, nil
+}
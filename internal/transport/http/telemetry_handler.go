@@ -1,36 +1,55 @@
 package http
 
 import (
-	"encoding/json"
+	"context"
 	"errors"
 	"fmt"
+	"io"
+	"mime"
 	"net/http"
+	"path"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/go-chi/chi/v5"
+	"github.com/raphico/go-device-telemetry-api/internal/authz"
 	"github.com/raphico/go-device-telemetry-api/internal/common/pagination"
 	"github.com/raphico/go-device-telemetry-api/internal/device"
 	"github.com/raphico/go-device-telemetry-api/internal/logger"
+	"github.com/raphico/go-device-telemetry-api/internal/ratelimit"
 	"github.com/raphico/go-device-telemetry-api/internal/telemetry"
 )
 
 type TelemetryHandler struct {
-	log       *logger.Logger
-	telemetry *telemetry.Service
+	log           *logger.Logger
+	telemetry     *telemetry.Service
+	limits        *ratelimit.Service
+	importTimeout time.Duration
 }
 
-func NewTelemetryHandler(log *logger.Logger, telemetry *telemetry.Service) *TelemetryHandler {
+func NewTelemetryHandler(
+	log *logger.Logger,
+	telemetry *telemetry.Service,
+	limits *ratelimit.Service,
+	importTimeout time.Duration,
+) *TelemetryHandler {
 	return &TelemetryHandler{
-		log:       log,
-		telemetry: telemetry,
+		log:           log,
+		telemetry:     telemetry,
+		limits:        limits,
+		importTimeout: importTimeout,
 	}
 }
 
+// maxBatchReadings caps how many readings one batch request may carry.
+const maxBatchReadings = 500
+
 type createTelemetryRequest struct {
 	TelemetryType string `json:"telemetry_type"`
 	Payload       any    `json:"payload"`
 	RecordedAt    string `json:"recorded_at"`
+	MessageID     string `json:"message_id"`
 }
 
 type telemetryResponse struct {
@@ -38,9 +57,21 @@ type telemetryResponse struct {
 	TelemetryType string         `json:"telemetry_type"`
 	Payload       map[string]any `json:"payload"`
 	RecordedAt    time.Time      `json:"recorded_at"`
+	CreatedAt     time.Time      `json:"created_at"` // ingestion time
+	MessageID     string         `json:"message_id,omitempty"`
+	// set when recorded_at was corrected for clock skew
+	DeviceRecordedAt *time.Time `json:"device_recorded_at,omitempty"`
+	ClockSkewFlagged bool       `json:"clock_skew_flagged"`
 }
 
 func (h *TelemetryHandler) HandleCreateTelemetry(w http.ResponseWriter, r *http.Request) {
+	userId, ok := GetUserID(r.Context())
+	if !ok {
+		h.log.Debug(fmt.Sprint("missing user id in context", "path", r.URL.Path))
+		WriteUnauthorizedError(w)
+		return
+	}
+
 	deviceIDStr := chi.URLParam(r, "device_id")
 	deviceID, err := device.NewDeviceID(deviceIDStr)
 	if err != nil {
@@ -48,53 +79,321 @@ func (h *TelemetryHandler) HandleCreateTelemetry(w http.ResponseWriter, r *http.
 		return
 	}
 
+	resCodec, ok := negotiateCodec(r, true)
+	if !ok {
+		writeNotAcceptable(w, true)
+		return
+	}
+
+	reqCodec, err := requestCodec(r)
+	if err != nil {
+		WriteJSONError(w, http.StatusUnsupportedMediaType, invalidRequest, err.Error())
+		return
+	}
+
 	var req createTelemetryRequest
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		WriteJSONError(w, http.StatusBadRequest, invalidRequest, "invalid request body")
+	if reqCodec == protobufCodec {
+		body, err := readBody(w, r, maxTelemetryBodyBytes)
+		if err != nil {
+			writeDecodeError(w, err)
+			return
+		}
+		if req, err = decodeProtoReading(body); err != nil {
+			WriteJSONError(w, http.StatusBadRequest, invalidRequest, err.Error())
+			return
+		}
+	} else if !DecodeBody(w, r, reqCodec, &req, maxTelemetryBodyBytes) {
 		return
 	}
 
-	telemetryType, err := telemetry.NewTelemetryType(req.TelemetryType)
+	in, err := parseTelemetryInput(req, r.Header.Get("Idempotency-Key"))
 	if err != nil {
 		WriteJSONError(w, http.StatusBadRequest, invalidRequest, err.Error())
 		return
 	}
 
-	payload, err := telemetry.NewPayload(req.Payload)
+	if !h.consumeQuota(w, r, 1) {
+		return
+	}
+
+	t, replayed, err := h.telemetry.CreateTelemetry(
+		r.Context(),
+		authz.NewActor(userId),
+		deviceID,
+		in.telemetryType,
+		in.payload,
+		in.recordedAt,
+		in.messageID,
+	)
 	if err != nil {
-		WriteJSONError(w, http.StatusBadRequest, invalidRequest, err.Error())
+		h.writeCreateTelemetryError(w, err)
+		return
+	}
+
+	status := http.StatusCreated
+	if replayed {
+		status = http.StatusOK
+	}
+
+	res := toTelemetryResponse(t)
+
+	if resCodec == protobufCodec {
+		body, err := appendProtoTelemetry(nil, res)
+		if err != nil {
+			h.log.Error(fmt.Sprintf("failed to encode telemetry: %v", err))
+			WriteInternalError(w)
+			return
+		}
+		writeProtobuf(w, status, body)
+		return
+	}
+
+	WriteEncoded(w, resCodec, status, res, nil)
+}
+
+type createTelemetryBatchRequest struct {
+	Readings []createTelemetryRequest `json:"readings"`
+}
+
+type telemetryBatchMeta struct {
+	Created  int `json:"created"`
+	Replayed int `json:"replayed"`
+}
+
+// HandleCreateTelemetryBatch ingests several readings in one request. Every
+// reading is validated before any is stored, and they are stored all at
+// once or not at all. An Idempotency-Key header stands in for the message
+// ids readings do not carry, as the key suffixed with their index.
+func (h *TelemetryHandler) HandleCreateTelemetryBatch(w http.ResponseWriter, r *http.Request) {
+	userId, ok := GetUserID(r.Context())
+	if !ok {
+		h.log.Debug(fmt.Sprint("missing user id in context", "path", r.URL.Path))
+		WriteUnauthorizedError(w)
 		return
 	}
 
-	recordedAt, err := telemetry.NewRecordedAt(req.RecordedAt)
+	deviceIDStr := chi.URLParam(r, "device_id")
+	deviceID, err := device.NewDeviceID(deviceIDStr)
 	if err != nil {
-		WriteJSONError(w, http.StatusBadRequest, invalidRequest, err.Error())
+		WriteJSONError(w, http.StatusBadRequest, invalidRequest, "invalid device id")
+		return
+	}
+
+	resCodec, ok := negotiateCodec(r, true)
+	if !ok {
+		writeNotAcceptable(w, true)
 		return
 	}
 
-	t, err := h.telemetry.CreateTelemetry(r.Context(), deviceID, telemetryType, payload, recordedAt)
+	reqCodec, err := requestCodec(r)
 	if err != nil {
-		switch {
-		case errors.Is(err, device.ErrDeviceNotFound):
-			WriteJSONError(w, http.StatusNotFound, notfound, "device not found")
-		default:
-			h.log.Error(fmt.Sprintf("failed to add telemetry: %v", err))
+		WriteJSONError(w, http.StatusUnsupportedMediaType, invalidRequest, err.Error())
+		return
+	}
+
+	var req createTelemetryBatchRequest
+	if reqCodec == protobufCodec {
+		body, err := readBody(w, r, maxBatchBodyBytes)
+		if err != nil {
+			writeDecodeError(w, err)
+			return
+		}
+		if req, err = decodeProtoBatch(body); err != nil {
+			WriteJSONError(w, http.StatusBadRequest, invalidRequest, err.Error())
+			return
+		}
+	} else if !DecodeBody(w, r, reqCodec, &req, maxBatchBodyBytes) {
+		return
+	}
+
+	if len(req.Readings) == 0 {
+		WriteJSONError(w, http.StatusBadRequest, invalidRequest, "readings cannot be empty")
+		return
+	}
+
+	if len(req.Readings) > maxBatchReadings {
+		WriteJSONError(
+			w,
+			http.StatusBadRequest,
+			invalidRequest,
+			fmt.Sprintf("a batch can hold at most %d readings", maxBatchReadings),
+		)
+		return
+	}
+
+	idempotencyKey := r.Header.Get("Idempotency-Key")
+
+	inputs := make([]telemetryInput, 0, len(req.Readings))
+	for i, reading := range req.Readings {
+		var readingKey string
+		if idempotencyKey != "" && reading.MessageID == "" {
+			readingKey = fmt.Sprintf("%s/%d", idempotencyKey, i)
+		}
+
+		in, err := parseTelemetryInput(reading, readingKey)
+		if err != nil {
+			WriteJSONError(w, http.StatusBadRequest, invalidRequest, fmt.Sprintf("readings[%d]: %v", i, err))
+			return
+		}
+		inputs = append(inputs, in)
+	}
+
+	if !h.consumeQuota(w, r, int64(len(inputs))) {
+		return
+	}
+
+	readings := make([]telemetry.Reading, 0, len(inputs))
+	for _, in := range inputs {
+		readings = append(readings, telemetry.Reading{
+			TelemetryType: in.telemetryType,
+			Payload:       in.payload,
+			RecordedAt:    in.recordedAt,
+			MessageID:     in.messageID,
+		})
+	}
+
+	results, err := h.telemetry.CreateTelemetryBatch(r.Context(), authz.NewActor(userId), deviceID, readings)
+	if err != nil {
+		h.writeCreateTelemetryError(w, err)
+		return
+	}
+
+	out := make([]telemetryResponse, 0, len(results))
+	var meta telemetryBatchMeta
+
+	for _, res := range results {
+		if res.Replayed {
+			meta.Replayed++
+		} else {
+			meta.Created++
+		}
+
+		out = append(out, toTelemetryResponse(res.Telemetry))
+	}
+
+	status := http.StatusCreated
+	if meta.Created == 0 {
+		status = http.StatusOK
+	}
+
+	if resCodec == protobufCodec {
+		body, err := encodeProtoTelemetryList(out, "", 0)
+		if err != nil {
+			h.log.Error(fmt.Sprintf("failed to encode telemetry: %v", err))
 			WriteInternalError(w)
+			return
 		}
+		writeProtobuf(w, status, body)
 		return
 	}
 
+	WriteEncoded(w, resCodec, status, out, meta)
+}
+
+func (h *TelemetryHandler) writeCreateTelemetryError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, device.ErrDeviceNotFound):
+		WriteJSONError(w, http.StatusNotFound, notfound, "device not found")
+	case errors.Is(err, authz.ErrForbidden):
+		WriteJSONError(w, http.StatusForbidden, forbidden, "your role does not allow sending telemetry for this device")
+	case errors.Is(err, telemetry.ErrRecordedAtInFuture):
+		WriteJSONError(w, http.StatusBadRequest, invalidRequest, err.Error())
+	case errors.Is(err, telemetry.ErrTelemetryNotFound):
+		// the id is still claimed but its reading has aged out
+		WriteJSONError(w, http.StatusConflict, conflict, "message id already used")
+	default:
+		h.log.Error(fmt.Sprintf("failed to add telemetry: %v", err))
+		WriteInternalError(w)
+	}
+}
+
+// telemetryInput is a create request after validation.
+type telemetryInput struct {
+	telemetryType telemetry.TelemetryType
+	payload       telemetry.Payload
+	recordedAt    telemetry.RecordedAt
+	messageID     *telemetry.MessageID
+}
+
+func parseTelemetryInput(req createTelemetryRequest, idempotencyKey string) (telemetryInput, error) {
+	telemetryType, err := telemetry.NewTelemetryType(req.TelemetryType)
+	if err != nil {
+		return telemetryInput{}, err
+	}
+
+	payload, err := telemetry.NewPayload(req.Payload)
+	if err != nil {
+		return telemetryInput{}, err
+	}
+
+	recordedAt, err := telemetry.ParseRecordedAt(req.RecordedAt)
+	if err != nil {
+		return telemetryInput{}, err
+	}
+
+	messageID, err := parseMessageID(req.MessageID, idempotencyKey)
+	if err != nil {
+		return telemetryInput{}, err
+	}
+
+	return telemetryInput{
+		telemetryType: telemetryType,
+		payload:       payload,
+		recordedAt:    recordedAt,
+		messageID:     messageID,
+	}, nil
+}
+
+func toTelemetryResponse(t *telemetry.Telemetry) telemetryResponse {
 	res := telemetryResponse{
-		ID:            t.ID.String(),
-		TelemetryType: t.TelemetryType.String(),
-		Payload:       t.Payload,
-		RecordedAt:    t.RecordedAt.Time(),
+		ID:               t.ID.String(),
+		TelemetryType:    t.TelemetryType.String(),
+		Payload:          t.Payload,
+		RecordedAt:       t.RecordedAt.Time(),
+		CreatedAt:        t.CreatedAt,
+		DeviceRecordedAt: t.DeviceRecordedAt,
+		ClockSkewFlagged: t.ClockSkewFlagged,
 	}
 
-	WriteJSON(w, http.StatusCreated, res, nil)
+	if t.MessageID != nil {
+		res.MessageID = t.MessageID.String()
+	}
+
+	return res
+}
+
+// parseMessageID takes the message id from the body or, failing that, the
+// Idempotency-Key header. Both may be sent as long as they agree.
+func parseMessageID(fromBody, fromHeader string) (*telemetry.MessageID, error) {
+	if fromBody == "" && fromHeader == "" {
+		return nil, nil
+	}
+
+	if fromBody != "" && fromHeader != "" && fromBody != fromHeader {
+		return nil, errors.New("message_id and Idempotency-Key must match")
+	}
+
+	raw := fromBody
+	if raw == "" {
+		raw = fromHeader
+	}
+
+	id, err := telemetry.NewMessageID(raw)
+	if err != nil {
+		return nil, err
+	}
+
+	return &id, nil
 }
 
 func (h *TelemetryHandler) HandleGetDeviceTelemetry(w http.ResponseWriter, r *http.Request) {
+	userId, ok := GetUserID(r.Context())
+	if !ok {
+		h.log.Debug(fmt.Sprint("missing user id in context", "path", r.URL.Path))
+		WriteUnauthorizedError(w)
+		return
+	}
+
 	deviceIDStr := chi.URLParam(r, "device_id")
 	deviceID, err := device.NewDeviceID(deviceIDStr)
 	if err != nil {
@@ -102,6 +401,12 @@ func (h *TelemetryHandler) HandleGetDeviceTelemetry(w http.ResponseWriter, r *ht
 		return
 	}
 
+	resCodec, ok := negotiateCodec(r, true)
+	if !ok {
+		writeNotAcceptable(w, true)
+		return
+	}
+
 	limit := pagination.DefaultLimit
 	if limitStr := r.URL.Query().Get("limit"); limitStr != "" {
 		if v, err := strconv.Atoi(limitStr); err != nil || v < 0 {
@@ -112,9 +417,9 @@ func (h *TelemetryHandler) HandleGetDeviceTelemetry(w http.ResponseWriter, r *ht
 		}
 	}
 
-	var cur *pagination.Cursor
+	var cur *pagination.RecordedAtCursor
 	if cstr := r.URL.Query().Get("cursor"); cstr != "" {
-		if decoded, err := pagination.Decode(cstr); err != nil {
+		if decoded, err := pagination.DecodeRecordedAt(cstr); err != nil {
 			WriteJSONError(w, http.StatusBadRequest, invalidRequest, err.Error())
 			return
 		} else {
@@ -122,26 +427,39 @@ func (h *TelemetryHandler) HandleGetDeviceTelemetry(w http.ResponseWriter, r *ht
 		}
 	}
 
-	telemetry, next, err := h.telemetry.ListDeviceTelemetry(r.Context(), deviceID, limit, cur)
+	telemetry, next, err := h.telemetry.ListDeviceTelemetry(r.Context(), authz.NewActor(userId), deviceID, limit, cur)
 	if err != nil {
-		h.log.Error(fmt.Sprintf("failed to get device telemetry: %v", err))
-		WriteInternalError(w)
+		switch {
+		case errors.Is(err, device.ErrDeviceNotFound):
+			WriteJSONError(w, http.StatusNotFound, notfound, "device not found")
+		case errors.Is(err, authz.ErrForbidden):
+			WriteJSONError(w, http.StatusForbidden, forbidden, "your role does not allow reading this device's telemetry")
+		default:
+			h.log.Error(fmt.Sprintf("failed to get device telemetry: %v", err))
+			WriteInternalError(w)
+		}
 		return
 	}
 
 	out := make([]telemetryResponse, 0, len(telemetry))
 	for _, t := range telemetry {
-		out = append(out, telemetryResponse{
-			ID:            t.ID.String(),
-			TelemetryType: t.TelemetryType.String(),
-			Payload:       t.Payload,
-			RecordedAt:    t.RecordedAt.Time(),
-		})
+		out = append(out, toTelemetryResponse(t))
 	}
 
 	var nextStr string
 	if next != nil {
-		nextStr = pagination.Encode(*next)
+		nextStr = pagination.EncodeRecordedAt(*next)
+	}
+
+	if resCodec == protobufCodec {
+		body, err := encodeProtoTelemetryList(out, nextStr, limit)
+		if err != nil {
+			h.log.Error(fmt.Sprintf("failed to encode telemetry: %v", err))
+			WriteInternalError(w)
+			return
+		}
+		writeProtobuf(w, http.StatusOK, body)
+		return
 	}
 
 	meta := pageMeta{
@@ -149,5 +467,496 @@ func (h *TelemetryHandler) HandleGetDeviceTelemetry(w http.ResponseWriter, r *ht
 		Limit:      limit,
 	}
 
-	WriteJSON(w, http.StatusOK, out, meta)
+	WriteEncoded(w, resCodec, http.StatusOK, out, meta)
+}
+
+type rollupResponse struct {
+	TelemetryType string    `json:"telemetry_type"`
+	Field         string    `json:"field"`
+	Bucket        time.Time `json:"bucket"`
+	Count         int64     `json:"count"`
+	Sum           float64   `json:"sum"`
+	Min           float64   `json:"min"`
+	Max           float64   `json:"max"`
+	Mean          float64   `json:"mean"`
+}
+
+type rollupMeta struct {
+	Resolution string    `json:"resolution"`
+	From       time.Time `json:"from"`
+	To         time.Time `json:"to"`
+}
+
+func (h *TelemetryHandler) HandleGetDeviceTelemetryRollups(w http.ResponseWriter, r *http.Request) {
+	userId, ok := GetUserID(r.Context())
+	if !ok {
+		h.log.Debug(fmt.Sprint("missing user id in context", "path", r.URL.Path))
+		WriteUnauthorizedError(w)
+		return
+	}
+
+	deviceIDStr := chi.URLParam(r, "device_id")
+	deviceID, err := device.NewDeviceID(deviceIDStr)
+	if err != nil {
+		WriteJSONError(w, http.StatusBadRequest, invalidRequest, "invalid device id")
+		return
+	}
+
+	resCodec, ok := negotiateCodec(r, false)
+	if !ok {
+		writeNotAcceptable(w, false)
+		return
+	}
+
+	query := r.URL.Query()
+
+	to := time.Now().UTC()
+	if v := query.Get("to"); v != "" {
+		if to, err = time.Parse(time.RFC3339, v); err != nil {
+			WriteJSONError(w, http.StatusBadRequest, invalidRequest, "to must be an RFC3339 timestamp")
+			return
+		}
+	}
+
+	from := to.Add(-24 * time.Hour)
+	if v := query.Get("from"); v != "" {
+		if from, err = time.Parse(time.RFC3339, v); err != nil {
+			WriteJSONError(w, http.StatusBadRequest, invalidRequest, "from must be an RFC3339 timestamp")
+			return
+		}
+	}
+
+	timeRange, err := telemetry.NewTimeRange(from, to)
+	if err != nil {
+		WriteJSONError(w, http.StatusBadRequest, invalidRequest, err.Error())
+		return
+	}
+
+	var field *telemetry.RollupField
+	if v := query.Get("field"); v != "" {
+		f, err := telemetry.NewRollupField(v)
+		if err != nil {
+			WriteJSONError(w, http.StatusBadRequest, invalidRequest, err.Error())
+			return
+		}
+		field = &f
+	}
+
+	var resolution *telemetry.Resolution
+	if v := query.Get("resolution"); v != "" {
+		res, err := telemetry.NewResolution(v)
+		if err != nil {
+			WriteJSONError(w, http.StatusBadRequest, invalidRequest, err.Error())
+			return
+		}
+		resolution = &res
+	}
+
+	rollups, res, err := h.telemetry.ListDeviceRollups(
+		r.Context(),
+		authz.NewActor(userId),
+		deviceID,
+		field,
+		timeRange,
+		resolution,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, device.ErrDeviceNotFound):
+			WriteJSONError(w, http.StatusNotFound, notfound, "device not found")
+		case errors.Is(err, authz.ErrForbidden):
+			WriteJSONError(w, http.StatusForbidden, forbidden, "your role does not allow reading this device's telemetry")
+		default:
+			h.log.Error(fmt.Sprintf("failed to get device telemetry rollups: %v", err))
+			WriteInternalError(w)
+		}
+		return
+	}
+
+	out := make([]rollupResponse, 0, len(rollups))
+	for _, ru := range rollups {
+		out = append(out, rollupResponse{
+			TelemetryType: ru.TelemetryType.String(),
+			Field:         ru.Field.String(),
+			Bucket:        ru.Bucket,
+			Count:         ru.Count,
+			Sum:           ru.Sum,
+			Min:           ru.Min,
+			Max:           ru.Max,
+			Mean:          ru.Mean(),
+		})
+	}
+
+	meta := rollupMeta{
+		Resolution: res.String(),
+		From:       timeRange.From(),
+		To:         timeRange.To(),
+	}
+
+	WriteEncoded(w, resCodec, http.StatusOK, out, meta)
+}
+
+func (h *TelemetryHandler) HandleExportDeviceTelemetry(w http.ResponseWriter, r *http.Request) {
+	deviceIDStr := chi.URLParam(r, "device_id")
+	deviceID, err := device.NewDeviceID(deviceIDStr)
+	if err != nil {
+		WriteJSONError(w, http.StatusBadRequest, invalidRequest, "invalid device id")
+		return
+	}
+
+	h.exportTelemetry(w, r, []device.DeviceID{deviceID})
+}
+
+func (h *TelemetryHandler) HandleExportTelemetry(w http.ResponseWriter, r *http.Request) {
+	var deviceIDs []device.DeviceID
+	for _, v := range r.URL.Query()["device_id"] {
+		id, err := device.NewDeviceID(v)
+		if err != nil {
+			WriteJSONError(w, http.StatusBadRequest, invalidRequest, "invalid device id")
+			return
+		}
+		deviceIDs = append(deviceIDs, id)
+	}
+
+	h.exportTelemetry(w, r, deviceIDs)
+}
+
+func (h *TelemetryHandler) exportTelemetry(w http.ResponseWriter, r *http.Request, deviceIDs []device.DeviceID) {
+	userId, ok := GetUserID(r.Context())
+	if !ok {
+		h.log.Debug(fmt.Sprint("missing user id in context", "path", r.URL.Path))
+		WriteUnauthorizedError(w)
+		return
+	}
+
+	format, ok := negotiateFileFormat(r)
+	if !ok {
+		WriteJSONError(w, http.StatusNotAcceptable, invalidRequest, "supported formats are text/csv and application/x-ndjson")
+		return
+	}
+
+	timeRange, err := parseOptionalTimeRange(r)
+	if err != nil {
+		WriteJSONError(w, http.StatusBadRequest, invalidRequest, err.Error())
+		return
+	}
+
+	filter := telemetry.ExportFilter{
+		UserID:    userId,
+		DeviceIDs: deviceIDs,
+		TimeRange: timeRange,
+	}
+
+	w.Header().Add("Vary", "Accept")
+
+	out := &exportResponseWriter{w: w, format: format}
+	_, err = h.telemetry.ExportTelemetry(r.Context(), authz.NewActor(userId), filter, format, out, nil)
+	if err != nil && out.committed {
+		// headers are already on the wire, so a failure can only be logged
+		h.log.Error(fmt.Sprintf("failed to export telemetry: %v", err))
+		return
+	}
+	if err != nil {
+		switch {
+		case errors.Is(err, device.ErrDeviceNotFound):
+			WriteJSONError(w, http.StatusNotFound, notfound, "device not found")
+		case errors.Is(err, authz.ErrForbidden):
+			WriteJSONError(w, http.StatusForbidden, forbidden, "your role does not allow reading this device's telemetry")
+		default:
+			h.log.Error(fmt.Sprintf("failed to export telemetry: %v", err))
+			WriteInternalError(w)
+		}
+		return
+	}
+
+	// an export without rows may not have written anything yet
+	out.commit()
+}
+
+// exportResponseWriter sends the export's headers along with its first
+// bytes, so that failing before any row, authorization included, still
+// gets an error status.
+type exportResponseWriter struct {
+	w         http.ResponseWriter
+	format    telemetry.FileFormat
+	committed bool
+}
+
+func (e *exportResponseWriter) Write(p []byte) (int, error) {
+	e.commit()
+	return e.w.Write(p)
+}
+
+func (e *exportResponseWriter) commit() {
+	if e.committed {
+		return
+	}
+	e.committed = true
+
+	e.w.Header().Set("Content-Type", e.format.ContentType())
+	e.w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="telemetry.%s"`, e.format.Extension()))
+	e.w.WriteHeader(http.StatusOK)
+}
+
+// negotiateFileFormat picks the first export format listed in Accept,
+// defaulting to CSV when the client accepts anything.
+func negotiateFileFormat(r *http.Request) (telemetry.FileFormat, bool) {
+	if v := r.URL.Query().Get("format"); v != "" {
+		f, err := telemetry.NewFileFormat(v)
+		return f, err == nil
+	}
+
+	accept := r.Header.Get("Accept")
+	if accept == "" {
+		return telemetry.FileFormatCSV, true
+	}
+
+	for _, part := range strings.Split(accept, ",") {
+		mediaType, _, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+
+		if mediaType == "*/*" || mediaType == "text/*" {
+			return telemetry.FileFormatCSV, true
+		}
+
+		if f, ok := telemetry.FileFormatFromMediaType(mediaType); ok {
+			return f, true
+		}
+	}
+
+	return telemetry.FileFormat{}, false
+}
+
+// parseOptionalTimeRange reads the from/to query parameters; either bound may
+// be left open.
+func parseOptionalTimeRange(r *http.Request) (*telemetry.TimeRange, error) {
+	query := r.URL.Query()
+	fromStr, toStr := query.Get("from"), query.Get("to")
+
+	if fromStr == "" && toStr == "" {
+		return nil, nil
+	}
+
+	from := time.Unix(0, 0).UTC()
+	if fromStr != "" {
+		t, err := time.Parse(time.RFC3339, fromStr)
+		if err != nil {
+			return nil, errors.New("from must be an RFC3339 timestamp")
+		}
+		from = t
+	}
+
+	to := time.Now().UTC().Add(24 * time.Hour)
+	if toStr != "" {
+		t, err := time.Parse(time.RFC3339, toStr)
+		if err != nil {
+			return nil, errors.New("to must be an RFC3339 timestamp")
+		}
+		to = t
+	}
+
+	timeRange, err := telemetry.NewTimeRange(from, to)
+	if err != nil {
+		return nil, err
+	}
+
+	return &timeRange, nil
+}
+
+type importErrorResponse struct {
+	Line  int    `json:"line"`
+	Error string `json:"error"`
+}
+
+type importReportResponse struct {
+	Accepted   int64                 `json:"accepted"`
+	Duplicates int64                 `json:"duplicates"`
+	Rejected   int64                 `json:"rejected"`
+	Errors     []importErrorResponse `json:"errors"`
+	Truncated  bool                  `json:"truncated"`
+}
+
+func (h *TelemetryHandler) HandleImportTelemetry(w http.ResponseWriter, r *http.Request) {
+	userId, ok := GetUserID(r.Context())
+	if !ok {
+		h.log.Debug(fmt.Sprint("missing user id in context", "path", r.URL.Path))
+		WriteUnauthorizedError(w)
+		return
+	}
+
+	deviceIDStr := chi.URLParam(r, "device_id")
+	deviceID, err := device.NewDeviceID(deviceIDStr)
+	if err != nil {
+		WriteJSONError(w, http.StatusBadRequest, invalidRequest, "invalid device id")
+		return
+	}
+
+	// a large file takes longer to upload and load than the server's read
+	// and write timeouts allow, so the import gets its own deadlines; a
+	// client that goes away still ends it through the failing reads
+	deadline := time.Now().Add(h.importTimeout)
+	rc := http.NewResponseController(w)
+	if err := rc.SetReadDeadline(deadline); err != nil {
+		h.log.Error(fmt.Sprintf("failed to extend import read deadline: %v", err))
+	}
+	if err := rc.SetWriteDeadline(deadline); err != nil {
+		h.log.Error(fmt.Sprintf("failed to extend import write deadline: %v", err))
+	}
+
+	ctx, cancel := context.WithDeadline(r.Context(), deadline)
+	defer cancel()
+
+	r.Body = http.MaxBytesReader(w, r.Body, maxImportBodyBytes)
+
+	format, body, err := importSource(r)
+	if err != nil {
+		WriteJSONError(w, http.StatusUnsupportedMediaType, invalidRequest, err.Error())
+		return
+	}
+
+	// the row count is only known once the file has been read, so an import
+	// only needs some quota left to start and is charged for what it loaded
+	if !h.checkQuota(w, r) {
+		return
+	}
+
+	report, err := h.telemetry.ImportTelemetry(ctx, authz.NewActor(userId), deviceID, format, body)
+	if report != nil {
+		h.chargeQuota(r, report.Accepted)
+	}
+	if err != nil {
+		switch {
+		case errors.Is(err, device.ErrDeviceNotFound):
+			WriteJSONError(w, http.StatusNotFound, notfound, "device not found")
+		case errors.Is(err, authz.ErrForbidden):
+			WriteJSONError(w, http.StatusForbidden, forbidden, "your role does not allow sending telemetry for this device")
+		case isBodyTooLarge(err):
+			writeBodyTooLarge(w, err)
+		case errors.Is(err, telemetry.ErrInvalidImport):
+			WriteJSONError(w, http.StatusBadRequest, invalidRequest, err.Error())
+		default:
+			h.log.Error(fmt.Sprintf("failed to import telemetry: %v", err))
+			WriteInternalError(w)
+		}
+		return
+	}
+
+	res := importReportResponse{
+		Accepted:   report.Accepted,
+		Duplicates: report.Duplicates,
+		Rejected:   report.Rejected,
+		Errors:     make([]importErrorResponse, 0, len(report.Errors)),
+		Truncated:  report.Truncated,
+	}
+
+	for _, e := range report.Errors {
+		res.Errors = append(res.Errors, importErrorResponse{Line: e.Line, Error: e.Err.Error()})
+	}
+
+	WriteJSON(w, http.StatusOK, res, nil)
+}
+
+// importSource returns the uploaded file and its format, either from the raw
+// request body or from the "file" part of a multipart upload. The body is
+// never buffered, so files up to maxImportBodyBytes stream straight into the
+// import.
+func importSource(r *http.Request) (telemetry.FileFormat, io.Reader, error) {
+	unsupported := errors.New("upload must be text/csv, application/x-ndjson or multipart/form-data")
+
+	mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil {
+		return telemetry.FileFormat{}, nil, unsupported
+	}
+
+	if f, ok := telemetry.FileFormatFromMediaType(mediaType); ok {
+		return f, r.Body, nil
+	}
+
+	if mediaType != "multipart/form-data" {
+		return telemetry.FileFormat{}, nil, unsupported
+	}
+
+	mr, err := r.MultipartReader()
+	if err != nil {
+		return telemetry.FileFormat{}, nil, unsupported
+	}
+
+	for {
+		part, err := mr.NextPart()
+		if err != nil {
+			return telemetry.FileFormat{}, nil, errors.New(`multipart upload must include a "file" part`)
+		}
+
+		if part.FormName() != "file" {
+			continue
+		}
+
+		if f, ok := telemetry.FileFormatFromMediaType(part.Header.Get("Content-Type")); ok {
+			return f, part, nil
+		}
+
+		ext := strings.TrimPrefix(path.Ext(part.FileName()), ".")
+		f, err := telemetry.NewFileFormat(ext)
+		if err != nil {
+			return telemetry.FileFormat{}, nil, errors.New("file must be a .csv or .ndjson upload")
+		}
+
+		return f, part, nil
+	}
+}
+
+// consumeQuota charges n readings to the caller's daily ingestion quota and
+// answers 429 when they do not fit. Quota failures are logged and let
+// through, like rate limit failures.
+func (h *TelemetryHandler) consumeQuota(w http.ResponseWriter, r *http.Request, n int64) bool {
+	userID, ok := GetUserID(r.Context())
+	if !ok {
+		return true
+	}
+
+	res, err := h.limits.ConsumeQuota(r.Context(), userID.String(), n)
+	if err != nil {
+		h.log.Error(fmt.Sprintf("failed to consume ingestion quota: %v", err))
+		return true
+	}
+
+	if !res.Allowed {
+		writeQuotaExceeded(w, res)
+		return false
+	}
+
+	return true
+}
+
+func (h *TelemetryHandler) checkQuota(w http.ResponseWriter, r *http.Request) bool {
+	userID, ok := GetUserID(r.Context())
+	if !ok {
+		return true
+	}
+
+	res, err := h.limits.CheckQuota(r.Context(), userID.String())
+	if err != nil {
+		h.log.Error(fmt.Sprintf("failed to check ingestion quota: %v", err))
+		return true
+	}
+
+	if !res.Allowed {
+		writeQuotaExceeded(w, res)
+		return false
+	}
+
+	return true
+}
+
+func (h *TelemetryHandler) chargeQuota(r *http.Request, n int64) {
+	userID, ok := GetUserID(r.Context())
+	if !ok {
+		return
+	}
+
+	if err := h.limits.ChargeQuota(r.Context(), userID.String(), n); err != nil {
+		h.log.Error(fmt.Sprintf("failed to charge ingestion quota: %v", err))
+	}
 }
@@ -0,0 +1,196 @@
+package http
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"google.golang.org/protobuf/encoding/protowire"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/structpb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// Wire encoding of the messages in api/telemetry/v1/telemetry.proto. The
+// schema is small and stable, so the fields are read and written with
+// protowire directly rather than through generated code.
+
+var errInvalidProtobuf = errors.New("invalid protobuf body")
+
+// ---------- Decoding ----------
+
+func decodeProtoReading(b []byte) (createTelemetryRequest, error) {
+	var req createTelemetryRequest
+
+	err := consumeProtoFields(b, func(num protowire.Number, typ protowire.Type, b []byte) (int, error) {
+		switch {
+		case num == 1 && typ == protowire.BytesType:
+			v, n := protowire.ConsumeString(b)
+			req.TelemetryType = v
+			return n, nil
+		case num == 2 && typ == protowire.BytesType:
+			v, n := protowire.ConsumeBytes(b)
+			if n < 0 {
+				return n, nil
+			}
+			var s structpb.Struct
+			if err := proto.Unmarshal(v, &s); err != nil {
+				return 0, errInvalidProtobuf
+			}
+			req.Payload = s.AsMap()
+			return n, nil
+		case num == 3 && typ == protowire.BytesType:
+			v, n := protowire.ConsumeBytes(b)
+			if n < 0 {
+				return n, nil
+			}
+			var ts timestamppb.Timestamp
+			if err := proto.Unmarshal(v, &ts); err != nil || ts.CheckValid() != nil {
+				return 0, errors.New("invalid recorded_at")
+			}
+			req.RecordedAt = ts.AsTime().Format(time.RFC3339Nano)
+			return n, nil
+		case num == 4 && typ == protowire.BytesType:
+			v, n := protowire.ConsumeString(b)
+			req.MessageID = v
+			return n, nil
+		default:
+			return protowire.ConsumeFieldValue(num, typ, b), nil
+		}
+	})
+
+	return req, err
+}
+
+func decodeProtoBatch(b []byte) (createTelemetryBatchRequest, error) {
+	var req createTelemetryBatchRequest
+
+	err := consumeProtoFields(b, func(num protowire.Number, typ protowire.Type, b []byte) (int, error) {
+		if num != 1 || typ != protowire.BytesType {
+			return protowire.ConsumeFieldValue(num, typ, b), nil
+		}
+
+		v, n := protowire.ConsumeBytes(b)
+		if n < 0 {
+			return n, nil
+		}
+
+		reading, err := decodeProtoReading(v)
+		if err != nil {
+			return 0, fmt.Errorf("readings[%d]: %w", len(req.Readings), err)
+		}
+		req.Readings = append(req.Readings, reading)
+
+		return n, nil
+	})
+
+	return req, err
+}
+
+// consumeProtoFields walks the top-level fields of a message, handing each
+// value to fn, which returns how many bytes it consumed (negative on a
+// malformed value).
+func consumeProtoFields(
+	b []byte,
+	fn func(num protowire.Number, typ protowire.Type, b []byte) (int, error),
+) error {
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return errInvalidProtobuf
+		}
+		b = b[n:]
+
+		n, err := fn(num, typ, b)
+		if err != nil {
+			return err
+		}
+		if n < 0 {
+			return errInvalidProtobuf
+		}
+		b = b[n:]
+	}
+
+	return nil
+}
+
+// ---------- Encoding ----------
+
+func appendProtoTelemetry(b []byte, t telemetryResponse) ([]byte, error) {
+	payload, err := structpb.NewStruct(t.Payload)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode payload: %w", err)
+	}
+
+	b = appendProtoString(b, 1, t.ID)
+	b = appendProtoString(b, 2, t.TelemetryType)
+	if b, err = appendProtoMessage(b, 3, payload); err != nil {
+		return nil, err
+	}
+	if b, err = appendProtoMessage(b, 4, timestamppb.New(t.RecordedAt)); err != nil {
+		return nil, err
+	}
+	if b, err = appendProtoMessage(b, 5, timestamppb.New(t.CreatedAt)); err != nil {
+		return nil, err
+	}
+	b = appendProtoString(b, 6, t.MessageID)
+	if t.DeviceRecordedAt != nil {
+		if b, err = appendProtoMessage(b, 7, timestamppb.New(*t.DeviceRecordedAt)); err != nil {
+			return nil, err
+		}
+	}
+	if t.ClockSkewFlagged {
+		b = protowire.AppendTag(b, 8, protowire.VarintType)
+		b = protowire.AppendVarint(b, 1)
+	}
+
+	return b, nil
+}
+
+func encodeProtoTelemetryList(items []telemetryResponse, nextCursor string, limit int) ([]byte, error) {
+	var b []byte
+
+	for _, t := range items {
+		item, err := appendProtoTelemetry(nil, t)
+		if err != nil {
+			return nil, err
+		}
+		b = protowire.AppendTag(b, 1, protowire.BytesType)
+		b = protowire.AppendBytes(b, item)
+	}
+
+	b = appendProtoString(b, 2, nextCursor)
+	if limit != 0 {
+		b = protowire.AppendTag(b, 3, protowire.VarintType)
+		b = protowire.AppendVarint(b, uint64(limit))
+	}
+
+	return b, nil
+}
+
+func appendProtoString(b []byte, num protowire.Number, v string) []byte {
+	if v == "" {
+		return b
+	}
+
+	b = protowire.AppendTag(b, num, protowire.BytesType)
+	return protowire.AppendString(b, v)
+}
+
+func appendProtoMessage(b []byte, num protowire.Number, m proto.Message) ([]byte, error) {
+	v, err := proto.Marshal(m)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode field %d: %w", num, err)
+	}
+
+	b = protowire.AppendTag(b, num, protowire.BytesType)
+	return protowire.AppendBytes(b, v), nil
+}
+
+func writeProtobuf(w http.ResponseWriter, status int, body []byte) {
+	w.Header().Set("Content-Type", protobufCodec.contentType)
+	w.Header().Add("Vary", "Accept")
+	w.WriteHeader(status)
+	_, _ = w.Write(body)
+}
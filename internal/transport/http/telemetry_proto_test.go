@@ -0,0 +1,380 @@
+package http
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/dynamicpb"
+	"google.golang.org/protobuf/types/known/structpb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// telemetrySchema mirrors api/telemetry/v1/telemetry.proto, so messages
+// built with it are encoded the way generated code would encode them.
+func telemetrySchema(t *testing.T) protoreflect.FileDescriptor {
+	t.Helper()
+
+	scalar := func(name string, num int32, typ descriptorpb.FieldDescriptorProto_Type) *descriptorpb.FieldDescriptorProto {
+		return &descriptorpb.FieldDescriptorProto{
+			Name:     proto.String(name),
+			JsonName: proto.String(name),
+			Number:   proto.Int32(num),
+			Label:    descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL.Enum(),
+			Type:     typ.Enum(),
+		}
+	}
+	message := func(name string, num int32, typeName string) *descriptorpb.FieldDescriptorProto {
+		f := scalar(name, num, descriptorpb.FieldDescriptorProto_TYPE_MESSAGE)
+		f.TypeName = proto.String(typeName)
+		return f
+	}
+	repeated := func(f *descriptorpb.FieldDescriptorProto) *descriptorpb.FieldDescriptorProto {
+		f.Label = descriptorpb.FieldDescriptorProto_LABEL_REPEATED.Enum()
+		return f
+	}
+
+	const (
+		str  = descriptorpb.FieldDescriptorProto_TYPE_STRING
+		bln  = descriptorpb.FieldDescriptorProto_TYPE_BOOL
+		i32  = descriptorpb.FieldDescriptorProto_TYPE_INT32
+		strc = ".google.protobuf.Struct"
+		ts   = ".google.protobuf.Timestamp"
+	)
+
+	file := &descriptorpb.FileDescriptorProto{
+		Name:       proto.String("telemetry/v1/telemetry.proto"),
+		Package:    proto.String("telemetry.v1"),
+		Syntax:     proto.String("proto3"),
+		Dependency: []string{"google/protobuf/struct.proto", "google/protobuf/timestamp.proto"},
+		MessageType: []*descriptorpb.DescriptorProto{
+			{
+				Name: proto.String("TelemetryReading"),
+				Field: []*descriptorpb.FieldDescriptorProto{
+					scalar("telemetry_type", 1, str),
+					message("payload", 2, strc),
+					message("recorded_at", 3, ts),
+					scalar("message_id", 4, str),
+				},
+			},
+			{
+				Name: proto.String("TelemetryBatch"),
+				Field: []*descriptorpb.FieldDescriptorProto{
+					repeated(message("readings", 1, ".telemetry.v1.TelemetryReading")),
+				},
+			},
+			{
+				Name: proto.String("Telemetry"),
+				Field: []*descriptorpb.FieldDescriptorProto{
+					scalar("id", 1, str),
+					scalar("telemetry_type", 2, str),
+					message("payload", 3, strc),
+					message("recorded_at", 4, ts),
+					message("created_at", 5, ts),
+					scalar("message_id", 6, str),
+					message("device_recorded_at", 7, ts),
+					scalar("clock_skew_flagged", 8, bln),
+				},
+			},
+			{
+				Name: proto.String("TelemetryList"),
+				Field: []*descriptorpb.FieldDescriptorProto{
+					repeated(message("items", 1, ".telemetry.v1.Telemetry")),
+					scalar("next_cursor", 2, str),
+					scalar("limit", 3, i32),
+				},
+			},
+		},
+	}
+
+	fd, err := protodesc.NewFile(file, protoregistry.GlobalFiles)
+	if err != nil {
+		t.Fatalf("invalid schema: %v", err)
+	}
+
+	return fd
+}
+
+// newSchemaMessage builds a message of the schema from field values, which
+// may be strings, bools, int32s, proto messages or lists of messages.
+func newSchemaMessage(t *testing.T, fd protoreflect.FileDescriptor, name string, fields map[string]any) *dynamicpb.Message {
+	t.Helper()
+
+	desc := fd.Messages().ByName(protoreflect.Name(name))
+	m := dynamicpb.NewMessage(desc)
+
+	for fieldName, v := range fields {
+		f := desc.Fields().ByName(protoreflect.Name(fieldName))
+		if f == nil {
+			t.Fatalf("%s has no field %s", name, fieldName)
+		}
+
+		switch v := v.(type) {
+		case []proto.Message:
+			list := m.Mutable(f).List()
+			for _, item := range v {
+				list.Append(protoreflect.ValueOfMessage(item.ProtoReflect()))
+			}
+		case proto.Message:
+			m.Set(f, protoreflect.ValueOfMessage(v.ProtoReflect()))
+		default:
+			m.Set(f, protoreflect.ValueOf(v))
+		}
+	}
+
+	return m
+}
+
+func mustStruct(t *testing.T, v map[string]any) *structpb.Struct {
+	t.Helper()
+
+	s, err := structpb.NewStruct(v)
+	if err != nil {
+		t.Fatalf("invalid struct: %v", err)
+	}
+
+	return s
+}
+
+func TestDecodeProtoReading(t *testing.T) {
+	fd := telemetrySchema(t)
+	recordedAt := time.Date(2025, 9, 7, 9, 0, 0, 123000000, time.UTC)
+
+	tests := []struct {
+		name   string
+		fields map[string]any
+		want   createTelemetryRequest
+	}{
+		{
+			name: "all fields",
+			fields: map[string]any{
+				"telemetry_type": "temperature",
+				"payload":        mustStruct(t, map[string]any{"celsius": 21.5, "ok": true, "tags": []any{"a"}}),
+				"recorded_at":    timestamppb.New(recordedAt),
+				"message_id":     "msg-1",
+			},
+			want: createTelemetryRequest{
+				TelemetryType: "temperature",
+				Payload:       map[string]any{"celsius": 21.5, "ok": true, "tags": []any{"a"}},
+				RecordedAt:    "2025-09-07T09:00:00.123Z",
+				MessageID:     "msg-1",
+			},
+		},
+		{
+			name: "optional fields left out",
+			fields: map[string]any{
+				"telemetry_type": "status",
+				"payload":        mustStruct(t, map[string]any{}),
+				"recorded_at":    timestamppb.New(recordedAt),
+			},
+			want: createTelemetryRequest{
+				TelemetryType: "status",
+				Payload:       map[string]any{},
+				RecordedAt:    "2025-09-07T09:00:00.123Z",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			body, err := proto.Marshal(newSchemaMessage(t, fd, "TelemetryReading", tt.fields))
+			if err != nil {
+				t.Fatalf("marshal: %v", err)
+			}
+
+			got, err := decodeProtoReading(body)
+			if err != nil {
+				t.Fatalf("decodeProtoReading: %v", err)
+			}
+
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("decodeProtoReading = %#v, want %#v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDecodeProtoReadingRejectsMalformedBodies(t *testing.T) {
+	tests := []struct {
+		name string
+		body []byte
+	}{
+		{name: "truncated tag", body: []byte{0x80}},
+		{name: "length past the end", body: []byte{0x0a, 0x05, 't'}},
+		{name: "invalid payload", body: []byte{0x12, 0x02, 0xff, 0xff}},
+		{name: "invalid timestamp", body: []byte{0x1a, 0x02, 0x08, 0xff}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := decodeProtoReading(tt.body); err == nil {
+				t.Errorf("decodeProtoReading(%x) succeeded, want an error", tt.body)
+			}
+		})
+	}
+}
+
+func TestDecodeProtoBatch(t *testing.T) {
+	fd := telemetrySchema(t)
+	recordedAt := time.Date(2025, 9, 7, 9, 0, 0, 0, time.UTC)
+
+	readings := []proto.Message{
+		newSchemaMessage(t, fd, "TelemetryReading", map[string]any{
+			"telemetry_type": "temperature",
+			"payload":        mustStruct(t, map[string]any{"celsius": 20.0}),
+			"recorded_at":    timestamppb.New(recordedAt),
+			"message_id":     "msg-1",
+		}),
+		newSchemaMessage(t, fd, "TelemetryReading", map[string]any{
+			"telemetry_type": "humidity",
+			"payload":        mustStruct(t, map[string]any{"percent": 40.0}),
+			"recorded_at":    timestamppb.New(recordedAt.Add(time.Second)),
+		}),
+	}
+
+	body, err := proto.Marshal(newSchemaMessage(t, fd, "TelemetryBatch", map[string]any{"readings": readings}))
+	if err != nil {
+		t.Fatalf("marshal: %v", err)
+	}
+
+	got, err := decodeProtoBatch(body)
+	if err != nil {
+		t.Fatalf("decodeProtoBatch: %v", err)
+	}
+
+	want := createTelemetryBatchRequest{Readings: []createTelemetryRequest{
+		{
+			TelemetryType: "temperature",
+			Payload:       map[string]any{"celsius": 20.0},
+			RecordedAt:    "2025-09-07T09:00:00Z",
+			MessageID:     "msg-1",
+		},
+		{
+			TelemetryType: "humidity",
+			Payload:       map[string]any{"percent": 40.0},
+			RecordedAt:    "2025-09-07T09:00:01Z",
+		},
+	}}
+
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("decodeProtoBatch = %#v, want %#v", got, want)
+	}
+}
+
+func TestAppendProtoTelemetry(t *testing.T) {
+	fd := telemetrySchema(t)
+	recordedAt := time.Date(2025, 9, 7, 9, 0, 0, 0, time.UTC)
+	createdAt := recordedAt.Add(2 * time.Second)
+	deviceRecordedAt := recordedAt.Add(6 * time.Minute)
+
+	tests := []struct {
+		name string
+		res  telemetryResponse
+		want map[string]any
+	}{
+		{
+			name: "corrected and flagged",
+			res: telemetryResponse{
+				ID:               "5b0e5c8e-6f7a-4c1e-9a57-1d0f7f1c2e3a",
+				TelemetryType:    "temperature",
+				Payload:          map[string]any{"celsius": 21.5},
+				RecordedAt:       recordedAt,
+				CreatedAt:        createdAt,
+				MessageID:        "msg-1",
+				DeviceRecordedAt: &deviceRecordedAt,
+				ClockSkewFlagged: true,
+			},
+			want: map[string]any{
+				"id":                 "5b0e5c8e-6f7a-4c1e-9a57-1d0f7f1c2e3a",
+				"telemetry_type":     "temperature",
+				"payload":            mustStruct(t, map[string]any{"celsius": 21.5}),
+				"recorded_at":        timestamppb.New(recordedAt),
+				"created_at":         timestamppb.New(createdAt),
+				"message_id":         "msg-1",
+				"device_recorded_at": timestamppb.New(deviceRecordedAt),
+				"clock_skew_flagged": true,
+			},
+		},
+		{
+			name: "defaults left out",
+			res: telemetryResponse{
+				ID:            "5b0e5c8e-6f7a-4c1e-9a57-1d0f7f1c2e3a",
+				TelemetryType: "status",
+				Payload:       map[string]any{},
+				RecordedAt:    recordedAt,
+				CreatedAt:     createdAt,
+			},
+			want: map[string]any{
+				"id":             "5b0e5c8e-6f7a-4c1e-9a57-1d0f7f1c2e3a",
+				"telemetry_type": "status",
+				"payload":        mustStruct(t, map[string]any{}),
+				"recorded_at":    timestamppb.New(recordedAt),
+				"created_at":     timestamppb.New(createdAt),
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			body, err := appendProtoTelemetry(nil, tt.res)
+			if err != nil {
+				t.Fatalf("appendProtoTelemetry: %v", err)
+			}
+
+			got := dynamicpb.NewMessage(fd.Messages().ByName("Telemetry"))
+			if err := proto.Unmarshal(body, got); err != nil {
+				t.Fatalf("unmarshal: %v", err)
+			}
+
+			want := newSchemaMessage(t, fd, "Telemetry", tt.want)
+			if !proto.Equal(got, want) {
+				t.Errorf("appendProtoTelemetry decoded to %v, want %v", got, want)
+			}
+		})
+	}
+}
+
+func TestEncodeProtoTelemetryList(t *testing.T) {
+	fd := telemetrySchema(t)
+	recordedAt := time.Date(2025, 9, 7, 9, 0, 0, 0, time.UTC)
+
+	res := telemetryResponse{
+		ID:            "5b0e5c8e-6f7a-4c1e-9a57-1d0f7f1c2e3a",
+		TelemetryType: "temperature",
+		Payload:       map[string]any{"celsius": 21.5},
+		RecordedAt:    recordedAt,
+		CreatedAt:     recordedAt,
+	}
+
+	body, err := encodeProtoTelemetryList([]telemetryResponse{res, res}, "next-page", 10)
+	if err != nil {
+		t.Fatalf("encodeProtoTelemetryList: %v", err)
+	}
+
+	got := dynamicpb.NewMessage(fd.Messages().ByName("TelemetryList"))
+	if err := proto.Unmarshal(body, got); err != nil {
+		t.Fatalf("unmarshal: %v", err)
+	}
+
+	item := newSchemaMessage(t, fd, "Telemetry", map[string]any{
+		"id":             res.ID,
+		"telemetry_type": res.TelemetryType,
+		"payload":        mustStruct(t, res.Payload),
+		"recorded_at":    timestamppb.New(recordedAt),
+		"created_at":     timestamppb.New(recordedAt),
+	})
+	want := newSchemaMessage(t, fd, "TelemetryList", map[string]any{
+		"items":       []proto.Message{item, item},
+		"next_cursor": "next-page",
+		"limit":       int32(10),
+	})
+
+	if !proto.Equal(got, want) {
+		t.Errorf("encodeProtoTelemetryList decoded to %v, want %v", got, want)
+	}
+}
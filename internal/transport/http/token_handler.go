@@ -0,0 +1,171 @@
+package http
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/raphico/go-device-telemetry-api/internal/logger"
+	"github.com/raphico/go-device-telemetry-api/internal/token"
+	"github.com/raphico/go-device-telemetry-api/internal/user"
+)
+
+type TokenHandler struct {
+	log   *logger.Logger
+	token *token.Service
+}
+
+func NewTokenHandler(log *logger.Logger, tokenService *token.Service) *TokenHandler {
+	return &TokenHandler{
+		log:   log,
+		token: tokenService,
+	}
+}
+
+type createTokenRequest struct {
+	Name      string   `json:"name"`
+	Scopes    []string `json:"scopes"`
+	ExpiresAt string   `json:"expires_at"`
+}
+
+type personalAccessTokenResponse struct {
+	ID         string     `json:"id"`
+	Name       string     `json:"name"`
+	Prefix     string     `json:"prefix"`
+	Scopes     []string   `json:"scopes"`
+	ExpiresAt  *time.Time `json:"expires_at"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+	CreatedAt  time.Time  `json:"created_at"`
+	// Token is the plaintext, returned once at creation.
+	Token string `json:"token,omitempty"`
+}
+
+func (h *TokenHandler) HandleCreateToken(w http.ResponseWriter, r *http.Request) {
+	userId, ok := GetUserID(r.Context())
+	if !ok {
+		h.log.Debug(fmt.Sprint("missing user id in context", "path", r.URL.Path))
+		WriteUnauthorizedError(w)
+		return
+	}
+
+	var req createTokenRequest
+	if !DecodeJSON(w, r, &req, maxAuthBodyBytes) {
+		return
+	}
+
+	name, err := token.NewName(req.Name)
+	if err != nil {
+		WriteJSONError(w, http.StatusBadRequest, invalidRequest, err.Error())
+		return
+	}
+
+	scopes, err := token.NewAccessScopes(req.Scopes)
+	if err != nil {
+		WriteJSONError(w, http.StatusBadRequest, invalidRequest, err.Error())
+		return
+	}
+
+	var expiresAt time.Time
+	if req.ExpiresAt != "" {
+		if expiresAt, err = time.Parse(time.RFC3339, req.ExpiresAt); err != nil {
+			WriteJSONError(w, http.StatusBadRequest, invalidRequest, "expires_at must be an RFC3339 timestamp")
+			return
+		}
+		if !expiresAt.After(time.Now()) {
+			WriteJSONError(w, http.StatusBadRequest, invalidRequest, "expires_at must be in the future")
+			return
+		}
+	}
+
+	t, err := h.token.CreatePersonalAccessToken(r.Context(), userId, name, scopes, expiresAt)
+	if err != nil {
+		switch {
+		case errors.Is(err, user.ErrUserNotFound):
+			WriteJSONError(w, http.StatusUnauthorized, unauthorized, "User does not exist")
+		default:
+			h.log.Error(fmt.Sprintf("failed to create personal access token: %v", err))
+			WriteInternalError(w)
+		}
+		return
+	}
+
+	res := toPersonalAccessTokenResponse(t)
+	res.Token = t.Plaintext
+
+	WriteJSON(w, http.StatusCreated, res, nil)
+}
+
+func (h *TokenHandler) HandleListTokens(w http.ResponseWriter, r *http.Request) {
+	userId, ok := GetUserID(r.Context())
+	if !ok {
+		h.log.Debug(fmt.Sprint("missing user id in context", "path", r.URL.Path))
+		WriteUnauthorizedError(w)
+		return
+	}
+
+	tokens, err := h.token.ListPersonalAccessTokens(r.Context(), userId)
+	if err != nil {
+		h.log.Error(fmt.Sprintf("failed to list personal access tokens: %v", err))
+		WriteInternalError(w)
+		return
+	}
+
+	out := make([]personalAccessTokenResponse, 0, len(tokens))
+	for _, t := range tokens {
+		out = append(out, toPersonalAccessTokenResponse(t))
+	}
+
+	WriteJSON(w, http.StatusOK, out, nil)
+}
+
+func (h *TokenHandler) HandleRevokeToken(w http.ResponseWriter, r *http.Request) {
+	userId, ok := GetUserID(r.Context())
+	if !ok {
+		h.log.Debug(fmt.Sprint("missing user id in context", "path", r.URL.Path))
+		WriteUnauthorizedError(w)
+		return
+	}
+
+	id, err := token.NewTokenID(chi.URLParam(r, "token_id"))
+	if err != nil {
+		WriteJSONError(w, http.StatusBadRequest, invalidRequest, "invalid token id")
+		return
+	}
+
+	if err := h.token.RevokePersonalAccessToken(r.Context(), id, userId); err != nil {
+		switch {
+		case errors.Is(err, token.ErrTokenNotFound):
+			WriteJSONError(w, http.StatusNotFound, notfound, "token not found")
+		default:
+			h.log.Error(fmt.Sprintf("failed to revoke personal access token: %v", err))
+			WriteInternalError(w)
+		}
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func toPersonalAccessTokenResponse(t *token.Token) personalAccessTokenResponse {
+	res := personalAccessTokenResponse{
+		ID:         t.ID.String(),
+		Name:       t.Name.String(),
+		Prefix:     t.Prefix,
+		Scopes:     make([]string, 0, len(t.AccessScopes)),
+		LastUsedAt: t.LastUsedAt,
+		CreatedAt:  t.CreatedAt,
+	}
+
+	for _, s := range t.AccessScopes {
+		res.Scopes = append(res.Scopes, s.String())
+	}
+
+	if !t.ExpiresAt.IsZero() {
+		expiresAt := t.ExpiresAt
+		res.ExpiresAt = &expiresAt
+	}
+
+	return res
+}
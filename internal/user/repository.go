@@ -3,6 +3,27 @@ package user
 import "context"
 
 type Repository interface {
+	// Create stores u, with email_verified_at when it is set, together with
+	// the personal organization they own, in one transaction. It fails with
+	// ErrUsernameTaken or ErrEmailAlreadyExists.
 	Create(ctx context.Context, u *User) error
 	FindByEmail(ctx context.Context, email Email) (*User, error)
+	FindById(ctx context.Context, id UserID) (*User, error)
+	// MarkEmailVerified sets email_verified_at unless it is already set.
+	MarkEmailVerified(ctx context.Context, id UserID) error
+	UpdatePassword(ctx context.Context, id UserID, password Password) error
+	// ConfirmEmail marks the address verification links go to as verified:
+	// the pending email, which replaces the current one, or else the
+	// current email. It returns the email the user had before and fails
+	// with ErrEmailAlreadyExists when someone took the pending address in
+	// the meantime.
+	ConfirmEmail(ctx context.Context, id UserID) (Email, error)
+	// UpdateProfile stores u's username and pending email. It fails with
+	// ErrUsernameTaken like Create.
+	UpdateProfile(ctx context.Context, u *User) error
+	// Delete removes the user together with every organization no one else
+	// belongs to, and with them their devices, telemetry and commands. It
+	// fails with organization.ErrLastOwner, deleting nothing, when the user
+	// is the only owner of an organization that has other members.
+	Delete(ctx context.Context, id UserID) error
 }
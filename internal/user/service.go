@@ -2,6 +2,8 @@ package user
 
 import (
 	"context"
+	"errors"
+	"time"
 )
 
 type Service struct {
@@ -9,18 +11,27 @@ type Service struct {
 }
 
 func NewService(repo Repository) *Service {
+	dummyHash()
+
 	return &Service{
 		repo: repo,
 	}
 }
 
+// RegisterUser creates a user and their personal organization. emailVerified
+// marks an address already known to be theirs as verified.
 func (s *Service) RegisterUser(
 	ctx context.Context,
 	username Username,
 	email Email,
 	password Password,
+	emailVerified bool,
 ) (*User, error) {
 	user := NewUser(email, username, password)
+	if emailVerified {
+		now := time.Now()
+		user.EmailVerifiedAt = &now
+	}
 
 	if err := s.repo.Create(ctx, user); err != nil {
 		return nil, err
@@ -29,9 +40,21 @@ func (s *Service) RegisterUser(
 	return user, nil
 }
 
+// AuthenticateUser checks rawPassword against the account registered as
+// email. Unknown addresses and accounts without a password are compared
+// against a dummy hash, so every failure takes as long as a wrong password.
 func (s *Service) AuthenticateUser(ctx context.Context, email Email, rawPassword string) (*User, error) {
 	user, err := s.repo.FindByEmail(ctx, email)
 	if err != nil {
+		if !errors.Is(err, ErrUserNotFound) {
+			return nil, err
+		}
+		equalizeTiming(rawPassword)
+		return nil, ErrInvalidCredentials
+	}
+
+	if !user.Password.IsSet() {
+		equalizeTiming(rawPassword)
 		return nil, ErrInvalidCredentials
 	}
 
@@ -41,3 +64,98 @@ func (s *Service) AuthenticateUser(ctx context.Context, email Email, rawPassword
 
 	return user, nil
 }
+
+func (s *Service) GetUserByEmail(ctx context.Context, email Email) (*User, error) {
+	return s.repo.FindByEmail(ctx, email)
+}
+
+func (s *Service) GetUser(ctx context.Context, id UserID) (*User, error) {
+	return s.repo.FindById(ctx, id)
+}
+
+// VerifyEmail marks id's current email as verified, leaving a pending one
+// alone.
+func (s *Service) VerifyEmail(ctx context.Context, id UserID) error {
+	return s.repo.MarkEmailVerified(ctx, id)
+}
+
+// ChangePassword replaces id's password after checking currentPassword,
+// failing with ErrInvalidCredentials when it does not match.
+func (s *Service) ChangePassword(
+	ctx context.Context,
+	id UserID,
+	currentPassword string,
+	newPassword Password,
+) error {
+	user, err := s.repo.FindById(ctx, id)
+	if err != nil {
+		return err
+	}
+
+	if !user.Password.Matches(currentPassword) {
+		return ErrInvalidCredentials
+	}
+
+	return s.repo.UpdatePassword(ctx, id, newPassword)
+}
+
+// UpdateProfile changes id's username, leaving it as it is when nil. A new
+// email only becomes the pending email, which ConfirmEmail applies once
+// the address is verified; the current address cancels a pending change.
+// An address another account uses fails with ErrEmailAlreadyExists.
+func (s *Service) UpdateProfile(ctx context.Context, id UserID, username *Username, email *Email) (*User, error) {
+	user, err := s.repo.FindById(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	if username != nil {
+		user.Username = *username
+	}
+
+	if email != nil {
+		if *email == user.Email {
+			user.PendingEmail = nil
+		} else {
+			if _, err := s.repo.FindByEmail(ctx, *email); err == nil {
+				return nil, ErrEmailAlreadyExists
+			} else if !errors.Is(err, ErrUserNotFound) {
+				return nil, err
+			}
+			user.PendingEmail = email
+		}
+	}
+
+	if err := s.repo.UpdateProfile(ctx, user); err != nil {
+		return nil, err
+	}
+
+	return user, nil
+}
+
+// ConfirmEmail records that id followed a verification link, which went to
+// the pending email when there is one. It returns the user and the email
+// they had before, which differs when a pending change was applied.
+func (s *Service) ConfirmEmail(ctx context.Context, id UserID) (*User, Email, error) {
+	previous, err := s.repo.ConfirmEmail(ctx, id)
+	if err != nil {
+		return nil, Email{}, err
+	}
+
+	user, err := s.repo.FindById(ctx, id)
+	if err != nil {
+		return nil, Email{}, err
+	}
+
+	return user, previous, nil
+}
+
+func (s *Service) DeleteUser(ctx context.Context, id UserID) error {
+	return s.repo.Delete(ctx, id)
+}
+
+// ResetPassword replaces id's password without knowing the current one; the
+// caller must have proven control of the account some other way.
+func (s *Service) ResetPassword(ctx context.Context, id UserID, password Password) error {
+	return s.repo.UpdatePassword(ctx, id, password)
+}
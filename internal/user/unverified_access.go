@@ -0,0 +1,36 @@
+package user
+
+import "fmt"
+
+var (
+	// UnverifiedAccessAllow lets unverified users do everything.
+	UnverifiedAccessAllow = UnverifiedAccess{"allow"}
+	// UnverifiedAccessReadOnly lets unverified users read but not change
+	// anything.
+	UnverifiedAccessReadOnly = UnverifiedAccess{"read_only"}
+	// UnverifiedAccessBlock keeps unverified users out of the API entirely.
+	UnverifiedAccessBlock = UnverifiedAccess{"block"}
+)
+
+// UnverifiedAccess is what a signed-in user may do before verifying their
+// email address.
+type UnverifiedAccess struct {
+	value string
+}
+
+func NewUnverifiedAccess(value string) (UnverifiedAccess, error) {
+	switch value {
+	case UnverifiedAccessAllow.value:
+		return UnverifiedAccessAllow, nil
+	case UnverifiedAccessReadOnly.value:
+		return UnverifiedAccessReadOnly, nil
+	case UnverifiedAccessBlock.value:
+		return UnverifiedAccessBlock, nil
+	default:
+		return UnverifiedAccess{}, fmt.Errorf("unknown unverified access policy: %s", value)
+	}
+}
+
+func (a UnverifiedAccess) String() string {
+	return a.value
+}
@@ -6,6 +6,7 @@ import (
 	"net/mail"
 	"regexp"
 	"strings"
+	"sync"
 	"time"
 	"unicode"
 
@@ -34,10 +35,18 @@ type Password struct {
 }
 
 type User struct {
-	ID        UserID
-	Email     Email
-	Username  Username
-	Password  Password
+	ID       UserID
+	Email    Email
+	Username Username
+	Password Password
+	// EmailVerifiedAt is nil until the user follows a verification link.
+	EmailVerifiedAt *time.Time
+	// PendingEmail is an address the user asked to change to. It replaces
+	// Email once a verification link sent to it is followed.
+	PendingEmail *Email
+	// IsAdmin grants operator actions across all users; it is only set in
+	// the database.
+	IsAdmin   bool
 	CreatedAt time.Time
 	UpdatedAt time.Time
 }
@@ -157,6 +166,13 @@ func PasswordFromHash(hash []byte) Password {
 	return Password{hash: hash}
 }
 
+// IsSet reports whether the user has a password; accounts created through
+// an identity provider start without one and the zero Password matches
+// nothing.
+func (p Password) IsSet() bool {
+	return len(p.hash) > 0
+}
+
 func (p Password) Hash() string {
 	return string(p.hash)
 }
@@ -166,6 +182,23 @@ func (p *Password) Matches(candidate string) bool {
 	return err == nil
 }
 
+// dummyHash is compared against when there is no real hash to check, at the
+// cost real hashes are created with. NewService computes it, so neither
+// other binaries nor the first failed login pay for it.
+var dummyHash = sync.OnceValue(func() []byte {
+	hash, err := bcrypt.GenerateFromPassword([]byte("not a real password"), bcrypt.DefaultCost)
+	if err != nil {
+		panic(fmt.Sprintf("failed to hash dummy password: %v", err))
+	}
+	return hash
+})
+
+// equalizeTiming spends as long as checking candidate against a real hash
+// would.
+func equalizeTiming(candidate string) {
+	_ = bcrypt.CompareHashAndPassword(dummyHash(), []byte(candidate))
+}
+
 // ---------- User ----------
 
 func NewUser(email Email, username Username, password Password) *User {
@@ -176,6 +209,19 @@ func NewUser(email Email, username Username, password Password) *User {
 	}
 }
 
+func (u *User) IsEmailVerified() bool {
+	return u.EmailVerifiedAt != nil
+}
+
+// EmailToVerify is the address verification links go to: the pending one
+// while a change is waiting, and otherwise the current one.
+func (u *User) EmailToVerify() Email {
+	if u.PendingEmail != nil {
+		return *u.PendingEmail
+	}
+	return u.Email
+}
+
 // ---------- Rehydration ----------
 
 func RehydrateUser(
@@ -183,6 +229,9 @@ func RehydrateUser(
 	emailStr string,
 	usernameStr string,
 	passwordHash []byte,
+	emailVerifiedAt *time.Time,
+	pendingEmailStr *string,
+	isAdmin bool,
 	createdAt, updatedAt time.Time,
 ) (*User, error) {
 	e, err := NewEmail(emailStr)
@@ -190,17 +239,29 @@ func RehydrateUser(
 		return nil, fmt.Errorf("corrupt email: %w", err)
 	}
 
+	var pending *Email
+	if pendingEmailStr != nil {
+		p, err := NewEmail(*pendingEmailStr)
+		if err != nil {
+			return nil, fmt.Errorf("corrupt pending email: %w", err)
+		}
+		pending = &p
+	}
+
 	uname, err := NewUsername(usernameStr)
 	if err != nil {
 		return nil, fmt.Errorf("corrupt username: %w", err)
 	}
 
 	return &User{
-		ID:        UserID(id),
-		Email:     e,
-		Username:  uname,
-		Password:  PasswordFromHash(passwordHash),
-		CreatedAt: createdAt,
-		UpdatedAt: updatedAt,
+		ID:              UserID(id),
+		Email:           e,
+		Username:        uname,
+		Password:        PasswordFromHash(passwordHash),
+		EmailVerifiedAt: emailVerifiedAt,
+		PendingEmail:    pending,
+		IsAdmin:         isAdmin,
+		CreatedAt:       createdAt,
+		UpdatedAt:       updatedAt,
 	}, nil
 }